@@ -66,12 +66,15 @@ kernel driver, and sends them back to the kernel using uinput devices.
 %{_bindir}/iptsd
 %{_bindir}/iptsd-check-device
 %{_bindir}/iptsd-calibrate
+%{_bindir}/iptsd-decode
 %{_bindir}/iptsd-dump
 %{_bindir}/iptsd-find-hidraw
 %{_bindir}/iptsd-find-service
 %{_bindir}/iptsd-foreach
 %{_bindir}/iptsd-perf
 %{_bindir}/iptsd-plot
+%{_bindir}/iptsd-receive
+%{_bindir}/iptsd-selftest
 %{_bindir}/iptsd-show
 %{_bindir}/iptsd-systemd
 %{_unitdir}/iptsd@.service
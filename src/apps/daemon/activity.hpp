@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_ACTIVITY_HPP
+#define IPTSD_APPS_DAEMON_ACTIVITY_HPP
+
+#include "uinput-device.hpp"
+
+#include <common/chrono.hpp>
+#include <common/clock.hpp>
+#include <common/types.hpp>
+#include <core/generic/config.hpp>
+#include <core/generic/device.hpp>
+
+#include <linux/input-event-codes.h>
+
+#include <filesystem>
+#include <memory>
+#include <optional>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * Tells the compositor that the device is being used, so that the screen doesn't dim.
+ *
+ * Compositors only reset their idle timer for events that they receive. Inputs that are
+ * rejected or suppressed (e.g. palms) produce no events, so this device emits a timestamp
+ * instead. Unlike a key press, it can't trigger any action or end up in a text field. To not
+ * flood the compositor, it is emitted at most once per interval.
+ */
+class ActivityDevice {
+private:
+	std::shared_ptr<UinputDevice> m_uinput;
+
+	// How much time has to pass between two signals of activity.
+	seconds<f64> m_interval {5};
+
+	// When activity was signaled the last time.
+	std::optional<chrono::steady_clock::time_point> m_last = std::nullopt;
+
+public:
+	ActivityDevice(const core::Config &config,
+	               const core::DeviceInfo &info,
+	               const std::filesystem::path &record = {})
+		: m_uinput {open_uinput_device({}, record)},
+		  m_interval {config.idle_interval}
+	{
+		m_uinput->set_name("Activity");
+		m_uinput->set_vendor(info.vendor);
+		m_uinput->set_product(info.product);
+
+		m_uinput->set_evbit(EV_MSC);
+		m_uinput->set_mscbit(MSC_TIMESTAMP);
+
+		m_uinput->create();
+	}
+
+	/*!
+	 * Signals that the device is being used, unless this was signaled recently.
+	 */
+	void update()
+	{
+		const auto now = chrono::steady_clock::now();
+
+		if (m_last.has_value() && now - m_last.value() < m_interval)
+			return;
+
+		m_last = now;
+
+		m_uinput->emit(EV_MSC, MSC_TIMESTAMP, common::CounterClock::timestamp(now));
+		m_uinput->emit(EV_SYN, SYN_REPORT, 0);
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_ACTIVITY_HPP
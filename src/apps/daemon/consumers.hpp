@@ -0,0 +1,298 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_CONSUMERS_HPP
+#define IPTSD_APPS_DAEMON_CONSUMERS_HPP
+
+#include <common/chrono.hpp>
+#include <common/json.hpp>
+#include <common/types.hpp>
+
+#include <spdlog/spdlog.h>
+
+#include <algorithm>
+#include <condition_variable>
+#include <csignal>
+#include <exception>
+#include <filesystem>
+#include <map>
+#include <memory>
+#include <mutex>
+#include <optional>
+#include <pthread.h>
+#include <set>
+#include <string>
+#include <system_error>
+#include <thread>
+#include <unistd.h>
+#include <utility>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * Checks whether the events that are emitted actually reach anyone.
+ *
+ * The events of an evdev device are only delivered to the processes that have it open. A device
+ * that nobody has open is only reported if other input devices do have readers, otherwise there
+ * is simply no session running yet.
+ *
+ * Whether another process grabbed a device can't be queried without grabbing it too. While such
+ * a probe holds the grab, our own events would only reach the probe, so this is not checked.
+ *
+ * Since every check scans all processes, the checks run on a separate thread.
+ */
+class ConsumerMonitor {
+public:
+	/*
+	 * Who receives the events of a device.
+	 */
+	struct Consumers {
+		// The evdev node of the device.
+		std::filesystem::path node {};
+
+		// How many other processes have the device open.
+		usize readers = 0;
+	};
+
+private:
+	using clock = chrono::steady_clock;
+
+	// How much time passes between two checks, since every check scans all processes.
+	constexpr static clock::duration INTERVAL = 10s;
+
+	/*
+	 * The state that is shared with the thread that runs the checks.
+	 *
+	 * The thread is detached, because it can't be interrupted while it scans the processes.
+	 */
+	struct Checker {
+		std::mutex mutex {};
+		std::condition_variable wakeup {};
+
+		// Whether the thread should stop.
+		bool should_stop = false;
+
+		// The evdev nodes of the devices that the thread should check next, by their name.
+		std::optional<std::map<std::string, std::filesystem::path>> pending = std::nullopt;
+
+		// The results of the last check, by the name of the device.
+		std::map<std::string, Consumers> devices {};
+	};
+
+	// When the next check is due. New devices get some time to be opened by the session.
+	clock::time_point m_next = clock::now() + INTERVAL;
+
+	// The state of the thread that runs the checks, once it was started.
+	std::shared_ptr<Checker> m_checker = nullptr;
+
+public:
+	ConsumerMonitor() = default;
+
+	ConsumerMonitor(const ConsumerMonitor &) = delete;
+	ConsumerMonitor &operator=(const ConsumerMonitor &) = delete;
+
+	~ConsumerMonitor()
+	{
+		if (!m_checker)
+			return;
+
+		const std::lock_guard<std::mutex> lock {m_checker->mutex};
+
+		m_checker->should_stop = true;
+		m_checker->wakeup.notify_all();
+	}
+
+	/*!
+	 * Whether enough time has passed since the last check.
+	 *
+	 * @return true if @ref check() should be called.
+	 */
+	[[nodiscard]] bool due() const
+	{
+		return clock::now() >= m_next;
+	}
+
+	/*!
+	 * Asks the thread to check who receives the events of the devices.
+	 *
+	 * The first call starts the thread, it never blocks.
+	 *
+	 * @param[in] nodes The evdev nodes of the devices, by their name.
+	 */
+	void check(const std::map<std::string, std::filesystem::path> &nodes)
+	{
+		m_next = clock::now() + INTERVAL;
+
+		if (!m_checker) {
+			m_checker = std::make_shared<Checker>();
+
+			std::thread thread {run, m_checker};
+			thread.detach();
+		}
+
+		const std::lock_guard<std::mutex> lock {m_checker->mutex};
+
+		m_checker->pending = nodes;
+		m_checker->wakeup.notify_all();
+	}
+
+	/*!
+	 * The results of the last check.
+	 *
+	 * @return A JSON object with the consumers of every device.
+	 */
+	[[nodiscard]] common::Json json() const
+	{
+		common::Json json {};
+
+		if (!m_checker)
+			return json;
+
+		const std::lock_guard<std::mutex> lock {m_checker->mutex};
+
+		for (const auto &[name, consumers] : m_checker->devices) {
+			common::Json device {};
+			device.add("node", consumers.node.string())
+				.add("readers", consumers.readers);
+
+			json.add(name, device);
+		}
+
+		return json;
+	}
+
+private:
+	/*!
+	 * Runs the checks that are requested, until it is asked to stop.
+	 *
+	 * @param[in] checker The state that is shared with the monitor.
+	 */
+	static void run(const std::shared_ptr<Checker> &checker)
+	{
+		// Signals should be handled by the main thread, which reads from the touch device.
+		sigset_t signals {};
+		sigfillset(&signals);
+		pthread_sigmask(SIG_BLOCK, &signals, nullptr);
+
+		// Whether a warning was logged already.
+		bool warned = false;
+
+		std::unique_lock<std::mutex> lock {checker->mutex};
+
+		while (!checker->should_stop) {
+			if (!checker->pending.has_value()) {
+				checker->wakeup.wait(lock);
+				continue;
+			}
+
+			const auto nodes = std::exchange(checker->pending, std::nullopt).value();
+
+			lock.unlock();
+
+			std::map<std::string, Consumers> devices {};
+
+			try {
+				devices = check_nodes(nodes, warned);
+			} catch (const std::exception &e) {
+				spdlog::warn("Failed to check the consumers: {}", e.what());
+			}
+
+			lock.lock();
+
+			checker->devices = std::move(devices);
+		}
+	}
+
+	/*!
+	 * Checks who receives the events of the devices.
+	 *
+	 * The first device whose events are probably not consumed is logged once.
+	 *
+	 * @param[in] nodes The evdev nodes of the devices, by their name.
+	 * @param[in,out] warned Whether a warning was logged already.
+	 * @return Who receives the events of every device, by its name.
+	 */
+	static std::map<std::string, Consumers>
+	check_nodes(const std::map<std::string, std::filesystem::path> &nodes, bool &warned)
+	{
+		std::map<std::string, Consumers> devices {};
+
+		const std::map<std::filesystem::path, usize> readers = count_readers();
+
+		// Without any process that reads input devices, no session is running.
+		const bool session = std::any_of(readers.begin(), readers.end(), [](const auto &n) {
+			return n.second > 0;
+		});
+
+		for (const auto &[name, node] : nodes) {
+			Consumers consumers {};
+			consumers.node = node;
+
+			const auto it = readers.find(node);
+			if (it != readers.end())
+				consumers.readers = it->second;
+
+			devices[name] = consumers;
+
+			if (warned || !session || consumers.readers > 0)
+				continue;
+
+			spdlog::warn("No process reads the events of the {} device ({}), "
+			             "they are probably not consumed",
+			             name,
+			             node.string());
+
+			warned = true;
+		}
+
+		return devices;
+	}
+
+	/*!
+	 * Counts the processes that have an evdev node open, for every node.
+	 *
+	 * @return How many processes other than this one have each evdev node open.
+	 */
+	static std::map<std::filesystem::path, usize> count_readers()
+	{
+		std::map<std::filesystem::path, usize> readers {};
+		std::error_code ec {};
+
+		const std::string self = std::to_string(getpid());
+		const std::filesystem::directory_iterator end {};
+
+		/*
+		 * Processes can exit while they are inspected, so the iterators are advanced
+		 * without throwing. Processes that can't be inspected are skipped.
+		 */
+		std::filesystem::directory_iterator process {"/proc", ec};
+
+		for (; !ec && process != end; process.increment(ec)) {
+			if (process->path().filename().string() == self)
+				continue;
+
+			std::set<std::filesystem::path> nodes {};
+			std::error_code fd_ec {};
+
+			std::filesystem::directory_iterator fd {process->path() / "fd", fd_ec};
+
+			for (; !fd_ec && fd != end; fd.increment(fd_ec)) {
+				std::error_code link_ec {};
+
+				const std::filesystem::path target =
+					std::filesystem::read_symlink(fd->path(), link_ec);
+
+				if (target.string().rfind("/dev/input/event", 0) == 0)
+					nodes.insert(target);
+			}
+
+			for (const std::filesystem::path &node : nodes)
+				readers[node]++;
+		}
+
+		return readers;
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_CONSUMERS_HPP
@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_CURVE_HPP
+#define IPTSD_APPS_DAEMON_CURVE_HPP
+
+#include "errors.hpp"
+
+#include <common/error.hpp>
+#include <common/types.hpp>
+
+#include <algorithm>
+#include <cmath>
+#include <exception>
+#include <iterator>
+#include <sstream>
+#include <string>
+#include <vector>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * Maps a value in the range [0, 1] to another value in the range [0, 1].
+ *
+ * This is used to shape how the measured pressure is reported, e.g. because the measurement
+ * doesn't grow linearly with the applied force.
+ */
+class Curve {
+private:
+	// How steep the logarithmic curve is at the start.
+	constexpr static f64 LOG_STEEPNESS = 9;
+
+private:
+	// The points of a custom curve, sorted by their x value. Empty if the curve is not custom.
+	std::vector<Vector2<f64>> m_points {};
+
+	// Whether the curve is logarithmic.
+	bool m_log = false;
+
+public:
+	/*!
+	 * Parses a curve.
+	 *
+	 * The curve can be "linear", "log", or a list of points like "0.1:0,0.5:0.8,1:1".
+	 * Between the points of a custom curve, the values are interpolated linearly.
+	 *
+	 * @param[in] curve The description of the curve.
+	 * @return The parsed curve.
+	 */
+	static Curve parse(const std::string &curve)
+	{
+		Curve parsed {};
+
+		if (curve == "linear")
+			return parsed;
+
+		if (curve == "log") {
+			parsed.m_log = true;
+			return parsed;
+		}
+
+		std::istringstream stream {curve};
+		std::string point {};
+
+		while (std::getline(stream, point, ',')) {
+			const usize colon = point.find(':');
+
+			if (colon == std::string::npos)
+				throw common::Error<Error::InvalidCurve> {curve};
+
+			try {
+				const f64 x = std::stod(point.substr(0, colon));
+				const f64 y = std::stod(point.substr(colon + 1));
+
+				if (x < 0 || x > 1 || y < 0 || y > 1)
+					throw common::Error<Error::InvalidCurve> {curve};
+
+				parsed.m_points.emplace_back(x, y);
+			} catch (const std::logic_error & /* unused */) {
+				throw common::Error<Error::InvalidCurve> {curve};
+			}
+		}
+
+		if (parsed.m_points.empty())
+			throw common::Error<Error::InvalidCurve> {curve};
+
+		std::vector<Vector2<f64>> &points = parsed.m_points;
+
+		std::sort(points.begin(), points.end(), [](const auto &a, const auto &b) {
+			return a.x() < b.x();
+		});
+
+		return parsed;
+	}
+
+	/*!
+	 * Applies the curve to a value.
+	 *
+	 * @param[in] value The value to map, will be clamped to [0, 1].
+	 * @return The mapped value, in the range [0, 1].
+	 */
+	[[nodiscard]] f64 map(const f64 value) const
+	{
+		const f64 x = std::clamp(value, 0.0, 1.0);
+
+		if (m_log)
+			return std::log1p(LOG_STEEPNESS * x) / std::log1p(LOG_STEEPNESS);
+
+		if (m_points.empty())
+			return x;
+
+		// Outside of the points, the curve continues with the value of the closest one.
+		if (x <= m_points.front().x())
+			return m_points.front().y();
+
+		if (x >= m_points.back().x())
+			return m_points.back().y();
+
+		const auto upper = std::find_if(m_points.cbegin(),
+		                                m_points.cend(),
+		                                [&](const auto &p) { return p.x() >= x; });
+
+		const Vector2<f64> &b = *upper;
+		const Vector2<f64> &a = *std::prev(upper);
+
+		if (b.x() == a.x())
+			return b.y();
+
+		return a.y() + ((b.y() - a.y()) * (x - a.x()) / (b.x() - a.x()));
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_CURVE_HPP
@@ -3,18 +3,40 @@
 #ifndef IPTSD_APPS_DAEMON_DAEMON_HPP
 #define IPTSD_APPS_DAEMON_DAEMON_HPP
 
+#include "activity.hpp"
+#include "consumers.hpp"
+#include "emit-errors.hpp"
+#include "latency.hpp"
+#include "pointer.hpp"
+#include "resampler.hpp"
 #include "stylus.hpp"
+#include "tablet-mode.hpp"
+#include "tilt.hpp"
 #include "touch.hpp"
 
+#include <common/casts.hpp>
+#include <common/chrono.hpp>
+#include <common/error.hpp>
+#include <common/json.hpp>
 #include <common/types.hpp>
 #include <contacts/contact.hpp>
 #include <core/generic/application.hpp>
+#include <core/generic/commands.hpp>
 #include <core/generic/config.hpp>
+#include <core/generic/errors.hpp>
+#include <core/linux/errors.hpp>
 #include <ipts/samples/button.hpp>
 #include <ipts/samples/stylus.hpp>
 
+#include <gsl/gsl>
 #include <spdlog/spdlog.h>
 
+#include <exception>
+#include <filesystem>
+#include <map>
+#include <memory>
+#include <optional>
+#include <string>
 #include <vector>
 
 namespace iptsd::apps::daemon {
@@ -24,27 +46,135 @@ private:
 	// The touch device.
 	std::optional<TouchDevice> m_touch = std::nullopt;
 
+	// The touchscreen, if it is emulating a touchpad.
+	std::optional<PointerDevice> m_pointer = std::nullopt;
+
 	// The stylus device.
 	std::optional<StylusDevice> m_stylus = std::nullopt;
 
+	// Signals activity to the compositor, if inputs should prevent the screen from dimming.
+	std::optional<ActivityDevice> m_activity = std::nullopt;
+
+	// The tablet mode switch, if the touch policy should depend on the posture of the device.
+	std::shared_ptr<TabletModeSwitch> m_tablet_mode = nullptr;
+
+	// Whether the touch device was disabled because the stylus was in use.
+	bool m_blocked_by_stylus = false;
+
+	// Whether the device was told to stop sending touch data.
+	bool m_firmware_disabled = false;
+
+	// How long it takes to emit the inputs of a buffer, if it is measured.
+	std::optional<Latency> m_latency = std::nullopt;
+
+	// Whether the current buffer contained any inputs.
+	bool m_had_input = false;
+
+	// Where the events of the stylus are recorded, to record a recreated device as well.
+	std::filesystem::path m_stylus_record {};
+
+	// Where the events of the eraser are recorded.
+	std::filesystem::path m_eraser_record {};
+
+	// Where the scrolling of the stylus is recorded.
+	std::filesystem::path m_scroll_record {};
+
+	// Decides what happens to stylus samples whose events can't be written.
+	EmitErrors m_emit_errors;
+
+	// Learns which styli support tilt, if the tilt axes depend on the stylus.
+	std::optional<TiltDetector> m_tilt_detector = std::nullopt;
+
+	// Whether the stylus device has tilt axes.
+	bool m_tilt = true;
+
+	// How many levels of pressure the stylus reports, if MaxPressure is detected.
+	u32 m_pressure_levels = 0;
+
+	// Emits the stylus samples at a fixed rate, if enabled.
+	std::optional<StylusResampler> m_resampler = std::nullopt;
+
+	// Checks whether the emitted events reach anyone.
+	ConsumerMonitor m_consumers {};
+
 public:
-	Daemon(const core::Config &config, const core::DeviceInfo &info)
-		: core::Application(config, info)
+	/*!
+	 * Creates the devices that the inputs are emitted through.
+	 *
+	 * @param[in] config The config of the daemon.
+	 * @param[in] info The type of the device.
+	 * @param[in] record A directory where the emitted events are recorded, in evemu format.
+	 */
+	Daemon(const core::Config &config,
+	       const core::DeviceInfo &info,
+	       const std::filesystem::path &record = {})
+		: core::Application(config, info),
+		  m_emit_errors {config}
 	{
 		const bool create_touch =
 			(m_info.is_touchscreen() && !m_config.touchscreen_disable) ||
 			(m_info.is_touchpad() && !m_config.touchpad_disable);
 
-		if (create_touch)
-			m_touch.emplace(config, info);
+		const bool relative = m_config.touchscreen_mode == "relative";
+
+		if (!relative && m_config.touchscreen_mode != "absolute")
+			throw common::Error<core::Error::InvalidTouchscreenMode> {};
+
+		// Every device is written to its own recording, since evemu can only replay one.
+		const auto recording = [&](const std::string &name) {
+			if (record.empty())
+				return std::filesystem::path {};
+
+			return record / (name + ".evemu");
+		};
+
+		if (create_touch && m_info.is_touchscreen() && relative)
+			m_pointer.emplace(config, info, recording("pointer"));
+		else if (create_touch && m_info.is_touchscreen())
+			m_touch.emplace(config, info, recording("touchscreen"));
+		else if (create_touch)
+			m_touch.emplace(config, info, recording("touchpad"));
+
+		m_stylus_record = recording("stylus");
+		m_eraser_record = recording("eraser");
+		m_scroll_record = recording("scroll");
 
-		if (m_info.is_touchscreen() && !m_config.stylus_disable)
-			m_stylus.emplace(config, info);
+		if (m_info.is_touchscreen() && !m_config.stylus_disable) {
+			m_stylus.emplace(config,
+			                 info,
+			                 m_stylus_record,
+			                 m_eraser_record,
+			                 m_scroll_record);
+		}
+
+		if (m_config.stylus_auto_tilt && !m_config.stylus_disable_tilt)
+			m_tilt_detector.emplace(config);
+
+		if (m_config.stylus_resample_rate > 0)
+			m_resampler.emplace(config);
+
+		if (m_config.idle_inhibit)
+			this->create_activity_device(recording("activity"));
+
+		if (m_touch.has_value() && !m_config.tablet_mode_device.empty()) {
+			const std::string &device = m_config.tablet_mode_device;
+			m_tablet_mode = std::make_shared<TabletModeSwitch>(device);
+		}
+	}
+
+	/*!
+	 * Starts measuring how long it takes to emit the inputs of a buffer.
+	 *
+	 * The distribution is logged when the daemon stops, and is part of the state.
+	 */
+	void measure_latency()
+	{
+		m_latency.emplace();
 	}
 
 	void on_start() override
 	{
-		if (!m_touch.has_value() && m_info.is_touchscreen())
+		if (!m_touch.has_value() && !m_pointer.has_value() && m_info.is_touchscreen())
 			spdlog::warn("Touchscreen is disabled!");
 
 		if (!m_touch.has_value() && m_info.is_touchpad())
@@ -54,22 +184,100 @@ public:
 			spdlog::warn("Stylus is disabled!");
 	}
 
-	void on_touch(const std::vector<contacts::Contact<f64>> &contacts) override
+	void on_stop() override
 	{
-		if (!m_touch.has_value())
+		// Don't leave the device without touch input once iptsd is gone.
+		if (m_firmware_disabled && this->set_hardware_touch)
+			m_firmware_disabled = !this->set_hardware_touch(true);
+
+		if (m_latency.has_value())
+			spdlog::info("Latency: {}", m_latency->summary());
+	}
+
+	[[nodiscard]] common::Json state() const override
+	{
+		common::Json daemon {};
+		daemon.add("touch", m_touch.has_value() && m_touch->enabled())
+			.add("pointer", m_pointer.has_value())
+			.add("stylus", m_stylus.has_value() && m_stylus->enabled())
+			.add("stylus_active", m_stylus.has_value() && m_stylus->active())
+			.add("stylus_errors", m_emit_errors.count())
+			.add("stylus_tilt", m_stylus.has_value() && m_tilt)
+			.add("tablet_mode", m_tablet_mode != nullptr)
+			.add("activity", m_activity.has_value())
+			.add("firmware_disabled", m_firmware_disabled)
+			.add("consumers", m_consumers.json());
+
+		common::Json state = core::Application::state();
+		state.add("daemon", daemon);
+
+		if (m_latency.has_value())
+			state.add("latency", m_latency->json());
+
+		return state;
+	}
+
+	void on_command(const core::Command command) override
+	{
+		const bool touch = m_touch.has_value() || m_pointer.has_value();
+
+		if (command == core::Command::ToggleTouch && touch) {
+			m_blocked_by_stylus = false;
+			this->set_touch_enabled(!this->touch_enabled(), true);
+
+			const bool enabled = this->touch_enabled();
+			spdlog::info("Touch input is {}", enabled ? "enabled" : "disabled");
+		}
+
+		core::Application::on_command(command);
+	}
+
+	void on_data(const gsl::span<u8> data) override
+	{
+		if (!m_latency.has_value()) {
+			core::Application::on_data(data);
 			return;
+		}
+
+		const auto start = chrono::steady_clock::now();
+
+		m_had_input = false;
+		core::Application::on_data(data);
+
+		// Buffers without inputs (e.g. metadata) would skew the distribution.
+		if (m_had_input)
+			m_latency->add(chrono::steady_clock::now() - start);
+	}
+
+	void on_touch(const std::vector<contacts::Contact<f64>> &contacts) override
+	{
+		m_had_input = true;
+
+		if (!contacts.empty()) {
+			this->signal_activity();
+			this->check_consumers();
+		}
 
 		// Enable the touchscreen if it was disabled by a stylus that is no longer active.
-		if (m_config.touchscreen_disable_on_stylus && m_stylus.has_value()) {
-			if (!m_stylus->active() && !m_touch->enabled())
-				m_touch->enable();
+		if (m_blocked_by_stylus && !m_stylus->active()) {
+			m_blocked_by_stylus = false;
+			this->set_touch_enabled(true, false);
 		}
 
-		m_touch->update(contacts);
+		if (m_pointer.has_value())
+			m_pointer->update(contacts);
+
+		if (!m_touch.has_value())
+			return;
+
+		this->update_tablet_mode();
+		m_touch->update(contacts, m_touch_timestamp);
 	}
 
 	void on_button(const ipts::samples::Button &button) override
 	{
+		m_had_input = true;
+
 		if (!m_touch.has_value())
 			return;
 
@@ -78,15 +286,319 @@ public:
 
 	void on_stylus(const ipts::samples::Stylus &stylus) override
 	{
+		m_had_input = true;
+
+		if (stylus.proximity) {
+			this->signal_activity();
+			this->check_consumers();
+		}
+
 		if (!m_stylus.has_value())
 			return;
 
-		if (m_config.touchscreen_disable_on_stylus && m_touch.has_value()) {
-			if (m_touch->enabled())
-				m_touch->disable();
+		const bool touch = m_touch.has_value() || m_pointer.has_value();
+
+		if (m_config.touchscreen_disable_on_stylus && touch) {
+			if (this->touch_enabled()) {
+				m_blocked_by_stylus = true;
+				const bool firmware = m_config.touchscreen_disable_on_stylus_firmware;
+				this->set_touch_enabled(false, firmware);
+			}
+		}
+
+		if (m_resampler.has_value()) {
+			const auto emit = [&](const ipts::samples::Stylus &sample) {
+				this->emit_stylus(sample);
+			};
+
+			m_resampler->update(stylus, emit);
+		} else {
+			this->emit_stylus(stylus);
+		}
+
+		if (m_tilt_detector.has_value() && m_stylus.has_value())
+			this->update_tilt(stylus);
+
+		if (m_config.stylus_max_pressure == 0 && m_stylus.has_value())
+			this->update_max_pressure(stylus);
+
+		// No heatmaps arrive while the firmware is disabled, so touch is enabled here.
+		if (m_blocked_by_stylus && m_firmware_disabled && !m_stylus->active()) {
+			m_blocked_by_stylus = false;
+			this->set_touch_enabled(true, false);
 		}
+	}
+
+	void on_dropped() override
+	{
+		// The stylus is lifted once it leaves proximity, until then clients start over.
+		if (m_stylus.has_value())
+			m_stylus->resync();
 
-		m_stylus->update(stylus);
+		// Interpolating across the lost samples would invent a stroke.
+		if (m_resampler.has_value())
+			m_resampler->reset();
+
+		if (m_pointer.has_value())
+			m_pointer->reset();
+
+		// The contacts can't be tracked across the lost frames, so release them.
+		if (m_touch.has_value())
+			m_touch->lift();
+	}
+
+	void on_profile(const core::StylusProfile & /* unused */) override
+	{
+		if (m_stylus.has_value())
+			m_stylus->set_pressure_curves(m_config);
+	}
+
+private:
+	/*!
+	 * Creates the device that signals activity to the compositor.
+	 *
+	 * Inhibiting the idle timer is not essential, so if the device can't be created
+	 * (e.g. because of missing permissions), the daemon continues without it.
+	 *
+	 * @param[in] record Where the events are recorded in evemu format. If empty, they are not.
+	 */
+	void create_activity_device(const std::filesystem::path &record)
+	{
+		try {
+			m_activity.emplace(m_config, m_info, record);
+		} catch (const std::exception &e) {
+			spdlog::warn("Failed to create activity device: {}", e.what());
+		}
+	}
+
+	/*!
+	 * Signals to the compositor that the device is being used, if enabled.
+	 *
+	 * If the activity device stops working, no more activity will be signaled from then on.
+	 */
+	void signal_activity()
+	{
+		if (!m_activity.has_value())
+			return;
+
+		try {
+			m_activity->update();
+		} catch (const std::exception &e) {
+			spdlog::warn("Failed to signal activity: {}", e.what());
+			m_activity.reset();
+		}
+	}
+
+	/*!
+	 * Passes a stylus sample to the stylus device.
+	 *
+	 * @param[in] stylus The state of the stylus that should be emitted.
+	 */
+	void emit_stylus(const ipts::samples::Stylus &stylus)
+	{
+		// The device is gone if it could not be created again.
+		if (!m_stylus.has_value())
+			return;
+
+		try {
+			m_stylus->update(stylus);
+		} catch (const common::Error<core::linux::Error::SyscallWriteNoDevice> &e) {
+			if (m_emit_errors.abort())
+				throw;
+
+			if (m_emit_errors.failed(e, true))
+				this->recreate_stylus();
+
+			return;
+		} catch (const common::Error<core::linux::Error::SyscallWriteFailed> &e) {
+			if (m_emit_errors.abort())
+				throw;
+
+			m_emit_errors.failed(e, false);
+			return;
+		}
+
+		m_emit_errors.succeeded();
+	}
+
+	/*!
+	 * Adds or removes the tilt axes once the stylus leaves proximity, if enabled.
+	 *
+	 * @param[in] stylus The current state of the stylus.
+	 */
+	void update_tilt(const ipts::samples::Stylus &stylus)
+	{
+		m_tilt_detector->update(stylus);
+
+		// The axes can't change while the stylus is used.
+		if (m_stylus->active())
+			return;
+
+		const bool tilt = m_tilt_detector->tilt();
+
+		if (tilt == m_tilt)
+			return;
+
+		spdlog::info("The stylus {} tilt, recreating stylus device",
+		             tilt ? "supports" : "doesn't support");
+
+		m_tilt = tilt;
+		this->recreate_stylus();
+	}
+
+	/*!
+	 * Adapts the pressure axis to the pressure levels of the stylus reports.
+	 *
+	 * Like the tilt axes, the axis can only change once the stylus leaves proximity.
+	 *
+	 * @param[in] stylus The current state of the stylus.
+	 */
+	void update_max_pressure(const ipts::samples::Stylus &stylus)
+	{
+		// Reports that don't know their pressure levels keep the last known range.
+		if (stylus.pressure_levels > 0)
+			m_pressure_levels = stylus.pressure_levels;
+
+		if (m_pressure_levels == 0 || m_stylus->active())
+			return;
+
+		if (casts::to<i32>(m_pressure_levels) == m_stylus->max_pressure())
+			return;
+
+		spdlog::info("The stylus reports {} levels of pressure, recreating stylus device",
+		             m_pressure_levels);
+
+		this->recreate_stylus();
+	}
+
+	/*!
+	 * Replaces the stylus device with a new one, which keeps its enabled state.
+	 *
+	 * If the new device can't be created, the stylus is disabled from then on.
+	 */
+	void recreate_stylus()
+	{
+		const bool enabled = m_stylus->enabled();
+
+		core::Config config = m_config;
+		config.stylus_disable_tilt = config.stylus_disable_tilt || !m_tilt;
+
+		if (config.stylus_max_pressure == 0)
+			config.stylus_max_pressure = m_pressure_levels;
+
+		try {
+			m_stylus.emplace(config,
+			                 m_info,
+			                 m_stylus_record,
+			                 m_eraser_record,
+			                 m_scroll_record);
+		} catch (const std::exception &e) {
+			spdlog::error("Failed to recreate stylus device: {}", e.what());
+
+			// Without a stylus, nothing would enable the touchscreen again.
+			if (m_blocked_by_stylus) {
+				m_blocked_by_stylus = false;
+				this->set_touch_enabled(true, false);
+			}
+
+			return;
+		}
+
+		if (!enabled)
+			m_stylus->disable();
+	}
+
+	/*!
+	 * Enables or disables the touch device, or the pointer device that replaces it.
+	 *
+	 * Touch inputs are always suppressed in software. Additionally the device can be told to
+	 * stop sending touch data. If the device doesn't support this, or fails to do it,
+	 * only the software suppression is used.
+	 *
+	 * @param[in] enabled Whether touch inputs should be processed.
+	 * @param[in] firmware Whether the device should stop sending touch data when disabling.
+	 */
+	void set_touch_enabled(const bool enabled, const bool firmware)
+	{
+		if (m_touch.has_value() && enabled)
+			m_touch->enable();
+		else if (m_touch.has_value())
+			m_touch->disable();
+
+		if (m_pointer.has_value() && enabled)
+			m_pointer->enable();
+		else if (m_pointer.has_value())
+			m_pointer->disable();
+
+		if (!this->set_hardware_touch)
+			return;
+
+		if (enabled && m_firmware_disabled)
+			m_firmware_disabled = !this->set_hardware_touch(true);
+		else if (!enabled && firmware && !m_firmware_disabled)
+			m_firmware_disabled = this->set_hardware_touch(false);
+	}
+
+	/*!
+	 * Whether touch inputs are processed, by the touch device or the pointer device.
+	 *
+	 * @return true if the device that emits touch inputs is enabled.
+	 */
+	[[nodiscard]] bool touch_enabled() const
+	{
+		if (m_touch.has_value())
+			return m_touch->enabled();
+
+		return m_pointer.has_value() && m_pointer->enabled();
+	}
+
+	/*!
+	 * Checks whether the events of the devices reach anyone, from time to time.
+	 *
+	 * This is only done while inputs are emitted, since only then missing events are noticed.
+	 */
+	void check_consumers()
+	{
+		if (!m_consumers.due())
+			return;
+
+		std::map<std::string, std::filesystem::path> nodes {};
+
+		const auto add = [&](const std::string &name, const auto &device) {
+			if (!device.has_value())
+				return;
+
+			const std::optional<std::filesystem::path> node = device->node();
+
+			if (node.has_value())
+				nodes[name] = node.value();
+		};
+
+		add("touch", m_touch);
+		add("pointer", m_pointer);
+		add("stylus", m_stylus);
+
+		m_consumers.check(nodes);
+	}
+
+	/*!
+	 * Applies the touch policy for the current posture of the device.
+	 *
+	 * If the switch can't be read anymore, the posture will be ignored from then on.
+	 */
+	void update_tablet_mode()
+	{
+		if (!m_tablet_mode)
+			return;
+
+		try {
+			m_touch->set_tablet_mode(m_tablet_mode->active());
+		} catch (const std::exception &e) {
+			spdlog::warn("Failed to read tablet mode switch: {}", e.what());
+
+			m_tablet_mode = nullptr;
+			m_touch->set_tablet_mode(false);
+		}
 	}
 };
 
@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_EMIT_ERRORS_HPP
+#define IPTSD_APPS_DAEMON_EMIT_ERRORS_HPP
+
+#include "errors.hpp"
+
+#include <common/chrono.hpp>
+#include <common/error.hpp>
+#include <common/throttle.hpp>
+#include <common/types.hpp>
+#include <core/generic/config.hpp>
+
+#include <spdlog/spdlog.h>
+
+#include <algorithm>
+#include <exception>
+#include <optional>
+#include <string>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * Decides what happens when the events of a stylus sample can't be written.
+ *
+ * The sample is dropped. If enabled and the device is gone, the stylus device is created
+ * again. Other errors, like a full buffer, are temporary, so the device is kept.
+ *
+ * The device is created again at most once per backoff, which doubles until a sample
+ * was written successfully. Warnings about dropped samples are printed at most once
+ * per second.
+ */
+class EmitErrors {
+private:
+	// How long to wait before the stylus device is created again after it failed once.
+	constexpr static chrono::steady_clock::duration BACKOFF_MIN = 100ms;
+
+	// The longest time between two attempts to create the stylus device again.
+	constexpr static chrono::steady_clock::duration BACKOFF_MAX = 30s;
+
+private:
+	// Whether the stylus device is created again if its events can't be written.
+	bool m_recreate = true;
+
+	// Whether a stylus sample whose events can't be written is passed on as an error.
+	bool m_abort = false;
+
+	// How often the events of the stylus could not be written.
+	usize m_errors = 0;
+
+	// Limits the warnings about dropped stylus samples to one per second.
+	common::Throttle m_warnings {};
+
+	// How long to wait before the stylus device is created again. Doubles with every attempt.
+	chrono::steady_clock::duration m_backoff = BACKOFF_MIN;
+
+	// When the stylus device can be created again.
+	chrono::steady_clock::time_point m_recreate_after {};
+
+public:
+	EmitErrors(const core::Config &config)
+	{
+		const std::string &policy = config.stylus_emit_errors;
+
+		if (policy == "drop") {
+			m_recreate = false;
+		} else if (policy == "abort") {
+			m_recreate = false;
+			m_abort = true;
+		} else if (policy != "recreate") {
+			throw common::Error<Error::InvalidEmitErrorPolicy> {};
+		}
+	}
+
+	/*!
+	 * Whether errors are passed on instead of dropping the sample.
+	 *
+	 * @return true if the daemon should stop when the events can't be written.
+	 */
+	[[nodiscard]] bool abort() const
+	{
+		return m_abort;
+	}
+
+	/*!
+	 * Registers a stylus sample whose events could not be written.
+	 *
+	 * @param[in] error The error that occurred while writing the events.
+	 * @param[in] gone Whether writing failed because the device is gone.
+	 * @return true if the stylus device should be created again.
+	 */
+	bool failed(const std::exception &error, const bool gone)
+	{
+		m_errors++;
+
+		const auto now = chrono::steady_clock::now();
+
+		if (gone && m_recreate && now >= m_recreate_after) {
+			spdlog::warn("Recreating stylus device: {}", error.what());
+
+			m_recreate_after = now + m_backoff;
+			m_backoff = std::min(m_backoff * 2, BACKOFF_MAX);
+
+			return true;
+		}
+
+		const std::optional<u64> dropped = m_warnings.add();
+
+		if (!dropped.has_value())
+			return false;
+
+		spdlog::warn("Dropped {} stylus samples: {}", dropped.value(), error.what());
+
+		return false;
+	}
+
+	/*!
+	 * Registers a stylus sample whose events were written.
+	 */
+	void succeeded()
+	{
+		m_backoff = BACKOFF_MIN;
+	}
+
+	/*!
+	 * How often the events of the stylus could not be written.
+	 *
+	 * @return The number of stylus samples that failed.
+	 */
+	[[nodiscard]] usize count() const
+	{
+		return m_errors;
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_EMIT_ERRORS_HPP
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_ERRORS_HPP
+#define IPTSD_APPS_DAEMON_ERRORS_HPP
+
+#include <common/types.hpp>
+
+#include <string>
+
+namespace iptsd::apps::daemon {
+
+enum class Error : u8 {
+	MissingCapability,
+	IncompatibleAxis,
+	InvalidKeyCombo,
+	InvalidRemoteAddress,
+	InvalidRemoteMessage,
+	MissingRemoteToken,
+	InvalidCurve,
+	InvalidRangePolicy,
+	InvalidEmitErrorPolicy,
+	InvalidMultitouchProtocol,
+};
+
+inline std::string format_as(Error err)
+{
+	switch (err) {
+	case Error::MissingCapability:
+		return "daemon: {} does not support event type {} with code {}!";
+	case Error::IncompatibleAxis:
+		return "daemon: Axis {} of {} has range {} to {}, but {} to {} is required!";
+	case Error::InvalidKeyCombo:
+		return "daemon: Invalid key combination {}, expected key codes separated by +!";
+	case Error::InvalidRemoteAddress:
+		return "daemon: Invalid remote address {}, expected HOST:PORT!";
+	case Error::InvalidRemoteMessage:
+		return "daemon: Received an invalid message from the remote: {}";
+	case Error::MissingRemoteToken:
+		return "daemon: A token is required, so that only trusted senders are accepted!";
+	case Error::InvalidCurve:
+		return "daemon: Invalid curve {}, expected linear, log or points like 0:0,1:1!";
+	case Error::InvalidRangePolicy:
+		return "daemon: The selected out of range policy is invalid!";
+	case Error::InvalidEmitErrorPolicy:
+		return "daemon: The selected emit error policy is invalid!";
+	case Error::InvalidMultitouchProtocol:
+		return "daemon: Invalid multitouch protocol {}, expected A or B!";
+	default:
+		return "daemon: Invalid error code!";
+	}
+}
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_ERRORS_HPP
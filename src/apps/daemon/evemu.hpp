@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_EVEMU_HPP
+#define IPTSD_APPS_DAEMON_EVEMU_HPP
+
+#include <common/casts.hpp>
+#include <common/chrono.hpp>
+#include <common/types.hpp>
+
+#include <fmt/format.h>
+#include <spdlog/spdlog.h>
+
+#include <linux/input.h>
+
+#include <array>
+#include <filesystem>
+#include <fstream>
+#include <map>
+#include <optional>
+#include <string>
+#include <vector>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * Records the description and the events of a device in the format of evemu.
+ *
+ * The recording can be replayed with evemu-play, after creating the device with evemu-device.
+ * Event times start at zero with the first event.
+ */
+class EvemuRecorder {
+private:
+	struct Axis {
+		i32 min;
+		i32 max;
+		i32 fuzz;
+		i32 res;
+	};
+
+	// The file that the recording is written to.
+	std::filesystem::path m_path;
+	std::ofstream m_file;
+
+	// The bits of all event types, indexed by the event type (0 for the types themselves).
+	std::map<u16, std::vector<u8>> m_bits {};
+
+	// The properties of the device.
+	std::array<u8, (INPUT_PROP_MAX / 8) + 1> m_props {};
+
+	// The axes of the device.
+	std::map<u16, Axis> m_axes {};
+
+	// When the first event was recorded.
+	std::optional<chrono::steady_clock::time_point> m_start = std::nullopt;
+
+public:
+	EvemuRecorder(std::filesystem::path path) : m_path {std::move(path)}, m_file {m_path} {};
+
+	/*!
+	 * Records that the device supports an event.
+	 *
+	 * @param[in] type The event type, or 0 to record the event type itself.
+	 * @param[in] code The event code.
+	 */
+	void set_bit(const u16 type, const u16 code)
+	{
+		std::vector<u8> &bits = m_bits[type];
+
+		if (bits.size() <= code / 8U)
+			bits.resize((code / 8U) + 1);
+
+		bits[code / 8U] |= casts::to<u8>(1U << (code % 8U));
+	}
+
+	/*!
+	 * Records a property of the device.
+	 *
+	 * @param[in] prop The property.
+	 */
+	void set_prop(const u16 prop)
+	{
+		if (prop / 8U < m_props.size())
+			m_props.at(prop / 8U) |= casts::to<u8>(1U << (prop % 8U));
+	}
+
+	/*!
+	 * Records an axis of the device.
+	 *
+	 * @param[in] code The axis.
+	 * @param[in] min The minimal value of the axis.
+	 * @param[in] max The maximal value of the axis.
+	 * @param[in] fuzz The fuzz of the axis.
+	 * @param[in] res The resolution of the axis.
+	 */
+	void set_abs(const u16 code, const i32 min, const i32 max, const i32 fuzz, const i32 res)
+	{
+		this->set_bit(EV_ABS, code);
+		m_axes[code] = Axis {min, max, fuzz, res};
+	}
+
+	/*!
+	 * Writes the description of the device.
+	 *
+	 * @param[in] name The name of the device.
+	 * @param[in] id The bus type, vendor, product and version of the device.
+	 */
+	void start(const std::string &name, const struct input_id &id)
+	{
+		m_file << "# EVEMU 1.3\n";
+		m_file << "N: " << name << "\n";
+		m_file << fmt::format("I: {:04x} {:04x} {:04x} {:04x}\n",
+		                      id.bustype,
+		                      id.vendor,
+		                      id.product,
+		                      id.version);
+
+		this->write_mask("P:", m_props.data(), m_props.size());
+
+		for (const auto &[type, bits] : m_bits)
+			this->write_mask(fmt::format("B: {:02x}", type), bits.data(), bits.size());
+
+		for (const auto &[code, axis] : m_axes) {
+			m_file << fmt::format("A: {:02x} {} {} {} 0 {}\n",
+			                      code,
+			                      axis.min,
+			                      axis.max,
+			                      axis.fuzz,
+			                      axis.res);
+		}
+
+		m_file.flush();
+
+		if (!m_file)
+			spdlog::warn("Failed to write {}", m_path.string());
+		else
+			spdlog::info("Recording events to {}", m_path.string());
+	}
+
+	/*!
+	 * Records an event.
+	 *
+	 * @param[in] type The event type.
+	 * @param[in] code The event code.
+	 * @param[in] value The value of the event.
+	 */
+	void event(const u16 type, const u16 code, const i32 value)
+	{
+		const auto now = chrono::steady_clock::now();
+
+		if (!m_start.has_value())
+			m_start = now;
+
+		const auto time = std::chrono::duration_cast<std::chrono::microseconds>(
+			now - m_start.value());
+
+		const auto sec = time.count() / 1000000;
+		const auto usec = time.count() % 1000000;
+
+		m_file << fmt::format("E: {}.{:06} {:04x} {:04x} {}\n",
+		                      sec,
+		                      usec,
+		                      type,
+		                      code,
+		                      value);
+
+		// Flush every frame, so that the recording is complete up to the last frame.
+		if (type == EV_SYN && code == SYN_REPORT)
+			m_file.flush();
+	}
+
+private:
+	/*!
+	 * Writes a bitmask, in lines of eight bytes.
+	 *
+	 * @param[in] prefix What every line starts with.
+	 * @param[in] data The bytes of the mask.
+	 * @param[in] size How many bytes the mask has.
+	 */
+	void write_mask(const std::string &prefix, const u8 *data, const usize size)
+	{
+		for (usize i = 0; i < size; i += 8) {
+			m_file << prefix;
+
+			// Lines are padded with zeros, because evemu always reads eight bytes.
+			for (usize j = i; j < i + 8; j++)
+				m_file << fmt::format(" {:02x}", j < size ? data[j] : 0);
+
+			m_file << "\n";
+		}
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_EVEMU_HPP
@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_KEY_COMBO_HPP
+#define IPTSD_APPS_DAEMON_KEY_COMBO_HPP
+
+#include "errors.hpp"
+
+#include <common/casts.hpp>
+#include <common/error.hpp>
+#include <common/types.hpp>
+#include <core/linux/syscalls.hpp>
+
+#include <spdlog/spdlog.h>
+
+#include <linux/input.h>
+
+#include <algorithm>
+#include <atomic>
+#include <csignal>
+#include <exception>
+#include <fcntl.h>
+#include <filesystem>
+#include <functional>
+#include <pthread.h>
+#include <set>
+#include <sstream>
+#include <string>
+#include <thread>
+#include <utility>
+#include <vector>
+
+namespace iptsd::apps::daemon {
+
+/*!
+ * Parses a key combination.
+ *
+ * @param[in] combo The key codes of the combination, separated by a plus, e.g. "29+56+20".
+ * @return The key codes of the combination.
+ */
+inline std::vector<u16> parse_key_combo(const std::string &combo)
+{
+	std::vector<u16> keys {};
+
+	std::istringstream stream {combo};
+	std::string key {};
+
+	while (std::getline(stream, key, '+')) {
+		try {
+			const int code = std::stoi(key);
+
+			if (code <= 0 || code > KEY_MAX)
+				throw common::Error<Error::InvalidKeyCombo> {combo};
+
+			keys.push_back(casts::to<u16>(code));
+		} catch (const std::logic_error & /* unused */) {
+			throw common::Error<Error::InvalidKeyCombo> {combo};
+		}
+	}
+
+	if (keys.empty())
+		throw common::Error<Error::InvalidKeyCombo> {combo};
+
+	return keys;
+}
+
+/*
+ * Watches an evdev device for a key combination, on a separate thread.
+ *
+ * The device is read on its own thread because the touch device stops sending data
+ * when nothing touches the screen, so the combination can't be checked between buffers.
+ */
+class KeyComboListener {
+private:
+	// How long the thread waits for events before checking if it should stop, in milliseconds.
+	constexpr static int POLL_TIMEOUT = 200;
+
+	// The file descriptor of the open evdev node.
+	int m_fd;
+
+	// The keys that have to be pressed at the same time.
+	std::vector<u16> m_keys;
+
+	// The function that is called when the combination was pressed.
+	std::function<void()> m_callback;
+
+	// Whether the thread should stop.
+	std::atomic_bool m_should_stop = false;
+
+	// The thread that reads from the device.
+	std::thread m_thread {};
+
+public:
+	KeyComboListener(const std::filesystem::path &path,
+	                 std::vector<u16> keys,
+	                 std::function<void()> callback)
+		: m_fd {core::linux::syscalls::open(path, O_RDONLY | O_NONBLOCK)},
+		  m_keys {std::move(keys)},
+		  m_callback {std::move(callback)}
+	{
+		m_thread = std::thread {[&]() { this->listen(); }};
+	}
+
+	KeyComboListener(const KeyComboListener &) = delete;
+	KeyComboListener &operator=(const KeyComboListener &) = delete;
+
+	~KeyComboListener()
+	{
+		m_should_stop = true;
+
+		if (m_thread.joinable())
+			m_thread.join();
+
+		try {
+			core::linux::syscalls::close(m_fd);
+		} catch (const std::exception & /* unused */) {
+			// ignored
+		}
+	}
+
+private:
+	/*!
+	 * Reads key events until the listener is destroyed or the device fails.
+	 */
+	void listen()
+	{
+		// Signals should be handled by the main thread, which reads from the touch device.
+		sigset_t signals {};
+		sigfillset(&signals);
+		pthread_sigmask(SIG_BLOCK, &signals, nullptr);
+
+		std::set<u16> pressed {};
+
+		// Whether the combination is being held down, so it only triggers once.
+		bool held = false;
+
+		try {
+			while (!m_should_stop) {
+				if (!core::linux::syscalls::poll(m_fd, POLL_TIMEOUT))
+					continue;
+
+				struct input_event event {};
+				core::linux::syscalls::read(m_fd, event);
+
+				if (event.type != EV_KEY)
+					continue;
+
+				// Key repeats (value 2) don't change the state.
+				if (event.value == 1)
+					pressed.insert(event.code);
+				else if (event.value == 0)
+					pressed.erase(event.code);
+
+				const auto is_pressed = [&](u16 key) { return pressed.count(key) > 0; };
+				const bool complete = std::all_of(m_keys.cbegin(), m_keys.cend(), is_pressed);
+
+				if (complete && !held)
+					m_callback();
+
+				held = complete;
+			}
+		} catch (const std::exception &e) {
+			spdlog::warn("Stopped listening for the key combination: {}", e.what());
+		}
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_KEY_COMBO_HPP
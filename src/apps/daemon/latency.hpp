@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_LATENCY_HPP
+#define IPTSD_APPS_DAEMON_LATENCY_HPP
+
+#include <common/casts.hpp>
+#include <common/chrono.hpp>
+#include <common/json.hpp>
+#include <common/types.hpp>
+
+#include <fmt/format.h>
+
+#include <algorithm>
+#include <cmath>
+#include <limits>
+#include <string>
+#include <vector>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * Collects how long it took to turn buffers into input events.
+ *
+ * This only covers the time spent in iptsd, from receiving a buffer until all of its events
+ * were written to the input devices. Everything after that (the kernel, the compositor and
+ * the application) is not included.
+ */
+class Latency {
+private:
+	// How many measurements are kept at most, to limit the memory used by long runs.
+	constexpr static usize MAX_SAMPLES = 1000000;
+
+private:
+	// The measured latencies, in microseconds.
+	std::vector<u32> m_samples {};
+
+	// How many buffers were measured, including the ones that were not kept.
+	usize m_count = 0;
+
+	// The highest latency that was measured, in microseconds.
+	u32 m_max = 0;
+
+public:
+	/*!
+	 * Adds a measurement.
+	 *
+	 * @param[in] latency How long it took until the events of a buffer were written.
+	 */
+	void add(const chrono::steady_clock::duration latency)
+	{
+		const auto us = chrono::duration_cast<microseconds<i64>>(latency).count();
+		const i64 max = std::numeric_limits<u32>::max();
+		const u32 value = casts::to<u32>(std::clamp<i64>(us, 0, max));
+
+		m_count++;
+		m_max = std::max(m_max, value);
+
+		if (m_samples.size() < MAX_SAMPLES)
+			m_samples.push_back(value);
+	}
+
+	/*!
+	 * The distribution of the measured latencies.
+	 *
+	 * @return A JSON object with the percentiles of the latency, in microseconds.
+	 */
+	[[nodiscard]] common::Json json() const
+	{
+		common::Json json {};
+		json.add("frames", m_count)
+			.add("p50", this->percentile(0.50))
+			.add("p95", this->percentile(0.95))
+			.add("p99", this->percentile(0.99))
+			.add("max", m_max);
+
+		return json;
+	}
+
+	/*!
+	 * A short human readable summary of the distribution.
+	 *
+	 * @return The percentiles of the latency.
+	 */
+	[[nodiscard]] std::string summary() const
+	{
+		return fmt::format("{} frames, p50 {}μs, p95 {}μs, p99 {}μs, max {}μs",
+		                   m_count,
+		                   this->percentile(0.50),
+		                   this->percentile(0.95),
+		                   this->percentile(0.99),
+		                   m_max);
+	}
+
+private:
+	/*!
+	 * Finds the latency that the given share of measurements doesn't exceed.
+	 *
+	 * @param[in] share The share of measurements, between 0 and 1.
+	 * @return The percentile, in microseconds. 0 if nothing was measured.
+	 */
+	[[nodiscard]] u32 percentile(const f64 share) const
+	{
+		if (m_samples.empty())
+			return 0;
+
+		std::vector<u32> samples = m_samples;
+
+		const f64 position = share * casts::to<f64>(samples.size() - 1);
+		const auto nth = samples.begin() + casts::to<isize>(std::round(position));
+
+		std::nth_element(samples.begin(), nth, samples.end());
+		return *nth;
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_LATENCY_HPP
@@ -1,9 +1,15 @@
 // SPDX-License-Identifier: GPL-2.0-or-later
 
 #include "daemon.hpp"
+#include "key-combo.hpp"
 
+#include <common/buildopts.hpp>
+#include <common/chrono.hpp>
+#include <common/error.hpp>
 #include <common/types.hpp>
+#include <core/generic/commands.hpp>
 #include <core/linux/device/hidraw.hpp>
+#include <core/linux/errors.hpp>
 #include <core/linux/runner.hpp>
 #include <core/linux/signal-handler.hpp>
 
@@ -15,7 +21,9 @@
 #include <cstdlib>
 #include <exception>
 #include <filesystem>
+#include <optional>
 #include <string>
+#include <sysexits.h>
 
 namespace iptsd::apps::daemon {
 namespace {
@@ -23,6 +31,7 @@ namespace {
 int run(const int argc, const char **argv)
 {
 	CLI::App app {"Daemon to translate touchscreen inputs to Linux input events"};
+	app.set_version_flag("-v,--version", std::string {common::buildopts::Version});
 
 	std::filesystem::path path {};
 	app.add_option("DEVICE", path)
@@ -30,14 +39,104 @@ int run(const int argc, const char **argv)
 		->type_name("FILE")
 		->required();
 
+	std::filesystem::path state {};
+	app.add_option("-s,--state", state)
+		->description("Where the internal state is written to when receiving SIGUSR1")
+		->type_name("FILE");
+
+	std::filesystem::path events {};
+	app.add_option("-e,--events", events)
+		->description("A unix socket that streams the processed inputs as JSON")
+		->type_name("FILE");
+
+	std::filesystem::path record {};
+	app.add_option("-r,--record", record)
+		->description("A directory where the emitted events are recorded, in evemu format")
+		->type_name("DIR")
+		->check(CLI::ExistingDirectory);
+
+	std::filesystem::path journal {};
+	app.add_option("-j,--journal", journal)
+		->description("Where the last buffers are written to after errors")
+		->type_name("DIR")
+		->check(CLI::ExistingDirectory);
+
+	usize journal_buffers = 100;
+	app.add_option("--journal-buffers", journal_buffers)
+		->description("How many buffers are kept for the journal (default: 100)")
+		->type_name("N");
+
+	usize journal_anomalies = 0;
+	app.add_option("--journal-anomalies", journal_anomalies)
+		->description("Also write the journal for unusual data, until the directory has N")
+		->type_name("N");
+
+	bool latency = false;
+	app.add_flag("-l,--latency", latency)
+		->description("Measure how long it takes to emit the inputs of a buffer");
+
+	core::linux::Handshake handshake {};
+
+	f64 handshake_timeout = handshake.timeout.count();
+	app.add_option("--handshake-timeout", handshake_timeout)
+		->description("How long the device has to answer after starting (default: 10)")
+		->type_name("SECONDS")
+		->check(CLI::PositiveNumber);
+
+	app.add_option("--handshake-retries", handshake.retries)
+		->description("How often to ask the device again if it doesn't answer (default: 3)")
+		->type_name("N");
+
 	CLI11_PARSE(app, argc, argv);
 
+	handshake.timeout = seconds<f64> {handshake_timeout};
+
+	if (state.empty()) {
+		const std::string name = "iptsd-" + path.filename().string() + ".json";
+		state = std::filesystem::temp_directory_path() / name;
+	}
+
 	// Create a daemon application that reads from a device.
-	core::linux::Runner<Daemon, core::linux::device::Hidraw> daemon {path};
+	core::linux::Runner<Daemon, core::linux::device::Hidraw> daemon {path, handshake, record};
+	daemon.set_state_file(state);
+
+	if (latency)
+		daemon.set_setup([](Daemon &application) { application.measure_latency(); });
+
+	if (!events.empty())
+		daemon.set_event_socket(events);
+
+	if (!journal.empty()) {
+		daemon.set_journal(journal, journal_buffers);
+		daemon.set_anomaly_limit(journal_anomalies);
+	}
 
 	const auto _sigterm = core::linux::signal<SIGTERM>([&](int) { daemon.stop(); });
 	const auto _sigint = core::linux::signal<SIGINT>([&](int) { daemon.stop(); });
 
+	// Everything that accesses the daemon has to go through the command queue.
+	const auto _sigusr1 = core::linux::signal<SIGUSR1>([&](int) {
+		daemon.send(core::Command::DumpState);
+	});
+
+	const auto _sigusr2 = core::linux::signal<SIGUSR2>([&](int) {
+		daemon.send(core::Command::ToggleTouch);
+	});
+
+	const auto _sighup = core::linux::signal<SIGHUP>([&](int) {
+		daemon.send(core::Command::Reload);
+	});
+
+	const core::Config &config = daemon.application().config();
+	std::optional<KeyComboListener> toggle = std::nullopt;
+
+	// Sending a command wakes up the main thread, even if it is waiting for the device.
+	if (!config.touchscreen_toggle_device.empty()) {
+		toggle.emplace(config.touchscreen_toggle_device,
+		               parse_key_combo(config.touchscreen_toggle_keys),
+		               [&]() { daemon.send(core::Command::ToggleTouch); });
+	}
+
 	if (!daemon.run())
 		return EXIT_FAILURE;
 
@@ -53,6 +152,11 @@ int main(const int argc, const char **argv)
 
 	try {
 		return iptsd::apps::daemon::run(argc, argv);
+	} catch (const iptsd::common::Error<iptsd::core::linux::Error::HandshakeFailed> &e) {
+		spdlog::error(e.what());
+
+		// The firmware might still be initializing, let the service manager try again.
+		return EX_TEMPFAIL;
 	} catch (const std::exception &e) {
 		spdlog::error(e.what());
 		return EXIT_FAILURE;
@@ -0,0 +1,299 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_POINTER_HPP
+#define IPTSD_APPS_DAEMON_POINTER_HPP
+
+#include "uinput-device.hpp"
+#include "wheel.hpp"
+
+#include <common/casts.hpp>
+#include <common/chrono.hpp>
+#include <common/types.hpp>
+#include <contacts/contact.hpp>
+#include <core/generic/config.hpp>
+#include <core/generic/device.hpp>
+
+#include <linux/input-event-codes.h>
+
+#include <algorithm>
+#include <cmath>
+#include <filesystem>
+#include <map>
+#include <memory>
+#include <optional>
+#include <vector>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * Emits touch inputs as relative pointer events, like a large touchpad.
+ *
+ * One finger moves the pointer, a short tap clicks and two fingers scroll.
+ */
+class PointerDevice {
+private:
+	// How long a tap can take at most to be registered as a click.
+	constexpr static auto TAP_TIMEOUT = 200ms;
+
+	// How many centimeters a contact can move at most during a tap.
+	constexpr static f64 TAP_DISTANCE = 0.2;
+
+	// How many hi-res wheel units one millimeter of scrolling produces.
+	constexpr static f64 SCROLL_SPEED = 12;
+
+private:
+	std::shared_ptr<UinputDevice> m_uinput;
+
+	// The daemon configuration.
+	core::Config m_config;
+
+	// The positions of the contacts in the last frame, in centimeters.
+	std::map<usize, Vector2<f64>> m_last {};
+
+	// The movement that was too small to be emitted yet, in pointer units.
+	Vector2<f64> m_motion = Vector2<f64>::Zero();
+
+	// Emits the scrolling of two fingers.
+	ScrollWheel m_wheel {};
+
+	// When the first contact of the current gesture was registered.
+	std::optional<chrono::steady_clock::time_point> m_start = std::nullopt;
+
+	// How far the contacts of the current gesture have moved in total, in centimeters.
+	f64 m_distance = 0;
+
+	// The highest number of contacts that were active during the current gesture.
+	usize m_max_contacts = 0;
+
+	// Whether contacts are translated into events.
+	bool m_enabled = true;
+
+public:
+	PointerDevice(const core::Config &config,
+	              const core::DeviceInfo &info,
+	              const std::filesystem::path &record = {})
+		: m_uinput {open_uinput_device(config.touchscreen_output_device, record)},
+		  m_config {config}
+	{
+		m_uinput->set_name("Pointer");
+		m_uinput->set_vendor(info.vendor);
+		m_uinput->set_product(info.product);
+
+		m_uinput->set_evbit(EV_REL);
+		m_uinput->set_evbit(EV_KEY);
+
+		m_uinput->set_relbit(REL_X);
+		m_uinput->set_relbit(REL_Y);
+		ScrollWheel::setup(*m_uinput);
+
+		m_uinput->set_keybit(BTN_LEFT);
+
+		m_uinput->set_propbit(INPUT_PROP_POINTER);
+
+		m_uinput->create();
+	}
+
+	/*!
+	 * Translates a frame of detected contacts into pointer events.
+	 *
+	 * @param[in] contacts All currently active contacts.
+	 */
+	void update(const std::vector<contacts::Contact<f64>> &contacts)
+	{
+		if (!m_enabled)
+			return;
+
+		std::map<usize, Vector2<f64>> current {};
+
+		for (const contacts::Contact<f64> &contact : contacts) {
+			if (!contact.index.has_value())
+				continue;
+
+			// Palms should neither move the pointer nor click.
+			if (!contact.valid.value_or(true))
+				continue;
+
+			const f64 x = contact.mean.x() * m_config.width;
+			const f64 y = contact.mean.y() * m_config.height;
+
+			current.emplace(contact.index.value(), Vector2<f64> {x, y});
+		}
+
+		if (current.empty()) {
+			this->finish_gesture();
+			m_last.clear();
+			return;
+		}
+
+		if (!m_start.has_value())
+			m_start = chrono::steady_clock::now();
+
+		m_max_contacts = std::max(m_max_contacts, current.size());
+
+		const Vector2<f64> delta = this->average_delta(current);
+		m_distance += delta.norm();
+
+		if (current.size() == 1)
+			this->move(delta);
+		else if (current.size() == 2)
+			this->scroll(delta);
+
+		m_last = current;
+	}
+
+	/*!
+	 * Forgets about the previous frames, without emitting any events.
+	 */
+	void reset()
+	{
+		m_last.clear();
+		m_start.reset();
+
+		m_motion = Vector2<f64>::Zero();
+		m_wheel.reset();
+
+		m_distance = 0;
+		m_max_contacts = 0;
+	}
+
+	/*!
+	 * Stops translating contacts into events, and forgets the current gesture.
+	 */
+	void disable()
+	{
+		m_enabled = false;
+
+		this->reset();
+	}
+
+	/*!
+	 * Starts translating contacts into events again.
+	 */
+	void enable()
+	{
+		m_enabled = true;
+	}
+
+	/*!
+	 * Whether the pointer device is disabled or enabled.
+	 *
+	 * @return true if contacts are translated into events.
+	 */
+	[[nodiscard]] bool enabled() const
+	{
+		return m_enabled;
+	}
+
+	/*!
+	 * The evdev node of the pointer device.
+	 *
+	 * @return The path of the node, if the device is a local one.
+	 */
+	[[nodiscard]] std::optional<std::filesystem::path> node() const
+	{
+		return m_uinput->node();
+	}
+
+private:
+	/*!
+	 * Calculates the average movement of all contacts that were present in the last frame.
+	 *
+	 * @param[in] current The positions of the contacts in the current frame.
+	 * @return The average movement in centimeters.
+	 */
+	[[nodiscard]] Vector2<f64> average_delta(const std::map<usize, Vector2<f64>> &current) const
+	{
+		Vector2<f64> sum = Vector2<f64>::Zero();
+		usize count = 0;
+
+		for (const auto &[index, position] : current) {
+			const auto last = m_last.find(index);
+
+			if (last == m_last.cend())
+				continue;
+
+			sum += position - last->second;
+			count++;
+		}
+
+		// Contacts that were added or removed in this frame don't move anything.
+		if (count == 0 || count != current.size() || count != m_last.size())
+			return Vector2<f64>::Zero();
+
+		return sum / casts::to<f64>(count);
+	}
+
+	/*!
+	 * Moves the pointer.
+	 *
+	 * @param[in] delta The movement of the finger in centimeters.
+	 */
+	void move(const Vector2<f64> &delta)
+	{
+		// Convert to millimeters
+		const Vector2<f64> mm = delta * 10;
+
+		const f64 accel = 1 + (m_config.touchscreen_pointer_acceleration * mm.norm());
+		m_motion += mm * m_config.touchscreen_pointer_speed * accel;
+
+		const i32 x = casts::to<i32>(std::trunc(m_motion.x()));
+		const i32 y = casts::to<i32>(std::trunc(m_motion.y()));
+
+		if (x == 0 && y == 0)
+			return;
+
+		m_motion.x() -= x;
+		m_motion.y() -= y;
+
+		m_uinput->emit(EV_REL, REL_X, x);
+		m_uinput->emit(EV_REL, REL_Y, y);
+		this->sync();
+	}
+
+	/*!
+	 * Scrolls vertically and horizontally.
+	 *
+	 * Scrolling is natural, the content follows the movement of the fingers.
+	 *
+	 * @param[in] delta The movement of the fingers in centimeters.
+	 */
+	void scroll(const Vector2<f64> &delta)
+	{
+		if (m_wheel.scroll(*m_uinput, delta * 10 * SCROLL_SPEED))
+			this->sync();
+	}
+
+	/*!
+	 * Ends the current gesture and emits a click if it was a tap.
+	 */
+	void finish_gesture()
+	{
+		if (!m_start.has_value())
+			return;
+
+		const auto duration = chrono::steady_clock::now() - m_start.value();
+		const bool tap = m_max_contacts == 1 && m_distance <= TAP_DISTANCE;
+
+		if (tap && duration <= TAP_TIMEOUT) {
+			m_uinput->emit(EV_KEY, BTN_LEFT, 1);
+			this->sync();
+
+			m_uinput->emit(EV_KEY, BTN_LEFT, 0);
+			this->sync();
+		}
+
+		this->reset();
+	}
+
+	/*!
+	 * Commits the emitted events to the linux kernel.
+	 */
+	void sync() const
+	{
+		m_uinput->emit(EV_SYN, SYN_REPORT, 0);
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_POINTER_HPP
@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_PREDICTION_HPP
+#define IPTSD_APPS_DAEMON_PREDICTION_HPP
+
+#include <common/chrono.hpp>
+#include <common/clock.hpp>
+#include <common/types.hpp>
+#include <common/unwrap.hpp>
+#include <core/generic/config.hpp>
+#include <ipts/samples/stylus.hpp>
+
+#include <algorithm>
+#include <optional>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * Moves the position of the stylus ahead, to where it will probably be soon.
+ *
+ * Strokes lag behind the tip of the stylus, because every step from the sensor to the screen
+ * takes time. The velocity of the tip is estimated from the samples, and the position is
+ * extrapolated linearly. Only strokes are predicted, and the prediction stops when the
+ * pressure is about to reach zero, so that strokes don't overshoot where the tip was lifted.
+ */
+class StylusPredictor {
+private:
+	using clock = chrono::steady_clock;
+
+	// How far ahead the position can be predicted at most.
+	constexpr static milliseconds<f64> MAX_HORIZON {30};
+
+	// How far the position can be moved at most, in centimeters.
+	constexpr static f64 MAX_DISTANCE = 0.5;
+
+	// How strongly new measurements of the velocity are weighted.
+	constexpr static f64 VELOCITY_WEIGHT = 0.5;
+
+	// Samples that were generated closer together than this don't update the velocity.
+	constexpr static clock::duration MIN_INTERVAL = 1ms;
+
+private:
+	// How far ahead the position is predicted.
+	seconds<f64> m_horizon;
+
+	// The size of the screen, in centimeters.
+	Vector2<f64> m_size;
+
+	// Estimates when the samples were generated.
+	common::Unwrapper<u16> m_unwrapper {};
+	common::CounterClock m_clock {};
+
+	// The last sample that updated the velocity, and when it was generated.
+	std::optional<ipts::samples::Stylus> m_last = std::nullopt;
+	clock::time_point m_last_time {};
+
+	// The velocity of the tip, in centimeters per second.
+	Vector2<f64> m_velocity = Vector2<f64>::Zero();
+
+	// How fast the pressure changes, per second.
+	f64 m_pressure_velocity = 0;
+
+public:
+	StylusPredictor(const core::Config &config)
+		: m_horizon {std::min(milliseconds<f64> {config.stylus_prediction}, MAX_HORIZON)},
+		  m_size {config.width, config.height} {};
+
+	/*!
+	 * Predicts where the stylus will be.
+	 *
+	 * @param[in] sample The current state of the stylus.
+	 * @return The state with the predicted position.
+	 */
+	ipts::samples::Stylus predict(const ipts::samples::Stylus &sample)
+	{
+		const auto now = clock::now();
+		const auto time = m_clock.input(m_unwrapper.unwrap(sample.timestamp), now);
+
+		// Strokes start exactly where the tip touched the screen.
+		if (!sample.contact || !m_last.has_value() || !m_last->contact) {
+			m_last = sample;
+			m_last_time = time;
+			m_velocity = Vector2<f64>::Zero();
+			m_pressure_velocity = 0;
+
+			return sample;
+		}
+
+		if (time - m_last_time >= MIN_INTERVAL)
+			this->update_velocity(sample, time);
+
+		const f64 horizon = m_horizon.count();
+
+		// The tip is about to be lifted, a prediction would draw a tail.
+		if (sample.pressure + m_pressure_velocity * horizon <= 0)
+			return sample;
+
+		Vector2<f64> offset = m_velocity * horizon;
+
+		if (offset.norm() > MAX_DISTANCE)
+			offset *= MAX_DISTANCE / offset.norm();
+
+		ipts::samples::Stylus predicted = sample;
+		predicted.x = std::clamp(sample.x + offset.x() / m_size.x(), 0.0, 1.0);
+		predicted.y = std::clamp(sample.y + offset.y() / m_size.y(), 0.0, 1.0);
+
+		return predicted;
+	}
+
+	/*!
+	 * Forgets the previous samples, e.g. because the stylus left proximity.
+	 */
+	void reset()
+	{
+		m_unwrapper.reset();
+		m_clock.reset();
+
+		m_last = std::nullopt;
+		m_velocity = Vector2<f64>::Zero();
+		m_pressure_velocity = 0;
+	}
+
+private:
+	/*!
+	 * Measures the velocity since the last sample.
+	 *
+	 * @param[in] sample The current state of the stylus.
+	 * @param[in] time When the sample was generated.
+	 */
+	void update_velocity(const ipts::samples::Stylus &sample, const clock::time_point time)
+	{
+		const ipts::samples::Stylus &last = m_last.value();
+		const f64 dt = seconds<f64> {time - m_last_time}.count();
+
+		const Vector2<f64> delta {(sample.x - last.x) * m_size.x(),
+		                          (sample.y - last.y) * m_size.y()};
+
+		m_velocity += VELOCITY_WEIGHT * (delta / dt - m_velocity);
+
+		const f64 pressure = (sample.pressure - last.pressure) / dt;
+		m_pressure_velocity += VELOCITY_WEIGHT * (pressure - m_pressure_velocity);
+
+		m_last = sample;
+		m_last_time = time;
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_PREDICTION_HPP
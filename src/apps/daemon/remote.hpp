@@ -0,0 +1,447 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_REMOTE_HPP
+#define IPTSD_APPS_DAEMON_REMOTE_HPP
+
+#include "errors.hpp"
+
+#include <common/casts.hpp>
+#include <common/chrono.hpp>
+#include <common/error.hpp>
+#include <common/types.hpp>
+#include <core/linux/syscalls.hpp>
+
+#include <gsl/gsl>
+#include <spdlog/spdlog.h>
+
+#include <linux/input.h>
+#include <sys/socket.h>
+
+#include <algorithm>
+#include <condition_variable>
+#include <csignal>
+#include <cstring>
+#include <exception>
+#include <memory>
+#include <mutex>
+#include <pthread.h>
+#include <stdexcept>
+#include <string>
+#include <thread>
+#include <tuple>
+#include <utility>
+#include <vector>
+
+/*
+ * Sends the events of a device to another machine, which replays them with iptsd-receive.
+ *
+ * Every message starts with a header that contains the version of the protocol, the type
+ * of the message and the length of the payload that follows it. All values are sent in the
+ * byte order of the sender, which is little endian on all devices that iptsd supports.
+ *
+ * After connecting, the sender sends a hello message with the token, followed by a setup
+ * message that describes the device. After that, each batch of events up to and including
+ * the SYN_REPORT is sent as one message.
+ */
+namespace iptsd::apps::daemon::remote {
+
+// The version of the protocol. Receivers reject senders that use a different version.
+constexpr u8 VERSION = 2;
+
+// The largest payload that is accepted, to reject garbage before allocating it.
+constexpr u32 MAX_PAYLOAD = 64 * 1024;
+
+enum class Message : u8 {
+	// The token that authenticates the sender.
+	Hello,
+
+	// The events and axes that the device supports, as a list of capabilities.
+	Setup,
+
+	// A batch of events.
+	Events,
+};
+
+struct [[gnu::packed]] Header {
+	u8 version;
+	Message message;
+	u32 length;
+};
+static_assert(sizeof(Header) == 6);
+
+/*
+ * An event type, event code or property that the device supports.
+ */
+struct [[gnu::packed]] Capability {
+	enum class Kind : u8 {
+		Event,
+		Property,
+		Key,
+		Relative,
+		Absolute,
+		Misc,
+	};
+
+	Kind kind;
+	u16 code;
+
+	// The range, resolution and fuzz of absolute axes.
+	i32 min;
+	i32 max;
+	i32 res;
+	i32 fuzz;
+};
+static_assert(sizeof(Capability) == 19);
+
+struct [[gnu::packed]] Event {
+	u16 type;
+	u16 code;
+	i32 value;
+};
+static_assert(sizeof(Event) == 8);
+
+/*!
+ * Splits an address of the form HOST:PORT.
+ *
+ * IPv6 addresses have to be enclosed in brackets, e.g. [::1]:4000.
+ *
+ * @param[in] address The address to split.
+ * @return The host and the port.
+ */
+inline std::pair<std::string, u16> parse_address(const std::string &address)
+{
+	const usize colon = address.rfind(':');
+
+	if (colon == std::string::npos || colon == 0 || colon + 1 == address.size())
+		throw common::Error<Error::InvalidRemoteAddress> {address};
+
+	std::string host = address.substr(0, colon);
+
+	if (host.front() == '[' && host.back() == ']')
+		host = host.substr(1, host.size() - 2);
+
+	try {
+		const int port = std::stoi(address.substr(colon + 1));
+
+		if (port <= 0 || port > 0xFFFF)
+			throw common::Error<Error::InvalidRemoteAddress> {address};
+
+		return {host, casts::to<u16>(port)};
+	} catch (const std::logic_error & /* unused */) {
+		throw common::Error<Error::InvalidRemoteAddress> {address};
+	}
+}
+
+/*!
+ * Sends a message over a connection.
+ *
+ * The connection must not block, a receiver that can't keep up is treated like an error.
+ *
+ * @param[in] fd The file descriptor of the connection.
+ * @param[in] message The type of the message.
+ * @param[in] payload The contents of the message.
+ * @return Whether the whole message was sent.
+ */
+template <class T>
+bool send(const int fd, const Message message, const gsl::span<const T> payload)
+{
+	const Header header {VERSION, message, casts::to<u32>(payload.size_bytes())};
+
+	std::vector<u8> buffer(sizeof(Header) + payload.size_bytes());
+
+	std::memcpy(buffer.data(), &header, sizeof(Header));
+	std::memcpy(buffer.data() + sizeof(Header), payload.data(), payload.size_bytes());
+
+	const isize ret = ::send(fd, buffer.data(), buffer.size(), MSG_DONTWAIT | MSG_NOSIGNAL);
+	return ret == casts::to_signed(buffer.size());
+}
+
+/*
+ * Forwards the events of a device to a remote receiver.
+ *
+ * If the connection fails or is lost, events are dropped and connecting is retried
+ * with an increasing delay, so that the receiver can be restarted independently.
+ *
+ * Resolving the host and connecting can block for a long time, so it happens on a separate
+ * thread. Events are only sent once that thread handed over a connection that is set up.
+ */
+class Sink {
+private:
+	// How long to wait before trying to connect again after the first failure.
+	constexpr static chrono::steady_clock::duration RETRY_MIN = 1s;
+
+	// The longest time between two attempts to connect.
+	constexpr static chrono::steady_clock::duration RETRY_MAX = 60s;
+
+	/*
+	 * The state that is shared with the thread that connects to the receiver.
+	 *
+	 * The thread is detached, because it can't be interrupted while it is blocking.
+	 * It keeps the state alive until it finishes, even if the sink is gone by then.
+	 */
+	struct Connector {
+		std::mutex mutex {};
+		std::condition_variable wakeup {};
+
+		// Whether the thread should stop.
+		bool should_stop = false;
+
+		// Whether a new connection is needed.
+		bool needed = true;
+
+		// A connection that is set up, but wasn't picked up yet, or -1.
+		int fd = -1;
+	};
+
+	// The receiver that events are sent to.
+	std::string m_host;
+	u16 m_port = 0;
+
+	// Authenticates the sender to the receiver.
+	std::string m_token;
+
+	// The file descriptor of the connection, or -1 if not connected.
+	int m_fd = -1;
+
+	// The events and axes of the device, sent after every connect.
+	std::vector<Capability> m_capabilities {};
+
+	// The events that were emitted since the last SYN_REPORT.
+	std::vector<Event> m_events {};
+
+	// The state of the thread that connects to the receiver, once it was started.
+	std::shared_ptr<Connector> m_connector = nullptr;
+
+public:
+	Sink(const std::string &address, std::string token) : m_token {std::move(token)}
+	{
+		std::tie(m_host, m_port) = parse_address(address);
+	}
+
+	Sink(const Sink &) = delete;
+	Sink &operator=(const Sink &) = delete;
+
+	~Sink()
+	{
+		this->disconnect();
+
+		if (!m_connector)
+			return;
+
+		const std::lock_guard<std::mutex> lock {m_connector->mutex};
+
+		m_connector->should_stop = true;
+		m_connector->wakeup.notify_all();
+	}
+
+	/*!
+	 * Adds something that the device supports.
+	 *
+	 * Must be called before @ref connect().
+	 *
+	 * @param[in] kind What is supported.
+	 * @param[in] code The code of the event, axis or property.
+	 * @param[in] min The minimal value of an absolute axis.
+	 * @param[in] max The maximal value of an absolute axis.
+	 * @param[in] res The resolution of an absolute axis.
+	 * @param[in] fuzz The noise that the input core filters from an absolute axis.
+	 */
+	void add(const Capability::Kind kind,
+	         const u16 code,
+	         const i32 min = 0,
+	         const i32 max = 0,
+	         const i32 res = 0,
+	         const i32 fuzz = 0)
+	{
+		m_capabilities.push_back(Capability {kind, code, min, max, res, fuzz});
+	}
+
+	/*!
+	 * Uses the connection to the receiver, if one was set up meanwhile.
+	 *
+	 * The first call starts the thread that connects to the receiver, it never blocks.
+	 */
+	void connect()
+	{
+		if (m_fd != -1)
+			return;
+
+		if (!m_connector) {
+			m_connector = std::make_shared<Connector>();
+
+			std::thread thread {run,
+			                    m_connector,
+			                    m_host,
+			                    m_port,
+			                    m_token,
+			                    m_capabilities};
+
+			thread.detach();
+			return;
+		}
+
+		const std::lock_guard<std::mutex> lock {m_connector->mutex};
+
+		if (m_connector->fd == -1)
+			return;
+
+		m_fd = std::exchange(m_connector->fd, -1);
+		spdlog::info("Sending events to {}:{}", m_host, m_port);
+	}
+
+	/*!
+	 * Forwards an event.
+	 *
+	 * The events are sent once a SYN_REPORT is emitted.
+	 *
+	 * @param[in] type The event type.
+	 * @param[in] code The key of the button or axis.
+	 * @param[in] value The value of the button or axis.
+	 */
+	void emit(const u16 type, const u16 code, const i32 value)
+	{
+		m_events.push_back(Event {type, code, value});
+
+		if (type != EV_SYN || code != SYN_REPORT)
+			return;
+
+		this->connect();
+
+		if (m_fd != -1 && !send(m_fd, Message::Events, gsl::span<const Event> {m_events})) {
+			spdlog::warn("Lost the connection to {}:{}", m_host, m_port);
+			this->disconnect();
+		}
+
+		m_events.clear();
+	}
+
+private:
+	/*!
+	 * Closes the connection and asks the thread for a new one.
+	 */
+	void disconnect()
+	{
+		if (m_fd == -1)
+			return;
+
+		close_connection(m_fd);
+		m_fd = -1;
+
+		if (!m_connector)
+			return;
+
+		const std::lock_guard<std::mutex> lock {m_connector->mutex};
+
+		m_connector->needed = true;
+		m_connector->wakeup.notify_all();
+	}
+
+	/*!
+	 * Connects to the receiver whenever a connection is needed, until it is asked to stop.
+	 *
+	 * The delay between failed attempts doubles, up to @ref RETRY_MAX.
+	 *
+	 * @param[in] connector The state that is shared with the sink.
+	 * @param[in] host The name or address of the receiver.
+	 * @param[in] port The port of the receiver.
+	 * @param[in] token Authenticates the sender to the receiver.
+	 * @param[in] capabilities The events and axes of the device.
+	 */
+	static void run(const std::shared_ptr<Connector> &connector,
+	                const std::string &host,
+	                const u16 port,
+	                const std::string &token,
+	                const std::vector<Capability> &capabilities)
+	{
+		// Signals should be handled by the main thread, which reads from the touch device.
+		sigset_t signals {};
+		sigfillset(&signals);
+		pthread_sigmask(SIG_BLOCK, &signals, nullptr);
+
+		chrono::steady_clock::duration backoff = RETRY_MIN;
+		std::unique_lock<std::mutex> lock {connector->mutex};
+
+		while (!connector->should_stop) {
+			if (!connector->needed) {
+				connector->wakeup.wait(lock);
+				continue;
+			}
+
+			lock.unlock();
+			const int fd = open_connection(host, port, token, capabilities);
+			lock.lock();
+
+			if (fd != -1) {
+				connector->fd = fd;
+				connector->needed = false;
+
+				backoff = RETRY_MIN;
+				continue;
+			}
+
+			const auto stopped = [&]() { return connector->should_stop; };
+
+			connector->wakeup.wait_for(lock, backoff, stopped);
+			backoff = std::min(backoff * 2, RETRY_MAX);
+		}
+
+		close_connection(std::exchange(connector->fd, -1));
+	}
+
+	/*!
+	 * Connects to the receiver and sends the hello and setup messages.
+	 *
+	 * @param[in] host The name or address of the receiver.
+	 * @param[in] port The port of the receiver.
+	 * @param[in] token Authenticates the sender to the receiver.
+	 * @param[in] capabilities The events and axes of the device.
+	 * @return The file descriptor of the connection, or -1 if it failed.
+	 */
+	static int open_connection(const std::string &host,
+	                           const u16 port,
+	                           const std::string &token,
+	                           const std::vector<Capability> &capabilities)
+	{
+		int fd = -1;
+
+		try {
+			fd = core::linux::syscalls::connect_tcp(host, port);
+		} catch (const std::exception &e) {
+			spdlog::warn(e.what());
+			return -1;
+		}
+
+		const gsl::span<const char> hello {token.data(), token.size()};
+		const gsl::span<const Capability> setup {capabilities};
+
+		if (!send(fd, Message::Hello, hello) || !send(fd, Message::Setup, setup)) {
+			spdlog::warn("Failed to set up the connection to {}:{}", host, port);
+
+			close_connection(fd);
+			return -1;
+		}
+
+		return fd;
+	}
+
+	/*!
+	 * Closes a connection, ignoring any errors.
+	 *
+	 * @param[in] fd The file descriptor of the connection, or -1.
+	 */
+	static void close_connection(const int fd)
+	{
+		if (fd == -1)
+			return;
+
+		try {
+			core::linux::syscalls::close(fd);
+		} catch (const std::exception & /* unused */) {
+			// ignored
+		}
+	}
+};
+
+} // namespace iptsd::apps::daemon::remote
+
+#endif // IPTSD_APPS_DAEMON_REMOTE_HPP
@@ -0,0 +1,240 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_RESAMPLER_HPP
+#define IPTSD_APPS_DAEMON_RESAMPLER_HPP
+
+#include <common/casts.hpp>
+#include <common/chrono.hpp>
+#include <common/clock.hpp>
+#include <common/types.hpp>
+#include <common/unwrap.hpp>
+#include <core/generic/config.hpp>
+#include <ipts/samples/stylus.hpp>
+
+#include <algorithm>
+#include <cmath>
+#include <functional>
+#include <optional>
+#include <thread>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * Emits stylus samples at a fixed rate, interpolated from the samples of the stylus.
+ *
+ * Some devices send their samples in bursts (e.g. 4 samples every 32ms), which makes strokes
+ * jitter in applications that expect them to be evenly spaced. The time at which every sample
+ * was generated is estimated from its counter, and the position and pressure are interpolated
+ * at evenly spaced points in that time. To spread a burst over the time it covers, samples are
+ * held back as long as the burst was late, but never longer than MAX_DELAY. Once the bursts
+ * arrive sooner again, the delay shrinks by DELAY_DECAY with every sample.
+ *
+ * The counter of the stylus is interpolated too, and it never repeats, since a repeated
+ * counter makes the estimated time of the sample start over. If the counter doesn't advance
+ * fast enough for the configured rate, interpolated samples are left out.
+ *
+ * Samples where the tip, the button, the rubber or the stylus changes are never interpolated,
+ * they are emitted as they were received, together with the sample before them. This makes
+ * sure that strokes start and end exactly where the stylus touched and left the screen.
+ */
+class StylusResampler {
+private:
+	using clock = chrono::steady_clock;
+
+	// How long samples are held back at most.
+	constexpr static clock::duration MAX_DELAY = 50ms;
+
+	// How much the delay shrinks with every sample that was less late.
+	constexpr static clock::duration DELAY_DECAY = 1ms;
+
+	// Samples that are further apart than this are not interpolated.
+	constexpr static clock::duration MAX_GAP = 50ms;
+
+private:
+	// The time between two emitted samples.
+	clock::duration m_period;
+
+	// Estimates when the samples were generated.
+	common::Unwrapper<u16> m_unwrapper {};
+	common::CounterClock m_clock {};
+
+	// The last sample, and when it was generated.
+	std::optional<ipts::samples::Stylus> m_last = std::nullopt;
+	clock::time_point m_last_time {};
+
+	// The unwrapped counter of the last sample.
+	u32 m_last_counter = 0;
+
+	// The unwrapped counter of the last sample that was emitted.
+	u32 m_emitted_counter = 0;
+
+	// Whether the last sample was emitted as it was received.
+	bool m_last_emitted = false;
+
+	// When the next interpolated sample is due, in the time of the samples.
+	clock::time_point m_next {};
+
+	// How long samples are held back after they were generated.
+	clock::duration m_delay = clock::duration::zero();
+
+public:
+	StylusResampler(const core::Config &config)
+		: m_period {chrono::duration_cast<clock::duration>(
+			  seconds<f64> {1.0 / config.stylus_resample_rate})} {};
+
+	/*!
+	 * Registers a stylus sample and emits the samples that are due until it.
+	 *
+	 * This blocks until the samples are due, i.e. at most MAX_DELAY after the sample was
+	 * generated.
+	 *
+	 * @param[in] sample The current state of the stylus.
+	 * @param[in] emit Passes a sample on to the stylus device.
+	 */
+	void update(const ipts::samples::Stylus &sample,
+	            const std::function<void(const ipts::samples::Stylus &)> &emit)
+	{
+		const auto now = clock::now();
+
+		if (!sample.proximity) {
+			this->flush(emit);
+			this->reset();
+
+			emit(sample);
+			return;
+		}
+
+		const u32 counter = m_unwrapper.unwrap(sample.timestamp);
+		const auto time = m_clock.input(counter, now);
+
+		// A late burst is held back for the same time, so that it can be spread out.
+		const auto decayed = std::max<clock::duration>(m_delay - DELAY_DECAY, 0ns);
+		m_delay = std::clamp<clock::duration>(now - time, decayed, MAX_DELAY);
+
+		const bool gap = time <= m_last_time || time - m_last_time > MAX_GAP;
+
+		if (!m_last.has_value() || gap || is_transition(m_last.value(), sample)) {
+			this->flush(emit);
+			this->wait(time);
+
+			emit(sample);
+
+			m_last = sample;
+			m_last_time = time;
+			m_last_counter = counter;
+			m_last_emitted = true;
+			m_emitted_counter = counter;
+			m_next = time + m_period;
+
+			return;
+		}
+
+		const ipts::samples::Stylus &last = m_last.value();
+		const seconds<f64> span = time - m_last_time;
+		const u32 counts = counter - m_last_counter;
+
+		for (; m_next <= time; m_next += m_period) {
+			const seconds<f64> elapsed = m_next - m_last_time;
+			const f64 t = elapsed / span;
+
+			const f64 advance = std::round(t * casts::to<f64>(counts));
+			const u32 interpolated_counter = m_last_counter + casts::to<u32>(advance);
+
+			/*
+			 * The counter must stay between the one that was emitted last and the one
+			 * of the current sample, which is emitted as it is if the stroke ends.
+			 * Devices that don't count their samples keep their counter.
+			 */
+			if (counts > 0) {
+				if (interpolated_counter <= m_emitted_counter)
+					continue;
+
+				if (interpolated_counter >= counter)
+					continue;
+			}
+
+			ipts::samples::Stylus interpolated = sample;
+			interpolated.x = last.x + t * (sample.x - last.x);
+			interpolated.y = last.y + t * (sample.y - last.y);
+			interpolated.pressure =
+				last.pressure + t * (sample.pressure - last.pressure);
+
+			// The counter is unwrapped again by the stylus device.
+			if (counts > 0) {
+				const u32 wrapped = interpolated_counter & 0xFFFF;
+				interpolated.timestamp = casts::to<u16>(wrapped);
+			}
+
+			this->wait(m_next);
+			emit(interpolated);
+
+			m_emitted_counter = interpolated_counter;
+		}
+
+		m_last = sample;
+		m_last_time = time;
+		m_last_counter = counter;
+		m_last_emitted = false;
+	}
+
+	/*!
+	 * Forgets the previous samples, e.g. because samples were lost.
+	 */
+	void reset()
+	{
+		m_unwrapper.reset();
+		m_clock.reset();
+
+		m_last = std::nullopt;
+		m_last_time = {};
+		m_delay = clock::duration::zero();
+	}
+
+private:
+	/*!
+	 * Emits the last sample as it was received, if only interpolated samples were emitted.
+	 *
+	 * @param[in] emit Passes a sample on to the stylus device.
+	 */
+	void flush(const std::function<void(const ipts::samples::Stylus &)> &emit)
+	{
+		if (!m_last.has_value() || m_last_emitted)
+			return;
+
+		this->wait(m_last_time);
+		emit(m_last.value());
+
+		m_last_emitted = true;
+		m_emitted_counter = m_last_counter;
+	}
+
+	/*!
+	 * Waits until a sample that was generated at the given time is due.
+	 *
+	 * @param[in] time When the sample was generated.
+	 */
+	void wait(const clock::time_point time) const
+	{
+		std::this_thread::sleep_until(time + m_delay);
+	}
+
+	/*!
+	 * Whether anything changed between two samples that can't be interpolated.
+	 *
+	 * @param[in] a The previous sample.
+	 * @param[in] b The current sample.
+	 * @return true if the current sample must be emitted as it is.
+	 */
+	[[nodiscard]] static bool is_transition(const ipts::samples::Stylus &a,
+	                                        const ipts::samples::Stylus &b)
+	{
+		if (a.contact != b.contact || a.button != b.button || a.rubber != b.rubber)
+			return true;
+
+		return a.serial != b.serial;
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_RESAMPLER_HPP
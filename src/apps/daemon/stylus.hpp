@@ -3,32 +3,54 @@
 #ifndef IPTSD_APPS_DAEMON_STYLUS_HPP
 #define IPTSD_APPS_DAEMON_STYLUS_HPP
 
+#include "curve.hpp"
+#include "errors.hpp"
+#include "prediction.hpp"
 #include "uinput-device.hpp"
+#include "wheel.hpp"
 
 #include <common/casts.hpp>
+#include <common/chrono.hpp>
+#include <common/clock.hpp>
+#include <common/error.hpp>
 #include <common/types.hpp>
+#include <common/unwrap.hpp>
 #include <core/generic/config.hpp>
 #include <core/generic/device.hpp>
+#include <ipts/protocol/stylus.hpp>
 #include <ipts/samples/stylus.hpp>
 
 #include <gsl/gsl>
+#include <spdlog/spdlog.h>
 
 #include <linux/input-event-codes.h>
 
+#include <algorithm>
 #include <climits>
 #include <cmath>
+#include <filesystem>
 #include <memory>
+#include <optional>
 
 namespace iptsd::apps::daemon {
 
 class StylusDevice {
+private:
+	// Intervals are measured with a clock that can't jump, unlike the time of the system.
+	using clock = chrono::steady_clock;
+
 private:
 	constexpr static usize MAX_X = 9600;
 	constexpr static usize MAX_Y = 7200;
-	constexpr static usize MAX_P = 4096;
+
+	// For how many samples in proximity an armed rubber is kept without the bit being set.
+	constexpr static usize ARM_SAMPLES = 10;
 
 private:
-	std::shared_ptr<UinputDevice> m_uinput = std::make_shared<UinputDevice>();
+	std::shared_ptr<UinputDevice> m_uinput;
+
+	// The device that the rubber is reported through, if it is separate from the pen.
+	std::shared_ptr<UinputDevice> m_eraser = nullptr;
 
 	// Whether the device is enabled.
 	bool m_enabled = true;
@@ -36,83 +58,307 @@ private:
 	// Whether the stylus is currently in proximity and sending data.
 	bool m_active = false;
 
+	// Whether the pressure is reset when the stylus is lifted.
+	bool m_instant_lift = false;
+
+	// Whether the rubber is reported as a pen with a key pressed.
+	bool m_rubber_as_pen = false;
+
+	// The key that is pressed while the rubber is used.
+	u16 m_rubber_key = BTN_STYLUS2;
+
+	// Whether a rubber bit without proximity selects the tool for entering proximity.
+	bool m_arm_rubber = false;
+
+	// For how many more samples the rubber is kept, because it was armed before proximity.
+	usize m_armed = 0;
+
+	// The maximum value of the pressure axis.
+	i32 m_max_pressure = ipts::protocol::stylus::MAX_PRESSURE_MPP_1_51;
+
+	// How the pressure of the pen and of the rubber is reported.
+	Curve m_pen_curve {};
+	Curve m_rubber_curve {};
+
+	// Whether samples outside of the screen are moved to its edge, or dropped.
+	bool m_clamp_out_of_range = true;
+	bool m_drop_out_of_range = false;
+
+	// Whether the stylus is outside of the screen, and this was already reported.
+	bool m_out_of_range = false;
+
 	// The last known state of the stylus.
 	ipts::samples::Stylus m_last;
 
+	// Turns the wrapping timestamp of the stylus into a continuously increasing value.
+	common::Unwrapper<u16> m_unwrapper {};
+
+	// The last unwrapped timestamp.
+	i32 m_timestamp = 0;
+
+	// Whether the estimated time of each sample is emitted as MSC_TIMESTAMP.
+	bool m_hardware_timestamps = false;
+
+	// Estimates when the samples were generated, from the unwrapped timestamp.
+	common::CounterClock m_clock {};
+
+	// The estimated time of the last sample, in microseconds.
+	i32 m_sample_time = 0;
+
+	// The last known tilt of the stylus, kept while the stylus sends no tilt information.
+	Vector2<i32> m_tilt = Vector2<i32>::Zero();
+
+	// Whether the tilt axes are left out of the device.
+	bool m_disable_tilt = false;
+
+	// How many samples pass at most until the emitted tilt is updated.
+	usize m_tilt_interval = 1;
+
+	// How much the tilt must change to be updated before the interval has passed, or 0.
+	i32 m_tilt_threshold = 0;
+
+	// The tilt that is being emitted, and how many samples ago it was updated.
+	Vector2<i32> m_emitted_tilt = Vector2<i32>::Zero();
+	usize m_tilt_age = 0;
+
+	// Whether the press of the tip is emitted one sample late, with a fresher position.
+	bool m_delay_contact = false;
+
+	// Whether the tip touched the screen, but the press was not emitted yet.
+	bool m_contact_pending = false;
+
+	// Moves the emitted position ahead, if enabled.
+	std::optional<StylusPredictor> m_predictor = std::nullopt;
+
+	// Whether a double tap with the tip emits a double click.
+	bool m_double_tap = false;
+
+	// The key that is clicked twice when a double tap is detected.
+	u16 m_double_tap_key = BTN_LEFT;
+
+	// How long a tap, and the pause between two taps, can take at most.
+	milliseconds<f64> m_double_tap_timeout {300};
+
+	// How many centimeters the tip can move at most during a double tap.
+	f64 m_double_tap_distance = 0.3;
+
+	// The size of the screen, in centimeters.
+	Vector2<f64> m_size = Vector2<f64>::Zero();
+
+	// When and where the tip touched the screen, if it is on the screen.
+	std::optional<clock::time_point> m_tap_start = std::nullopt;
+	Vector2<f64> m_tap_origin = Vector2<f64>::Zero();
+
+	// Whether the tip moved too far since touching the screen to be a tap.
+	bool m_tap_moved = false;
+
+	// The device that scroll events are emitted through, if the button scrolls.
+	std::shared_ptr<UinputDevice> m_scroll = nullptr;
+
+	// How many hi-res wheel units one centimeter of movement scrolls.
+	f64 m_scroll_speed = 0;
+
+	// Emits the scrolling of the stylus.
+	ScrollWheel m_wheel {};
+
+	// Where the stylus was when it scrolled the last time, if it is scrolling.
+	std::optional<Vector2<f64>> m_scroll_position = std::nullopt;
+
+	// When and where the last tap was released, if it can still become a double tap.
+	std::optional<clock::time_point> m_tap_end = std::nullopt;
+	Vector2<f64> m_tap_position = Vector2<f64>::Zero();
+
 public:
-	StylusDevice(const core::Config &config, const core::DeviceInfo &info)
+	StylusDevice(const core::Config &config,
+	             const core::DeviceInfo &info,
+	             const std::filesystem::path &record = {},
+	             const std::filesystem::path &eraser_record = {},
+	             const std::filesystem::path &scroll_record = {})
+		: m_uinput {open_stylus_device(config, record)},
+		  m_instant_lift {config.stylus_instant_lift},
+		  m_rubber_as_pen {config.stylus_rubber_as_pen && !config.stylus_separate_rubber},
+		  m_rubber_key {config.stylus_rubber_key},
+		  m_arm_rubber {config.stylus_arm_rubber},
+		  m_max_pressure {config.stylus_max_pressure > 0
+		                          ? casts::to<i32>(config.stylus_max_pressure)
+		                          : ipts::protocol::stylus::MAX_PRESSURE_MPP_1_51},
+		  m_pen_curve {Curve::parse(config.stylus_pressure_curve)},
+		  m_rubber_curve {m_pen_curve},
+		  m_hardware_timestamps {config.stylus_hardware_timestamps},
+		  m_disable_tilt {config.stylus_disable_tilt},
+		  m_tilt_interval {std::max<usize>(config.stylus_tilt_interval, 1)},
+		  m_tilt_threshold {casts::to<i32>(std::round(config.stylus_tilt_threshold * 100))},
+		  m_tilt_age {m_tilt_interval},
+		  m_delay_contact {config.stylus_delay_contact},
+		  m_double_tap {config.stylus_double_tap},
+		  m_double_tap_key {config.stylus_double_tap_key},
+		  m_double_tap_timeout {config.stylus_double_tap_timeout},
+		  m_double_tap_distance {config.stylus_double_tap_distance},
+		  m_size {config.width, config.height},
+		  m_scroll_speed {config.stylus_button_scroll_speed * ScrollWheel::WHEEL_NOTCH}
 	{
-		m_uinput->set_name("Stylus");
-		m_uinput->set_vendor(info.vendor);
-		m_uinput->set_product(info.product);
+		if (!config.stylus_rubber_pressure_curve.empty())
+			m_rubber_curve = Curve::parse(config.stylus_rubber_pressure_curve);
+
+		// Profiles are only applied once their stylus is seen, so check their curves now.
+		for (const core::StylusProfile &profile : config.profiles)
+			Curve::parse(profile.pressure_curve);
 
-		m_uinput->set_evbit(EV_KEY);
-		m_uinput->set_evbit(EV_ABS);
+		if (config.stylus_prediction > 0)
+			m_predictor.emplace(config);
 
-		m_uinput->set_propbit(INPUT_PROP_DIRECT);
-		m_uinput->set_propbit(INPUT_PROP_POINTER);
+		const std::string &policy = config.stylus_out_of_range;
+
+		if (policy == "drop") {
+			m_clamp_out_of_range = false;
+			m_drop_out_of_range = true;
+		} else if (policy == "pass") {
+			m_clamp_out_of_range = false;
+		} else if (policy != "clamp") {
+			throw common::Error<Error::InvalidRangePolicy> {};
+		}
+
+		m_uinput->set_name("Stylus");
+		this->setup(*m_uinput, config, info);
 
-		m_uinput->set_keybit(BTN_TOUCH);
-		m_uinput->set_keybit(BTN_STYLUS);
 		m_uinput->set_keybit(BTN_TOOL_PEN);
-		m_uinput->set_keybit(BTN_TOOL_RUBBER);
 
-		// Resolution for X / Y is expected to be units/mm.
-		const i32 res_x = casts::to<i32>(std::round(MAX_X / (config.width * 10)));
-		const i32 res_y = casts::to<i32>(std::round(MAX_Y / (config.height * 10)));
+		if (!config.stylus_separate_rubber)
+			m_uinput->set_keybit(BTN_TOOL_RUBBER);
 
-		// Resolution for tilt is expected to be units/radian.
-		const i32 res_tilt = casts::to<i32>(std::round(18000.0 / M_PI));
+		if (m_rubber_as_pen)
+			m_uinput->set_keybit(m_rubber_key);
 
-		m_uinput->set_absinfo(ABS_X, 0, MAX_X, res_x);
-		m_uinput->set_absinfo(ABS_Y, 0, MAX_Y, res_y);
-		m_uinput->set_absinfo(ABS_PRESSURE, 0, MAX_P, 0);
-		m_uinput->set_absinfo(ABS_TILT_X, -9000, 9000, res_tilt);
-		m_uinput->set_absinfo(ABS_TILT_Y, -9000, 9000, res_tilt);
-		m_uinput->set_absinfo(ABS_MISC, 0, USHRT_MAX, 0);
+		if (m_double_tap)
+			m_uinput->set_keybit(m_double_tap_key);
 
 		m_uinput->create();
+
+		if (config.stylus_button_scroll)
+			this->create_scroll_device(info, scroll_record);
+
+		if (!config.stylus_separate_rubber)
+			return;
+
+		// Remote receivers only serve one device, so the eraser is always created locally.
+		m_eraser = open_uinput_device({}, eraser_record);
+
+		m_eraser->set_name("Eraser");
+		this->setup(*m_eraser, config, info);
+
+		m_eraser->set_keybit(BTN_TOOL_RUBBER);
+		m_eraser->create();
 	}
 
 	/*!
 	 * Passes stylus data to the linux kernel.
 	 *
-	 * @param[in] data The current state of the stylus.
+	 * @param[in] sample The current state of the stylus.
 	 */
-	void update(const ipts::samples::Stylus &data)
+	void update(const ipts::samples::Stylus &sample)
 	{
+		ipts::samples::Stylus data = m_arm_rubber ? this->arm_rubber(sample) : sample;
+
+		// Out of range samples are dropped before they can change any state.
+		if (data.proximity && !this->check_range(data))
+			return;
+
 		m_active = data.proximity;
 
 		// Switching tools within one frame causes issues, lift the stylus for one frame.
 		if (m_last.rubber != data.rubber)
 			m_active = false;
 
+		// Taps of one stylus must not be combined with taps of another one.
+		if (m_last.serial != data.serial) {
+			this->reset_tap();
+			m_scroll_position = std::nullopt;
+
+			if (m_predictor.has_value())
+				m_predictor->reset();
+		}
+
+		const clock::time_point now = clock::now();
+		const bool double_tap = m_double_tap && this->detect_double_tap(data, now);
+
 		if (m_active) {
-			const Vector2<i32> tilt = calculate_tilt(data.altitude, data.azimuth);
+			const u32 counter = m_unwrapper.unwrap(data.timestamp);
 
-			const i32 x = casts::to<i32>(std::round(data.x * MAX_X));
-			const i32 y = casts::to<i32>(std::round(data.y * MAX_Y));
-			const i32 pressure = casts::to<i32>(std::round(data.pressure * MAX_P));
+			// Keep the value in range of the axis.
+			m_timestamp = casts::to<i32>(counter & INT_MAX);
 
-			m_uinput->emit(EV_KEY, BTN_TOUCH, data.contact ? 1 : 0);
-			m_uinput->emit(EV_KEY, BTN_TOOL_PEN, !data.rubber ? 1 : 0);
-			m_uinput->emit(EV_KEY, BTN_TOOL_RUBBER, data.rubber ? 1 : 0);
-			m_uinput->emit(EV_KEY, BTN_STYLUS, data.button ? 1 : 0);
+			if (m_hardware_timestamps) {
+				const auto time = m_clock.input(counter, now);
 
-			m_uinput->emit(EV_ABS, ABS_X, x);
-			m_uinput->emit(EV_ABS, ABS_Y, y);
-			m_uinput->emit(EV_ABS, ABS_PRESSURE, pressure);
-			m_uinput->emit(EV_ABS, ABS_MISC, data.timestamp);
+				m_sample_time = common::CounterClock::timestamp(time);
+			}
 
-			m_uinput->emit(EV_ABS, ABS_TILT_X, tilt.x());
-			m_uinput->emit(EV_ABS, ABS_TILT_Y, tilt.y());
+			// An altitude of 0 means that the sample contains no tilt information.
+			if (data.altitude > 0)
+				m_tilt = calculate_tilt(data.altitude, data.azimuth);
+
+			this->update_tilt();
+
+			if (m_scroll && data.button) {
+				this->scroll(data);
+			} else {
+				m_scroll_position = std::nullopt;
+
+				const ipts::samples::Stylus current =
+					m_delay_contact ? this->delay_contact(data) : data;
+
+				if (m_predictor.has_value())
+					this->emit(m_predictor->predict(current));
+				else
+					this->emit(current);
+			}
 		} else {
+			m_unwrapper.reset();
+			m_clock.reset();
+			m_tilt = Vector2<i32>::Zero();
+			m_emitted_tilt = Vector2<i32>::Zero();
+
+			// The first sample after entering proximity always updates the tilt.
+			m_tilt_age = m_tilt_interval;
+			m_contact_pending = false;
+			m_scroll_position = std::nullopt;
+
+			if (m_predictor.has_value())
+				m_predictor->reset();
+
+			// Release everything at once, whatever the other bits of the sample say.
 			this->lift();
 		}
 
 		m_last = data;
 
 		this->sync();
+
+		if (double_tap)
+			this->double_click();
+	}
+
+	/*!
+	 * Makes clients start from the current state of the stylus again, after data was lost.
+	 *
+	 * The input core drops events whose value didn't change, so emitting the same state
+	 * again would never reach the clients. Instead, the stylus leaves proximity for one
+	 * frame and then enters it again with its last known state.
+	 */
+	void resync()
+	{
+		if (!m_enabled || !m_active)
+			return;
+
+		// While scrolling, the stylus is already lifted.
+		if (m_scroll_position.has_value())
+			return;
+
+		this->lift();
+		this->sync();
+
+		this->emit(m_last);
+		this->sync();
 	}
 
 	/*!
@@ -156,10 +402,396 @@ public:
 		return m_active;
 	}
 
+	/*!
+	 * The maximum value of the pressure axis.
+	 *
+	 * @return The range of the pressure that is emitted.
+	 */
+	[[nodiscard]] i32 max_pressure() const
+	{
+		return m_max_pressure;
+	}
+
+	/*!
+	 * Replaces how the pressure of the pen and of the rubber is reported.
+	 *
+	 * @param[in] config The config that contains the new pressure curves.
+	 */
+	void set_pressure_curves(const core::Config &config)
+	{
+		m_pen_curve = Curve::parse(config.stylus_pressure_curve);
+		m_rubber_curve = m_pen_curve;
+
+		if (!config.stylus_rubber_pressure_curve.empty())
+			m_rubber_curve = Curve::parse(config.stylus_rubber_pressure_curve);
+	}
+
+	/*!
+	 * The evdev node of the stylus device.
+	 *
+	 * @return The path of the node, if the device is a local one.
+	 */
+	[[nodiscard]] std::optional<std::filesystem::path> node() const
+	{
+		return m_uinput->node();
+	}
+
 private:
+	/*!
+	 * Updates the emitted tilt, if the interval has passed or the tilt changed enough.
+	 */
+	void update_tilt()
+	{
+		m_tilt_age++;
+
+		const i32 change = (m_tilt - m_emitted_tilt).cwiseAbs().maxCoeff();
+
+		const bool due = m_tilt_age >= m_tilt_interval;
+		const bool moved = m_tilt_threshold > 0 && change >= m_tilt_threshold;
+
+		if (!due && !moved)
+			return;
+
+		m_emitted_tilt = m_tilt;
+		m_tilt_age = 0;
+	}
+
+	/*!
+	 * Applies the out of range policy, if the position is outside of the screen.
+	 *
+	 * The axes of the device only cover the screen, so the position of the stylus should
+	 * never leave it. If it does repeatedly, the scale of the device is probably wrong.
+	 * Unless the position is clamped, every time it leaves the screen is reported.
+	 *
+	 * @param[in,out] data The current state of the stylus.
+	 * @return Whether the sample should be emitted.
+	 */
+	bool check_range(ipts::samples::Stylus &data)
+	{
+		const bool inside_x = data.x >= 0 && data.x <= 1;
+		const bool inside_y = data.y >= 0 && data.y <= 1;
+
+		if (inside_x && inside_y) {
+			m_out_of_range = false;
+			return true;
+		}
+
+		// TipDistance moves positions near the edge outside, which is fine when clamping.
+		if (!m_out_of_range && !m_clamp_out_of_range) {
+			spdlog::warn("Stylus position {:.3f}x{:.3f} is outside of the screen",
+			             data.x,
+			             data.y);
+		}
+
+		m_out_of_range = true;
+
+		if (m_drop_out_of_range)
+			return false;
+
+		if (m_clamp_out_of_range) {
+			data.x = std::clamp(data.x, 0.0, 1.0);
+			data.y = std::clamp(data.y, 0.0, 1.0);
+		}
+
+		return true;
+	}
+
+	/*!
+	 * Uses the rubber bit of samples without proximity to select the tool beforehand.
+	 *
+	 * When the stylus is flipped quickly, the rubber bit can be set before proximity is
+	 * registered, while the first samples in proximity still report the pen. Instead of
+	 * switching from the pen to the rubber right after entering proximity, the rubber is
+	 * used from the start, until the bit is confirmed or ARM_SAMPLES samples passed.
+	 *
+	 * @param[in] data The current state of the stylus.
+	 * @return The state that should be used instead.
+	 */
+	ipts::samples::Stylus arm_rubber(const ipts::samples::Stylus &data)
+	{
+		ipts::samples::Stylus sample = data;
+
+		if (!data.proximity) {
+			// A rubber that left proximity keeps the bit set, only a change is a flip.
+			if (data.rubber && !m_last.rubber)
+				m_armed = ARM_SAMPLES;
+			else if (!data.rubber)
+				m_armed = 0;
+
+			return sample;
+		}
+
+		if (m_armed == 0)
+			return sample;
+
+		if (data.rubber) {
+			m_armed = 0;
+			return sample;
+		}
+
+		m_armed--;
+		sample.rubber = true;
+
+		return sample;
+	}
+
+	/*!
+	 * Holds back the press of the tip for one sample.
+	 *
+	 * The firmware updates the contact state before the position, so the first sample with
+	 * contact still has the position of the previous one, which makes strokes start with a
+	 * hook. If the tip is lifted before the press was emitted, the tap is emitted with the
+	 * held back sample, so it is not lost.
+	 *
+	 * @param[in] data The current state of the stylus.
+	 * @return The state that should be emitted.
+	 */
+	ipts::samples::Stylus delay_contact(const ipts::samples::Stylus &data)
+	{
+		ipts::samples::Stylus sample = data;
+
+		if (!data.contact) {
+			if (m_contact_pending) {
+				this->emit(m_last);
+				this->sync();
+			}
+
+			m_contact_pending = false;
+			return sample;
+		}
+
+		if (!m_last.contact) {
+			m_contact_pending = true;
+
+			sample.contact = false;
+			sample.pressure = 0;
+
+			return sample;
+		}
+
+		m_contact_pending = false;
+		return sample;
+	}
+
+	/*!
+	 * Tracks the tip of the stylus and checks if it was tapped twice at the same position.
+	 *
+	 * @param[in] data The current state of the stylus.
+	 * @param[in] now When the sample arrived.
+	 * @return Whether a double tap was completed with this sample.
+	 */
+	bool detect_double_tap(const ipts::samples::Stylus &data, const clock::time_point now)
+	{
+		const bool contact = m_active && data.contact && !data.rubber;
+
+		const Vector2<f64> position {data.x * m_size.x(), data.y * m_size.y()};
+
+		// A stylus that leaves proximity will not come back in time for a double tap.
+		if (!m_active) {
+			this->reset_tap();
+			return false;
+		}
+
+		if (contact) {
+			if (!m_tap_start.has_value()) {
+				m_tap_start = now;
+				m_tap_origin = position;
+				m_tap_moved = false;
+			}
+
+			if ((position - m_tap_origin).norm() > m_double_tap_distance)
+				m_tap_moved = true;
+
+			return false;
+		}
+
+		if (!m_tap_start.has_value())
+			return false;
+
+		const bool tap = !m_tap_moved && now - m_tap_start.value() <= m_double_tap_timeout;
+		m_tap_start = std::nullopt;
+
+		if (!tap) {
+			m_tap_end = std::nullopt;
+			return false;
+		}
+
+		if (m_tap_end.has_value()) {
+			const f64 distance = (m_tap_origin - m_tap_position).norm();
+
+			const bool quick = now - m_tap_end.value() <= m_double_tap_timeout;
+			const bool close = distance <= m_double_tap_distance;
+
+			if (quick && close) {
+				m_tap_end = std::nullopt;
+				return true;
+			}
+		}
+
+		m_tap_end = now;
+		m_tap_position = m_tap_origin;
+
+		return false;
+	}
+
+	/*!
+	 * Scrolls by the movement of the stylus since the last sample.
+	 *
+	 * When scrolling starts, the stylus is lifted, so that it doesn't draw.
+	 *
+	 * @param[in] data The current state of the stylus.
+	 */
+	void scroll(const ipts::samples::Stylus &data)
+	{
+		const Vector2<f64> position {data.x * m_size.x(), data.y * m_size.y()};
+
+		if (!m_scroll_position.has_value()) {
+			this->lift();
+			m_wheel.reset();
+
+			m_scroll_position = position;
+			return;
+		}
+
+		const Vector2<f64> delta = position - m_scroll_position.value();
+		m_scroll_position = position;
+
+		m_wheel.scroll(*m_scroll, delta * m_scroll_speed);
+	}
+
+	/*!
+	 * Creates the device that the scrolling of the stylus is emitted through.
+	 *
+	 * Tablets can't scroll, so the events are emitted through a separate pointer device.
+	 *
+	 * @param[in] info The device that the stylus is connected to.
+	 * @param[in] record Where the events are recorded in evemu format. If empty, they are not.
+	 */
+	void create_scroll_device(const core::DeviceInfo &info, const std::filesystem::path &record)
+	{
+		m_scroll = open_uinput_device({}, record);
+
+		m_scroll->set_name("Stylus Scroll");
+		m_scroll->set_vendor(info.vendor);
+		m_scroll->set_product(info.product);
+
+		m_scroll->set_evbit(EV_REL);
+		m_scroll->set_evbit(EV_KEY);
+
+		// Without motion and a button, the device is not recognized as a mouse.
+		m_scroll->set_relbit(REL_X);
+		m_scroll->set_relbit(REL_Y);
+		ScrollWheel::setup(*m_scroll);
+
+		m_scroll->set_keybit(BTN_LEFT);
+		m_scroll->set_propbit(INPUT_PROP_POINTER);
+
+		m_scroll->create();
+	}
+
+	/*!
+	 * Forgets about previous taps.
+	 */
+	void reset_tap()
+	{
+		m_tap_start = std::nullopt;
+		m_tap_end = std::nullopt;
+		m_tap_moved = false;
+	}
+
+	/*!
+	 * Emits two clicks of the double tap key.
+	 */
+	void double_click() const
+	{
+		for (usize i = 0; i < 2; i++) {
+			m_uinput->emit(EV_KEY, m_double_tap_key, 1);
+			this->sync();
+
+			m_uinput->emit(EV_KEY, m_double_tap_key, 0);
+			this->sync();
+		}
+	}
+
+	/*!
+	 * Enables the events that the pen and the eraser device have in common.
+	 *
+	 * @param[in] device The device to set up.
+	 * @param[in] config The config of the daemon.
+	 * @param[in] info The device that the stylus is connected to.
+	 */
+	void setup(UinputDevice &device,
+	           const core::Config &config,
+	           const core::DeviceInfo &info) const
+	{
+		device.set_vendor(info.vendor);
+		device.set_product(info.product);
+
+		device.set_evbit(EV_KEY);
+		device.set_evbit(EV_ABS);
+
+		if (m_hardware_timestamps) {
+			device.set_evbit(EV_MSC);
+			device.set_mscbit(MSC_TIMESTAMP);
+		}
+
+		device.set_propbit(INPUT_PROP_DIRECT);
+		device.set_propbit(INPUT_PROP_POINTER);
+
+		device.set_keybit(BTN_TOUCH);
+		device.set_keybit(BTN_STYLUS);
+
+		// Resolution for X / Y is expected to be units/mm.
+		const i32 res_x = casts::to<i32>(std::round(MAX_X / (config.width * 10)));
+		const i32 res_y = casts::to<i32>(std::round(MAX_Y / (config.height * 10)));
+
+		// Resolution for tilt is expected to be units/radian.
+		const i32 res_tilt = casts::to<i32>(std::round(18000.0 / M_PI));
+
+		const i32 fuzz_x = casts::to<i32>(config.stylus_fuzz_x);
+		const i32 fuzz_y = casts::to<i32>(config.stylus_fuzz_y);
+		const i32 fuzz_pressure = casts::to<i32>(config.stylus_fuzz_pressure);
+		const i32 fuzz_tilt = casts::to<i32>(config.stylus_fuzz_tilt);
+
+		device.set_absinfo(ABS_X, 0, MAX_X, res_x, fuzz_x);
+		device.set_absinfo(ABS_Y, 0, MAX_Y, res_y, fuzz_y);
+		device.set_absinfo(ABS_PRESSURE, 0, m_max_pressure, 0, fuzz_pressure);
+
+		if (!m_disable_tilt) {
+			device.set_absinfo(ABS_TILT_X, -9000, 9000, res_tilt, fuzz_tilt);
+			device.set_absinfo(ABS_TILT_Y, -9000, 9000, res_tilt, fuzz_tilt);
+		}
+
+		device.set_absinfo(ABS_MISC, 0, INT_MAX, 0);
+	}
+
+	/*!
+	 * Opens the device that stylus events are emitted through.
+	 *
+	 * @param[in] config The config of the daemon.
+	 * @param[in] record Where the events are recorded in evemu format. If empty, they are not.
+	 * @return A remote device if a receiver is configured, otherwise a local device.
+	 */
+	static std::shared_ptr<UinputDevice> open_stylus_device(const core::Config &config,
+	                                                        const std::filesystem::path &record)
+	{
+		if (!config.stylus_remote.empty()) {
+			return open_remote_device(config.stylus_remote,
+			                          config.stylus_remote_token,
+			                          record);
+		}
+
+		return open_uinput_device(config.stylus_output_device, record);
+	}
+
 	/*!
 	 * Calculates the tilt of the stylus on X and Y axis.
 	 *
+	 * The altitude is the angle between the stylus and the normal of the screen.
+	 * The azimuth is the direction that the top of the stylus points to, counterclockwise
+	 * from the right edge of the screen. A stylus whose top points to the right has a positive
+	 * tilt on the X axis, one whose top points towards the user a positive tilt on the Y axis.
+	 *
 	 * @param[in] altitude The altitude of the stylus.
 	 * @param[in] azimuth The azimuth of the stylus.
 	 * @return A Vector containing the tilt on the X and Y axis.
@@ -184,6 +816,52 @@ private:
 		return Vector2<i32> {tx, ty};
 	}
 
+	/*!
+	 * Emits the position and state of the stylus.
+	 *
+	 * @param[in] data The state of the stylus.
+	 */
+	void emit(const ipts::samples::Stylus &data) const
+	{
+		const i32 x = casts::to<i32>(std::round(data.x * MAX_X));
+		const i32 y = casts::to<i32>(std::round(data.y * MAX_Y));
+		const Curve &curve = data.rubber ? m_rubber_curve : m_pen_curve;
+		const f64 mapped = curve.map(data.pressure);
+
+		const i32 pressure = casts::to<i32>(std::round(mapped * m_max_pressure));
+
+		const std::shared_ptr<UinputDevice> &device =
+			m_eraser && data.rubber ? m_eraser : m_uinput;
+
+		device->emit(EV_KEY, BTN_TOUCH, data.contact ? 1 : 0);
+
+		if (m_eraser) {
+			device->emit(EV_KEY, data.rubber ? BTN_TOOL_RUBBER : BTN_TOOL_PEN, 1);
+		} else if (m_rubber_as_pen) {
+			device->emit(EV_KEY, BTN_TOOL_PEN, 1);
+			device->emit(EV_KEY, m_rubber_key, data.rubber ? 1 : 0);
+		} else {
+			device->emit(EV_KEY, BTN_TOOL_PEN, !data.rubber ? 1 : 0);
+			device->emit(EV_KEY, BTN_TOOL_RUBBER, data.rubber ? 1 : 0);
+		}
+
+		device->emit(EV_KEY, BTN_STYLUS, data.button ? 1 : 0);
+
+		device->emit(EV_ABS, ABS_X, x);
+		device->emit(EV_ABS, ABS_Y, y);
+		device->emit(EV_ABS, ABS_PRESSURE, pressure);
+		device->emit(EV_ABS, ABS_MISC, m_timestamp);
+
+		if (m_hardware_timestamps)
+			device->emit(EV_MSC, MSC_TIMESTAMP, m_sample_time);
+
+		if (m_disable_tilt)
+			return;
+
+		device->emit(EV_ABS, ABS_TILT_X, m_emitted_tilt.x());
+		device->emit(EV_ABS, ABS_TILT_Y, m_emitted_tilt.y());
+	}
+
 	/*!
 	 * Lifts the stylus input.
 	 */
@@ -191,8 +869,26 @@ private:
 	{
 		m_uinput->emit(EV_KEY, BTN_TOUCH, 0);
 		m_uinput->emit(EV_KEY, BTN_TOOL_PEN, 0);
-		m_uinput->emit(EV_KEY, BTN_TOOL_RUBBER, 0);
 		m_uinput->emit(EV_KEY, BTN_STYLUS, 0);
+
+		if (!m_eraser)
+			m_uinput->emit(EV_KEY, BTN_TOOL_RUBBER, 0);
+
+		if (m_rubber_as_pen)
+			m_uinput->emit(EV_KEY, m_rubber_key, 0);
+
+		if (m_instant_lift)
+			m_uinput->emit(EV_ABS, ABS_PRESSURE, 0);
+
+		if (!m_eraser)
+			return;
+
+		m_eraser->emit(EV_KEY, BTN_TOUCH, 0);
+		m_eraser->emit(EV_KEY, BTN_TOOL_RUBBER, 0);
+		m_eraser->emit(EV_KEY, BTN_STYLUS, 0);
+
+		if (m_instant_lift)
+			m_eraser->emit(EV_ABS, ABS_PRESSURE, 0);
 	}
 
 	/*!
@@ -201,6 +897,13 @@ private:
 	void sync() const
 	{
 		m_uinput->emit(EV_SYN, SYN_REPORT, 0);
+
+		// The kernel drops reports without any events, so syncing all devices is safe.
+		if (m_eraser)
+			m_eraser->emit(EV_SYN, SYN_REPORT, 0);
+
+		if (m_scroll)
+			m_scroll->emit(EV_SYN, SYN_REPORT, 0);
 	}
 };
 
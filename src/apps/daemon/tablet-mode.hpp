@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_TABLET_MODE_HPP
+#define IPTSD_APPS_DAEMON_TABLET_MODE_HPP
+
+#include <common/chrono.hpp>
+#include <common/types.hpp>
+#include <core/linux/syscalls.hpp>
+
+#include <linux/input.h>
+
+#include <array>
+#include <exception>
+#include <fcntl.h>
+#include <filesystem>
+#include <optional>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * Reads the state of the tablet mode switch (SW_TABLET_MODE) from an evdev device.
+ *
+ * On convertibles, the switch is set once the keyboard is folded behind the screen.
+ * Since the state is needed for every frame, but changes rarely, it is only queried
+ * from the kernel once per interval.
+ */
+class TabletModeSwitch {
+private:
+	using clock = chrono::steady_clock;
+
+	// How long the state of the switch is cached. Folding the device takes longer than this.
+	constexpr static clock::duration INTERVAL = 500ms;
+
+	// The file descriptor of the open evdev node.
+	int m_fd;
+
+	// The state of the switch, as it was queried last.
+	bool m_active = false;
+
+	// When the state of the switch was queried last.
+	std::optional<clock::time_point> m_last = std::nullopt;
+
+public:
+	TabletModeSwitch(const std::filesystem::path &path)
+		: m_fd {core::linux::syscalls::open(path, O_RDONLY | O_NONBLOCK)} {};
+
+	TabletModeSwitch(const TabletModeSwitch &) = delete;
+	TabletModeSwitch &operator=(const TabletModeSwitch &) = delete;
+
+	~TabletModeSwitch()
+	{
+		try {
+			core::linux::syscalls::close(m_fd);
+		} catch (const std::exception & /* unused */) {
+			// ignored
+		}
+	}
+
+	/*!
+	 * The state of the switch, queried from the kernel if the cached one is too old.
+	 *
+	 * @return true if the device is in tablet mode.
+	 */
+	[[nodiscard]] bool active()
+	{
+		const clock::time_point now = clock::now();
+
+		if (m_last.has_value() && now - m_last.value() < INTERVAL)
+			return m_active;
+
+		m_active = this->query();
+		m_last = now;
+
+		return m_active;
+	}
+
+private:
+	/*!
+	 * Queries the current state of the switch from the kernel.
+	 *
+	 * @return true if the device is in tablet mode.
+	 */
+	[[nodiscard]] bool query() const
+	{
+		std::array<u8, (SW_MAX / 8) + 1> bits {};
+
+		core::linux::syscalls::ioctl(m_fd, EVIOCGSW(bits.size()), bits.data());
+		return (bits.at(SW_TABLET_MODE / 8) & (1 << (SW_TABLET_MODE % 8))) != 0;
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_TABLET_MODE_HPP
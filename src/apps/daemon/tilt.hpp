@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_TILT_HPP
+#define IPTSD_APPS_DAEMON_TILT_HPP
+
+#include <common/types.hpp>
+#include <core/generic/config.hpp>
+#include <ipts/samples/stylus.hpp>
+
+#include <algorithm>
+#include <map>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * Learns which styli support tilt, by their serial number.
+ *
+ * Samples that contain no tilt information have an altitude of 0. A stylus that only sent
+ * such samples for a while is assumed to not support tilt, until it reports tilt once.
+ */
+class TiltDetector {
+private:
+	/*
+	 * What is known about the tilt of one stylus.
+	 */
+	struct Pen {
+		// Whether the stylus reported tilt at least once.
+		bool tilt = false;
+
+		// How many samples in proximity the stylus sent without tilt.
+		usize samples = 0;
+	};
+
+private:
+	// How many samples without tilt are needed to decide that a stylus doesn't support it.
+	usize m_samples;
+
+	// The styli that were seen so far, by their serial number.
+	std::map<u32, Pen> m_pens {};
+
+	// The serial number of the stylus that was in proximity last.
+	u32 m_serial = 0;
+
+public:
+	TiltDetector(const core::Config &config)
+		: m_samples {std::max<usize>(config.stylus_auto_tilt_samples, 1)} {};
+
+	/*!
+	 * Registers a stylus sample.
+	 *
+	 * @param[in] sample The current state of the stylus.
+	 */
+	void update(const ipts::samples::Stylus &sample)
+	{
+		if (!sample.proximity)
+			return;
+
+		m_serial = sample.serial;
+		Pen &pen = m_pens[sample.serial];
+
+		if (sample.altitude > 0)
+			pen.tilt = true;
+		else if (pen.samples < m_samples)
+			pen.samples++;
+	}
+
+	/*!
+	 * Whether the stylus that was in proximity last supports tilt.
+	 *
+	 * @return false if it was seen long enough without reporting any tilt.
+	 */
+	[[nodiscard]] bool tilt() const
+	{
+		const auto it = m_pens.find(m_serial);
+
+		if (it == m_pens.end())
+			return true;
+
+		return it->second.tilt || it->second.samples < m_samples;
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_TILT_HPP
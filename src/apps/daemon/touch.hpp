@@ -3,25 +3,35 @@
 #ifndef IPTSD_APPS_DAEMON_TOUCH_HPP
 #define IPTSD_APPS_DAEMON_TOUCH_HPP
 
+#include "curve.hpp"
+#include "errors.hpp"
 #include "uinput-device.hpp"
 
 #include <common/casts.hpp>
+#include <common/chrono.hpp>
+#include <common/clock.hpp>
+#include <common/error.hpp>
 #include <common/types.hpp>
+#include <common/unwrap.hpp>
 #include <contacts/contact.hpp>
 #include <core/generic/config.hpp>
 #include <core/generic/device.hpp>
 #include <ipts/samples/button.hpp>
 
 #include <gsl/gsl>
+#include <spdlog/spdlog.h>
 
 #include <linux/input-event-codes.h>
 
 #include <algorithm>
 #include <cmath>
+#include <filesystem>
 #include <iterator>
+#include <map>
 #include <memory>
 #include <optional>
 #include <set>
+#include <string>
 #include <vector>
 
 namespace iptsd::apps::daemon {
@@ -38,8 +48,11 @@ private:
 	 */
 	constexpr static usize DIAGONAL = 12000;
 
+	// The highest pressure that can be reported for a contact.
+	constexpr static i32 MAX_PRESSURE = 255;
+
 private:
-	std::shared_ptr<UinputDevice> m_uinput = std::make_shared<UinputDevice>();
+	std::shared_ptr<UinputDevice> m_uinput;
 
 	// The daemon configuration.
 	core::Config m_config;
@@ -50,9 +63,49 @@ private:
 	// How far a contact can be outside of the touch area and still get registered.
 	f64 m_overshoot = 0;
 
+	// How far a contact has to move from where it started before its movement is emitted.
+	f64 m_drag_threshold = 0;
+
+	// The contacts that didn't move far enough yet, and the position where they started.
+	std::map<usize, Vector2<f64>> m_held {};
+
+	// What happens to contacts outside of the touch area, that are still within the overshoot.
+	bool m_clamp_out_of_range = true;
+	bool m_drop_out_of_range = false;
+
+	// The contacts that are outside of the touch area and were reported already.
+	std::set<usize> m_out_of_range {};
+
 	// Whether all inputs will be lifted once a palm is registered.
 	bool m_disable_on_palm = false;
 
+	// Whether the width of contacts is emitted in addition to their size.
+	bool m_emit_width = false;
+
+	// Whether the intensity of contacts is emitted as their pressure.
+	bool m_emit_pressure = false;
+
+	// How the intensity of contacts is mapped to their pressure.
+	Curve m_pressure_curve {};
+
+	// Whether contacts are emitted as anonymous packets (type A), instead of slots (type B).
+	bool m_protocol_a = false;
+
+	// Whether the estimated time of each frame is emitted as MSC_TIMESTAMP.
+	bool m_hardware_timestamps = false;
+
+	// Unwraps the timestamps of the reports that contained the heatmaps.
+	common::Unwrapper<u16> m_unwrapper {};
+
+	// Estimates when the frames were generated, from the unwrapped timestamp.
+	common::CounterClock m_clock {};
+
+	// How many contact packets were emitted in the current frame, when using type A.
+	usize m_packets = 0;
+
+	// The last state that was emitted for every contact, when using type A.
+	std::map<usize, contacts::Contact<f64>> m_emitted {};
+
 	// The indices of the contacts in the current frame.
 	std::set<usize> m_current {};
 
@@ -62,16 +115,50 @@ private:
 	// The difference between m_last and m_current.
 	std::set<usize> m_lift {};
 
+	// The contacts that disappeared, and for how many frames they are missing already.
+	std::map<usize, usize> m_missing {};
+
+	// For how many frames a contact that disappeared stays active, in case it comes back.
+	usize m_retain_frames = 0;
+
+	// The slots that are in use, and the frame in which their contact was seen the last time.
+	std::map<usize, u64> m_slots {};
+
+	// How many frames were passed to the device.
+	u64 m_frame = 0;
+
+	// After how many frames without updates a slot is released. 0 disables this.
+	usize m_stale_frames = 5;
+
 	// The index of the contact that is emitted through the singletouch API.
 	usize m_single_index = 0;
 
+	// For how many more frames a new number of contacts has to be seen before it is reported.
+	usize m_count_debounce = 0;
+
+	// The number of contacts that is reported through the tool buttons.
+	usize m_count = 0;
+
+	// A new number of contacts, and in how many frames in a row it was seen.
+	usize m_pending_count = 0;
+	usize m_pending_frames = 0;
+
 	// Whether the device is enabled.
 	bool m_enabled = true;
 
 public:
-	TouchDevice(const core::Config &config, const core::DeviceInfo &info)
-		: m_config {config},
-		  m_info {info}
+	TouchDevice(const core::Config &config,
+	            const core::DeviceInfo &info,
+	            const std::filesystem::path &record = {})
+		: m_uinput {open_uinput_device(info.is_touchscreen()
+		                                       ? config.touchscreen_output_device
+		                                       : config.touchpad_output_device,
+		                               record)},
+		  m_config {config},
+		  m_info {info},
+		  m_retain_frames {config.contacts_retain_frames},
+		  m_stale_frames {config.contacts_stale_frames},
+		  m_count_debounce {config.touchpad_count_debounce}
 	{
 		if (info.is_touchscreen())
 			m_uinput->set_name("Touchscreen");
@@ -98,12 +185,26 @@ public:
 			m_uinput->set_propbit(INPUT_PROP_BUTTONPAD);
 
 			m_overshoot = config.touchpad_overshoot;
+			m_drag_threshold = config.touchpad_drag_threshold;
 			m_disable_on_palm = config.touchpad_disable_on_palm;
+			m_emit_width = config.touchpad_emit_width;
+			m_emit_pressure = config.touchpad_emit_pressure;
+			m_pressure_curve = Curve::parse(config.touchpad_pressure_curve);
+			m_protocol_a = parse_protocol(config.touchpad_protocol);
+			m_hardware_timestamps = config.touchpad_hardware_timestamps;
+			this->parse_range_policy(config.touchpad_out_of_range);
 		} else {
 			m_uinput->set_propbit(INPUT_PROP_DIRECT);
 
 			m_overshoot = config.touchscreen_overshoot;
+			m_drag_threshold = config.touchscreen_drag_threshold;
 			m_disable_on_palm = config.touchscreen_disable_on_palm;
+			m_emit_width = config.touchscreen_emit_width;
+			m_emit_pressure = config.touchscreen_emit_pressure;
+			m_pressure_curve = Curve::parse(config.touchscreen_pressure_curve);
+			m_protocol_a = parse_protocol(config.touchscreen_protocol);
+			m_hardware_timestamps = config.touchscreen_hardware_timestamps;
+			this->parse_range_policy(config.touchscreen_out_of_range);
 		}
 
 		const f64 diag = std::hypot(config.width, config.height);
@@ -113,16 +214,31 @@ public:
 		const i32 res_y = casts::to<i32>(std::round(MAX_Y / (config.height * 10)));
 		const i32 res_d = casts::to<i32>(std::round(DIAGONAL / (diag * 10)));
 
-		m_uinput->set_absinfo(ABS_MT_SLOT, 0, MAX_CONTACTS, 0);
-		m_uinput->set_absinfo(ABS_MT_TRACKING_ID, 0, MAX_CONTACTS, 0);
+		// Type A has no slots, the contacts are not tracked by the kernel.
+		if (!m_protocol_a) {
+			m_uinput->set_absinfo(ABS_MT_SLOT, 0, MAX_CONTACTS, 0);
+			m_uinput->set_absinfo(ABS_MT_TRACKING_ID, 0, MAX_CONTACTS, 0);
+		}
 		m_uinput->set_absinfo(ABS_MT_POSITION_X, 0, MAX_X, res_x);
 		m_uinput->set_absinfo(ABS_MT_POSITION_Y, 0, MAX_Y, res_y);
 		m_uinput->set_absinfo(ABS_MT_ORIENTATION, 0, 180, 0);
 		m_uinput->set_absinfo(ABS_MT_TOUCH_MAJOR, 0, DIAGONAL, res_d);
 		m_uinput->set_absinfo(ABS_MT_TOUCH_MINOR, 0, DIAGONAL, res_d);
+
+		if (m_emit_width)
+			m_uinput->set_absinfo(ABS_MT_WIDTH_MAJOR, 0, DIAGONAL, res_d);
+
+		if (m_emit_pressure)
+			m_uinput->set_absinfo(ABS_MT_PRESSURE, 0, MAX_PRESSURE, 0);
+
 		m_uinput->set_absinfo(ABS_X, 0, MAX_X, res_x);
 		m_uinput->set_absinfo(ABS_Y, 0, MAX_Y, res_y);
 
+		if (m_hardware_timestamps) {
+			m_uinput->set_evbit(EV_MSC);
+			m_uinput->set_mscbit(MSC_TIMESTAMP);
+		}
+
 		m_uinput->create();
 	}
 
@@ -130,8 +246,9 @@ public:
 	 * Passes a frame of detected contacts to the linux kernel.
 	 *
 	 * @param[in] contacts All currently active contacts.
+	 * @param[in] timestamp The timestamp of the report that contained the heatmap.
 	 */
-	void update(const std::vector<contacts::Contact<f64>> &contacts)
+	void update(const std::vector<contacts::Contact<f64>> &contacts, const u16 timestamp)
 	{
 		// If the touch device is disabled ignore all inputs.
 		if (!m_enabled)
@@ -139,12 +256,24 @@ public:
 
 		// Find the inputs that need to be lifted
 		this->search_lifted(contacts);
+		this->debounce_count();
 
 		if (this->is_blocked(contacts))
 			this->lift_all();
 		else
 			this->process(contacts);
 
+		this->release_stale();
+		this->end_packets();
+
+		if (m_hardware_timestamps) {
+			const u32 counter = m_unwrapper.unwrap(timestamp);
+			const auto time = m_clock.input(counter, chrono::steady_clock::now());
+			const i32 value = common::CounterClock::timestamp(time);
+
+			m_uinput->emit(EV_MSC, MSC_TIMESTAMP, value);
+		}
+
 		this->sync();
 	}
 
@@ -160,7 +289,31 @@ public:
 			return;
 
 		m_uinput->emit(EV_KEY, BTN_LEFT, button.active ? 1 : 0);
-		this->sync();
+
+		// With type A, every frame must contain all contacts, so the button waits for one.
+		if (!m_protocol_a)
+			this->sync();
+	}
+
+	/*!
+	 * Switches between the laptop and tablet mode touch policy.
+	 *
+	 * Only touchscreens have a separate policy for tablet mode.
+	 *
+	 * @param[in] tablet Whether the device is in tablet mode.
+	 */
+	void set_tablet_mode(const bool tablet)
+	{
+		if (!m_info.is_touchscreen())
+			return;
+
+		if (tablet) {
+			m_overshoot = m_config.tablet_mode_overshoot;
+			m_disable_on_palm = m_config.tablet_mode_disable_on_palm;
+		} else {
+			m_overshoot = m_config.touchscreen_overshoot;
+			m_disable_on_palm = m_config.touchscreen_disable_on_palm;
+		}
 	}
 
 	/*!
@@ -170,13 +323,26 @@ public:
 	{
 		m_enabled = false;
 
+		this->lift();
+	}
+
+	/*!
+	 * Lifts all contacts and forgets about the previous frames.
+	 */
+	void lift()
+	{
 		// Lift all currently active contacts.
 		this->lift_all();
+		this->end_packets();
 		this->sync();
 
 		m_current.clear();
 		m_last.clear();
 		m_lift.clear();
+		m_missing.clear();
+
+		m_count = 0;
+		m_pending_frames = 0;
 	}
 
 	/*!
@@ -207,11 +373,24 @@ public:
 		return !m_current.empty();
 	}
 
+	/*!
+	 * The evdev node of the touch device.
+	 *
+	 * @return The path of the node, if the device is a local one.
+	 */
+	[[nodiscard]] std::optional<std::filesystem::path> node() const
+	{
+		return m_uinput->node();
+	}
+
 private:
 	/*!
 	 * Builds the difference between the current and the last frame.
 	 * Contacts that were present in the last frame but not in this one have to be lifted.
 	 *
+	 * The contact tracker keeps the index of a contact that disappeared for a few frames, so
+	 * that it can come back. Until then, the contact stays active instead of being lifted.
+	 *
 	 * @param[in] contacts All currently active contacts.
 	 */
 	void search_lifted(const std::vector<contacts::Contact<f64>> &contacts)
@@ -219,23 +398,85 @@ private:
 		std::swap(m_current, m_last);
 
 		m_current.clear();
+		m_frame++;
 
 		// Build a set of current indices
 		for (const contacts::Contact<f64> &contact : contacts) {
 			if (!contact.index.has_value())
 				continue;
 
-			m_current.insert(contact.index.value());
+			const usize index = contact.index.value();
+			m_current.insert(index);
+			m_missing.erase(index);
+
+			// The slot is still in use, even if the contact is not emitted this time.
+			this->touch_slot(index);
 		}
 
-		m_lift.clear();
+		std::set<usize> disappeared {};
 
 		// Determine all indices that were in the last frame but not in this one
 		std::set_difference(m_last.cbegin(),
 		                    m_last.cend(),
 		                    m_current.cbegin(),
 		                    m_current.cend(),
-		                    std::inserter(m_lift, m_lift.begin()));
+		                    std::inserter(disappeared, disappeared.begin()));
+
+		m_lift.clear();
+
+		for (const usize index : disappeared) {
+			const usize missing = ++m_missing[index];
+
+			if (missing > m_retain_frames) {
+				m_missing.erase(index);
+				m_lift.insert(index);
+			} else {
+				m_current.insert(index);
+				this->touch_slot(index);
+			}
+		}
+	}
+
+	/*!
+	 * Marks the slot of a contact as being in use in the current frame.
+	 *
+	 * @param[in] index The index of the contact.
+	 */
+	void touch_slot(const usize index)
+	{
+		const auto slot = m_slots.find(index);
+
+		if (slot != m_slots.end())
+			slot->second = m_frame;
+	}
+
+	/*!
+	 * Updates the number of contacts that is reported, once a new one is stable.
+	 *
+	 * The first contact and the last lift are reported immediately, so that the tool buttons
+	 * always match BTN_TOUCH.
+	 */
+	void debounce_count()
+	{
+		const usize count = m_current.size();
+
+		if (count == m_count) {
+			m_pending_frames = 0;
+			return;
+		}
+
+		if (count == m_pending_count) {
+			m_pending_frames++;
+		} else {
+			m_pending_count = count;
+			m_pending_frames = 1;
+		}
+
+		if (m_count != 0 && count != 0 && m_pending_frames <= m_count_debounce)
+			return;
+
+		m_count = count;
+		m_pending_frames = 0;
 	}
 
 	/*!
@@ -250,7 +491,7 @@ private:
 			return false;
 
 		return std::any_of(contacts.cbegin(), contacts.cend(), [&](const auto &c) {
-			return !c.valid.value_or(true);
+			return c.rejected();
 		});
 	}
 
@@ -266,22 +507,36 @@ private:
 		const f64 ox = m_overshoot / m_config.width;
 		const f64 oy = m_overshoot / m_config.height;
 
-		for (const contacts::Contact<f64> &contact : contacts) {
+		for (const contacts::Contact<f64> &original : contacts) {
 			// Ignore contacts without an index
-			if (!contact.index.has_value())
+			if (!original.index.has_value())
 				continue;
 
-			const usize index = contact.index.value();
+			const usize index = original.index.value();
 
 			// Ignore unstable changes
-			if (!contact.stable.value_or(true))
+			if (!original.stable.value_or(true)) {
+				this->repeat_multitouch(index);
 				continue;
+			}
+
+			const contacts::Contact<f64> contact = this->hold(original);
 
 			// Check if the contact is too far outside of the screen.
 			bool lift = !contact.valid.value_or(true);
 			lift |= contact.mean.x() < -ox || contact.mean.x() > (ox + 1);
 			lift |= contact.mean.y() < -oy || contact.mean.y() > (oy + 1);
 
+			// Contacts outside of the touch area keep their last state, if requested.
+			if (!lift && !this->check_range(contact)) {
+				this->repeat_multitouch(index);
+
+				if (m_single_index == index)
+					reset_singletouch = false;
+
+				continue;
+			}
+
 			if (!lift)
 				this->emit_multitouch(contact);
 			else
@@ -297,6 +552,14 @@ private:
 			}
 		}
 
+		// Contacts that went missing keep their last state until they are lifted.
+		for (const auto &[index, frames] : m_missing) {
+			this->repeat_multitouch(index);
+
+			if (index == m_single_index)
+				reset_singletouch = false;
+		}
+
 		for (const usize &index : m_lift)
 			this->lift_multitouch(index);
 
@@ -323,13 +586,140 @@ private:
 		}
 	}
 
+	/*!
+	 * Keeps a contact at the position where it started, until it moved far enough.
+	 *
+	 * @param[in] contact The contact to emit.
+	 * @return The contact, with the position that should be emitted.
+	 */
+	[[nodiscard]] contacts::Contact<f64> hold(const contacts::Contact<f64> &contact)
+	{
+		if (m_drag_threshold <= 0)
+			return contact;
+
+		const usize index = contact.index.value_or(0);
+
+		// A contact that has no slot yet just started.
+		if (m_slots.find(index) == m_slots.end())
+			m_held[index] = contact.mean;
+
+		const auto held = m_held.find(index);
+
+		if (held == m_held.end())
+			return contact;
+
+		const Vector2<f64> delta = contact.mean - held->second;
+		const f64 distance =
+			std::hypot(delta.x() * m_config.width, delta.y() * m_config.height);
+
+		if (distance >= m_drag_threshold) {
+			m_held.erase(held);
+			return contact;
+		}
+
+		contacts::Contact<f64> out = contact;
+		out.mean = held->second;
+
+		return out;
+	}
+
+	/*!
+	 * Checks if a contact is outside of the touch area, and reports it once if it is.
+	 *
+	 * @param[in] contact The contact to check.
+	 * @return Whether the contact should be emitted.
+	 */
+	bool check_range(const contacts::Contact<f64> &contact)
+	{
+		const usize index = contact.index.value_or(0);
+		const Vector2<f64> &mean = contact.mean;
+
+		const bool inside_x = mean.x() >= 0 && mean.x() <= 1;
+		const bool inside_y = mean.y() >= 0 && mean.y() <= 1;
+
+		if (inside_x && inside_y) {
+			m_out_of_range.erase(index);
+			return true;
+		}
+
+		const bool reported = !m_out_of_range.insert(index).second;
+
+		if (!reported && !m_clamp_out_of_range) {
+			spdlog::warn("Contact {} at {:.3f}x{:.3f} is outside of the touch area",
+			             index,
+			             mean.x(),
+			             mean.y());
+		}
+
+		return !m_drop_out_of_range;
+	}
+
+	/*!
+	 * Releases slots whose contact has not been seen for too long.
+	 *
+	 * Once a contact disappears, its slot is lifted in the next frame. If that doesn't happen
+	 * because of a bug, the slot would be stuck until the daemon is restarted, which breaks
+	 * all gestures. This is a safety net that should never be needed.
+	 */
+	void release_stale()
+	{
+		if (m_stale_frames == 0)
+			return;
+
+		std::vector<usize> stale {};
+
+		for (const auto &[index, frame] : m_slots) {
+			if (m_frame - frame > m_stale_frames)
+				stale.push_back(index);
+		}
+
+		for (const usize index : stale) {
+			spdlog::error("Internal error: Slot {} was not updated for {} frames",
+			              index,
+			              m_stale_frames);
+
+			this->lift_multitouch(index);
+		}
+	}
+
 	/*!
 	 * Emits a lift event using the linux multitouch protocol.
+	 *
+	 * With type A, a contact is lifted by not emitting it, so only its state is reset.
+	 */
+	void lift_multitouch(const usize index)
+	{
+		if (!m_protocol_a) {
+			m_uinput->emit(EV_ABS, ABS_MT_SLOT, casts::to<i32>(index));
+			m_uinput->emit(EV_ABS, ABS_MT_TRACKING_ID, -1);
+		}
+
+		m_slots.erase(index);
+		m_held.erase(index);
+		m_emitted.erase(index);
+		m_out_of_range.erase(index);
+	}
+
+	/*!
+	 * Emits the last state of a contact again, when using type A.
+	 *
+	 * With type B, the kernel keeps the state of the slot. With type A, a contact that is
+	 * missing from a frame is lifted, so contacts whose changes are ignored must be repeated.
+	 *
+	 * @param[in] index The index of the contact.
 	 */
-	void lift_multitouch(const usize index) const
+	void repeat_multitouch(const usize index)
 	{
-		m_uinput->emit(EV_ABS, ABS_MT_SLOT, casts::to<i32>(index));
-		m_uinput->emit(EV_ABS, ABS_MT_TRACKING_ID, -1);
+		if (!m_protocol_a)
+			return;
+
+		const auto emitted = m_emitted.find(index);
+
+		if (emitted == m_emitted.end())
+			return;
+
+		const contacts::Contact<f64> contact = emitted->second;
+		this->emit_multitouch(contact);
 	}
 
 	/*!
@@ -337,14 +727,16 @@ private:
 	 *
 	 * @param[in] contact The contact to emit.
 	 */
-	void emit_multitouch(const contacts::Contact<f64> &contact) const
+	void emit_multitouch(const contacts::Contact<f64> &contact)
 	{
 		const Vector2<f64> size = contact.size;
 
 		Vector2<f64> mean = contact.mean;
 
-		mean.x() = std::clamp(mean.x(), 0.0, 1.0);
-		mean.y() = std::clamp(mean.y(), 0.0, 1.0);
+		if (m_clamp_out_of_range) {
+			mean.x() = std::clamp(mean.x(), 0.0, 1.0);
+			mean.y() = std::clamp(mean.y(), 0.0, 1.0);
+		}
 
 		const i32 index = casts::to<i32>(contact.index.value_or(0));
 
@@ -355,14 +747,38 @@ private:
 		const i32 major = casts::to<i32>(std::round(size.maxCoeff() * DIAGONAL));
 		const i32 minor = casts::to<i32>(std::round(size.minCoeff() * DIAGONAL));
 
-		m_uinput->emit(EV_ABS, ABS_MT_SLOT, index);
-		m_uinput->emit(EV_ABS, ABS_MT_TRACKING_ID, index);
+		m_slots[contact.index.value_or(0)] = m_frame;
+
+		if (!m_protocol_a) {
+			m_uinput->emit(EV_ABS, ABS_MT_SLOT, index);
+			m_uinput->emit(EV_ABS, ABS_MT_TRACKING_ID, index);
+		}
+
 		m_uinput->emit(EV_ABS, ABS_MT_POSITION_X, x);
 		m_uinput->emit(EV_ABS, ABS_MT_POSITION_Y, y);
 
 		m_uinput->emit(EV_ABS, ABS_MT_ORIENTATION, angle);
 		m_uinput->emit(EV_ABS, ABS_MT_TOUCH_MAJOR, major);
 		m_uinput->emit(EV_ABS, ABS_MT_TOUCH_MINOR, minor);
+
+		if (m_emit_width) {
+			const i32 width = casts::to<i32>(std::round(contact.width * DIAGONAL));
+			m_uinput->emit(EV_ABS, ABS_MT_WIDTH_MAJOR, width);
+		}
+
+		if (m_emit_pressure) {
+			const f64 value = m_pressure_curve.map(contact.intensity);
+			const i32 pressure = casts::to<i32>(std::round(value * MAX_PRESSURE));
+
+			m_uinput->emit(EV_ABS, ABS_MT_PRESSURE, pressure);
+		}
+
+		if (m_protocol_a) {
+			m_uinput->emit(EV_SYN, SYN_MT_REPORT, 0);
+			m_packets++;
+
+			m_emitted.insert_or_assign(contact.index.value_or(0), contact);
+		}
 	}
 
 	/*!
@@ -436,8 +852,10 @@ private:
 	{
 		Vector2<f64> mean = contact.mean;
 
-		mean.x() = std::clamp(mean.x(), 0.0, 1.0);
-		mean.y() = std::clamp(mean.y(), 0.0, 1.0);
+		if (m_clamp_out_of_range) {
+			mean.x() = std::clamp(mean.x(), 0.0, 1.0);
+			mean.y() = std::clamp(mean.y(), 0.0, 1.0);
+		}
 
 		const i32 x = casts::to<i32>(std::round(mean.x() * MAX_X));
 		const i32 y = casts::to<i32>(std::round(mean.y() * MAX_Y));
@@ -445,11 +863,11 @@ private:
 		m_uinput->emit(EV_KEY, BTN_TOUCH, 1);
 
 		if (m_info.is_touchpad()) {
-			m_uinput->emit(EV_KEY, BTN_TOOL_FINGER, m_current.size() == 1 ? 1 : 0);
-			m_uinput->emit(EV_KEY, BTN_TOOL_DOUBLETAP, m_current.size() == 2 ? 1 : 0);
-			m_uinput->emit(EV_KEY, BTN_TOOL_TRIPLETAP, m_current.size() == 3 ? 1 : 0);
-			m_uinput->emit(EV_KEY, BTN_TOOL_QUADTAP, m_current.size() == 4 ? 1 : 0);
-			m_uinput->emit(EV_KEY, BTN_TOOL_QUINTTAP, m_current.size() >= 5 ? 1 : 0);
+			m_uinput->emit(EV_KEY, BTN_TOOL_FINGER, m_count == 1 ? 1 : 0);
+			m_uinput->emit(EV_KEY, BTN_TOOL_DOUBLETAP, m_count == 2 ? 1 : 0);
+			m_uinput->emit(EV_KEY, BTN_TOOL_TRIPLETAP, m_count == 3 ? 1 : 0);
+			m_uinput->emit(EV_KEY, BTN_TOOL_QUADTAP, m_count == 4 ? 1 : 0);
+			m_uinput->emit(EV_KEY, BTN_TOOL_QUINTTAP, m_count >= 5 ? 1 : 0);
 		}
 
 		m_uinput->emit(EV_ABS, ABS_X, x);
@@ -459,21 +877,66 @@ private:
 	/*!
 	 * Lifts all currently active inputs.
 	 */
-	void lift_all() const
+	void lift_all()
 	{
-		for (const usize &index : m_current) {
-			m_uinput->emit(EV_ABS, ABS_MT_SLOT, casts::to<i32>(index));
+		for (const usize &index : m_current)
 			this->lift_multitouch(index);
-		}
 
-		for (const usize &index : m_last) {
-			m_uinput->emit(EV_ABS, ABS_MT_SLOT, casts::to<i32>(index));
+		for (const usize &index : m_last)
 			this->lift_multitouch(index);
-		}
+
+		// Slots that were not part of the last two frames are lifted as well.
+		while (!m_slots.empty())
+			this->lift_multitouch(m_slots.cbegin()->first);
 
 		this->lift_singletouch();
 	}
 
+	/*!
+	 * Finishes the contacts of a frame, when using type A.
+	 *
+	 * A frame without any contacts has to contain an empty packet, otherwise the contacts of
+	 * the previous frame would stay active.
+	 */
+	void end_packets()
+	{
+		if (m_protocol_a && m_packets == 0)
+			m_uinput->emit(EV_SYN, SYN_MT_REPORT, 0);
+
+		m_packets = 0;
+	}
+
+	/*!
+	 * Checks which multitouch protocol was selected.
+	 *
+	 * @param[in] protocol The name of the protocol, A or B.
+	 * @return Whether contacts are emitted using type A.
+	 */
+	[[nodiscard]] static bool parse_protocol(const std::string &protocol)
+	{
+		if (protocol != "A" && protocol != "B")
+			throw common::Error<Error::InvalidMultitouchProtocol> {protocol};
+
+		return protocol == "A";
+	}
+
+	/*!
+	 * Selects what happens to contacts outside of the touch area.
+	 *
+	 * @param[in] policy The name of the policy, clamp, drop or pass.
+	 */
+	void parse_range_policy(const std::string &policy)
+	{
+		if (policy == "drop") {
+			m_clamp_out_of_range = false;
+			m_drop_out_of_range = true;
+		} else if (policy == "pass") {
+			m_clamp_out_of_range = false;
+		} else if (policy != "clamp") {
+			throw common::Error<Error::InvalidRangePolicy> {};
+		}
+	}
+
 	/*!
 	 * Commits the emitted events to the linux kernel.
 	 */
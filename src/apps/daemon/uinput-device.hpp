@@ -3,21 +3,53 @@
 #ifndef IPTSD_APPS_DAEMON_UINPUT_DEVICE_HPP
 #define IPTSD_APPS_DAEMON_UINPUT_DEVICE_HPP
 
+#include "errors.hpp"
+#include "evemu.hpp"
+#include "remote.hpp"
+
+#include <common/casts.hpp>
+#include <common/error.hpp>
 #include <common/types.hpp>
 #include <core/linux/syscalls.hpp>
 
+#include <fmt/format.h>
+
 #include <linux/input.h>
 #include <linux/uinput.h>
 
+#include <array>
 #include <exception>
 #include <fcntl.h>
+#include <filesystem>
+#include <memory>
+#include <optional>
 #include <string>
+#include <system_error>
 #include <utility>
+#include <vector>
 
 namespace syscalls = iptsd::core::linux::syscalls;
 
 namespace iptsd::apps::daemon {
 
+/*
+ * Receives the events of devices that are only simulated, e.g. to check them for consistency.
+ */
+class EventObserver {
+public:
+	virtual ~EventObserver() = default;
+
+	/*!
+	 * Invoked for every event that a simulated device emits.
+	 *
+	 * @param[in] device The name of the device.
+	 * @param[in] type The event type.
+	 * @param[in] code The key of the button or axis.
+	 * @param[in] value The value of the button or axis.
+	 */
+	virtual void on_event(const std::string &device, u16 type, u16 code, i32 value) = 0;
+};
+
 class UinputDevice {
 private:
 	std::string m_name;
@@ -28,19 +60,83 @@ private:
 	// The file descriptor of the open uinput node.
 	int m_fd;
 
+	// The path of the existing device that events are written to, instead of creating one.
+	std::optional<std::filesystem::path> m_target = std::nullopt;
+
+	// The event types and codes that the existing device has to support.
+	std::vector<std::pair<u16, u16>> m_required {};
+
+	// The axes that the existing device has to support, and their range.
+	std::vector<struct uinput_abs_setup> m_required_abs {};
+
+	// Where events are sent to instead of a local device, if enabled.
+	std::shared_ptr<remote::Sink> m_remote = nullptr;
+
+	// Records the device and its events, if enabled.
+	std::shared_ptr<EvemuRecorder> m_recorder = nullptr;
+
+	// Receives the events instead of the kernel, if the device is only simulated.
+	std::shared_ptr<EventObserver> m_observer = nullptr;
+
 public:
 	UinputDevice() : m_fd {syscalls::open("/dev/uinput", O_WRONLY | O_NONBLOCK)} {};
 
+	/*!
+	 * Writes events into an existing evdev device, instead of creating a new one.
+	 *
+	 * The capabilities of the device are validated in @ref create().
+	 *
+	 * @param[in] path The device node of the existing device.
+	 */
+	UinputDevice(const std::filesystem::path &path)
+		: m_fd {syscalls::open(path, O_RDWR | O_NONBLOCK)},
+		  m_target {path} {};
+
+	/*!
+	 * Sends events to another machine, instead of creating a local device.
+	 *
+	 * @param[in] remote The connection to the receiver.
+	 */
+	UinputDevice(std::shared_ptr<remote::Sink> remote)
+		: m_fd {-1},
+		  m_remote {std::move(remote)} {};
+
+	/*!
+	 * Simulates the device, instead of creating it.
+	 *
+	 * @param[in] observer Receives the events of the device.
+	 */
+	UinputDevice(std::shared_ptr<EventObserver> observer)
+		: m_fd {-1},
+		  m_observer {std::move(observer)} {};
+
 	~UinputDevice()
 	{
+		if (m_remote || m_observer)
+			return;
+
 		try {
-			syscalls::ioctl(m_fd, UI_DEV_DESTROY);
+			if (!m_target.has_value())
+				syscalls::ioctl(m_fd, UI_DEV_DESTROY);
+
 			syscalls::close(m_fd);
 		} catch (const std::exception & /* unused */) {
 			// ignored
 		}
 	}
 
+	/*!
+	 * Records the device and all events that it emits in the format of evemu.
+	 *
+	 * Must be called before any of the capabilities are set.
+	 *
+	 * @param[in] path The file that the recording is written to.
+	 */
+	void record(const std::filesystem::path &path)
+	{
+		m_recorder = std::make_shared<EvemuRecorder>(path);
+	}
+
 	/*!
 	 * Sets the name of the device.
 	 *
@@ -96,9 +192,21 @@ public:
 	 *
 	 * @param[in] ev The event type to enable (e.g. EV_KEY or EV_ABS).
 	 */
-	void set_evbit(const i32 ev) const
+	void set_evbit(const i32 ev)
 	{
-		syscalls::ioctl(m_fd, UI_SET_EVBIT, ev);
+		if (m_recorder)
+			m_recorder->set_bit(0, casts::to<u16>(ev));
+
+		// A simulated device accepts any event.
+		if (m_observer)
+			return;
+
+		if (m_remote)
+			m_remote->add(remote::Capability::Kind::Event, casts::to<u16>(ev));
+		else if (m_target.has_value())
+			m_required.emplace_back(0, casts::to<u16>(ev));
+		else
+			syscalls::ioctl(m_fd, UI_SET_EVBIT, ev);
 	}
 
 	/*!
@@ -110,7 +218,17 @@ public:
 	 */
 	void set_propbit(const i32 prop) const
 	{
-		syscalls::ioctl(m_fd, UI_SET_PROPBIT, prop);
+		if (m_recorder)
+			m_recorder->set_prop(casts::to<u16>(prop));
+
+		if (m_observer)
+			return;
+
+		// Properties only describe the device, so they don't matter when attaching.
+		if (m_remote)
+			m_remote->add(remote::Capability::Kind::Property, casts::to<u16>(prop));
+		else if (!m_target.has_value())
+			syscalls::ioctl(m_fd, UI_SET_PROPBIT, prop);
 	}
 
 	/*!
@@ -120,9 +238,66 @@ public:
 	 *
 	 * @param[in] key They key to enable (e.g. BTN_TOUCH).
 	 */
-	void set_keybit(const i32 key) const
+	void set_keybit(const i32 key)
+	{
+		if (m_recorder)
+			m_recorder->set_bit(EV_KEY, casts::to<u16>(key));
+
+		if (m_observer)
+			return;
+
+		if (m_remote)
+			m_remote->add(remote::Capability::Kind::Key, casts::to<u16>(key));
+		else if (m_target.has_value())
+			m_required.emplace_back(EV_KEY, casts::to<u16>(key));
+		else
+			syscalls::ioctl(m_fd, UI_SET_KEYBIT, key);
+	}
+
+	/*!
+	 * Enables a relative axis for this device.
+	 *
+	 * Must be called before @ref create().
+	 *
+	 * @param[in] rel The axis to enable (e.g. REL_X).
+	 */
+	void set_relbit(const i32 rel)
+	{
+		if (m_recorder)
+			m_recorder->set_bit(EV_REL, casts::to<u16>(rel));
+
+		if (m_observer)
+			return;
+
+		if (m_remote)
+			m_remote->add(remote::Capability::Kind::Relative, casts::to<u16>(rel));
+		else if (m_target.has_value())
+			m_required.emplace_back(EV_REL, casts::to<u16>(rel));
+		else
+			syscalls::ioctl(m_fd, UI_SET_RELBIT, rel);
+	}
+
+	/*!
+	 * Enables a miscellaneous event for this device.
+	 *
+	 * Must be called before @ref create().
+	 *
+	 * @param[in] msc The event to enable (e.g. MSC_TIMESTAMP).
+	 */
+	void set_mscbit(const i32 msc)
 	{
-		syscalls::ioctl(m_fd, UI_SET_KEYBIT, key);
+		if (m_recorder)
+			m_recorder->set_bit(EV_MSC, casts::to<u16>(msc));
+
+		if (m_observer)
+			return;
+
+		if (m_remote)
+			m_remote->add(remote::Capability::Kind::Misc, casts::to<u16>(msc));
+		else if (m_target.has_value())
+			m_required.emplace_back(EV_MSC, casts::to<u16>(msc));
+		else
+			syscalls::ioctl(m_fd, UI_SET_MSCBIT, msc);
 	}
 
 	/*!
@@ -134,8 +309,13 @@ public:
 	 * @param[in] min The minimal value of the axis.
 	 * @param[in] max The maximal value of the axis.
 	 * @param[in] res The resolution of the axis, for converting virtual to physical units.
+	 * @param[in] fuzz Changes smaller than this are filtered by the kernel.
 	 */
-	void set_absinfo(const u16 code, const i32 min, const i32 max, const i32 res) const
+	void set_absinfo(const u16 code,
+	                 const i32 min,
+	                 const i32 max,
+	                 const i32 res,
+	                 const i32 fuzz = 0)
 	{
 		struct uinput_abs_setup abs {};
 
@@ -143,12 +323,33 @@ public:
 		abs.absinfo.minimum = min;
 		abs.absinfo.maximum = max;
 		abs.absinfo.resolution = res;
+		abs.absinfo.fuzz = fuzz;
 
-		syscalls::ioctl(m_fd, UI_ABS_SETUP, &abs);
+		if (m_recorder)
+			m_recorder->set_abs(code, min, max, fuzz, res);
+
+		if (m_observer)
+			return;
+
+		if (m_remote) {
+			m_remote->add(remote::Capability::Kind::Absolute,
+			              code,
+			              min,
+			              max,
+			              res,
+			              fuzz);
+		} else if (m_target.has_value()) {
+			m_required_abs.push_back(abs);
+		} else {
+			syscalls::ioctl(m_fd, UI_ABS_SETUP, &abs);
+		}
 	}
 
 	/*!
 	 * Finalizes the device creation.
+	 *
+	 * If events are written into an existing device, it is checked that the device
+	 * supports all events and axes that were enabled.
 	 */
 	void create() const
 	{
@@ -162,6 +363,22 @@ public:
 		const std::string name =
 			fmt::format("IPTSD Virtual {} {:04X}:{:04X}", m_name, m_vendor, m_product);
 
+		if (m_recorder)
+			m_recorder->start(name, setup.id);
+
+		if (m_observer)
+			return;
+
+		if (m_remote) {
+			m_remote->connect();
+			return;
+		}
+
+		if (m_target.has_value()) {
+			this->validate();
+			return;
+		}
+
 		// NOLINTNEXTLINE(cppcoreguidelines-pro-bounds-array-to-pointer-decay)
 		name.copy(setup.name, name.length(), 0);
 
@@ -169,6 +386,90 @@ public:
 		syscalls::ioctl(m_fd, UI_DEV_CREATE);
 	}
 
+	/*!
+	 * Checks if the existing device supports all required events and axes.
+	 */
+	void validate() const
+	{
+		for (const auto &[type, code] : m_required) {
+			if (!this->has_bit(type, code)) {
+				throw common::Error<Error::MissingCapability> {m_target->c_str(),
+				                                               type,
+				                                               code};
+			}
+		}
+
+		for (const struct uinput_abs_setup &abs : m_required_abs) {
+			if (!this->has_bit(EV_ABS, abs.code))
+				throw common::Error<Error::MissingCapability> {m_target->c_str(), EV_ABS, abs.code};
+
+			struct input_absinfo info {};
+			syscalls::ioctl(m_fd, EVIOCGABS(abs.code), &info);
+
+			if (info.minimum != abs.absinfo.minimum || info.maximum != abs.absinfo.maximum) {
+				throw common::Error<Error::IncompatibleAxis> {abs.code,
+				                                              m_target->c_str(),
+				                                              info.minimum,
+				                                              info.maximum,
+				                                              abs.absinfo.minimum,
+				                                              abs.absinfo.maximum};
+			}
+		}
+	}
+
+	/*!
+	 * Checks if the existing device supports an event.
+	 *
+	 * @param[in] type The event type, or 0 to check for the event type itself.
+	 * @param[in] code The event code.
+	 * @return Whether the event is supported.
+	 */
+	[[nodiscard]] bool has_bit(const u16 type, const u16 code) const
+	{
+		std::array<u8, (KEY_MAX / 8) + 1> bits {};
+
+		syscalls::ioctl(m_fd, EVIOCGBIT(type, bits.size()), bits.data());
+		return (bits.at(code / 8) & (1 << (code % 8))) != 0;
+	}
+
+	/*!
+	 * Searches for the evdev node that processes read the events of this device from.
+	 *
+	 * Must be called after @ref create().
+	 *
+	 * @return The path of the evdev node, if the device is a local one and it was found.
+	 */
+	[[nodiscard]] std::optional<std::filesystem::path> node() const
+	{
+		if (m_remote || m_observer)
+			return std::nullopt;
+
+		if (m_target.has_value())
+			return m_target;
+
+		std::array<char, 64> sysname {};
+
+		try {
+			syscalls::ioctl(m_fd, UI_GET_SYSNAME(sysname.size()), sysname.data());
+		} catch (const std::exception & /* unused */) {
+			return std::nullopt;
+		}
+
+		std::error_code ec {};
+
+		const std::filesystem::path sys =
+			std::filesystem::path {"/sys/devices/virtual/input"} / sysname.data();
+
+		for (const auto &entry : std::filesystem::directory_iterator {sys, ec}) {
+			const std::string name = entry.path().filename().string();
+
+			if (name.rfind("event", 0) == 0)
+				return std::filesystem::path {"/dev/input"} / name;
+		}
+
+		return std::nullopt;
+	}
+
 	/*!
 	 * Emits an event.
 	 *
@@ -180,6 +481,19 @@ public:
 	 */
 	void emit(const u16 type, const u16 key, const i32 value) const
 	{
+		if (m_recorder)
+			m_recorder->event(type, key, value);
+
+		if (m_observer) {
+			m_observer->on_event(m_name, type, key, value);
+			return;
+		}
+
+		if (m_remote) {
+			m_remote->emit(type, key, value);
+			return;
+		}
+
 		struct input_event ie {};
 
 		ie.type = type;
@@ -190,6 +504,74 @@ public:
 	}
 };
 
+namespace impl {
+
+// Receives the events of all devices that are opened, if they are only simulated.
+inline std::shared_ptr<EventObserver> simulation = nullptr;
+
+} // namespace impl
+
+/*!
+ * Simulates all devices that are opened from now on, instead of creating them.
+ *
+ * This allows checking the events that would be emitted, without access to uinput.
+ *
+ * @param[in] observer Receives the events of the simulated devices.
+ */
+inline void simulate_devices(std::shared_ptr<EventObserver> observer)
+{
+	impl::simulation = std::move(observer);
+}
+
+/*!
+ * Opens the device that events are emitted through.
+ *
+ * @param[in] target The path of an existing device. If empty, a new device is created.
+ * @param[in] record Where the events are recorded in evemu format. If empty, they are not.
+ * @return The uinput device.
+ */
+inline std::shared_ptr<UinputDevice> open_uinput_device(const std::string &target,
+                                                        const std::filesystem::path &record = {})
+{
+	std::shared_ptr<UinputDevice> device = nullptr;
+
+	if (impl::simulation)
+		device = std::make_shared<UinputDevice>(impl::simulation);
+	else if (target.empty())
+		device = std::make_shared<UinputDevice>();
+	else
+		device = std::make_shared<UinputDevice>(std::filesystem::path {target});
+
+	if (!record.empty())
+		device->record(record);
+
+	return device;
+}
+
+/*!
+ * Creates a device that sends its events to another machine.
+ *
+ * @param[in] address The address of the receiver, as HOST:PORT.
+ * @param[in] token Authenticates this machine to the receiver.
+ * @param[in] record Where the events are recorded in evemu format. If empty, they are not.
+ * @return The device.
+ */
+inline std::shared_ptr<UinputDevice> open_remote_device(const std::string &address,
+                                                        const std::string &token,
+                                                        const std::filesystem::path &record = {})
+{
+	if (impl::simulation)
+		return open_uinput_device({}, record);
+
+	auto device =
+		std::make_shared<UinputDevice>(std::make_shared<remote::Sink>(address, token));
+
+	if (!record.empty())
+		device->record(record);
+
+	return device;
+}
+
 } // namespace iptsd::apps::daemon
 
 #endif // IPTSD_APPS_DAEMON_UINPUT_DEVICE_HPP
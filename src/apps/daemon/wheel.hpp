@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_WHEEL_HPP
+#define IPTSD_APPS_DAEMON_WHEEL_HPP
+
+#include "uinput-device.hpp"
+
+#include <common/casts.hpp>
+#include <common/types.hpp>
+
+#include <linux/input-event-codes.h>
+
+#include <cmath>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * Turns continuous scrolling into hi-res and regular wheel events.
+ *
+ * Scrolling is natural, the content follows the movement.
+ */
+class ScrollWheel {
+public:
+	// How many hi-res wheel units make up one notch of a regular scroll wheel.
+	constexpr static i32 WHEEL_NOTCH = 120;
+
+private:
+	// The scrolling that was too small to be emitted yet, in hi-res wheel units.
+	Vector2<f64> m_scroll = Vector2<f64>::Zero();
+
+	// The hi-res wheel units that don't fill a regular notch yet.
+	Vector2<i32> m_notch = Vector2<i32>::Zero();
+
+public:
+	/*!
+	 * Scrolls vertically and horizontally.
+	 *
+	 * The events are not committed, the caller has to sync the device.
+	 *
+	 * @param[in] device The device that the events are emitted through.
+	 * @param[in] units The movement in hi-res wheel units.
+	 * @return Whether any events were emitted.
+	 */
+	bool scroll(UinputDevice &device, const Vector2<f64> &units)
+	{
+		m_scroll += units;
+
+		const i32 h = casts::to<i32>(std::trunc(m_scroll.x()));
+		const i32 v = casts::to<i32>(std::trunc(m_scroll.y()));
+
+		if (h == 0 && v == 0)
+			return false;
+
+		m_scroll.x() -= h;
+		m_scroll.y() -= v;
+
+		if (v != 0)
+			device.emit(EV_REL, REL_WHEEL_HI_RES, v);
+
+		if (h != 0)
+			device.emit(EV_REL, REL_HWHEEL_HI_RES, -h);
+
+		// Emit regular wheel events for applications that don't support hi-res scrolling.
+		m_notch.x() += h;
+		m_notch.y() += v;
+
+		const i32 notch_h = m_notch.x() / WHEEL_NOTCH;
+		const i32 notch_v = m_notch.y() / WHEEL_NOTCH;
+
+		if (notch_v != 0) {
+			device.emit(EV_REL, REL_WHEEL, notch_v);
+			m_notch.y() -= notch_v * WHEEL_NOTCH;
+		}
+
+		if (notch_h != 0) {
+			device.emit(EV_REL, REL_HWHEEL, -notch_h);
+			m_notch.x() -= notch_h * WHEEL_NOTCH;
+		}
+
+		return true;
+	}
+
+	/*!
+	 * Forgets the scrolling that was not emitted yet.
+	 */
+	void reset()
+	{
+		m_scroll = Vector2<f64>::Zero();
+		m_notch = Vector2<i32>::Zero();
+	}
+
+	/*!
+	 * Enables the axes that are needed for scrolling.
+	 *
+	 * @param[in] device The device to set up.
+	 */
+	static void setup(UinputDevice &device)
+	{
+		device.set_relbit(REL_WHEEL);
+		device.set_relbit(REL_HWHEEL);
+		device.set_relbit(REL_WHEEL_HI_RES);
+		device.set_relbit(REL_HWHEEL_HI_RES);
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_WHEEL_HPP
@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DECODE_DECODE_HPP
+#define IPTSD_APPS_DECODE_DECODE_HPP
+
+#include <common/reader.hpp>
+#include <common/types.hpp>
+#include <contacts/contact.hpp>
+#include <core/generic/application.hpp>
+#include <core/generic/config.hpp>
+#include <core/generic/device.hpp>
+#include <ipts/samples/button.hpp>
+#include <ipts/samples/stylus.hpp>
+
+#include <fmt/format.h>
+#include <fmt/ranges.h>
+#include <gsl/gsl>
+
+#include <algorithm>
+#include <exception>
+#include <string>
+#include <string_view>
+#include <vector>
+
+namespace iptsd::apps::decode {
+
+/*
+ * Prints the contents of every buffer that is processed, for reverse engineering new data.
+ */
+class Decode : public core::Application {
+private:
+	// How many bytes of a region are printed at most.
+	constexpr static usize MAX_BYTES = 32;
+
+public:
+	// Whether an annotated hexdump of every buffer is printed.
+	bool hexdump = false;
+
+private:
+	// How many buffers were processed.
+	usize m_buffer = 0;
+
+	// The regions of the current buffer that were parsed.
+	std::vector<ReaderRegion> m_regions {};
+
+public:
+	Decode(const core::Config &config, const core::DeviceInfo &info)
+		: core::Application(config, info) {};
+
+	void on_data(const gsl::span<u8> data) override
+	{
+		m_regions.clear();
+		m_parser.trace(hexdump ? &m_regions : nullptr);
+
+		fmt::print("Buffer {} ({} bytes)\n", m_buffer++, data.size());
+
+		try {
+			core::Application::on_data(data);
+		} catch (const std::exception & /* unused */) {
+			// Show everything that was parsed until the data became invalid.
+			this->print_hexdump(data);
+			throw;
+		}
+
+		this->print_hexdump(data);
+	}
+
+	void on_touch(const std::vector<contacts::Contact<f64>> &contacts) override
+	{
+		fmt::print("  Touch: {} contacts\n", contacts.size());
+	}
+
+	void on_stylus(const ipts::samples::Stylus &stylus) override
+	{
+		fmt::print("  Stylus: serial {:08X}, proximity {}, contact {}, x {:.4f}, y {:.4f}, "
+		           "pressure {:.4f}\n",
+		           stylus.serial,
+		           stylus.proximity,
+		           stylus.contact,
+		           stylus.x,
+		           stylus.y,
+		           stylus.pressure);
+	}
+
+	void on_button(const ipts::samples::Button &button) override
+	{
+		fmt::print("  Button: {}\n", button.active ? "pressed" : "released");
+	}
+
+private:
+	/*!
+	 * Prints all regions of the buffer, labeled with what they were parsed as.
+	 *
+	 * Regions that were not touched by the parser are shown as undecoded.
+	 *
+	 * @param[in] data The buffer that was parsed.
+	 */
+	void print_hexdump(const gsl::span<u8> data)
+	{
+		if (!hexdump)
+			return;
+
+		std::sort(m_regions.begin(), m_regions.end(), [](const auto &a, const auto &b) {
+			return a.offset < b.offset;
+		});
+
+		usize position = 0;
+
+		for (const ReaderRegion &region : m_regions) {
+			if (region.offset > position)
+				print_region(data, position, region.offset - position, "undecoded");
+
+			print_region(data, region.offset, region.size, region.name);
+			position = std::max(position, region.offset + region.size);
+		}
+
+		if (position < data.size())
+			print_region(data, position, data.size() - position, "undecoded");
+	}
+
+	/*!
+	 * Prints a single region of the buffer.
+	 *
+	 * @param[in] data The buffer that was parsed.
+	 * @param[in] offset Where the region starts.
+	 * @param[in] size How many bytes the region spans.
+	 * @param[in] name What the region contains.
+	 */
+	static void print_region(const gsl::span<u8> data,
+	                         const usize offset,
+	                         const usize size,
+	                         std::string_view name)
+	{
+		// The namespace of the protocol structs is the same for all of them.
+		constexpr std::string_view prefix = "iptsd::ipts::protocol::";
+
+		if (name.substr(0, prefix.size()) == prefix)
+			name.remove_prefix(prefix.size());
+
+		const gsl::span<u8> bytes = data.subspan(offset, std::min(size, MAX_BYTES));
+		const std::string ellipsis = size > MAX_BYTES ? " ..." : "";
+
+		fmt::print("    {:#06x}  {:>5}  {:<40}  {:02x}{}\n",
+		           offset,
+		           size,
+		           name,
+		           fmt::join(bytes, " "),
+		           ellipsis);
+	}
+};
+
+} // namespace iptsd::apps::decode
+
+#endif // IPTSD_APPS_DECODE_DECODE_HPP
@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#include "decode.hpp"
+
+#include <common/types.hpp>
+#include <core/linux/device/file.hpp>
+#include <core/linux/runner.hpp>
+#include <core/linux/signal-handler.hpp>
+
+#include <CLI/CLI.hpp>
+#include <gsl/gsl>
+#include <spdlog/spdlog.h>
+
+#include <csignal>
+#include <cstdlib>
+#include <exception>
+#include <filesystem>
+#include <string>
+
+namespace iptsd::apps::decode {
+namespace {
+
+int run(const int argc, const char **argv)
+{
+	CLI::App app {"Utility for printing the contents of a binary data file"};
+
+	std::filesystem::path path {};
+	app.add_option("DATA", path)
+		->description("A binary data file containing touch reports")
+		->type_name("FILE")
+		->required();
+
+	bool hexdump = false;
+	app.add_flag("-x,--hexdump", hexdump)
+		->description("Print the offset and raw bytes of every parsed structure");
+
+	CLI11_PARSE(app, argc, argv);
+
+	// Create a decoding application that reads from a file.
+	core::linux::Runner<Decode, core::linux::device::File> decode {path};
+	decode.application().hexdump = hexdump;
+
+	const auto _sigterm = core::linux::signal<SIGTERM>([&](int) { decode.stop(); });
+	const auto _sigint = core::linux::signal<SIGINT>([&](int) { decode.stop(); });
+
+	decode.run();
+	return 0;
+}
+
+} // namespace
+} // namespace iptsd::apps::decode
+
+int main(const int argc, const char **argv)
+{
+	spdlog::set_pattern("[%X.%e] [%^%l%$] %v");
+
+	try {
+		return iptsd::apps::decode::run(argc, argv);
+	} catch (const std::exception &e) {
+		spdlog::error(e.what());
+		return EXIT_FAILURE;
+	}
+}
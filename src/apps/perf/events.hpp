@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_PERF_EVENTS_HPP
+#define IPTSD_APPS_PERF_EVENTS_HPP
+
+#include <apps/daemon/uinput-device.hpp>
+#include <common/casts.hpp>
+#include <common/types.hpp>
+
+#include <fmt/format.h>
+#include <spdlog/spdlog.h>
+
+#include <linux/input.h>
+
+#include <algorithm>
+#include <map>
+#include <string>
+
+namespace iptsd::apps::perf {
+
+/*
+ * Checks the events that the daemon emits for inconsistent states, e.g. leaking contacts.
+ *
+ * The events are checked like an evdev client would see them, i.e. every time a device
+ * emits SYN_REPORT.
+ */
+class EventChecker : public daemon::EventObserver {
+private:
+	/*
+	 * What a client knows about a device, from the events that it received.
+	 */
+	struct State {
+		// The state of all keys that were emitted.
+		std::map<u16, i32> keys {};
+
+		// The tracking IDs of the slots of a touch device.
+		std::map<i32, i32> slots {};
+
+		// How many slots were active after the last SYN_REPORT.
+		usize active = 0;
+
+		// The slot that the next multitouch events refer to.
+		i32 slot = 0;
+
+		// Whether the device uses slots.
+		bool multitouch = false;
+
+		// Whether the device reports which tool is in proximity.
+		bool tool = false;
+
+		// How many times SYN_REPORT was emitted.
+		usize frames = 0;
+	};
+
+	// The state of every device, by its name.
+	std::map<std::string, State> m_devices {};
+
+	// How many inconsistent states were found.
+	usize m_violations = 0;
+
+public:
+	/*!
+	 * How many inconsistent states were found.
+	 *
+	 * @return The number of inconsistent states.
+	 */
+	[[nodiscard]] usize violations() const
+	{
+		return m_violations;
+	}
+
+	void on_event(const std::string &device,
+	              const u16 type,
+	              const u16 code,
+	              const i32 value) override
+	{
+		State &state = m_devices[device];
+
+		if (type == EV_KEY) {
+			if (value < 0 || value > 2)
+				this->violation(device, state, "Key {} is {}", code, value);
+
+			if (code == BTN_TOOL_PEN || code == BTN_TOOL_RUBBER)
+				state.tool = true;
+
+			state.keys[code] = value;
+		} else if (type == EV_ABS && code == ABS_MT_SLOT) {
+			if (value < 0)
+				this->violation(device, state, "Slot {} is invalid", value);
+
+			state.multitouch = true;
+			state.slot = value;
+		} else if (type == EV_ABS && code == ABS_MT_TRACKING_ID) {
+			const auto it = state.slots.find(state.slot);
+			const i32 last = it != state.slots.end() ? it->second : -1;
+
+			if (value != -1 && last != -1 && value != last) {
+				this->violation(device,
+				                state,
+				                "Slot {} got tracking ID {} while {} was active",
+				                state.slot,
+				                value,
+				                last);
+			}
+
+			state.multitouch = true;
+			state.slots[state.slot] = value;
+		} else if (type == EV_SYN && code == SYN_REPORT) {
+			this->check_frame(device, state);
+			state.frames++;
+		}
+	}
+
+private:
+	/*!
+	 * Checks the state of a device, once a frame was completed.
+	 *
+	 * @param[in] device The name of the device.
+	 * @param[in,out] state What a client knows about the device.
+	 */
+	void check_frame(const std::string &device, State &state)
+	{
+		const bool touching = key(state, BTN_TOUCH);
+
+		if (state.multitouch)
+			this->check_slots(device, state, touching);
+
+		if (!state.tool)
+			return;
+
+		const bool pen = key(state, BTN_TOOL_PEN);
+		const bool rubber = key(state, BTN_TOOL_RUBBER);
+
+		if (touching && !pen && !rubber)
+			this->violation(device, state, "Touching without being in proximity");
+
+		if (pen && rubber)
+			this->violation(device, state, "Pen and rubber are both in proximity");
+	}
+
+	/*!
+	 * Checks that the slots of a touch device behave like the ones of a touchscreen.
+	 *
+	 * New contacts have to reuse the lowest free slot. This means that a slot can never be
+	 * higher than the number of active slots in the current and last frame combined.
+	 * Otherwise, slots are leaking.
+	 *
+	 * @param[in] device The name of the device.
+	 * @param[in,out] state What a client knows about the device.
+	 * @param[in] touching Whether BTN_TOUCH is pressed.
+	 */
+	void check_slots(const std::string &device, State &state, const bool touching)
+	{
+		const auto is_active = [](const auto &slot) { return slot.second != -1; };
+		const auto active = casts::to<usize>(
+			std::count_if(state.slots.begin(), state.slots.end(), is_active));
+
+		for (const auto &[slot, id] : state.slots) {
+			if (id == -1)
+				continue;
+
+			if (casts::to<usize>(slot) < state.active + active)
+				continue;
+
+			this->violation(device, state, "Slot {} is leaking", slot);
+		}
+
+		if (touching != (active > 0)) {
+			this->violation(device,
+			                state,
+			                "BTN_TOUCH is {} while {} slots are active",
+			                touching ? 1 : 0,
+			                active);
+		}
+
+		state.active = active;
+	}
+
+	/*!
+	 * Whether a key of a device is pressed.
+	 *
+	 * @param[in] state What a client knows about the device.
+	 * @param[in] code The key.
+	 * @return true if the last value of the key was not zero.
+	 */
+	[[nodiscard]] static bool key(const State &state, const u16 code)
+	{
+		const auto it = state.keys.find(code);
+		return it != state.keys.end() && it->second != 0;
+	}
+
+	/*!
+	 * Reports an inconsistent state.
+	 *
+	 * @param[in] device The name of the device.
+	 * @param[in] state What a client knows about the device.
+	 * @param[in] format What is inconsistent, as a format string.
+	 * @param[in] args The arguments of the format string.
+	 */
+	template <class... Args>
+	void violation(const std::string &device,
+	               const State &state,
+	               const std::string &format,
+	               const Args &...args)
+	{
+		m_violations++;
+
+		const std::string message = fmt::format(fmt::runtime(format), args...);
+		spdlog::warn("{} frame {}: {}", device, state.frames, message);
+	}
+};
+
+} // namespace iptsd::apps::perf
+
+#endif // IPTSD_APPS_PERF_EVENTS_HPP
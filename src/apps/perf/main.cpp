@@ -1,7 +1,10 @@
 // SPDX-License-Identifier: GPL-2.0-or-later
 
+#include "events.hpp"
 #include "perf.hpp"
 
+#include <apps/daemon/daemon.hpp>
+#include <apps/daemon/uinput-device.hpp>
 #include <common/casts.hpp>
 #include <common/chrono.hpp>
 #include <common/types.hpp>
@@ -18,11 +21,42 @@
 #include <cstdlib>
 #include <exception>
 #include <filesystem>
+#include <memory>
 #include <string>
 
 namespace iptsd::apps::perf {
 namespace {
 
+/*!
+ * Replays the data through the daemon, and checks the events that it emits.
+ *
+ * The devices of the daemon are only simulated, so this doesn't need access to uinput.
+ *
+ * @param[in] path The file that contains the data.
+ * @param[in] speed How much faster than the original timing the data is replayed, or 0.
+ * @return The exit code of the program.
+ */
+int run_check(const std::filesystem::path &path, const f64 speed)
+{
+	const auto checker = std::make_shared<EventChecker>();
+	daemon::simulate_devices(checker);
+
+	core::linux::Runner<daemon::Daemon, core::linux::device::File> replay {path};
+	replay.device().set_speed(speed);
+
+	const auto _sigterm = core::linux::signal<SIGTERM>([&](int) { replay.stop(); });
+	const auto _sigint = core::linux::signal<SIGINT>([&](int) { replay.stop(); });
+
+	const bool should_stop = replay.run();
+
+	spdlog::info("Inconsistent states: {}", checker->violations());
+
+	if (!should_stop || checker->violations() > 0)
+		return EXIT_FAILURE;
+
+	return 0;
+}
+
 int run(const int argc, const char **argv)
 {
 	CLI::App app {"Utility for performance testing of iptsd"};
@@ -39,10 +73,24 @@ int run(const int argc, const char **argv)
 		->check(CLI::PositiveNumber)
 		->default_val(10);
 
+	bool check = false;
+	app.add_flag("-c,--check", check)
+		->description("Check the emitted events for inconsistencies, e.g. leaking slots");
+
+	f64 speed = 0;
+	app.add_option("-t,--timing", speed)
+		->description("Replay the data with the original timing, sped up by this factor")
+		->type_name("FACTOR")
+		->check(CLI::NonNegativeNumber);
+
 	CLI11_PARSE(app, argc, argv);
 
+	if (check)
+		return run_check(path, speed);
+
 	// Create a performance testing application that reads from a file.
 	core::linux::Runner<Perf, core::linux::device::File> perf {path};
+	perf.device().set_speed(speed);
 
 	const auto _sigterm = core::linux::signal<SIGTERM>([&](int) { perf.stop(); });
 	const auto _sigint = core::linux::signal<SIGINT>([&](int) { perf.stop(); });
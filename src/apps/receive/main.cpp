@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#include "receive.hpp"
+
+#include <apps/daemon/remote.hpp>
+#include <common/buildopts.hpp>
+#include <common/types.hpp>
+#include <core/linux/signal-handler.hpp>
+
+#include <CLI/CLI.hpp>
+#include <spdlog/spdlog.h>
+
+#include <csignal>
+#include <cstdlib>
+#include <exception>
+#include <string>
+
+namespace iptsd::apps::receive {
+namespace {
+
+int run(const int argc, const char **argv)
+{
+	CLI::App app {"Utility for replaying the stylus events that a remote iptsd sends"};
+	app.set_version_flag("-v,--version", std::string {common::buildopts::Version});
+
+	std::string address = "127.0.0.1:4004";
+	app.add_option("-l,--listen", address)
+		->description("The address to listen on, as HOST:PORT (default: 127.0.0.1:4004)")
+		->type_name("ADDRESS");
+
+	std::string token {};
+	app.add_option("-t,--token", token)
+		->description("The token that senders have to send (Stylus.RemoteToken)")
+		->type_name("TOKEN")
+		->required();
+
+	CLI11_PARSE(app, argc, argv);
+
+	const auto [host, port] = daemon::remote::parse_address(address);
+	Receiver receiver {host, port, token};
+
+	const auto _sigterm = core::linux::signal<SIGTERM>([&](int) { receiver.stop(); });
+	const auto _sigint = core::linux::signal<SIGINT>([&](int) { receiver.stop(); });
+
+	receiver.run();
+	return 0;
+}
+
+} // namespace
+} // namespace iptsd::apps::receive
+
+int main(const int argc, const char **argv)
+{
+	spdlog::set_pattern("[%X.%e] [%^%l%$] %v");
+
+	try {
+		return iptsd::apps::receive::run(argc, argv);
+	} catch (const std::exception &e) {
+		spdlog::error(e.what());
+		return EXIT_FAILURE;
+	}
+}
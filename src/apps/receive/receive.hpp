@@ -0,0 +1,355 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_RECEIVE_RECEIVE_HPP
+#define IPTSD_APPS_RECEIVE_RECEIVE_HPP
+
+#include <apps/daemon/errors.hpp>
+#include <apps/daemon/remote.hpp>
+#include <apps/daemon/uinput-device.hpp>
+#include <common/casts.hpp>
+#include <common/error.hpp>
+#include <common/types.hpp>
+#include <core/linux/syscalls.hpp>
+
+#include <gsl/gsl>
+#include <spdlog/spdlog.h>
+
+#include <linux/input-event-codes.h>
+
+#include <atomic>
+#include <cstring>
+#include <exception>
+#include <memory>
+#include <optional>
+#include <set>
+#include <string>
+#include <utility>
+#include <vector>
+
+namespace iptsd::apps::receive {
+
+namespace remote = daemon::remote;
+using Error = daemon::Error;
+
+/*
+ * Replays the events that a remote iptsd sends into a local device.
+ *
+ * Only one sender is served at a time. The device is created from the setup message
+ * of the sender and removed again when the sender disconnects. Senders have to know the
+ * token, and the device can only be a stylus, so that a sender can't inject keystrokes.
+ */
+class Receiver {
+private:
+	// How long to wait for data before checking if the receiver should stop, in milliseconds.
+	constexpr static int POLL_TIMEOUT = 200;
+
+	// The file descriptor of the listening socket.
+	int m_fd;
+
+	// The token that senders have to send before they are accepted.
+	std::string m_token;
+
+	// Whether the receiver should stop.
+	std::atomic_bool m_should_stop = false;
+
+	// The event types and codes that the device of the current sender supports.
+	std::set<std::pair<u16, u16>> m_events {};
+
+public:
+	Receiver(const std::string &address, const u16 port, std::string token)
+		: m_fd {-1}, m_token {std::move(token)}
+	{
+		if (m_token.empty())
+			throw common::Error<Error::MissingRemoteToken> {};
+
+		m_fd = core::linux::syscalls::listen_tcp(address, port, 1);
+		spdlog::info("Listening on {}:{}", address, port);
+	}
+
+	Receiver(const Receiver &) = delete;
+	Receiver &operator=(const Receiver &) = delete;
+
+	~Receiver()
+	{
+		close(m_fd);
+	}
+
+	/*!
+	 * Stops the receiver.
+	 *
+	 * This function is designed to be called from a signal handler.
+	 */
+	void stop()
+	{
+		m_should_stop = true;
+	}
+
+	/*!
+	 * Accepts senders and replays their events, until the receiver is stopped.
+	 */
+	void run()
+	{
+		while (!m_should_stop) {
+			if (!core::linux::syscalls::poll(m_fd, POLL_TIMEOUT))
+				continue;
+
+			const int client = core::linux::syscalls::accept(m_fd);
+
+			if (client == -1)
+				continue;
+
+			try {
+				this->serve(client);
+			} catch (const std::exception &e) {
+				spdlog::warn(e.what());
+			}
+
+			close(client);
+		}
+	}
+
+private:
+	/*!
+	 * Replays the events of a sender, until it disconnects.
+	 *
+	 * @param[in] fd The file descriptor of the connection to the sender.
+	 */
+	void serve(const int fd)
+	{
+		const auto hello = this->receive(fd, remote::Message::Hello);
+		if (!hello.has_value())
+			return;
+
+		const std::string token {hello->cbegin(), hello->cend()};
+
+		if (token != m_token) {
+			spdlog::warn("Rejected a sender with an invalid token");
+			return;
+		}
+
+		const auto setup = this->receive(fd, remote::Message::Setup);
+		if (!setup.has_value())
+			return;
+
+		const std::shared_ptr<daemon::UinputDevice> device = create(setup.value());
+		spdlog::info("Receiving events from a sender");
+
+		while (!m_should_stop) {
+			const auto events = this->receive(fd, remote::Message::Events);
+
+			if (!events.has_value())
+				break;
+
+			for (const remote::Event &event : parse<remote::Event>(events.value())) {
+				const bool allowed = m_events.count({event.type, event.code}) == 1;
+
+				if (event.type != EV_SYN && !allowed)
+					continue;
+
+				device->emit(event.type, event.code, event.value);
+			}
+		}
+
+		spdlog::info("The sender disconnected");
+	}
+
+	/*!
+	 * Creates the local device that events are replayed into.
+	 *
+	 * Capabilities that a stylus doesn't have are skipped, and their events are dropped.
+	 *
+	 * @param[in] setup The payload of the setup message.
+	 * @return The created device.
+	 */
+	std::shared_ptr<daemon::UinputDevice> create(const std::vector<u8> &setup)
+	{
+		using Kind = remote::Capability::Kind;
+
+		auto device = std::make_shared<daemon::UinputDevice>();
+		device->set_name("Remote Stylus");
+
+		m_events.clear();
+
+		for (const remote::Capability &cap : parse<remote::Capability>(setup)) {
+			const u16 code = cap.code;
+
+			if (!is_stylus(cap)) {
+				const u8 kind = static_cast<u8>(cap.kind);
+				spdlog::warn("Ignoring capability {} with code {} of the sender",
+				             kind,
+				             code);
+
+				continue;
+			}
+
+			switch (cap.kind) {
+			case Kind::Event:
+				device->set_evbit(code);
+				break;
+			case Kind::Property:
+				device->set_propbit(code);
+				break;
+			case Kind::Key:
+				device->set_keybit(code);
+				m_events.emplace(EV_KEY, code);
+				break;
+			case Kind::Absolute:
+				device->set_absinfo(code, cap.min, cap.max, cap.res, cap.fuzz);
+				m_events.emplace(EV_ABS, code);
+				break;
+			case Kind::Misc:
+				device->set_mscbit(code);
+				m_events.emplace(EV_MSC, code);
+				break;
+			default:
+				break;
+			}
+		}
+
+		device->create();
+		return device;
+	}
+
+	/*!
+	 * Checks if a capability belongs to a stylus.
+	 *
+	 * @param[in] cap The capability that the sender requested.
+	 * @return Whether the device may get the capability.
+	 */
+	[[nodiscard]] static bool is_stylus(const remote::Capability &cap)
+	{
+		using Kind = remote::Capability::Kind;
+
+		const std::set<u16> events {EV_KEY, EV_ABS, EV_MSC};
+		const std::set<u16> properties {INPUT_PROP_DIRECT, INPUT_PROP_POINTER};
+
+		const std::set<u16> keys {
+			BTN_TOUCH,
+			BTN_STYLUS,
+			BTN_STYLUS2,
+			BTN_STYLUS3,
+			BTN_TOOL_PEN,
+			BTN_TOOL_RUBBER,
+		};
+
+		const std::set<u16> axes {
+			ABS_X,
+			ABS_Y,
+			ABS_PRESSURE,
+			ABS_TILT_X,
+			ABS_TILT_Y,
+			ABS_MISC,
+		};
+
+		switch (cap.kind) {
+		case Kind::Event:
+			return events.count(cap.code) == 1;
+		case Kind::Property:
+			return properties.count(cap.code) == 1;
+		case Kind::Key:
+			return keys.count(cap.code) == 1;
+		case Kind::Absolute:
+			return axes.count(cap.code) == 1;
+		case Kind::Misc:
+			return cap.code == MSC_TIMESTAMP;
+		default:
+			return false;
+		}
+	}
+
+	/*!
+	 * Waits for the next message of a sender.
+	 *
+	 * @param[in] fd The file descriptor of the connection.
+	 * @param[in] expected The type of message that has to be received.
+	 * @return The payload of the message, or nothing if the sender disconnected.
+	 */
+	std::optional<std::vector<u8>> receive(const int fd, const remote::Message expected)
+	{
+		remote::Header header {};
+
+		// NOLINTNEXTLINE(cppcoreguidelines-pro-type-reinterpret-cast)
+		if (!this->read(fd, gsl::span {reinterpret_cast<u8 *>(&header), sizeof(header)}))
+			return std::nullopt;
+
+		if (header.version != remote::VERSION) {
+			const u8 version = header.version;
+			const std::string error =
+				fmt::format("Got version {}, expected {}", version, remote::VERSION);
+
+			throw common::Error<Error::InvalidRemoteMessage> {error};
+		}
+
+		if (header.message != expected)
+			throw common::Error<Error::InvalidRemoteMessage> {"Unexpected message"};
+
+		if (header.length > remote::MAX_PAYLOAD)
+			throw common::Error<Error::InvalidRemoteMessage> {"Message is too large"};
+
+		std::vector<u8> payload(header.length);
+
+		if (!this->read(fd, gsl::span {payload}))
+			return std::nullopt;
+
+		return payload;
+	}
+
+	/*!
+	 * Reads a fixed amount of data from a connection.
+	 *
+	 * @param[in] fd The file descriptor of the connection.
+	 * @param[out] dest Where the data is written to.
+	 * @return Whether all data was read. Returns false if the sender disconnected.
+	 */
+	bool read(const int fd, gsl::span<u8> dest)
+	{
+		while (!dest.empty()) {
+			if (m_should_stop)
+				return false;
+
+			if (!core::linux::syscalls::poll(fd, POLL_TIMEOUT))
+				continue;
+
+			const usize size = core::linux::syscalls::read(fd, dest);
+
+			if (size == 0)
+				return false;
+
+			dest = dest.subspan(size);
+		}
+
+		return true;
+	}
+
+	/*!
+	 * Splits the payload of a message into its entries.
+	 *
+	 * @tparam T The type of the entries.
+	 * @param[in] payload The payload of the message.
+	 * @return The entries of the payload.
+	 */
+	template <class T>
+	static std::vector<T> parse(const std::vector<u8> &payload)
+	{
+		if (payload.size() % sizeof(T) != 0)
+			throw common::Error<Error::InvalidRemoteMessage> {"Truncated payload"};
+
+		std::vector<T> entries(payload.size() / sizeof(T));
+		std::memcpy(entries.data(), payload.data(), payload.size());
+
+		return entries;
+	}
+
+	static void close(const int fd)
+	{
+		try {
+			core::linux::syscalls::close(fd);
+		} catch (const std::exception & /* unused */) {
+			// ignored
+		}
+	}
+};
+
+} // namespace iptsd::apps::receive
+
+#endif // IPTSD_APPS_RECEIVE_RECEIVE_HPP
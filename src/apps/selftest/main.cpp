@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#include "selftest.hpp"
+
+#include <common/chrono.hpp>
+#include <common/types.hpp>
+#include <core/linux/device/timed-hidraw.hpp>
+#include <core/linux/runner.hpp>
+#include <core/linux/signal-handler.hpp>
+
+#include <CLI/CLI.hpp>
+#include <fmt/format.h>
+#include <spdlog/spdlog.h>
+
+#include <csignal>
+#include <cstdlib>
+#include <exception>
+#include <filesystem>
+#include <optional>
+#include <string>
+#include <system_error>
+#include <unistd.h>
+
+namespace iptsd::apps::selftest {
+namespace {
+
+/*!
+ * Searches for another process that has the device open, e.g. a running iptsd.
+ *
+ * @param[in] path The device node.
+ * @return The process ID of the first process that has the device open, if there is one.
+ */
+std::optional<std::string> find_user(const std::filesystem::path &path)
+{
+	std::error_code ec {};
+
+	const std::filesystem::path device = std::filesystem::canonical(path, ec);
+	const std::string self = std::to_string(getpid());
+
+	if (ec)
+		return std::nullopt;
+
+	for (const auto &process : std::filesystem::directory_iterator {"/proc", ec}) {
+		const std::string pid = process.path().filename().string();
+
+		if (pid == self)
+			continue;
+
+		const std::filesystem::path fds = process.path() / "fd";
+
+		// Processes that can't be inspected, or that exit while they are, are skipped.
+		for (const auto &fd : std::filesystem::directory_iterator {fds, ec}) {
+			if (std::filesystem::read_symlink(fd.path(), ec) == device)
+				return pid;
+		}
+	}
+
+	return std::nullopt;
+}
+
+int run(const int argc, const char **argv)
+{
+	CLI::App app {"Utility for checking if an IPTS device sends usable data"};
+
+	std::filesystem::path path {};
+	app.add_option("DEVICE", path)
+		->description("The hidraw device node to test")
+		->type_name("FILE")
+		->required();
+
+	f64 duration = 5;
+	app.add_option("-d,--duration", duration)
+		->description("For how long data is read from the device (default: 5)")
+		->type_name("SECONDS")
+		->check(CLI::PositiveNumber);
+
+	bool json = false;
+	app.add_flag("--json", json)->description("Print the result as JSON");
+
+	CLI11_PARSE(app, argc, argv);
+
+	// Only errors are printed, so that the output stays valid JSON.
+	if (json)
+		spdlog::set_level(spdlog::level::err);
+
+	// Switching the mode of the device would break the inputs of the other process.
+	const std::optional<std::string> user = find_user(path);
+
+	if (user.has_value()) {
+		spdlog::error("{} is in use by process {}, please stop iptsd before the test",
+		              path.string(),
+		              user.value());
+
+		return EXIT_FAILURE;
+	}
+
+	core::linux::Runner<SelfTest, core::linux::device::TimedHidraw> selftest {path};
+
+	const auto _sigterm = core::linux::signal<SIGTERM>([&](int) { selftest.stop(); });
+	const auto _sigint = core::linux::signal<SIGINT>([&](int) { selftest.stop(); });
+
+	spdlog::info("Reading from {} for {} seconds, use the touchscreen and the stylus now",
+	             path.string(),
+	             duration);
+
+	selftest.device().set_duration(seconds<f64> {duration});
+	selftest.run();
+
+	const SelfTest &result = selftest.application();
+
+	if (json)
+		fmt::print("{}\n", result.verdict().str());
+	else
+		result.summary();
+
+	if (!result.usable())
+		return EXIT_FAILURE;
+
+	return 0;
+}
+
+} // namespace
+} // namespace iptsd::apps::selftest
+
+int main(const int argc, const char **argv)
+{
+	spdlog::set_pattern("[%X.%e] [%^%l%$] %v");
+
+	try {
+		return iptsd::apps::selftest::run(argc, argv);
+	} catch (const std::exception &e) {
+		spdlog::error(e.what());
+		return EXIT_FAILURE;
+	}
+}
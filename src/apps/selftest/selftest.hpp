@@ -0,0 +1,240 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_SELFTEST_SELFTEST_HPP
+#define IPTSD_APPS_SELFTEST_SELFTEST_HPP
+
+#include <common/json.hpp>
+#include <common/types.hpp>
+#include <contacts/contact.hpp>
+#include <core/generic/application.hpp>
+#include <core/generic/config.hpp>
+#include <core/generic/device.hpp>
+#include <ipts/conformance.hpp>
+#include <ipts/protocol/report.hpp>
+#include <ipts/samples/button.hpp>
+#include <ipts/samples/stylus.hpp>
+
+#include <spdlog/spdlog.h>
+
+#include <algorithm>
+#include <vector>
+
+namespace iptsd::apps::selftest {
+
+/*
+ * Collects what kind of data a device sends, to decide whether the hardware works.
+ */
+class SelfTest : public core::Application {
+private:
+	// How many heatmaps were processed.
+	usize m_heatmaps = 0;
+
+	// How many heatmaps contained at least one contact.
+	usize m_touched = 0;
+
+	// The highest number of contacts in a single heatmap.
+	usize m_max_contacts = 0;
+
+	// How many stylus samples were processed.
+	usize m_stylus = 0;
+
+	// How many stylus samples were in proximity of the screen.
+	usize m_proximity = 0;
+
+	// How many stylus samples were touching the screen.
+	usize m_contact = 0;
+
+	// How many button samples were processed.
+	usize m_buttons = 0;
+
+public:
+	SelfTest(const core::Config &config, const core::DeviceInfo &info)
+		: core::Application(config, info) {};
+
+	void on_touch(const std::vector<contacts::Contact<f64>> &contacts) override
+	{
+		m_heatmaps++;
+		m_max_contacts = std::max(m_max_contacts, contacts.size());
+
+		if (!contacts.empty())
+			m_touched++;
+	}
+
+	void on_stylus(const ipts::samples::Stylus &stylus) override
+	{
+		m_stylus++;
+
+		if (stylus.proximity)
+			m_proximity++;
+
+		if (stylus.contact)
+			m_contact++;
+	}
+
+	void on_button(const ipts::samples::Button & /* unused */) override
+	{
+		m_buttons++;
+	}
+
+	/*!
+	 * Whether the device sent any data that can be used for input.
+	 *
+	 * @return true if heatmaps or stylus samples were received.
+	 */
+	[[nodiscard]] bool usable() const
+	{
+		return m_heatmaps > 0 || m_stylus > 0;
+	}
+
+	/*!
+	 * Describes what was received from the device.
+	 *
+	 * @return A JSON object with the decoded device information and the observed data.
+	 */
+	[[nodiscard]] common::Json verdict() const
+	{
+		common::Json device {};
+		device.add("vendor", m_info.vendor)
+			.add("product", m_info.product)
+			.add("type", m_info.is_touchscreen() ? "touchscreen" : "touchpad")
+			.add("metadata", m_info.meta.has_value());
+
+		if (m_info.meta.has_value()) {
+			device.add("rows", m_info.meta->rows)
+				.add("columns", m_info.meta->columns)
+				.add("width", m_info.meta->width)
+				.add("height", m_info.meta->height)
+				.add("invert_x", m_info.meta->invert_x)
+				.add("invert_y", m_info.meta->invert_y);
+		}
+
+		common::Json touch {};
+		touch.add("heatmaps", m_heatmaps)
+			.add("touched", m_touched)
+			.add("max_contacts", m_max_contacts);
+
+		common::Json stylus {};
+		stylus.add("samples", m_stylus)
+			.add("proximity", m_proximity)
+			.add("contact", m_contact);
+
+		common::Json errors {};
+		errors.add("invalid", m_stats.invalid)
+			.add("skipped", m_stats.skipped)
+			.add("unknown", m_stats.unknown)
+			.add("dropped", m_stats.dropped);
+
+		common::Json verdict {};
+		verdict.add("usable", this->usable())
+			.add("device", device)
+			.add("buffers", m_stats.buffers)
+			.add("touch", touch)
+			.add("stylus", stylus)
+			.add("buttons", m_buttons)
+			.add("errors", errors)
+			.add("reports", this->reports())
+			.add("conformance", this->conformance());
+
+		return verdict;
+	}
+
+	/*!
+	 * Prints a human readable summary of what was received from the device.
+	 */
+	void summary() const
+	{
+		spdlog::info("Device: {:04X}:{:04X} ({})",
+		             m_info.vendor,
+		             m_info.product,
+		             m_info.is_touchscreen() ? "touchscreen" : "touchpad");
+
+		if (m_info.meta.has_value()) {
+			spdlog::info("Metadata: {}x{} cells, {:.2f}x{:.2f}cm, inverted: {} {}",
+			             m_info.meta->columns,
+			             m_info.meta->rows,
+			             m_info.meta->width,
+			             m_info.meta->height,
+			             m_info.meta->invert_x,
+			             m_info.meta->invert_y);
+		} else {
+			spdlog::info("Metadata: not available");
+		}
+
+		spdlog::info("Buffers: {}", m_stats.buffers);
+		spdlog::info("Touch: {} heatmaps, {} with contacts, at most {} contacts",
+		             m_heatmaps,
+		             m_touched,
+		             m_max_contacts);
+		spdlog::info("Stylus: {} samples, {} in proximity, {} touching",
+		             m_stylus,
+		             m_proximity,
+		             m_contact);
+
+		if (m_info.is_touchpad())
+			spdlog::info("Buttons: {} samples", m_buttons);
+
+		spdlog::info("Errors: {} invalid buffers, {} skipped reports, {} unknown frames",
+		             m_stats.invalid,
+		             m_stats.skipped,
+		             m_stats.unknown);
+
+		if (m_stats.dropped > 0)
+			spdlog::warn("Dropped {} buffers", m_stats.dropped);
+
+		this->print_reports();
+		this->print_conformance();
+
+		if (this->usable())
+			spdlog::info("The device works");
+		else
+			spdlog::error("No usable data was received, was the device used?");
+	}
+
+private:
+	/*!
+	 * Prints which types of report frames the device sent.
+	 */
+	void print_reports() const
+	{
+		const auto &counts = m_parser.reports();
+
+		for (usize i = 0; i < counts.size(); i++) {
+			if (counts.at(i).count == 0)
+				continue;
+
+			const auto type = static_cast<ipts::protocol::report::Type>(i);
+
+			spdlog::info("Report {:#04x} ({}): {} frames, {} bytes",
+			             i,
+			             ipts::protocol::report::name(type),
+			             counts.at(i).count,
+			             counts.at(i).bytes);
+		}
+	}
+
+	/*!
+	 * Prints in which ways the data of the device deviated from the protocol.
+	 */
+	void print_conformance() const
+	{
+		using Check = ipts::Conformance::Check;
+
+		const auto &findings = m_parser.conformance().findings();
+
+		for (usize i = 0; i < findings.size(); i++) {
+			const ipts::Conformance::Finding &finding = findings.at(i);
+
+			if (finding.count == 0)
+				continue;
+
+			spdlog::warn("Protocol check {} failed {} times, first at offset {}",
+			             ipts::Conformance::name(static_cast<Check>(i)),
+			             finding.count,
+			             finding.offset);
+		}
+	}
+};
+
+} // namespace iptsd::apps::selftest
+
+#endif // IPTSD_APPS_SELFTEST_SELFTEST_HPP
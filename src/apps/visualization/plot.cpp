@@ -2,8 +2,10 @@
 
 #include "visualize-png.hpp"
 
+#include <common/chrono.hpp>
 #include <common/types.hpp>
 #include <core/linux/device/file.hpp>
+#include <core/linux/device/timed-hidraw.hpp>
 #include <core/linux/runner.hpp>
 #include <core/linux/signal-handler.hpp>
 
@@ -15,18 +17,97 @@
 #include <cstdlib>
 #include <exception>
 #include <filesystem>
+#include <future>
+#include <optional>
 #include <string>
+#include <thread>
 
 namespace iptsd::apps::visualization::plot {
 namespace {
 
+/*!
+ * Renders the inputs of a device, until the duration has passed or iptsd-plot is stopped.
+ *
+ * Reading blocks while the device is not used, so the deadline is checked while waiting
+ * for data, instead of after the next buffer.
+ *
+ * @param[in] path The hidraw device node.
+ * @param[in] output The directory where the rendered frames are saved.
+ * @param[in] duration For how many seconds the device is read, if limited.
+ * @return The exit code of iptsd-plot.
+ */
+int plot_device(const std::filesystem::path &path,
+                const std::filesystem::path &output,
+                const std::optional<f64> duration)
+{
+	using Device = core::linux::device::TimedHidraw;
+
+	core::linux::Runner<VisualizePNG, Device> visualize {path, output};
+
+	const auto _sigterm = core::linux::signal<SIGTERM>([&](int) { visualize.stop(); });
+	const auto _sigint = core::linux::signal<SIGINT>([&](int) { visualize.stop(); });
+
+	if (duration.has_value())
+		visualize.device().set_duration(seconds<f64> {duration.value()});
+
+	// Once the deadline has passed, the device signals the end of the data.
+	if (!visualize.run() && !duration.has_value())
+		return EXIT_FAILURE;
+
+	return 0;
+}
+
+/*!
+ * Renders the inputs of a capture, until it ends, the duration has passed or iptsd-plot
+ * is stopped.
+ *
+ * @param[in] path The capture file.
+ * @param[in] output The directory where the rendered frames are saved.
+ * @param[in] duration For how many seconds the capture is rendered, if limited.
+ * @return The exit code of iptsd-plot.
+ */
+int plot_file(const std::filesystem::path &path,
+              const std::filesystem::path &output,
+              const std::optional<f64> duration)
+{
+	core::linux::Runner<VisualizePNG, core::linux::device::File> visualize {path, output};
+
+	const auto _sigterm = core::linux::signal<SIGTERM>([&](int) { visualize.stop(); });
+	const auto _sigint = core::linux::signal<SIGINT>([&](int) { visualize.stop(); });
+
+	std::promise<void> done {};
+	std::thread timer {};
+
+	// Reading from a file never blocks, so the runner stops right after the timer expires.
+	if (duration.has_value()) {
+		timer = std::thread {[&visualize, finished = done.get_future(), duration]() {
+			const seconds<f64> timeout {duration.value()};
+
+			if (finished.wait_for(timeout) == std::future_status::timeout)
+				visualize.stop();
+		}};
+	}
+
+	const auto _timer = gsl::finally([&]() {
+		done.set_value();
+
+		if (timer.joinable())
+			timer.join();
+	});
+
+	if (!visualize.run())
+		return EXIT_FAILURE;
+
+	return 0;
+}
+
 int run(const int argc, const char **argv)
 {
 	CLI::App app {"Utility for rendering captured touchscreen inputs to PNG frames"};
 
 	std::filesystem::path path {};
 	app.add_option("DATA", path)
-		->description("A binary data file containing touch reports")
+		->description("A binary data file containing touch reports, or a hidraw device")
 		->type_name("FILE")
 		->required();
 
@@ -36,18 +117,18 @@ int run(const int argc, const char **argv)
 		->type_name("DIR")
 		->required();
 
-	CLI11_PARSE(app, argc, argv);
-
-	// Create a plotting application that reads from a file.
-	core::linux::Runner<VisualizePNG, core::linux::device::File> visualize {path, output};
+	std::optional<f64> duration = std::nullopt;
+	app.add_option("-d,--duration", duration)
+		->description("Stop rendering after this many seconds")
+		->type_name("SECONDS");
 
-	const auto _sigterm = core::linux::signal<SIGTERM>([&](int) { visualize.stop(); });
-	const auto _sigint = core::linux::signal<SIGINT>([&](int) { visualize.stop(); });
+	CLI11_PARSE(app, argc, argv);
 
-	if (!visualize.run())
-		return EXIT_FAILURE;
+	// A device node is recorded live, until the duration has passed or iptsd-plot is stopped.
+	if (std::filesystem::is_character_file(path))
+		return plot_device(path, output, duration);
 
-	return 0;
+	return plot_file(path, output, duration);
 }
 
 } // namespace
@@ -15,6 +15,11 @@ namespace iptsd::common::buildopts {
  */
 #include <configure.h>
 
+/*!
+ * The version of iptsd.
+ */
+constexpr std::string_view Version = IPTSD_VERSION;
+
 /*!
  * The main iptsd config file.
  */
@@ -41,6 +46,7 @@ constexpr bool ForceAccessChecks = IPTSD_FORCE_ACCESS_CHECKS;
 /*
  * Make sure that nothing uses the defines directly.
  */
+#undef IPTSD_VERSION
 #undef IPTSD_CONFIG_DIR
 #undef IPTSD_CONFIG_FILE
 #undef IPTSD_PRESET_DIR
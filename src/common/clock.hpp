@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_COMMON_CLOCK_HPP
+#define IPTSD_COMMON_CLOCK_HPP
+
+#include "casts.hpp"
+#include "chrono.hpp"
+#include "types.hpp"
+
+#include <gsl/gsl>
+
+#include <optional>
+
+namespace iptsd::common {
+
+/*!
+ * Estimates when samples were generated, from a counter that is incremented with each sample.
+ *
+ * The duration of one count is measured against the steady clock. The estimated times follow
+ * the counter, so that the intervals between them reflect the cadence of the hardware instead
+ * of the time at which the samples were processed.
+ *
+ * Processing can only delay a sample. If a sample arrives before its estimated time, the
+ * estimate is moved back to the time of arrival. Otherwise it is pulled slowly towards the
+ * arrivals, to correct errors of the measured duration.
+ */
+class CounterClock {
+public:
+	using clock = chrono::steady_clock;
+
+private:
+	// How strongly new measurements of the duration of a count are weighted.
+	constexpr static f64 PERIOD_WEIGHT = 0.05;
+
+	// How strongly the estimate is pulled towards the time of arrival with every sample.
+	constexpr static f64 OFFSET_WEIGHT = 0.01;
+
+	// If a sample arrives this much later than estimated, the counter was probably paused.
+	constexpr static auto MAX_DELAY = 50ms;
+
+	// The value of the counter for the last sample.
+	std::optional<u32> m_counter = std::nullopt;
+
+	// When the last sample arrived.
+	clock::time_point m_arrival {};
+
+	// The estimated time of the last sample.
+	clock::time_point m_estimate {};
+
+	// The measured duration of one count, in seconds.
+	std::optional<f64> m_period = std::nullopt;
+
+public:
+	/*!
+	 * Estimates when a sample was generated.
+	 *
+	 * @param[in] counter The unwrapped value of the counter of the sample.
+	 * @param[in] now When the sample arrived.
+	 * @return The estimated time at which the sample was generated.
+	 */
+	clock::time_point input(const u32 counter, const clock::time_point now)
+	{
+		/*
+		 * Start over if the counter is new or went backwards, e.g. because it wrapped
+		 * around. Some devices don't count their samples at all, they always get the time
+		 * of arrival.
+		 */
+		if (!m_counter.has_value() || counter <= m_counter.value()) {
+			this->rebase(counter, now);
+			return m_estimate;
+		}
+
+		const u32 counts = counter - m_counter.value();
+
+		const f64 elapsed = seconds<f64> {now - m_arrival}.count();
+		const f64 measured = elapsed / casts::to<f64>(counts);
+
+		if (m_period.has_value())
+			m_period = m_period.value() + PERIOD_WEIGHT * (measured - m_period.value());
+		else
+			m_period = measured;
+
+		const seconds<f64> advance {m_period.value() * casts::to<f64>(counts)};
+		auto estimate = m_estimate + chrono::duration_cast<clock::duration>(advance);
+
+		if (estimate > now) {
+			estimate = now;
+		} else if (now - estimate > MAX_DELAY) {
+			this->rebase(counter, now);
+			return m_estimate;
+		} else {
+			const seconds<f64> offset {now - estimate};
+			estimate += chrono::duration_cast<clock::duration>(OFFSET_WEIGHT * offset);
+		}
+
+		m_counter = counter;
+		m_arrival = now;
+		m_estimate = estimate;
+
+		return m_estimate;
+	}
+
+	/*!
+	 * Converts an estimated time to the value of an MSC_TIMESTAMP event.
+	 *
+	 * The event is a wrapping counter of microseconds, so only the lower 32 bits are kept.
+	 *
+	 * @param[in] time The estimated time of a sample.
+	 * @return The value of the event.
+	 */
+	[[nodiscard]] static i32 timestamp(const clock::time_point time)
+	{
+		const auto us = chrono::duration_cast<microseconds<u64>>(time.time_since_epoch());
+		return gsl::narrow_cast<i32>(gsl::narrow_cast<u32>(us.count()));
+	}
+
+	/*!
+	 * Forgets the previous samples, e.g. because the counter was reset.
+	 */
+	void reset()
+	{
+		m_counter = std::nullopt;
+	}
+
+private:
+	void rebase(const u32 counter, const clock::time_point now)
+	{
+		m_counter = counter;
+		m_arrival = now;
+		m_estimate = now;
+	}
+};
+
+} // namespace iptsd::common
+
+#endif // IPTSD_COMMON_CLOCK_HPP
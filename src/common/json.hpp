@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_COMMON_JSON_HPP
+#define IPTSD_COMMON_JSON_HPP
+
+#include "types.hpp"
+
+#include <fmt/format.h>
+
+#include <cmath>
+#include <string>
+#include <string_view>
+#include <type_traits>
+#include <vector>
+
+namespace iptsd::common {
+
+/*
+ * Builds a JSON object.
+ *
+ * Members are written in the order in which they are added, so the output is stable.
+ */
+class Json {
+private:
+	// The serialized members of the object.
+	std::string m_members {};
+
+public:
+	/*!
+	 * Adds a string member.
+	 *
+	 * @param[in] key The name of the member.
+	 * @param[in] value The value of the member.
+	 * @return A reference to this object, for chaining.
+	 */
+	Json &add(const std::string_view key, const std::string_view value)
+	{
+		return this->add_raw(key, escape(value));
+	}
+
+	Json &add(const std::string_view key, const char *value)
+	{
+		return this->add(key, std::string_view {value});
+	}
+
+	Json &add(const std::string_view key, const std::string &value)
+	{
+		return this->add(key, std::string_view {value});
+	}
+
+	/*!
+	 * Adds a nested object.
+	 *
+	 * @param[in] key The name of the member.
+	 * @param[in] value The object.
+	 * @return A reference to this object, for chaining.
+	 */
+	Json &add(const std::string_view key, const Json &value)
+	{
+		return this->add_raw(key, value.str());
+	}
+
+	/*!
+	 * Adds a boolean or numeric member.
+	 *
+	 * @param[in] key The name of the member.
+	 * @param[in] value The value of the member.
+	 * @return A reference to this object, for chaining.
+	 */
+	template <class T, std::enable_if_t<std::is_arithmetic_v<T>, bool> = true>
+	Json &add(const std::string_view key, const T value)
+	{
+		return this->add_raw(key, format(value));
+	}
+
+	/*!
+	 * Adds an array of boolean, numeric or string values, or of objects.
+	 *
+	 * @param[in] key The name of the member.
+	 * @param[in] values The values of the array.
+	 * @return A reference to this object, for chaining.
+	 */
+	template <class T>
+	Json &add(const std::string_view key, const std::vector<T> &values)
+	{
+		std::string array = "[";
+
+		for (const T &value : values) {
+			if (array.size() > 1)
+				array += ",";
+
+			array += format(value);
+		}
+
+		return this->add_raw(key, array + "]");
+	}
+
+	/*!
+	 * The serialized object.
+	 *
+	 * @return The JSON representation of the object.
+	 */
+	[[nodiscard]] std::string str() const
+	{
+		return "{" + m_members + "}";
+	}
+
+private:
+	Json &add_raw(const std::string_view key, const std::string &value)
+	{
+		if (!m_members.empty())
+			m_members += ",";
+
+		m_members += escape(key);
+		m_members += ":";
+		m_members += value;
+
+		return *this;
+	}
+
+	template <class T>
+	[[nodiscard]] static std::string format(const T &value)
+	{
+		if constexpr (std::is_same_v<T, Json>) {
+			return value.str();
+		} else if constexpr (std::is_convertible_v<T, std::string_view>) {
+			return escape(value);
+		} else if constexpr (std::is_same_v<T, bool>) {
+			return value ? "true" : "false";
+		} else if constexpr (std::is_floating_point_v<T>) {
+			// JSON has no representation for infinity or NaN.
+			if (!std::isfinite(value))
+				return "null";
+
+			return fmt::format("{}", value);
+		} else {
+			return fmt::format("{}", value);
+		}
+	}
+
+	[[nodiscard]] static std::string escape(const std::string_view str)
+	{
+		std::string out = "\"";
+
+		for (const char c : str) {
+			switch (c) {
+			case '"':
+				out += "\\\"";
+				break;
+			case '\\':
+				out += "\\\\";
+				break;
+			case '\n':
+				out += "\\n";
+				break;
+			case '\t':
+				out += "\\t";
+				break;
+			default:
+				if (static_cast<unsigned char>(c) < 0x20)
+					out += fmt::format("\\u{:04x}", static_cast<unsigned char>(c));
+				else
+					out += c;
+			}
+		}
+
+		return out + "\"";
+	}
+};
+
+} // namespace iptsd::common
+
+#endif // IPTSD_COMMON_JSON_HPP
@@ -9,6 +9,10 @@
 #include <gsl/gsl>
 
 #include <algorithm>
+#include <string>
+#include <string_view>
+#include <utility>
+#include <vector>
 
 namespace iptsd {
 namespace impl {
@@ -33,8 +37,41 @@ inline std::string format_as(ReaderError err)
 	}
 }
 
+/*!
+ * Determines the name of a type at compile time.
+ *
+ * This relies on the format of __PRETTY_FUNCTION__, which is the same for GCC and clang.
+ *
+ * @tparam T The type to name.
+ * @return The fully qualified name of the type.
+ */
+template <class T>
+std::string_view type_name()
+{
+	const std::string_view name = __PRETTY_FUNCTION__;
+
+	const usize start = name.find("T = ") + 4;
+	const usize end = name.find_first_of(";]", start);
+
+	return name.substr(start, end - start);
+}
+
 } // namespace impl
 
+/*
+ * A region of data that was consumed by a reader.
+ */
+struct ReaderRegion {
+	// The position of the region, relative to the start of the outermost reader.
+	usize offset = 0;
+
+	// How many bytes the region spans.
+	usize size = 0;
+
+	// What the region contains, e.g. the name of the type that was read from it.
+	std::string name {};
+};
+
 class Reader {
 public:
 	using Error = impl::ReaderError;
@@ -46,10 +83,28 @@ private:
 	// The current position in the data.
 	usize m_index = 0;
 
+	// The position of the data, relative to the start of the outermost reader.
+	usize m_offset = 0;
+
+	// Where the consumed regions are recorded to, if they are recorded.
+	std::vector<ReaderRegion> *m_trace = nullptr;
+
 public:
 	Reader(const gsl::span<u8> data) : m_data {data} {};
 	Reader(std::vector<u8> buffer) : m_buffer {std::move(buffer)}, m_data {m_buffer} {};
 
+	/*!
+	 * Records all regions of the data that are read or skipped from now on.
+	 *
+	 * Readers that are split off using @ref sub() record to the same list.
+	 *
+	 * @param[in] trace The list to record to. Recording is disabled if this is null.
+	 */
+	void trace(std::vector<ReaderRegion> *trace)
+	{
+		m_trace = trace;
+	}
+
 	/*!
 	 * The current position of the reader inside the data.
 	 */
@@ -58,6 +113,14 @@ public:
 		return m_index;
 	}
 
+	/*!
+	 * The current position of the reader, relative to the start of the outermost reader.
+	 */
+	[[nodiscard]] usize offset() const
+	{
+		return m_offset + m_index;
+	}
+
 	/*!
 	 * Changes the current position of the reader inside the data.
 	 *
@@ -78,14 +141,8 @@ public:
 	 */
 	void read(const gsl::span<u8> dest)
 	{
-		if (this->size() == 0)
-			throw common::Error<Error::EndOfData> {dest.size()};
-
-		if (dest.size() > this->size())
-			throw common::Error<Error::InvalidRead> {dest.size(), this->size()};
-
-		const gsl::span<u8> src = this->subspan<u8>(dest.size());
-		std::copy(src.begin(), src.end(), dest.begin());
+		this->record(dest.size(), "bytes");
+		this->copy(dest);
 	}
 
 	/*!
@@ -95,13 +152,8 @@ public:
 	 */
 	void skip(const usize size)
 	{
-		if (this->size() == 0)
-			throw common::Error<Error::EndOfData> {size};
-
-		if (size > this->size())
-			throw common::Error<Error::InvalidRead> {size, this->size()};
-
-		m_index += size;
+		this->record(size, "skipped");
+		this->take(size);
 	}
 
 	/*!
@@ -123,16 +175,10 @@ public:
 	template <class T>
 	gsl::span<T> subspan(const usize size)
 	{
-		if (this->size() == 0)
-			throw common::Error<Error::EndOfData> {size};
-
-		if (size > this->size())
-			throw common::Error<Error::InvalidRead> {size, this->size()};
-
 		const usize bytes = size * sizeof(T);
-		const gsl::span<u8> sub = m_data.subspan(m_index, bytes);
 
-		this->skip(bytes);
+		this->record(bytes, std::string {impl::type_name<T>()} + "[]");
+		const gsl::span<u8> sub = this->take(bytes);
 
 		// We have to break type safety here, since all we have is a bytestream.
 		// NOLINTNEXTLINE(cppcoreguidelines-pro-type-reinterpret-cast)
@@ -147,7 +193,13 @@ public:
 	 */
 	Reader sub(const usize size)
 	{
-		return Reader {this->subspan<u8>(size)};
+		const usize offset = m_offset + m_index;
+
+		Reader reader {this->take(size)};
+		reader.m_offset = offset;
+		reader.m_trace = m_trace;
+
+		return reader;
 	}
 
 	/*!
@@ -161,12 +213,62 @@ public:
 	{
 		T value {};
 
+		this->record(sizeof(value), std::string {impl::type_name<T>()});
+
 		// We have to break type safety here, since all we have is a bytestream.
 		// NOLINTNEXTLINE(cppcoreguidelines-pro-type-reinterpret-cast)
-		this->read(gsl::span {reinterpret_cast<u8 *>(&value), sizeof(value)});
+		this->copy(gsl::span {reinterpret_cast<u8 *>(&value), sizeof(value)});
 
 		return value;
 	}
+
+private:
+	/*!
+	 * Takes a chunk of bytes from the current position and moves the position forward.
+	 *
+	 * @param[in] size How many bytes to take.
+	 * @return The raw chunk of data.
+	 */
+	gsl::span<u8> take(const usize size)
+	{
+		if (this->size() == 0)
+			throw common::Error<Error::EndOfData> {size};
+
+		if (size > this->size())
+			throw common::Error<Error::InvalidRead> {size, this->size()};
+
+		const gsl::span<u8> chunk = m_data.subspan(m_index, size);
+		m_index += size;
+
+		return chunk;
+	}
+
+	/*!
+	 * Fills a buffer with the data at the current position.
+	 *
+	 * @param[in] dest The destination and size of the data.
+	 */
+	void copy(const gsl::span<u8> dest)
+	{
+		const gsl::span<u8> src = this->take(dest.size());
+		std::copy(src.begin(), src.end(), dest.begin());
+	}
+
+	/*!
+	 * Records a region that is about to be consumed, if recording is enabled.
+	 *
+	 * Regions that can't be consumed are not recorded.
+	 *
+	 * @param[in] size How many bytes will be consumed.
+	 * @param[in] name What the region contains.
+	 */
+	void record(const usize size, std::string name) const
+	{
+		if (m_trace == nullptr || size == 0 || size > this->size())
+			return;
+
+		m_trace->push_back(ReaderRegion {m_offset + m_index, size, std::move(name)});
+	}
 };
 
 } // namespace iptsd
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_COMMON_THROTTLE_HPP
+#define IPTSD_COMMON_THROTTLE_HPP
+
+#include "chrono.hpp"
+#include "types.hpp"
+
+#include <optional>
+
+namespace iptsd::common {
+
+/*
+ * Sums up events that should be reported to the log at most once per interval.
+ *
+ * Errors that repeat for every buffer would flood the log otherwise. The first event is
+ * reported right away, later ones are collected until the interval has passed.
+ */
+class Throttle {
+private:
+	// How much time has to pass between two reports.
+	chrono::steady_clock::duration m_interval;
+
+	// When the events were reported the last time.
+	std::optional<chrono::steady_clock::time_point> m_last = std::nullopt;
+
+	// How many events have not been reported yet.
+	u64 m_pending = 0;
+
+public:
+	Throttle(const chrono::steady_clock::duration interval = 1s) : m_interval {interval} {};
+
+	/*!
+	 * Counts events, and decides whether they should be reported now.
+	 *
+	 * @param[in] count How many events happened.
+	 * @return How many events happened since the last report, if they should be reported.
+	 */
+	std::optional<u64> add(const u64 count = 1)
+	{
+		m_pending += count;
+
+		const auto now = chrono::steady_clock::now();
+
+		if (m_last.has_value() && now - m_last.value() < m_interval)
+			return std::nullopt;
+
+		const u64 pending = m_pending;
+
+		m_last = now;
+		m_pending = 0;
+
+		return pending;
+	}
+};
+
+} // namespace iptsd::common
+
+#endif // IPTSD_COMMON_THROTTLE_HPP
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_COMMON_UNWRAP_HPP
+#define IPTSD_COMMON_UNWRAP_HPP
+
+#include "types.hpp"
+
+#include <limits>
+#include <optional>
+#include <type_traits>
+
+namespace iptsd::common {
+
+/*!
+ * Turns a wrapping counter (e.g. a 16 bit timestamp) into a continuously increasing value.
+ *
+ * Samples that arrive out of order (i.e. are slightly behind the last one) don't
+ * advance the counter, and are mapped to the current value instead.
+ *
+ * @tparam T The unsigned type of the wrapping counter.
+ */
+template <class T>
+class Unwrapper {
+public:
+	static_assert(std::is_unsigned_v<T>);
+
+private:
+	// The last raw value of the counter.
+	std::optional<T> m_last = std::nullopt;
+
+	// The unwrapped value of the counter.
+	u32 m_value = 0;
+
+public:
+	/*!
+	 * Unwraps the next value of the counter.
+	 *
+	 * @param[in] raw The raw value of the wrapping counter.
+	 * @return The continuously increasing value of the counter.
+	 */
+	u32 unwrap(const T raw)
+	{
+		if (!m_last.has_value()) {
+			m_last = raw;
+			m_value = raw;
+			return m_value;
+		}
+
+		// Unsigned subtraction is well defined and wraps around.
+		const auto delta = static_cast<T>(raw - m_last.value());
+
+		// A delta of more than half the counter range means the sample is out of order.
+		if (delta > std::numeric_limits<T>::max() / 2)
+			return m_value;
+
+		m_last = raw;
+		m_value += delta;
+
+		return m_value;
+	}
+
+	/*!
+	 * Starts counting from the next raw value again.
+	 */
+	void reset()
+	{
+		m_last = std::nullopt;
+		m_value = 0;
+	}
+};
+
+} // namespace iptsd::common
+
+#endif // IPTSD_COMMON_UNWRAP_HPP
@@ -38,6 +38,20 @@ public:
 	 */
 	T orientation = casts::to<T>(0);
 
+	/*
+	 * The highest value of the heatmap inside of the contact, with the neutral value removed.
+	 *
+	 * Range: Same as the input heatmap.
+	 */
+	T intensity = casts::to<T>(0);
+
+	/*
+	 * The extent of the contact along its major axis, including its weaker periphery.
+	 *
+	 * Range: Same as size.
+	 */
+	T width = casts::to<T>(0);
+
 	/*
 	 * Whether the stored values are normalized.
 	 */
@@ -58,7 +72,24 @@ public:
 	 */
 	std::optional<bool> stable = std::nullopt;
 
+	/*
+	 * Whether the contact is invalid only because it wasn't observed long enough yet.
+	 */
+	bool pending = false;
+
 public:
+	/*!
+	 * Whether the contact was rejected, e.g. because it is a palm.
+	 *
+	 * Contacts that are still pending are invalid, but were not rejected (yet).
+	 *
+	 * @return true if the contact is invalid and not pending.
+	 */
+	[[nodiscard]] bool rejected() const
+	{
+		return !this->valid.value_or(true) && !this->pending;
+	}
+
 	static std::optional<Contact<T>> find_in_frame(const usize index,
 	                                               const std::vector<Contact<T>> &frame)
 	{
@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CONTACTS_DETECTION_ALGORITHMS_BLOBS_HPP
+#define IPTSD_CONTACTS_DETECTION_ALGORITHMS_BLOBS_HPP
+
+#include "cluster.hpp"
+#include "errors.hpp"
+#include "maximas.hpp"
+
+#include <common/error.hpp>
+#include <common/types.hpp>
+
+#include <array>
+#include <memory>
+#include <type_traits>
+#include <vector>
+
+namespace iptsd::contacts::detection::blobs {
+
+/*
+ * The algorithm that will be used to find the blobs of the contacts on the heatmap.
+ */
+enum class Algorithm : u8 {
+	// Clusters are spanned from the local maxima of the heatmap.
+	MAXIMAS,
+
+	// All connected pixels above the thresholds form a single cluster.
+	COMPONENTS,
+};
+
+/*
+ * Finds the blobs of the contacts on a heatmap.
+ *
+ * All algorithms get the same preprocessed heatmap, and return the bounding boxes of the
+ * blobs that they found. Fitting the contacts onto the blobs is the same for all of them.
+ */
+template <class T>
+class Strategy {
+public:
+	static_assert(std::is_floating_point_v<T>);
+
+public:
+	virtual ~Strategy() = default;
+
+	/*!
+	 * Searches for blobs on a heatmap.
+	 *
+	 * @param[in] heatmap The heatmap, with the neutral value removed.
+	 * @param[in] activation_threshold Only blobs that have a pixel above this are found.
+	 * @param[in] deactivation_threshold Pixels below this are not part of any blob.
+	 * @param[out] clusters The list that the bounding boxes of the blobs are appended to.
+	 */
+	virtual void find(const Image<T> &heatmap,
+	                  T activation_threshold,
+	                  T deactivation_threshold,
+	                  std::vector<Box> &clusters) = 0;
+};
+
+/*
+ * Spans a cluster from every local maximum of the heatmap.
+ *
+ * Once the values fall below the activation threshold, they are not allowed to raise again.
+ * Blobs that touch each other are kept apart this way, but a noisy blob can be split.
+ */
+template <class T>
+class Maximas : public Strategy<T> {
+private:
+	// The list of local maximas.
+	std::vector<Point> m_maximas {};
+
+public:
+	void find(const Image<T> &heatmap,
+	          const T athresh,
+	          const T dthresh,
+	          std::vector<Box> &clusters) override
+	{
+		maximas::find(heatmap, athresh, m_maximas);
+
+		for (const Point &point : m_maximas) {
+			const Box cluster = cluster::span(heatmap, point, athresh, dthresh);
+
+			if (!cluster.isEmpty())
+				clusters.push_back(cluster);
+		}
+	}
+};
+
+/*
+ * Groups all connected pixels above the deactivation threshold into one cluster.
+ *
+ * This is a simple threshold with hysteresis, followed by a connected component search.
+ * A noisy blob always stays a single cluster, but blobs that touch each other are merged.
+ */
+template <class T>
+class Components : public Strategy<T> {
+private:
+	// Whether a pixel belongs to a cluster already.
+	Image<bool> m_visited {};
+
+	// The pixels that still have to be visited, for the cluster that is being searched.
+	std::vector<Point> m_pending {};
+
+public:
+	void find(const Image<T> &heatmap,
+	          const T athresh,
+	          const T dthresh,
+	          std::vector<Box> &clusters) override
+	{
+		const Eigen::Index cols = heatmap.cols();
+		const Eigen::Index rows = heatmap.rows();
+
+		m_visited.conservativeResize(rows, cols);
+		m_visited.setConstant(false);
+
+		for (Eigen::Index y = 0; y < rows; y++) {
+			for (Eigen::Index x = 0; x < cols; x++) {
+				if (m_visited(y, x) || heatmap(y, x) <= athresh)
+					continue;
+
+				clusters.push_back(this->span(heatmap, Point {x, y}, dthresh));
+			}
+		}
+	}
+
+private:
+	/*!
+	 * Collects all pixels that are connected to a starting point.
+	 *
+	 * @param[in] heatmap The heatmap to build the cluster from.
+	 * @param[in] start The pixel that the cluster starts at.
+	 * @param[in] threshold Pixels below this are not part of the cluster.
+	 * @return The bounding box of the cluster.
+	 */
+	Box span(const Image<T> &heatmap, const Point &start, const T threshold)
+	{
+		const Eigen::Index cols = heatmap.cols();
+		const Eigen::Index rows = heatmap.rows();
+
+		Box cluster {};
+		cluster.setEmpty();
+
+		m_pending.clear();
+		m_pending.push_back(start);
+		m_visited(start.y(), start.x()) = true;
+
+		while (!m_pending.empty()) {
+			const Point point = m_pending.back();
+			m_pending.pop_back();
+
+			cluster.extend(point);
+
+			const Eigen::Index x = point.x();
+			const Eigen::Index y = point.y();
+
+			const std::array<Point, 4> neighbours {
+				Point {x + 1, y},
+				Point {x - 1, y},
+				Point {x, y + 1},
+				Point {x, y - 1},
+			};
+
+			for (const Point &n : neighbours) {
+				if (n.x() < 0 || n.x() >= cols || n.y() < 0 || n.y() >= rows)
+					continue;
+
+				if (m_visited(n.y(), n.x()) || heatmap(n.y(), n.x()) <= threshold)
+					continue;
+
+				m_visited(n.y(), n.x()) = true;
+				m_pending.push_back(n);
+			}
+		}
+
+		return cluster;
+	}
+};
+
+/*!
+ * Creates the implementation of an algorithm for finding blobs.
+ *
+ * @param[in] algorithm The algorithm to use.
+ * @return The implementation of the algorithm.
+ */
+template <class T>
+std::unique_ptr<Strategy<T>> create(const Algorithm algorithm)
+{
+	switch (algorithm) {
+	case Algorithm::MAXIMAS:
+		return std::make_unique<Maximas<T>>();
+	case Algorithm::COMPONENTS:
+		return std::make_unique<Components<T>>();
+	default:
+		throw common::Error<Error::InvalidBlobAlgorithm> {};
+	}
+}
+
+} // namespace iptsd::contacts::detection::blobs
+
+#endif // IPTSD_CONTACTS_DETECTION_ALGORITHMS_BLOBS_HPP
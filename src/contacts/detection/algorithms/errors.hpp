@@ -13,6 +13,8 @@ enum class Error : u8 {
 	InvalidNeutralMode,
 	InvalidClusterOverlap,
 	FailedToMergeClusters,
+	InvalidFittingAlgorithm,
+	InvalidBlobAlgorithm,
 };
 
 inline std::string format_as(Error err)
@@ -24,6 +26,10 @@ inline std::string format_as(Error err)
 		return "contacts: Calculated invalid cluster overlap!";
 	case Error::FailedToMergeClusters:
 		return "contacts: Failed to merge overlapping clusters!";
+	case Error::InvalidFittingAlgorithm:
+		return "contacts: Invalid fitting algorithm!";
+	case Error::InvalidBlobAlgorithm:
+		return "contacts: Invalid blob algorithm!";
 	default:
 		return "contacts: Invalid error code!";
 	}
@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CONTACTS_DETECTION_ALGORITHMS_FITTING_HPP
+#define IPTSD_CONTACTS_DETECTION_ALGORITHMS_FITTING_HPP
+
+#include "errors.hpp"
+#include "gaussian.hpp"
+#include "moments.hpp"
+
+#include <common/error.hpp>
+#include <common/types.hpp>
+
+#include <vector>
+
+namespace iptsd::contacts::detection::fitting {
+
+/*
+ * The algorithm that will be used to determine the shape of a contact from its cluster.
+ */
+enum class Algorithm : u8 {
+	// An ellipse is fitted onto the cluster using iterative gaussian fitting.
+	GAUSSIAN,
+
+	// The weighted mean and covariance of the cluster are used.
+	MOMENTS,
+};
+
+/*!
+ * Determines the position and shape of contacts from their clusters.
+ *
+ * @param[in] algorithm The algorithm to use.
+ * @param[in,out] params The clusters (in) and fitted parameters (out).
+ * @param[in] data The heatmap that the clusters were spanned on.
+ * @param[in] tmp Temporary storage with the same size as the heatmap.
+ */
+template <class Derived, class DerivedData>
+void fit(const Algorithm algorithm,
+         std::vector<gaussian::Parameters<typename DenseBase<Derived>::Scalar>> &params,
+         const DenseBase<DerivedData> &data,
+         DenseBase<Derived> &tmp)
+{
+	switch (algorithm) {
+	case Algorithm::GAUSSIAN:
+		gaussian::fit(params, data, tmp, 3);
+		break;
+	case Algorithm::MOMENTS:
+		moments::fit(params, data);
+		break;
+	default:
+		throw common::Error<Error::InvalidFittingAlgorithm> {};
+	}
+}
+
+} // namespace iptsd::contacts::detection::fitting
+
+#endif // IPTSD_CONTACTS_DETECTION_ALGORITHMS_FITTING_HPP
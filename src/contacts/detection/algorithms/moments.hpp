@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CONTACTS_DETECTION_ALGORITHMS_MOMENTS_HPP
+#define IPTSD_CONTACTS_DETECTION_ALGORITHMS_MOMENTS_HPP
+
+#include "gaussian.hpp"
+
+#include <common/casts.hpp>
+#include <common/types.hpp>
+
+#include <vector>
+
+namespace iptsd::contacts::detection::moments {
+
+/*!
+ * Describes the shape of clusters using their statistical moments.
+ *
+ * The values of the heatmap inside of the cluster are treated as a weighted distribution.
+ * Its weighted mean and covariance describe the position and shape of the contact.
+ * This is a lot cheaper than gaussian fitting, but blobs that touch each other are not
+ * separated as cleanly.
+ *
+ * @param[in,out] params The clusters (in) and fitted parameters (out).
+ * @param[in] data The heatmap that the clusters were spanned on.
+ */
+template <class T, class Derived>
+void fit(std::vector<gaussian::Parameters<T>> &params, const DenseBase<Derived> &data)
+{
+	for (auto &p : params) {
+		if (!p.valid)
+			continue;
+
+		const Point bmin = p.bounds.min();
+		const Point bmax = p.bounds.max();
+
+		T sum = casts::to<T>(0);
+		Vector2<T> mean = Vector2<T>::Zero();
+
+		for (Eigen::Index y = bmin.y(); y <= bmax.y(); y++) {
+			for (Eigen::Index x = bmin.x(); x <= bmax.x(); x++) {
+				const T value = casts::to<T>(data(y, x));
+
+				if (value <= casts::to<T>(0))
+					continue;
+
+				sum += value;
+				mean += value * Vector2<T> {casts::to<T>(x), casts::to<T>(y)};
+			}
+		}
+
+		if (sum <= casts::to<T>(0)) {
+			p.valid = false;
+			continue;
+		}
+
+		mean /= sum;
+
+		Matrix2<T> cov = Matrix2<T>::Zero();
+
+		for (Eigen::Index y = bmin.y(); y <= bmax.y(); y++) {
+			for (Eigen::Index x = bmin.x(); x <= bmax.x(); x++) {
+				const T value = casts::to<T>(data(y, x));
+
+				if (value <= casts::to<T>(0))
+					continue;
+
+				const Vector2<T> d = Vector2<T> {casts::to<T>(x), casts::to<T>(y)} - mean;
+				cov += value * (d * d.transpose());
+			}
+		}
+
+		cov /= sum;
+
+		// Single pixel wide clusters have no spread in one direction.
+		if (cov.determinant() <= casts::to<T>(0)) {
+			p.valid = false;
+			continue;
+		}
+
+		p.scale = sum;
+		p.mean = mean;
+		p.prec = cov.inverse();
+	}
+}
+
+} // namespace iptsd::contacts::detection::moments
+
+#endif // IPTSD_CONTACTS_DETECTION_ALGORITHMS_MOMENTS_HPP
@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CONTACTS_DETECTION_ALGORITHMS_POSITION_HPP
+#define IPTSD_CONTACTS_DETECTION_ALGORITHMS_POSITION_HPP
+
+#include <common/casts.hpp>
+#include <common/types.hpp>
+
+#include <algorithm>
+
+namespace iptsd::contacts::detection::position {
+
+/*
+ * The algorithm that will be used to determine the position of a contact.
+ */
+enum class Algorithm : u8 {
+	// The center of the fitted ellipse is used.
+	CENTROID,
+
+	// The center of the strongest pixel of the cluster is used.
+	PEAK,
+
+	// A parabola is fitted through the strongest pixel and its neighbours on both axes.
+	PARABOLIC,
+};
+
+namespace impl {
+
+/*!
+ * Calculates the offset of the vertex of a parabola from its center sample.
+ *
+ * @param[in] prev The value before the center.
+ * @param[in] center The value of the center, which must be the largest of the three.
+ * @param[in] next The value after the center.
+ * @return The offset of the vertex, in range [-0.5, 0.5].
+ */
+template <class T>
+T vertex(const T prev, const T center, const T next)
+{
+	const T curvature = prev - (casts::to<T>(2) * center) + next;
+
+	// Flat or upwards opened parabolas have no maximum.
+	if (curvature >= casts::to<T>(0))
+		return casts::to<T>(0);
+
+	const T offset = (prev - next) / (casts::to<T>(2) * curvature);
+	return std::clamp(offset, casts::to<T>(-0.5), casts::to<T>(0.5));
+}
+
+} // namespace impl
+
+/*!
+ * Searches for the strongest pixel inside of a cluster.
+ *
+ * @param[in] data The heatmap that the cluster was spanned on.
+ * @param[in] bounds The bounding box of the cluster.
+ * @return The coordinates of the strongest pixel.
+ */
+template <class Derived>
+Point peak(const DenseBase<Derived> &data, const Box &bounds)
+{
+	const Point min = bounds.min();
+	const Point size = bounds.sizes() + Point::Ones();
+
+	Eigen::Index x = 0;
+	Eigen::Index y = 0;
+
+	data.block(min.y(), min.x(), size.y(), size.x()).maxCoeff(&y, &x);
+	return Point {min.x() + x, min.y() + y};
+}
+
+/*!
+ * Determines the position of a contact from its cluster.
+ *
+ * @param[in] algorithm The algorithm to use.
+ * @param[in] data The heatmap that the cluster was spanned on.
+ * @param[in] bounds The bounding box of the cluster.
+ * @param[in] centroid The center of the fitted ellipse.
+ * @return The position of the contact, in pixels.
+ */
+template <class T, class Derived>
+Vector2<T> estimate(const Algorithm algorithm,
+                    const DenseBase<Derived> &data,
+                    const Box &bounds,
+                    const Vector2<T> &centroid)
+{
+	if (algorithm == Algorithm::CENTROID)
+		return centroid;
+
+	const Point p = peak(data, bounds);
+	Vector2<T> pos = p.cast<T>();
+
+	if (algorithm == Algorithm::PEAK)
+		return pos;
+
+	// The neighbours of the peak are only used if they are still part of the cluster.
+	if (p.x() > bounds.min().x() && p.x() < bounds.max().x()) {
+		pos.x() += impl::vertex<T>(data(p.y(), p.x() - 1),
+		                           data(p.y(), p.x()),
+		                           data(p.y(), p.x() + 1));
+	}
+
+	if (p.y() > bounds.min().y() && p.y() < bounds.max().y()) {
+		pos.y() += impl::vertex<T>(data(p.y() - 1, p.x()),
+		                           data(p.y(), p.x()),
+		                           data(p.y() + 1, p.x()));
+	}
+
+	return pos;
+}
+
+} // namespace iptsd::contacts::detection::position
+
+#endif // IPTSD_CONTACTS_DETECTION_ALGORITHMS_POSITION_HPP
@@ -3,7 +3,10 @@
 #ifndef IPTSD_CONTACTS_DETECTION_CONFIG_HPP
 #define IPTSD_CONTACTS_DETECTION_CONFIG_HPP
 
+#include "algorithms/blobs.hpp"
+#include "algorithms/fitting.hpp"
 #include "algorithms/neutral.hpp"
+#include "algorithms/position.hpp"
 
 #include <common/casts.hpp>
 #include <common/types.hpp>
@@ -49,6 +52,21 @@ public:
 	 * the recursive cluster search will stop once it reaches it.
 	 */
 	T deactivation_threshold = casts::to<T>(20);
+
+	/*
+	 * How the clusters of the contacts are found on the heatmap.
+	 */
+	enum blobs::Algorithm blob_algorithm = blobs::Algorithm::MAXIMAS;
+
+	/*
+	 * How the position and shape of a contact are determined from its cluster.
+	 */
+	enum fitting::Algorithm fitting_algorithm = fitting::Algorithm::GAUSSIAN;
+
+	/*
+	 * How the position of a contact is determined from its cluster.
+	 */
+	enum position::Algorithm position_algorithm = position::Algorithm::CENTROID;
 };
 
 } // namespace iptsd::contacts::detection
@@ -4,14 +4,15 @@
 #define IPTSD_CONTACTS_DETECTION_DETECTOR_HPP
 
 #include "../contact.hpp"
-#include "algorithms/cluster.hpp"
+#include "algorithms/blobs.hpp"
 #include "algorithms/convolution.hpp"
 #include "algorithms/ellipse.hpp"
+#include "algorithms/fitting.hpp"
 #include "algorithms/gaussian.hpp"
 #include "algorithms/kernels.hpp"
-#include "algorithms/maximas.hpp"
 #include "algorithms/neutral.hpp"
 #include "algorithms/overlaps.hpp"
+#include "algorithms/position.hpp"
 #include "config.hpp"
 
 #include <common/casts.hpp>
@@ -19,7 +20,10 @@
 
 #include <gsl/gsl>
 
+#include <algorithm>
 #include <cmath>
+#include <limits>
+#include <memory>
 #include <type_traits>
 #include <vector>
 
@@ -46,8 +50,8 @@ private:
 	// The kernel that is used for blurring.
 	Matrix3<T> m_kernel_blur = kernels::gaussian<T, 3, 3>(gsl::narrow_cast<T>(0.75));
 
-	// The list of local maximas.
-	std::vector<Point> m_maximas {};
+	// Finds the clusters of the contacts on the heatmap.
+	std::unique_ptr<blobs::Strategy<T>> m_blobs;
 
 	// The list of spanned clusters.
 	std::vector<Box> m_clusters {};
@@ -68,14 +72,16 @@ private:
 	T m_neutral = casts::to<T>(0);
 
 public:
-	Detector(Config<T> config) : m_config {std::move(config)} {};
+	Detector(Config<T> config)
+		: m_config {std::move(config)},
+		  m_blobs {blobs::create<T>(m_config.blob_algorithm)} {};
 
 	/*!
 	 * Search for contacts in a capacitive heatmap.
 	 *
-	 * This function uses a threshold based recursive descent to build
-	 * a list of connected clusters based on the (pre-processed) heatmap,
-	 * and then uses gaussian fitting to fit an ellipse onto these clusters.
+	 * This function uses the configured blob algorithm to build a list of
+	 * connected clusters based on the (pre-processed) heatmap, and then uses
+	 * the configured fitting algorithm to fit an ellipse onto these clusters.
 	 *
 	 * @param[in] heatmap The heatmap to process.
 	 * @param[out] contacts The list of detected contacts.
@@ -105,6 +111,7 @@ public:
 
 		contacts.clear();
 		m_clusters.clear();
+		m_clusters_temp.clear();
 		m_fitting_params.clear();
 
 		// Recalculate the neutral value if neccessary
@@ -126,16 +133,10 @@ public:
 		const T athresh = m_config.activation_threshold;
 		const T dthresh = m_config.deactivation_threshold;
 
-		// Search for local maximas
-		maximas::find(m_img_blurred, athresh, m_maximas);
-
-		// Iterate over the maximas and start building clusters
-		for (const Point &point : m_maximas) {
-			Box cluster = cluster::span(m_img_blurred, point, athresh, dthresh);
-
-			if (cluster.isEmpty())
-				continue;
+		// Search for the clusters of the contacts
+		m_blobs->find(m_img_blurred, athresh, dthresh, m_clusters_temp);
 
+		for (Box cluster : m_clusters_temp) {
 			// Extend the sides of the cluster by one pixel
 			cluster.min() = (cluster.min() - one).cwiseMax(0);
 			cluster.max() = (cluster.max() + one).cwiseMin(dimensions);
@@ -173,8 +174,11 @@ public:
 			m_fitting_params.push_back(std::move(params));
 		}
 
-		// Run gaussian fitting
-		gaussian::fit(m_fitting_params, m_img_blurred, m_fitting_temp, 3);
+		// Fit the contacts onto the clusters
+		fitting::fit(m_config.fitting_algorithm,
+		             m_fitting_params,
+		             m_img_blurred,
+		             m_fitting_temp);
 
 		// Create a contact from every gaussian fitting parameter
 		for (const auto &p : m_fitting_params) {
@@ -186,23 +190,80 @@ public:
 			Eigen::SelfAdjointEigenSolver<Matrix2<TFit>> solver {};
 			solver.computeDirect(cov);
 
-			Vector2<TFit> mean = p.mean;
+			const Point bmin = p.bounds.min();
+			const Vector2<Eigen::Index> bsize = p.bounds.sizes() + one;
+
+			const T intensity =
+				m_img_blurred.block(bmin.y(), bmin.x(), bsize.y(), bsize.x()).maxCoeff();
+
+			Vector2<TFit> mean = position::estimate(m_config.position_algorithm,
+			                                        m_img_blurred,
+			                                        p.bounds,
+			                                        p.mean);
 			Vector2<TFit> size = ellipse::size(solver.eigenvalues());
 			TFit orientation = ellipse::angle<TFit>(solver.eigenvectors());
 
+			// The eigenvalues are sorted in increasing order, so the major axis is last.
+			const Vector2<TFit> axis = solver.eigenvectors().col(1);
+			const TFit extent = this->extent(p.bounds, p.mean, axis);
+
+			TFit width = std::max(extent, size.maxCoeff());
+
 			// Normalize dimensions.
 			if (m_config.normalize) {
 				mean = mean.cwiseQuotient(dimensions.cast<TFit>());
 				size = (size.array() / m_input_diagonal).matrix();
+				width /= m_input_diagonal;
 				orientation /= gsl::narrow_cast<TFit>(M_PI);
 			}
 
 			contacts.push_back(Contact<T> {mean.template cast<T>(),
 			                               size.template cast<T>(),
 			                               gsl::narrow_cast<T>(orientation),
+			                               intensity,
+			                               gsl::narrow_cast<T>(width),
 			                               m_config.normalize});
 		}
 	}
+
+private:
+	/*!
+	 * Measures how far a cluster spreads along an axis.
+	 *
+	 * All pixels of the cluster that are above the deactivation threshold are counted,
+	 * including the weaker ones at the edges, which the fitted ellipse doesn't cover.
+	 *
+	 * @param[in] bounds The bounding box of the cluster.
+	 * @param[in] mean The center of the cluster.
+	 * @param[in] axis The direction along which the cluster is measured.
+	 * @return The length of the cluster along the axis, in pixels.
+	 */
+	TFit extent(const Box &bounds, const Vector2<TFit> &mean, const Vector2<TFit> &axis) const
+	{
+		const T dthresh = m_config.deactivation_threshold;
+
+		TFit min = std::numeric_limits<TFit>::max();
+		TFit max = std::numeric_limits<TFit>::lowest();
+
+		for (Eigen::Index y = bounds.min().y(); y <= bounds.max().y(); y++) {
+			for (Eigen::Index x = bounds.min().x(); x <= bounds.max().x(); x++) {
+				if (m_img_neutral(y, x) < dthresh)
+					continue;
+
+				const Vector2<TFit> pos {casts::to<TFit>(x), casts::to<TFit>(y)};
+				const TFit distance = (pos - mean).dot(axis);
+
+				min = std::min(min, distance);
+				max = std::max(max, distance);
+			}
+		}
+
+		if (max < min)
+			return casts::to<TFit>(0);
+
+		// Every pixel covers the distance from its center to its edges.
+		return max - min + casts::to<TFit>(1);
+	}
 };
 
 } // namespace iptsd::contacts::detection
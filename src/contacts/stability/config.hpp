@@ -31,6 +31,14 @@ public:
 	 * exceed.
 	 */
 	std::optional<Vector2<T>> orientation_threshold = std::nullopt;
+
+	/*
+	 * How much a new frame contributes to the midpoint, distance and angle of a pair of
+	 * contacts, if exactly two contacts are present.
+	 *
+	 * Range: (0, 1]. 1 means no smoothing.
+	 */
+	std::optional<T> pinch_smoothing = std::nullopt;
 };
 
 } // namespace iptsd::contacts::stability
@@ -12,7 +12,10 @@
 #include <gsl/gsl>
 
 #include <algorithm>
+#include <array>
+#include <cmath>
 #include <iterator>
+#include <optional>
 #include <type_traits>
 #include <vector>
 
@@ -23,12 +26,33 @@ class Stabilizer {
 public:
 	static_assert(std::is_floating_point_v<T>);
 
+private:
+	/*
+	 * The smoothed parameters of two contacts that are moving together.
+	 */
+	struct Pair {
+		// The indices of the two contacts, in ascending order.
+		std::array<usize, 2> indices {};
+
+		// The point between the two contacts.
+		Vector2<T> midpoint = Vector2<T>::Zero();
+
+		// The distance between the two contacts.
+		T distance = casts::to<T>(0);
+
+		// The direction from the first to the second contact.
+		T angle = casts::to<T>(0);
+	};
+
 private:
 	Config<T> m_config;
 
 	// The last frame.
 	std::vector<Contact<T>> m_last {};
 
+	// The last pair of contacts, if the last frame contained exactly two.
+	std::optional<Pair> m_pair = std::nullopt;
+
 public:
 	Stabilizer(Config<T> config) : m_config {std::move(config)} {};
 
@@ -38,6 +62,7 @@ public:
 	void reset()
 	{
 		m_last.clear();
+		m_pair = std::nullopt;
 	}
 
 	/*!
@@ -57,6 +82,83 @@ public:
 		std::copy(frame.begin(), frame.end(), std::back_inserter(m_last));
 	}
 
+	/*!
+	 * Smoothes two contacts together, if they are the only valid ones in the frame.
+	 *
+	 * The jitter of two contacts is independent, so the distance between them fluctuates
+	 * more than either position, which makes pinch gestures stutter. Instead of smoothing
+	 * the contacts individually, the midpoint, distance and angle of the pair are smoothed,
+	 * and the positions of the contacts are reconstructed from them. Single contacts are
+	 * left alone, so they don't lag behind.
+	 *
+	 * This has to run after the contacts were validated, so that e.g. a palm next to a
+	 * single finger doesn't form a pair with it.
+	 *
+	 * @param[in,out] frame The list of contacts to stabilize.
+	 */
+	void stabilize_pair(std::vector<Contact<T>> &frame)
+	{
+		if (!m_config.pinch_smoothing.has_value())
+			return;
+
+		std::vector<Contact<T> *> valid {};
+
+		for (Contact<T> &contact : frame) {
+			if (contact.valid.value_or(false))
+				valid.push_back(&contact);
+		}
+
+		if (valid.size() != 2) {
+			m_pair = std::nullopt;
+			return;
+		}
+
+		// Contacts that can't be tracked can't be stabilized.
+		if (!valid[0]->index.has_value() || !valid[1]->index.has_value()) {
+			m_pair = std::nullopt;
+			return;
+		}
+
+		// Order the contacts by their index, so the angle doesn't flip between frames.
+		const bool swap = valid[0]->index.value() > valid[1]->index.value();
+
+		Contact<T> &first = swap ? *valid[1] : *valid[0];
+		Contact<T> &second = swap ? *valid[0] : *valid[1];
+
+		const Vector2<T> delta = second.mean - first.mean;
+
+		Pair current {};
+		current.indices = {first.index.value(), second.index.value()};
+		current.midpoint = (first.mean + second.mean) / 2;
+		current.distance = delta.norm();
+		current.angle = std::atan2(delta.y(), delta.x());
+
+		if (!m_pair.has_value() || m_pair->indices != current.indices) {
+			m_pair = current;
+			return;
+		}
+
+		const T zero = casts::to<T>(0);
+		const T one = casts::to<T>(1);
+
+		const T alpha = std::clamp(m_config.pinch_smoothing.value(), zero, one);
+		Pair &pair = m_pair.value();
+
+		// Take the shorter way around the circle, e.g. when going from -180° to 180°.
+		const T circle = gsl::narrow_cast<T>(2 * M_PI);
+		const T turn = std::remainder(current.angle - pair.angle, circle);
+
+		pair.midpoint += alpha * (current.midpoint - pair.midpoint);
+		pair.distance += alpha * (current.distance - pair.distance);
+		pair.angle += alpha * turn;
+
+		const Vector2<T> direction {std::cos(pair.angle), std::sin(pair.angle)};
+		const Vector2<T> offset = direction * pair.distance / 2;
+
+		first.mean = pair.midpoint - offset;
+		second.mean = pair.midpoint + offset;
+	}
+
 private:
 	/*!
 	 * Stabilize a single contact.
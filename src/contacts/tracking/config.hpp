@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CONTACTS_TRACKING_CONFIG_HPP
+#define IPTSD_CONTACTS_TRACKING_CONFIG_HPP
+
+#include <common/casts.hpp>
+#include <common/types.hpp>
+
+#include <type_traits>
+
+namespace iptsd::contacts::tracking {
+
+template <class T>
+struct Config {
+public:
+	static_assert(std::is_floating_point_v<T>);
+
+public:
+	/*
+	 * For how many frames the index of a contact that disappeared is kept.
+	 *
+	 * If a contact appears close to it within this time, it gets the same index.
+	 * 0 releases the index immediately.
+	 */
+	usize retain_frames = 0;
+
+	/*
+	 * How far a contact can be from a contact that disappeared to get its index.
+	 */
+	T retain_distance = casts::to<T>(0);
+};
+
+} // namespace iptsd::contacts::tracking
+
+#endif // IPTSD_CONTACTS_TRACKING_CONFIG_HPP
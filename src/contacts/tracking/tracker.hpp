@@ -4,6 +4,7 @@
 #define IPTSD_CONTACTS_TRACKING_TRACKER_HPP
 
 #include "../contact.hpp"
+#include "config.hpp"
 #include "distances.hpp"
 
 #include <common/casts.hpp>
@@ -11,6 +12,7 @@
 
 #include <algorithm>
 #include <iterator>
+#include <utility>
 #include <vector>
 
 namespace iptsd::contacts::tracking {
@@ -21,19 +23,37 @@ public:
 	static_assert(std::is_floating_point_v<T>);
 
 private:
+	struct Lost {
+		// The contact, as it was seen the last time.
+		Contact<T> contact;
+
+		// For how many frames the contact was missing.
+		usize frames = 0;
+	};
+
+private:
+	// The config for the tracking phase.
+	Config<T> m_config;
+
 	// The last frame.
 	std::vector<Contact<T>> m_last {};
 
+	// The contacts that disappeared recently, and whose index is still reserved.
+	std::vector<Lost> m_lost {};
+
 	// The distances between all contacts from the current and the last frame.
 	Image<T> m_distances {};
 
 public:
+	Tracker(Config<T> config) : m_config {std::move(config)} {};
+
 	/*!
 	 * Resets the tracker by clearing the stored copy of the last frame.
 	 */
 	void reset()
 	{
 		m_last.clear();
+		m_lost.clear();
 	}
 
 	/*!
@@ -51,6 +71,10 @@ public:
 			counter = contact.index.value() + 1;
 		}
 
+		// Whether a contact of the current or last frame got tracked.
+		std::vector<bool> tracked(frame.size(), false);
+		std::vector<bool> found(m_last.size(), false);
+
 		if (!m_last.empty()) {
 			const usize min = std::min(frame.size(), m_last.size());
 
@@ -69,34 +93,112 @@ public:
 				frame[casts::to_unsigned(x)].index =
 					m_last[casts::to_unsigned(y)].index;
 
+				tracked[casts::to_unsigned(x)] = true;
+				found[casts::to_unsigned(y)] = true;
+
 				// Invalidate all entries containing these contacts
 				m_distances.row(y) = Eigen::NumTraits<T>::infinity();
 				m_distances.col(x) = Eigen::NumTraits<T>::infinity();
 			}
-
-			m_last.clear();
 		}
 
+		if (m_config.retain_frames > 0)
+			this->retain(frame, tracked, found);
+
+		m_last.clear();
+
 		// Save a copy of the new data
 		std::copy(frame.begin(), frame.end(), std::back_inserter(m_last));
 	}
 
 private:
+	/*!
+	 * Gives new contacts the index of a close contact that disappeared recently.
+	 *
+	 * Contacts of the last frame that disappeared keep their index reserved for a few frames,
+	 * so that a contact which was lost for a moment (e.g. a very light finger) keeps its index.
+	 *
+	 * @param[in,out] frame The contacts of the current frame.
+	 * @param[in] tracked Which contacts of the current frame got the index of the last frame.
+	 * @param[in] found Which contacts of the last frame are part of the current frame.
+	 */
+	void retain(std::vector<Contact<T>> &frame,
+	            const std::vector<bool> &tracked,
+	            const std::vector<bool> &found)
+	{
+		for (usize i = 0; i < frame.size(); i++) {
+			if (tracked[i])
+				continue;
+
+			Contact<T> &contact = frame[i];
+			auto closest = m_lost.end();
+
+			T distance = m_config.retain_distance;
+
+			for (auto it = m_lost.begin(); it != m_lost.end(); it++) {
+				const T d = (contact.mean - it->contact.mean).hypotNorm();
+
+				if (d > distance)
+					continue;
+
+				distance = d;
+				closest = it;
+			}
+
+			if (closest == m_lost.end())
+				continue;
+
+			contact.index = closest->contact.index;
+			m_lost.erase(closest);
+		}
+
+		for (Lost &lost : m_lost)
+			lost.frames++;
+
+		// Release the index of contacts that were missing for too long.
+		const auto expired = [&](const Lost &lost) {
+			return lost.frames >= m_config.retain_frames;
+		};
+
+		m_lost.erase(std::remove_if(m_lost.begin(), m_lost.end(), expired), m_lost.end());
+
+		for (usize i = 0; i < m_last.size(); i++) {
+			if (!found[i])
+				m_lost.push_back(Lost {m_last[i], 0});
+		}
+	}
+
 	/*!
 	 * Searches for an index that is not already used by a contact from the last frame.
 	 *
+	 * Indices that are reserved for contacts that disappeared recently are not used either.
+	 *
 	 * @param[in] min The new index has to be at least this value.
 	 * @return A new unique index that was not used before.
 	 */
 	[[nodiscard]] usize find_new_index(usize min) const
 	{
 		while (true) {
-			if (!Contact<T>::find_in_frame(min, m_last).has_value())
+			const bool used = Contact<T>::find_in_frame(min, m_last).has_value();
+
+			if (!used && !this->reserved(min))
 				return min;
 
 			min++;
 		}
 	}
+
+	/*!
+	 * Whether an index is reserved for a contact that disappeared recently.
+	 *
+	 * @param[in] index The index to check.
+	 * @return true if the index must not be given to a new contact.
+	 */
+	[[nodiscard]] bool reserved(const usize index) const
+	{
+		const auto matches = [&](const Lost &lost) { return lost.contact.index == index; };
+		return std::any_of(m_lost.cbegin(), m_lost.cend(), matches);
+	}
 };
 
 } // namespace iptsd::contacts::tracking
@@ -30,8 +30,43 @@ public:
 
 	/*
 	 * The limits that the size of a valid contact must not exceed.
+	 *
+	 * The size is the diameter of the contact along its major axis, in the same unit
+	 * as the position of the contact.
 	 */
 	std::optional<Vector2<T>> size_limits = std::nullopt;
+
+	/*
+	 * How far the size of a contact must be within the size limits to become valid again,
+	 * if it was outside of them in the last frame. Uses the unit of the size limits.
+	 *
+	 * Without this, a contact with a size close to one of the limits can flip between
+	 * being valid and invalid with every frame, because its measured size fluctuates.
+	 * Has no effect if the validity is tracked, because then invalid contacts stay invalid.
+	 */
+	std::optional<T> size_hysteresis = std::nullopt;
+
+	/*
+	 * The standard deviation that the intensity of a new contact must reach at least.
+	 *
+	 * Static blobs, like water droplets or bubbles under a screen protector, keep their
+	 * intensity, while the intensity of a landing finger changes. New contacts are invalid
+	 * until their intensity was observed for intensity_frames frames.
+	 */
+	std::optional<T> intensity_deviation = std::nullopt;
+
+	/*
+	 * For how many frames the intensity of a new contact is observed.
+	 */
+	usize intensity_frames = 3;
+
+	/*
+	 * The intensity that a contact must fall below to end (x) and exceed to begin (y).
+	 *
+	 * A contact with an intensity close to the detection threshold would otherwise
+	 * appear and disappear with every frame.
+	 */
+	std::optional<Vector2<T>> intensity_thresholds = std::nullopt;
 };
 
 } // namespace iptsd::contacts::validation
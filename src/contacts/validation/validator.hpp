@@ -6,8 +6,13 @@
 #include "../contact.hpp"
 #include "config.hpp"
 
+#include <common/casts.hpp>
 #include <common/types.hpp>
 
+#include <algorithm>
+#include <cmath>
+#include <map>
+#include <optional>
 #include <type_traits>
 #include <vector>
 
@@ -18,6 +23,29 @@ class Validator {
 public:
 	static_assert(std::is_floating_point_v<T>);
 
+private:
+	enum class Gate : u8 {
+		// The intensity of the contact is still being observed.
+		Pending,
+
+		// The contact passed the gate in this frame.
+		Opened,
+
+		// The contact passed the gate in an earlier frame.
+		Open,
+
+		// The intensity of the contact didn't change enough.
+		Closed,
+	};
+
+	struct History {
+		// The intensities of the contact while it was pending.
+		std::vector<T> intensities {};
+
+		// Whether the contact passed the gate, once it was decided.
+		std::optional<bool> passed = std::nullopt;
+	};
+
 private:
 	// The config for the validity checking phase.
 	Config<T> m_config;
@@ -25,6 +53,16 @@ private:
 	// The last frame.
 	std::vector<Contact<T>> m_last {};
 
+	// The intensity history of all tracked contacts, for the noise gate.
+	std::map<usize, History> m_histories {};
+
+	// Whether the intensity of a tracked contact exceeded the upper threshold, without falling
+	// below the lower threshold since.
+	std::map<usize, bool> m_intense {};
+
+	// Whether the size of a tracked contact was within the limits in the last frame.
+	std::map<usize, bool> m_sized {};
+
 public:
 	Validator(Config<T> config) : m_config {std::move(config)} {};
 
@@ -34,6 +72,9 @@ public:
 	void reset()
 	{
 		m_last.clear();
+		m_histories.clear();
+		m_intense.clear();
+		m_sized.clear();
 	}
 
 	/*!
@@ -43,8 +84,19 @@ public:
 	 */
 	void validate(std::vector<Contact<T>> &frame)
 	{
-		for (Contact<T> &contact : frame)
+		if (m_config.intensity_deviation.has_value())
+			forget_lifted(m_histories, frame);
+
+		if (m_config.intensity_thresholds.has_value())
+			forget_lifted(m_intense, frame);
+
+		if (m_config.size_hysteresis.has_value())
+			forget_lifted(m_sized, frame);
+
+		for (Contact<T> &contact : frame) {
+			contact.pending = false;
 			contact.valid = this->check_contact(contact);
+		}
 
 		m_last.clear();
 
@@ -56,20 +108,50 @@ private:
 	/*!
 	 * Checks a single contact.
 	 *
-	 * @param[in] contact The contact to check.
+	 * @param[in,out] contact The contact to check.
 	 * @return Whether the contact is valid.
 	 */
-	bool check_contact(const Contact<T> &contact)
+	bool check_contact(Contact<T> &contact)
 	{
-		// Don't invalidate unstable contacts
+		std::optional<bool> last = this->last_validity(contact);
+
+		if (m_config.intensity_deviation.has_value()) {
+			const Gate gate = this->check_gate(contact);
+
+			// Until the gate decided, the contact is neither a finger nor a palm.
+			contact.pending = gate == Gate::Pending;
+
+			if (gate == Gate::Pending || gate == Gate::Closed)
+				return false;
+
+			// The contact was only invalid because it was pending.
+			if (gate == Gate::Opened)
+				last = std::nullopt;
+		}
+
+		if (m_config.intensity_thresholds.has_value()) {
+			const bool intense = this->was_intense(contact);
+
+			if (!this->check_intensity(contact, intense))
+				return false;
+
+			// The contact was only invalid because its intensity was too low.
+			if (!intense)
+				last = std::nullopt;
+		}
+
+		/*
+		 * Don't invalidate unstable contacts. But if hysteresis is enabled, a contact
+		 * that was invalid stays invalid, because size changes make a contact unstable.
+		 */
 		if (!contact.stable.value_or(true))
-			return true;
+			return m_config.size_hysteresis.has_value() ? last.value_or(true) : true;
 
 		/*
 		 * If the state should be tracked and the contact was invalid in the
 		 * last frame, it is also invalid in the current frame.
 		 */
-		if (m_config.track_validity && !this->check_temporal(contact))
+		if (m_config.track_validity && !last.value_or(true))
 			return false;
 
 		// Only do the size check if it is enabled
@@ -84,33 +166,133 @@ private:
 	}
 
 	/*!
-	 * Checks the temporal validity of a contact.
+	 * Observes the intensity of a new contact to decide if it is a static blob.
 	 *
 	 * @param[in] contact The contact to check.
-	 * @return Whether the contact was valid in the last frame.
+	 * @return The state of the noise gate for the contact.
 	 */
-	bool check_temporal(const Contact<T> &contact)
+	Gate check_gate(const Contact<T> &contact)
 	{
-		// Contacts that can't be tracked are considered temporally valid.
+		// Contacts that can't be tracked have no history.
 		if (!contact.index.has_value())
-			return true;
+			return Gate::Open;
+
+		History &history = m_histories[contact.index.value()];
+
+		if (history.passed.has_value())
+			return history.passed.value() ? Gate::Open : Gate::Closed;
+
+		history.intensities.push_back(contact.intensity);
+
+		if (history.intensities.size() < std::max<usize>(m_config.intensity_frames, 2))
+			return Gate::Pending;
+
+		const auto count = casts::to<T>(history.intensities.size());
+
+		T sum = 0;
+		T sum_sq = 0;
+
+		for (const T intensity : history.intensities) {
+			sum += intensity;
+			sum_sq += intensity * intensity;
+		}
+
+		const T mean = sum / count;
+		const T variance = std::max((sum_sq / count) - (mean * mean), casts::to<T>(0));
+
+		history.passed = std::sqrt(variance) >= m_config.intensity_deviation.value();
+		history.intensities.clear();
+
+		return history.passed.value() ? Gate::Opened : Gate::Closed;
+	}
+
+	/*!
+	 * Checks the intensity of a contact against the thresholds of the hysteresis.
+	 *
+	 * A contact that is not yet active must exceed the upper threshold to become active.
+	 * An active contact stays active until it falls below the lower threshold.
+	 *
+	 * @param[in] contact The contact to check.
+	 * @param[in] intense Whether the contact was active in the last frame.
+	 * @return Whether the contact is active.
+	 */
+	bool check_intensity(const Contact<T> &contact, const bool intense)
+	{
+		const Vector2<T> &thresholds = m_config.intensity_thresholds.value();
+
+		const T threshold = intense ? thresholds.x() : thresholds.y();
+		const bool active = contact.intensity >= threshold;
+
+		if (contact.index.has_value())
+			m_intense[contact.index.value()] = active;
+
+		return active;
+	}
+
+	/*!
+	 * Looks up whether a contact was active in the last frame, according to its intensity.
+	 *
+	 * @param[in] contact The contact to look up.
+	 * @return Whether the intensity of the contact reached the upper threshold and stayed
+	 *         above the lower threshold since.
+	 */
+	[[nodiscard]] bool was_intense(const Contact<T> &contact) const
+	{
+		// Contacts that can't be tracked have no history.
+		if (!contact.index.has_value())
+			return false;
+
+		const auto it = m_intense.find(contact.index.value());
+		return it != m_intense.end() && it->second;
+	}
+
+	/*!
+	 * Removes the state of contacts that are not present anymore.
+	 *
+	 * @param[in,out] states The state of all tracked contacts, by their index.
+	 * @param[in] frame The contacts of the current frame.
+	 */
+	template <class S>
+	static void forget_lifted(std::map<usize, S> &states, const std::vector<Contact<T>> &frame)
+	{
+		auto it = states.begin();
+
+		while (it != states.end()) {
+			if (Contact<T>::find_in_frame(it->first, frame).has_value())
+				it++;
+			else
+				it = states.erase(it);
+		}
+	}
+
+	/*!
+	 * Looks up the validity of a contact in the last frame.
+	 *
+	 * @param[in] contact The contact to look up.
+	 * @return Whether the contact was valid in the last frame, if it is known.
+	 */
+	std::optional<bool> last_validity(const Contact<T> &contact)
+	{
+		// Contacts that can't be tracked have no history.
+		if (!contact.index.has_value())
+			return std::nullopt;
 
 		const auto wrapper = Contact<T>::find_in_frame(contact.index.value(), m_last);
 
 		if (!wrapper.has_value())
-			return true;
+			return std::nullopt;
 
 		const Contact<T> &last = wrapper.value();
-
-		if (!last.valid.has_value())
-			return true;
-
-		return last.valid.value();
+		return last.valid;
 	}
 
 	/*!
 	 * Checks the size of a contact.
 	 *
+	 * If the size of the contact was outside of the limits in the last frame, the limits are
+	 * narrowed by the hysteresis. This only depends on the size, so that contacts that were
+	 * invalid for other reasons are not held back.
+	 *
 	 * @param[in] contact The contact to check.
 	 * @return Whether the size of the contact is within the valid range.
 	 */
@@ -122,8 +304,37 @@ private:
 		const Vector2<T> &limit = m_config.size_limits.value();
 		const Vector2<T> &size = contact.size;
 
+		T min = limit.minCoeff();
+		T max = limit.maxCoeff();
+
+		if (m_config.size_hysteresis.has_value() && !this->was_sized(contact)) {
+			min += m_config.size_hysteresis.value();
+			max -= m_config.size_hysteresis.value();
+		}
+
 		const T major = size.maxCoeff();
-		return major >= limit.minCoeff() && major <= limit.maxCoeff();
+		const bool sized = major >= min && major <= max;
+
+		if (m_config.size_hysteresis.has_value() && contact.index.has_value())
+			m_sized[contact.index.value()] = sized;
+
+		return sized;
+	}
+
+	/*!
+	 * Looks up whether the size of a contact was within the limits in the last frame.
+	 *
+	 * @param[in] contact The contact to look up.
+	 * @return Whether the size was within the limits, or true if the contact is new.
+	 */
+	[[nodiscard]] bool was_sized(const Contact<T> &contact) const
+	{
+		// Contacts that can't be tracked have no history.
+		if (!contact.index.has_value())
+			return true;
+
+		const auto it = m_sized.find(contact.index.value());
+		return it == m_sized.end() || it->second;
 	}
 
 	/*!
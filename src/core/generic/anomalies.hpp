@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_ANOMALIES_HPP
+#define IPTSD_CORE_GENERIC_ANOMALIES_HPP
+
+#include <common/types.hpp>
+#include <contacts/contact.hpp>
+
+#include <map>
+#include <optional>
+#include <string>
+#include <utility>
+#include <vector>
+
+namespace iptsd::core {
+
+/*
+ * Looks for touch data that no real input can produce.
+ *
+ * These are not errors, the data is processed as usual. But they are usually caused by
+ * interference or bugs in the processing, so the data is worth capturing.
+ */
+class TouchAnomalies {
+private:
+	/*
+	 * How far a contact can move between two frames, in normalized coordinates.
+	 *
+	 * Even a fast swipe covers only a fraction of this. Contacts that move further were
+	 * most likely matched to the wrong contact of the last frame by the tracker.
+	 */
+	constexpr static f64 MAX_JUMP = 0.5;
+
+	// The positions of the contacts of the last frame, by their index.
+	std::map<usize, Vector2<f64>> m_last {};
+
+	// The positions of the contacts of the current frame, by their index.
+	std::map<usize, Vector2<f64>> m_current {};
+
+public:
+	/*!
+	 * Checks whether a whole row or column of the heatmap is above a threshold.
+	 *
+	 * A real contact never covers the whole width or height of the sensor. If it does,
+	 * it is usually caused by electrical interference that shows up as a line of ghost touches.
+	 *
+	 * @param[in] heatmap The heatmap, with the neutral value removed.
+	 * @param[in] threshold The value that all cells of the line have to exceed.
+	 * @return The name of the anomaly, if one was found.
+	 */
+	[[nodiscard]] static std::optional<std::string> heatmap(const Image<f64> &heatmap,
+	                                                        const f64 threshold)
+	{
+		if (heatmap.rows() < 2 || heatmap.cols() < 2)
+			return std::nullopt;
+
+		for (Eigen::Index y = 0; y < heatmap.rows(); y++) {
+			if (heatmap.row(y).minCoeff() > threshold)
+				return "ghost_row";
+		}
+
+		for (Eigen::Index x = 0; x < heatmap.cols(); x++) {
+			if (heatmap.col(x).minCoeff() > threshold)
+				return "ghost_column";
+		}
+
+		return std::nullopt;
+	}
+
+	/*!
+	 * Checks whether a tracked contact jumped further than any finger can move.
+	 *
+	 * @param[in] contacts The tracked contacts of the current frame.
+	 * @return The name of the anomaly, if one was found.
+	 */
+	[[nodiscard]] std::optional<std::string>
+	contacts(const std::vector<contacts::Contact<f64>> &contacts)
+	{
+		bool jumped = false;
+
+		m_current.clear();
+
+		for (const contacts::Contact<f64> &contact : contacts) {
+			if (!contact.index.has_value())
+				continue;
+
+			const usize index = contact.index.value();
+			m_current[index] = contact.mean;
+
+			const auto it = m_last.find(index);
+			if (it == m_last.end())
+				continue;
+
+			if ((contact.mean - it->second).norm() > MAX_JUMP)
+				jumped = true;
+		}
+
+		std::swap(m_last, m_current);
+
+		if (jumped)
+			return "contact_jump";
+
+		return std::nullopt;
+	}
+
+	/*!
+	 * Forgets the contacts of the last frame, e.g. because frames were lost in between.
+	 */
+	void reset()
+	{
+		m_last.clear();
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_ANOMALIES_HPP
@@ -3,24 +3,63 @@
 #ifndef IPTSD_CORE_GENERIC_APPLICATION_HPP
 #define IPTSD_CORE_GENERIC_APPLICATION_HPP
 
+#include "anomalies.hpp"
+#include "area.hpp"
+#include "baseline.hpp"
+#include "calibration.hpp"
+#include "commands.hpp"
 #include "config.hpp"
+#include "correction.hpp"
 #include "device.hpp"
 #include "dft.hpp"
+#include "duplicates.hpp"
 #include "errors.hpp"
+#include "geometry.hpp"
+#include "layout.hpp"
+#include "lifetimes.hpp"
+#include "load.hpp"
+#include "mask.hpp"
+#include "missing.hpp"
+#include "pressure.hpp"
+#include "profiles.hpp"
+#include "rate.hpp"
+#include "regions.hpp"
+#include "serial.hpp"
+#include "settling.hpp"
+#include "smoothing.hpp"
+#include "statistics.hpp"
+#include "styli.hpp"
+#include "unknown.hpp"
 
+#include <common/buildopts.hpp>
 #include <common/casts.hpp>
+#include <common/chrono.hpp>
 #include <common/error.hpp>
+#include <common/json.hpp>
+#include <common/reader.hpp>
+#include <common/throttle.hpp>
 #include <common/types.hpp>
 #include <contacts/finder.hpp>
+#include <ipts/conformance.hpp>
+#include <ipts/errors.hpp>
 #include <ipts/parser.hpp>
+#include <ipts/protocol/report.hpp>
+#include <ipts/protocol/stylus.hpp>
 #include <ipts/samples/button.hpp>
 #include <ipts/samples/dft.hpp>
 #include <ipts/samples/stylus.hpp>
 #include <ipts/samples/touch.hpp>
+#include <ipts/samples/unknown.hpp>
 
+#include <fmt/format.h>
 #include <spdlog/spdlog.h>
 
+#include <algorithm>
+#include <exception>
 #include <functional>
+#include <optional>
+#include <string>
+#include <utility>
 #include <vector>
 
 namespace iptsd::core {
@@ -39,6 +78,35 @@ namespace iptsd::core {
  * need to be run by an application runner.
  */
 class Application {
+public:
+	// The version of the format of the state returned by @ref state().
+	constexpr static u32 STATE_VERSION = 2;
+
+	/*
+	 * Tells the device to stop or resume sending touch data, if it supports that.
+	 * Returns whether the request was accepted by the device.
+	 * This is set by the application runner.
+	 */
+	std::function<bool(bool)> set_hardware_touch;
+
+	/*
+	 * Receives a JSON object for every touch frame and stylus sample that was processed.
+	 * This is set by the application runner while someone is subscribed to the events.
+	 */
+	std::function<void(const common::Json &)> publish;
+
+	/*
+	 * Receives the name of the check that detected something unusual in the data,
+	 * e.g. a buffer that can't be parsed. This is set by the application runner.
+	 */
+	std::function<void(const std::string &)> report_anomaly;
+
+	/*
+	 * Called when a buffer can't be parsed, regardless of whether it is skipped or the error
+	 * is passed on. This is set by the application runner.
+	 */
+	std::function<void()> report_invalid;
+
 protected:
 	/*
 	 * The configuration for this application.
@@ -77,6 +145,11 @@ protected:
 	 */
 	std::vector<contacts::Contact<f64>> m_contacts {};
 
+	/*
+	 * The timestamp of the report that contained the last heatmap.
+	 */
+	u16 m_touch_timestamp = 0;
+
 	/*
 	 * Newer devices use a DFT based stylus interface. Instead of sending already processed
 	 * coordinates, these devices send antenna measurements that requires interpolating
@@ -84,20 +157,185 @@ protected:
 	 */
 	DftStylus m_dft;
 
+	/*
+	 * Smoothes the position of the stylus depending on its speed.
+	 */
+	StylusSmoothing m_smoothing;
+
+	/*
+	 * Smoothes the pressure of the stylus, independently from its position.
+	 */
+	PressureSmoothing m_pressure_smoothing;
+
+	/*
+	 * Fills the steps between the pressure levels of styli with a low resolution.
+	 */
+	PressureInterpolation m_pressure_interpolation;
+
+	/*
+	 * Corrects the stylus samples for the quirks of the firmware and of the stylus.
+	 */
+	StylusCorrection m_correction;
+
+	/*
+	 * Drops stylus samples that repeat the previous one, if enabled.
+	 */
+	DuplicateFilter m_duplicates;
+
+	/*
+	 * Detects and optionally suppresses serial numbers of the stylus that change too often.
+	 */
+	SerialMonitor m_serials;
+
+	/*
+	 * Suppresses the stylus inside of the configured areas of the screen.
+	 */
+	StylusRegions m_regions;
+
+	/*
+	 * Normalizes the heatmap and matches its layout to the screen.
+	 */
+	HeatmapLayout m_layout;
+
+	/*
+	 * Inverts the heatmap if needed, and detects its polarity and thresholds if enabled.
+	 */
+	HeatmapCalibration m_calibration;
+
+	/*
+	 * Removes the parts of the heatmap that are outside of the active area.
+	 */
+	HeatmapMask m_mask;
+
+	/*
+	 * Drops the contacts outside of the area of the digitizer that can be touched.
+	 */
+	ActiveArea m_area;
+
+	/*
+	 * Removes the slow drift of single cells from the heatmap.
+	 */
+	HeatmapBaseline m_baseline;
+
+	/*
+	 * Describes how the cells of the heatmap map to the screen, for the event stream.
+	 */
+	HeatmapGeometry m_geometry;
+
+	/*
+	 * Looks for touch data that no real input can produce, to report it as an anomaly.
+	 */
+	TouchAnomalies m_anomalies {};
+
+	/*
+	 * Collects statistics about the contacts, from when they appear until they are lifted.
+	 */
+	ContactLifetimes m_lifetimes;
+
+	/*
+	 * Switches the options of the stylus by the serial number of the stylus that is used.
+	 */
+	StylusProfiles m_profiles;
+
+	/*
+	 * Ignores the inputs of a device that was reconnected until its sensor has settled.
+	 */
+	SettlingWindow m_settling;
+
+	/*
+	 * Holds the contacts for a few frames while buffers can't be parsed.
+	 */
+	MissingFrames m_missing;
+
+	/*
+	 * Skips heatmaps while buffers are lost because processing can't keep up.
+	 */
+	LoadMonitor m_load;
+
+	/*
+	 * Measures how many buffers the device sends per second.
+	 */
+	RateEstimator m_rate {};
+
+	/*
+	 * The last state of every stylus that was used, for the state.
+	 */
+	StylusHistory m_styli {};
+
+	/*
+	 * Logs every type of frame that the parser doesn't know once.
+	 */
+	UnknownFrames m_unknown {};
+
+	/*
+	 * Counters that describe the data stream that is processed by this application.
+	 */
+	Statistics m_stats {};
+
+private:
+	// Limits the warnings about dropped buffers to one per second.
+	common::Throttle m_drop_warnings {};
+
+	// Limits the warnings about invalid buffers to one per second.
+	common::Throttle m_invalid_warnings {};
+
+	// Whether a stylus report with too many samples was already reported.
+	bool m_truncated = false;
+
+	// The last stylus sample that was processed.
+	ipts::samples::Stylus m_stylus {};
+
+	// When the application was created.
+	chrono::steady_clock::time_point m_started = chrono::steady_clock::now();
+
 public:
 	Application(const Config &config, const DeviceInfo &info)
 		: m_config {config},
 		  m_info {info},
 		  m_finder {config.contacts()},
-		  m_dft {config, info}
+		  m_dft {config, info},
+		  m_smoothing {config},
+		  m_pressure_smoothing {config},
+		  m_pressure_interpolation {config},
+		  m_correction {config},
+		  m_duplicates {config},
+		  m_serials {config},
+		  m_regions {config},
+		  m_layout {config},
+		  m_calibration {config},
+		  m_mask {config},
+		  m_area {config},
+		  m_baseline {config},
+		  m_geometry {config, info},
+		  m_lifetimes {config},
+		  m_profiles {config},
+		  m_settling {config},
+		  m_missing {config},
+		  m_load {config}
 	{
 		if (m_config.width == 0 || m_config.height == 0)
 			throw common::Error<Error::InvalidScreenSize> {};
 
+		const std::string &errors = m_config.parse_errors;
+
+		if (errors == "resync")
+			m_parser.resync(true);
+		else if (errors != "skip" && errors != "abort")
+			throw common::Error<Error::InvalidParseErrorPolicy> {};
+
 		m_parser.on_touch = [&](const auto &data) { this->process_touch(data); };
 		m_parser.on_stylus = [&](const auto &data) { this->process_stylus(data); };
 		m_parser.on_dft = [&](const auto &data) { this->process_dft(data); };
 		m_parser.on_button = [&](const auto &data) { this->process_button(data); };
+		m_parser.on_dropped = [&](const auto &gap) { this->process_dropped(gap); };
+		m_parser.on_unknown = [&](const auto &data) { this->process_unknown(data); };
+		m_parser.on_invalid = [&](const u16 type, const std::exception &e) {
+			this->process_invalid(type, e);
+		};
+		m_parser.on_truncated = [&](const u8 samples) { this->process_truncated(samples); };
+		m_parser.on_nonconformant = [&](const ipts::Conformance::Check check) {
+			this->anomaly(std::string {ipts::Conformance::name(check)});
+		};
 	}
 
 	virtual ~Application() = default;
@@ -105,11 +343,229 @@ public:
 	/*!
 	 * Parse and process an IPTS data buffer.
 	 *
+	 * Only errors of the parser are handled according to the parse error policy.
+	 * Errors that happen while processing the parsed data are always passed on.
+	 *
 	 * @param[in] data The buffer to process.
 	 */
 	void process(const gsl::span<u8> data)
 	{
-		this->on_data(data);
+		m_stats.buffers++;
+		m_load.processed();
+		m_rate.input();
+
+		try {
+			this->on_data(data);
+		} catch (const common::Error<Reader::Error::EndOfData> &e) {
+			this->process_unparsable(e);
+		} catch (const common::Error<Reader::Error::InvalidRead> &e) {
+			this->process_unparsable(e);
+		} catch (const common::Error<Reader::Error::InvalidSeek> &e) {
+			this->process_unparsable(e);
+		} catch (const common::Error<ipts::Error::NestingTooDeep> &e) {
+			this->process_unparsable(e);
+		}
+	}
+
+	/*!
+	 * The configuration of the application.
+	 *
+	 * @return The config that the application was created with.
+	 */
+	[[nodiscard]] const Config &config() const
+	{
+		return m_config;
+	}
+
+	/*!
+	 * Counters that describe the data stream that was processed so far.
+	 *
+	 * @return The statistics of this application.
+	 */
+	[[nodiscard]] const Statistics &stats() const
+	{
+		return m_stats;
+	}
+
+	/*!
+	 * Lists the workarounds for device specific behaviour that are active.
+	 *
+	 * These usually come from the presets, and change how the data of the device is
+	 * interpreted. Knowing them helps to understand reports about a device.
+	 *
+	 * @return The names of the active quirks.
+	 */
+	[[nodiscard]] std::vector<std::string> quirks() const
+	{
+		const std::vector<std::pair<std::string, bool>> quirks {
+			{"ignore_metadata", m_config.ignore_metadata},
+			{"heatmap_transpose", m_config.contacts_heatmap_transpose},
+			{"heatmap_flip_x", m_config.contacts_heatmap_flip_x},
+			{"heatmap_flip_y", m_config.contacts_heatmap_flip_y},
+			{"normal_polarity", !m_calibration.inverted()},
+			{"resync_reports", m_config.parse_errors == "resync"},
+			{"drop_duplicate_stylus", m_config.stylus_drop_duplicates},
+			{"arm_rubber", m_config.stylus_arm_rubber},
+			{"invert_tilt_x", m_config.stylus_invert_tilt_x},
+			{"invert_tilt_y", m_config.stylus_invert_tilt_y},
+			{"disable_tilt", m_config.stylus_disable_tilt},
+		};
+
+		std::vector<std::string> out {};
+
+		for (const auto &[name, active] : quirks) {
+			if (active)
+				out.push_back(name);
+		}
+
+		return out;
+	}
+
+	/*!
+	 * Collects the current internal state, e.g. for attaching it to bug reports.
+	 *
+	 * Applications can extend the state with their own data by overriding this method.
+	 * This must not be called while a buffer is being processed.
+	 *
+	 * @return A JSON object describing the state of the application.
+	 */
+	[[nodiscard]] virtual common::Json state() const
+	{
+		common::Json device {};
+		device.add("vendor", m_info.vendor)
+			.add("product", m_info.product)
+			.add("type", m_info.is_touchscreen() ? "touchscreen" : "touchpad")
+			.add("metadata", m_info.meta.has_value());
+
+		if (m_info.meta.has_value()) {
+			device.add("rows", m_info.meta->rows)
+				.add("columns", m_info.meta->columns);
+		}
+
+		if (m_rate.rate().has_value())
+			device.add("rate", m_rate.rate().value());
+
+		common::Json stats {};
+		stats.add("buffers", m_stats.buffers)
+			.add("dropped", m_stats.dropped)
+			.add("invalid", m_stats.invalid)
+			.add("skipped", m_stats.skipped)
+			.add("unknown", m_stats.unknown)
+			.add("duplicates", m_stats.duplicates)
+			.add("truncated", m_stats.truncated);
+
+		std::vector<common::Json> styli {};
+
+		for (const auto &entry : m_styli.styli())
+			styli.push_back(json(entry.second));
+
+		common::Json filters {};
+		filters.add("smoothing", m_config.stylus_smoothing && m_smoothing.active())
+			.add("pressure_smoothing",
+			     m_config.stylus_pressure_smoothing && m_pressure_smoothing.active())
+			.add("autodetect", m_calibration.detecting())
+			.add("baseline", m_config.contacts_baseline)
+			.add("ignore_regions", m_regions.active())
+			.add("active_area", m_area.active())
+			.add("settling", m_settling.active())
+			.add("serial_locked", m_serials.locked())
+			.add("inverted", m_calibration.inverted())
+			.add("missing_frames", m_missing.frames())
+			.add("decimation", m_load.decimation())
+			.add("contacts", m_contacts.size())
+			.add("stylus_profile", m_profiles.name());
+
+		common::Json state {};
+		const seconds<f64> uptime = chrono::steady_clock::now() - m_started;
+
+		state.add("version", STATE_VERSION)
+			.add("iptsd", common::buildopts::Version)
+			.add("uptime", uptime.count())
+			.add("device", device)
+			.add("statistics", stats)
+			.add("stylus", json(m_stylus))
+			.add("styli", styli)
+			.add("reports", this->reports())
+			.add("conformance", this->conformance())
+			.add("quirks", this->quirks())
+			.add("filters", filters)
+			.add("lifetimes", m_lifetimes.json())
+			.add("geometry", this->geometry())
+			.add("config", m_config.json());
+
+		return state;
+	}
+
+	/*!
+	 * Describes the heatmap and how its cells map to the screen.
+	 *
+	 * @return A JSON object describing the geometry of the heatmap.
+	 */
+	[[nodiscard]] common::Json geometry() const
+	{
+		return m_geometry.json(m_heatmap);
+	}
+
+	/*!
+	 * Ignores inputs until the sensor has settled, because the device was reconnected.
+	 *
+	 * This must not be called while a buffer is being processed.
+	 */
+	void settle()
+	{
+		// The device starts counting its frames from the beginning.
+		m_parser.reset();
+
+		m_settling.start();
+	}
+
+	/*!
+	 * Lifts all contacts and the stylus, e.g. because the device disappeared.
+	 *
+	 * This must not be called while a buffer is being processed.
+	 */
+	void lift()
+	{
+		m_finder.reset();
+		m_anomalies.reset();
+		m_contacts.clear();
+
+		this->emit_touch();
+
+		if (!m_stylus.proximity)
+			return;
+
+		m_stylus.proximity = false;
+		m_stylus.contact = false;
+		m_stylus.button = false;
+		m_stylus.rubber = false;
+		m_stylus.pressure = 0;
+
+		m_smoothing.reset();
+		m_pressure_smoothing.reset();
+		m_pressure_interpolation.reset();
+
+		m_styli.update(m_stylus);
+		this->emit_stylus(m_stylus);
+	}
+
+	/*!
+	 * Executes a command that was sent to the application from the outside.
+	 *
+	 * The runner only calls this between two buffers, so it is safe to modify any state.
+	 *
+	 * @param[in] command The command to execute.
+	 */
+	virtual void on_command(const Command command)
+	{
+		if (command != Command::ResetContacts)
+			return;
+
+		m_finder.reset();
+		m_anomalies.reset();
+		m_contacts.clear();
+
+		this->emit_touch();
 	}
 
 	/*!
@@ -147,7 +603,175 @@ protected:
 	 */
 	virtual void on_button(const ipts::samples::Button & /* unused */) {};
 
+	/*!
+	 * For running application specific code after buffers were lost.
+	 *
+	 * Contacts can not be tracked across the missing frames, so any state
+	 * that depends on the continuity of the data should be reset.
+	 */
+	virtual void on_dropped() {};
+
+	/*!
+	 * For running application specific code after the options of the stylus changed.
+	 *
+	 * The new options are already part of the config.
+	 *
+	 * @param[in] profile The profile that is used now.
+	 */
+	virtual void on_profile(const StylusProfile & /* unused */) {};
+
+	/*!
+	 * Serializes a stylus sample, for the state and the event stream.
+	 *
+	 * @param[in] stylus The stylus sample.
+	 * @return A JSON object describing the sample.
+	 */
+	[[nodiscard]] static common::Json json(const ipts::samples::Stylus &stylus)
+	{
+		common::Json out {};
+		out.add("serial", stylus.serial)
+			.add("proximity", stylus.proximity)
+			.add("contact", stylus.contact)
+			.add("button", stylus.button)
+			.add("rubber", stylus.rubber)
+			.add("timestamp", stylus.timestamp)
+			.add("x", stylus.x)
+			.add("y", stylus.y)
+			.add("pressure", stylus.pressure)
+			.add("altitude", stylus.altitude)
+			.add("azimuth", stylus.azimuth);
+
+		return out;
+	}
+
+	/*!
+	 * Serializes a contact, for the event stream.
+	 *
+	 * Positions and sizes are normalized, like they are passed to @ref on_touch.
+	 *
+	 * @param[in] contact The contact.
+	 * @return A JSON object describing the contact.
+	 */
+	[[nodiscard]] static common::Json json(const contacts::Contact<f64> &contact)
+	{
+		common::Json out {};
+
+		if (contact.index.has_value())
+			out.add("index", contact.index.value());
+
+		out.add("x", contact.mean.x())
+			.add("y", contact.mean.y())
+			.add("major", contact.size.maxCoeff())
+			.add("minor", contact.size.minCoeff())
+			.add("orientation", contact.orientation)
+			.add("intensity", contact.intensity)
+			.add("valid", contact.valid.value_or(true))
+			.add("stable", contact.stable.value_or(true));
+
+		return out;
+	}
+
+	/*!
+	 * Lists which types of report frames the device sent.
+	 *
+	 * @return A JSON object for every type that was parsed, with its count and total size.
+	 */
+	[[nodiscard]] std::vector<common::Json> reports() const
+	{
+		std::vector<common::Json> out {};
+		const auto &counts = m_parser.reports();
+
+		for (usize i = 0; i < counts.size(); i++) {
+			if (counts.at(i).count == 0)
+				continue;
+
+			const auto type = static_cast<ipts::protocol::report::Type>(i);
+
+			common::Json report {};
+			report.add("type", fmt::format("{:#04x}", i))
+				.add("name", ipts::protocol::report::name(type))
+				.add("count", counts.at(i).count)
+				.add("bytes", counts.at(i).bytes);
+
+			out.push_back(report);
+		}
+
+		return out;
+	}
+
+	/*!
+	 * Lists in which ways the data of the device deviated from the protocol.
+	 *
+	 * @return A JSON object for every check that failed, with the first offset that failed it.
+	 */
+	[[nodiscard]] std::vector<common::Json> conformance() const
+	{
+		using Check = ipts::Conformance::Check;
+
+		std::vector<common::Json> out {};
+		const auto &findings = m_parser.conformance().findings();
+
+		for (usize i = 0; i < findings.size(); i++) {
+			if (findings.at(i).count == 0)
+				continue;
+
+			common::Json finding {};
+			finding.add("check", ipts::Conformance::name(static_cast<Check>(i)))
+				.add("offset", findings.at(i).offset)
+				.add("count", findings.at(i).count);
+
+			out.push_back(finding);
+		}
+
+		return out;
+	}
+
 private:
+	/*!
+	 * Hands off the current contacts to the handler code and the event stream.
+	 */
+	void emit_touch()
+	{
+		m_lifetimes.update(m_contacts);
+
+		this->on_touch(m_contacts);
+
+		if (!this->publish)
+			return;
+
+		std::vector<common::Json> contacts {};
+
+		for (const contacts::Contact<f64> &contact : m_contacts)
+			contacts.push_back(json(contact));
+
+		common::Json event {};
+		event.add("type", "touch")
+			.add("device", m_info.is_touchscreen() ? "touchscreen" : "touchpad")
+			.add("contacts", contacts);
+
+		this->publish(event);
+	}
+
+	/*!
+	 * Hands off a stylus sample to the handler code and the event stream.
+	 *
+	 * @param[in] stylus The processed stylus sample.
+	 */
+	void emit_stylus(const ipts::samples::Stylus &stylus)
+	{
+		this->on_stylus(stylus);
+
+		if (!this->publish)
+			return;
+
+		common::Json event {};
+		event.add("type", "stylus")
+			.add("device", m_info.is_touchscreen() ? "touchscreen" : "touchpad")
+			.add("stylus", json(stylus));
+
+		this->publish(event);
+	}
+
 	/*!
 	 * Runs contact detection on an IPTS heatmap.
 	 *
@@ -158,31 +782,46 @@ private:
 	 */
 	void process_touch(const ipts::samples::Touch &data)
 	{
-		const Eigen::Index rows = casts::to_eigen(data.rows);
-		const Eigen::Index cols = casts::to_eigen(data.columns);
+		if (data.rows == 0 || data.columns == 0)
+			return;
 
-		if (rows == 0 || cols == 0)
+		m_missing.received();
+		m_touch_timestamp = data.timestamp;
+
+		if (m_load.skip())
 			return;
 
-		// Make sure the heatmap buffer has the right size
-		if (m_heatmap.rows() != rows || m_heatmap.cols() != cols)
-			m_heatmap.conservativeResize(rows, cols);
+		m_layout.apply(data, m_heatmap);
 
-		// Map the buffer to an Eigen container
-		const Eigen::Map<const Image<u8>> mapped {data.heatmap.data(), rows, cols};
+		// The thresholds of the finder can be detected from the first touch.
+		if (m_calibration.apply(m_heatmap, m_config))
+			m_finder = contacts::Finder<f64> {m_config.contacts()};
 
-		const auto min = casts::to<f64>(data.min);
-		const auto max = casts::to<f64>(data.max);
+		m_mask.apply(m_heatmap);
+		m_baseline.apply(m_heatmap, m_config.contacts_activation_threshold / 255.0);
 
-		// Normalize the heatmap to range [0, 1]
-		const auto norm = (mapped.cast<f64>() - min) / (max - min);
+		if (this->report_anomaly) {
+			const f64 threshold = m_config.contacts_activation_threshold / 255.0;
+			const auto artifact = TouchAnomalies::heatmap(m_heatmap, threshold);
 
-		// IPTS sends inverted heatmaps
-		m_heatmap = 1.0 - norm;
+			if (artifact.has_value())
+				this->anomaly(artifact.value());
+		}
 
 		// Search for contacts
 		m_finder.find(m_heatmap, m_contacts);
 
+		if (m_settling.active()) {
+			const auto palm = [](const auto &c) { return c.rejected(); };
+
+			bool sane = m_contacts.size() <= m_config.contacts_max;
+			sane &= std::none_of(m_contacts.cbegin(), m_contacts.cend(), palm);
+
+			// The contacts are still tracked, but only emitted once the sensor settled.
+			if (m_settling.touch(sane))
+				m_contacts.clear();
+		}
+
 		// Invert contact coordinates if neccessary
 		for (contacts::Contact<f64> &contact : m_contacts) {
 			if (m_config.invert_x)
@@ -195,8 +834,44 @@ private:
 				contact.orientation = 1.0 - contact.orientation;
 		}
 
+		// Contacts outside of the area must not take the place of real ones.
+		m_area.filter(m_contacts);
+		this->limit_contacts();
+
+		if (this->report_anomaly) {
+			const auto jump = m_anomalies.contacts(m_contacts);
+
+			if (jump.has_value())
+				this->anomaly(jump.value());
+		}
+
 		// Hand off the found contacts to the handler code.
-		this->on_touch(m_contacts);
+		this->emit_touch();
+	}
+
+	/*!
+	 * Drops the weakest contacts if more contacts than allowed were found.
+	 *
+	 * The contacts are dropped after tracking, so the remaining contacts keep their index.
+	 * Dropped contacts simply disappear from the frame and are lifted like any other contact.
+	 */
+	void limit_contacts()
+	{
+		const usize max = m_config.contacts_max;
+
+		if (m_contacts.size() <= max)
+			return;
+
+		const auto stronger = [](const auto &a, const auto &b) {
+			return a.intensity > b.intensity;
+		};
+
+		std::nth_element(m_contacts.begin(),
+		                 m_contacts.begin() + casts::to_signed(max),
+		                 m_contacts.end(),
+		                 stronger);
+
+		m_contacts.resize(max);
 	}
 
 	/*!
@@ -209,15 +884,59 @@ private:
 		if (!m_info.is_touchscreen())
 			return;
 
+		if (m_settling.stylus(data))
+			return;
+
+		if (m_duplicates.drop(data)) {
+			m_stats.duplicates++;
+			return;
+		}
+
 		ipts::samples::Stylus corrected = data;
+		m_correction.apply(corrected);
+
+		m_regions.filter(corrected);
+
+		if (m_config.stylus_smoothing)
+			m_smoothing.filter(corrected);
+
+		corrected.serial = m_serials.filter(corrected.serial);
+		this->select_profile(corrected.serial);
+
+		if (m_config.stylus_pressure_interpolation)
+			m_pressure_interpolation.filter(corrected);
+
+		if (m_config.stylus_pressure_smoothing)
+			m_pressure_smoothing.filter(corrected);
 
-		// Correct position based on tip-transmitter distance
-		const Vector2<f64> off = this->calculate_offset(data.altitude, data.azimuth);
-		corrected.x += off.x();
-		corrected.y += off.y();
+		m_stylus = corrected;
+		m_styli.update(corrected);
 
 		// Hand off the stylus data to the handler code.
-		this->on_stylus(corrected);
+		this->emit_stylus(corrected);
+	}
+
+	/*!
+	 * Switches to the profile of a stylus, when a different stylus is used.
+	 *
+	 * The filters start over with the new options, which only takes effect from the next
+	 * sample on for the position.
+	 *
+	 * @param[in] serial The serial number of the stylus.
+	 */
+	void select_profile(const u32 serial)
+	{
+		const std::optional<StylusProfile> profile = m_profiles.select(serial);
+
+		if (!profile.has_value())
+			return;
+
+		m_config.apply(profile.value());
+
+		m_smoothing = StylusSmoothing {m_config};
+		m_pressure_smoothing = PressureSmoothing {m_config};
+
+		this->on_profile(profile.value());
 	}
 
 	/*!
@@ -261,33 +980,142 @@ private:
 	}
 
 	/*!
-	 * Calculates the tilt-based offset of the stylus position.
+	 * Handles a touch frame that is missing, because its buffer could not be parsed.
+	 *
+	 * The contacts are held at their last position for a few frames. If no usable frame
+	 * arrives in that time, all contacts are lifted.
+	 */
+	void process_missing()
+	{
+		if (!m_missing.missed(m_rate.rate()) || m_contacts.empty())
+			return;
+
+		m_finder.reset();
+		m_anomalies.reset();
+		m_contacts.clear();
+
+		this->emit_touch();
+	}
+
+	/*!
+	 * Handles a gap in the incoming data.
+	 *
+	 * The gap is counted and a warning is printed, at most once per second.
+	 * Since the continuity of the contacts is broken, the contact finder is reset.
+	 *
+	 * @param[in] gap How many buffers were lost.
+	 */
+	void process_dropped(const u32 gap)
+	{
+		m_stats.dropped += gap;
+		m_load.dropped(gap);
+
+		const std::optional<u64> dropped = m_drop_warnings.add(gap);
+
+		if (dropped.has_value())
+			spdlog::warn("Dropped {} buffers (last gap: {})", dropped.value(), gap);
+
+		m_finder.reset();
+		m_anomalies.reset();
+
+		this->anomaly("dropped_buffers");
+		this->on_dropped();
+	}
+
+	/*!
+	 * Handles a buffer that could not be parsed.
 	 *
-	 * Some styli have the transmitter a few millimeters above the tip of the pen.
-	 * This means that the more you tilt the pen, the more the reported position will
-	 * diverge from the position of the pen tip.
+	 * Must be called while the error is being handled, so that it can be passed on if the
+	 * buffer should not be skipped. Otherwise a warning is printed, at most once per second.
 	 *
-	 * If the distance between transmitter and pen tip is known, this offset can be
-	 * calculated and added to the reported position.
+	 * @param[in] error Why the buffer could not be parsed.
+	 */
+	void process_unparsable(const std::exception &error)
+	{
+		m_stats.invalid++;
+
+		this->process_missing();
+		this->anomaly("invalid_buffer");
+
+		if (this->report_invalid)
+			this->report_invalid();
+
+		if (m_config.parse_errors == "abort")
+			throw;
+
+		const std::optional<u64> skipped = m_invalid_warnings.add();
+
+		if (!skipped.has_value())
+			return;
+
+		spdlog::warn("Skipped {} invalid buffers (last error: {})",
+		             skipped.value(),
+		             error.what());
+	}
+
+	/*!
+	 * Handles a report that was skipped because it could not be parsed.
 	 *
-	 * @param[in] altitude The altitude of the stylus.
-	 * @param[in] azimuth The azimuth of the stylus.
-	 * @return A Vector containing the offset on the X and Y axis.
+	 * @param[in] type The type of the skipped report.
+	 * @param[in] error Why the report could not be parsed.
 	 */
-	[[nodiscard]] Vector2<f64> calculate_offset(const f64 altitude, const f64 azimuth) const
+	void process_invalid(const u16 type, const std::exception &error)
 	{
-		if (altitude <= 0)
-			return Vector2<f64>::Zero();
+		m_stats.skipped++;
 
-		if (m_config.stylus_tip_distance == 0)
-			return Vector2<f64>::Zero();
+		spdlog::warn("Skipped invalid report of type {:#04x}: {}", type, error.what());
+		this->anomaly("invalid_report");
+	}
 
-		const f64 offset = std::sin(altitude) * m_config.stylus_tip_distance;
+	/*!
+	 * Handles a stylus report that claimed more samples than expected.
+	 *
+	 * This is only printed once, since it would repeat for every report of a broken device.
+	 *
+	 * @param[in] samples How many samples the report claimed to contain.
+	 */
+	void process_truncated(const u8 samples)
+	{
+		m_stats.truncated++;
+
+		if (m_truncated)
+			return;
+
+		m_truncated = true;
 
-		const f64 ox = offset * -std::cos(azimuth);
-		const f64 oy = offset * std::sin(azimuth);
+		spdlog::warn("Stylus report claims {} samples, more than the expected {}",
+		             samples,
+		             ipts::protocol::stylus::MAX_SAMPLES);
 
-		return Vector2<f64> {ox / m_config.width, oy / m_config.height};
+		this->anomaly("truncated_report");
+	}
+
+	/*!
+	 * Handles frames that were skipped because their type is not known.
+	 *
+	 * Every new type is reported as an anomaly once.
+	 *
+	 * @param[in] data The type and size of the skipped frame.
+	 */
+	void process_unknown(const ipts::samples::Unknown &data)
+	{
+		m_stats.unknown++;
+
+		if (!m_unknown.report(data))
+			return;
+
+		this->anomaly(fmt::format("unknown_{:02X}", data.type));
+	}
+
+	/*!
+	 * Tells the runner that something unusual was detected, if it is interested.
+	 *
+	 * @param[in] name The name of the check that detected the anomaly.
+	 */
+	void anomaly(const std::string &name) const
+	{
+		if (this->report_anomaly)
+			this->report_anomaly(name);
 	}
 };
 
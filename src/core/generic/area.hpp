@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_AREA_HPP
+#define IPTSD_CORE_GENERIC_AREA_HPP
+
+#include "config.hpp"
+#include "errors.hpp"
+
+#include <common/error.hpp>
+#include <common/types.hpp>
+#include <contacts/contact.hpp>
+
+#include <algorithm>
+#include <array>
+#include <exception>
+#include <sstream>
+#include <string>
+#include <utility>
+#include <vector>
+
+namespace iptsd::core {
+
+/*
+ * Drops touch contacts outside of the area of the digitizer that can actually be touched.
+ *
+ * On some devices the digitizer is larger than the screen and extends under the bezel,
+ * where it picks up the hand that holds the device. Unlike the mask, this works on the
+ * contacts instead of the heatmap, so contacts that are partially inside of the area
+ * are kept or dropped as a whole, depending on where their center is.
+ */
+class ActiveArea {
+private:
+	Config m_config;
+
+	// The upper left corner of the area, normalized to the size of the screen.
+	Vector2<f64> m_min = Vector2<f64>::Zero();
+
+	// The lower right corner of the area, normalized to the size of the screen.
+	Vector2<f64> m_max = Vector2<f64>::Ones();
+
+	// Whether an area is configured.
+	bool m_active = false;
+
+public:
+	ActiveArea(Config config) : m_config {std::move(config)}
+	{
+		const std::string &area = m_config.contacts_active_area;
+
+		if (area.empty())
+			return;
+
+		std::array<f64, 4> values {};
+
+		std::istringstream stream {area};
+		std::string value {};
+
+		usize count = 0;
+
+		while (std::getline(stream, value, ':')) {
+			if (count >= values.size())
+				throw common::Error<Error::InvalidActiveArea> {area};
+
+			try {
+				values.at(count++) = std::stod(value);
+			} catch (const std::exception & /* unused */) {
+				throw common::Error<Error::InvalidActiveArea> {area};
+			}
+		}
+
+		if (count != values.size())
+			throw common::Error<Error::InvalidActiveArea> {area};
+
+		m_min = Vector2<f64> {values[0], values[1]};
+		m_max = Vector2<f64> {values[2], values[3]};
+
+		if (m_min.x() >= m_max.x() || m_min.y() >= m_max.y())
+			throw common::Error<Error::InvalidActiveArea> {area};
+
+		m_active = true;
+	};
+
+	/*!
+	 * Whether an area is configured.
+	 *
+	 * @return true if contacts can be dropped.
+	 */
+	[[nodiscard]] bool active() const
+	{
+		return m_active;
+	}
+
+	/*!
+	 * Removes all contacts whose center is outside of the area.
+	 *
+	 * The edges belong to the area. Removed contacts disappear from the frame and are lifted
+	 * like any other contact.
+	 *
+	 * @param[in,out] contacts The contacts of the current frame, in screen coordinates.
+	 */
+	void filter(std::vector<contacts::Contact<f64>> &contacts) const
+	{
+		if (!m_active)
+			return;
+
+		const auto outside = [&](const contacts::Contact<f64> &contact) {
+			const Vector2<f64> &mean = contact.mean;
+
+			if (mean.x() < m_min.x() || mean.x() > m_max.x())
+				return true;
+
+			return mean.y() < m_min.y() || mean.y() > m_max.y();
+		};
+
+		contacts.erase(std::remove_if(contacts.begin(), contacts.end(), outside),
+		               contacts.end());
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_AREA_HPP
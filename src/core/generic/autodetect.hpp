@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_AUTODETECT_HPP
+#define IPTSD_CORE_GENERIC_AUTODETECT_HPP
+
+#include <common/casts.hpp>
+#include <common/types.hpp>
+
+#include <algorithm>
+#include <cmath>
+#include <optional>
+
+namespace iptsd::core {
+
+/*
+ * Detects the polarity of the heatmap and a suitable activation threshold.
+ *
+ * For the first frames, the distribution of the heatmap values is recorded as a baseline.
+ * The first blob that deviates from the baseline for multiple frames in a row is assumed
+ * to be a finger. Whether it raises or lowers the values determines the polarity.
+ */
+class HeatmapAutodetect {
+public:
+	struct Result {
+		// Whether touches lower the values of the heatmap.
+		bool inverted = true;
+
+		// The activation threshold for contact detection (Range 0 - 255).
+		f64 threshold = 0;
+	};
+
+private:
+	// How many frames are used to determine the baseline.
+	constexpr static usize BASELINE_FRAMES = 120;
+
+	// For how many frames in a row a blob has to be present.
+	constexpr static usize SUSTAIN_FRAMES = 10;
+
+	// How many standard deviations a blob must deviate from the baseline.
+	f64 m_deviations;
+
+	// How many frames were recorded for the baseline.
+	usize m_frames = 0;
+
+	// The sum of all values and the sum of all squared values of the baseline.
+	f64 m_sum = 0;
+	f64 m_sum_sq = 0;
+	f64 m_count = 0;
+
+	// The mean and standard deviation of the baseline.
+	f64 m_mean = 0;
+	f64 m_stddev = 0;
+
+	// For how many frames in a row a blob has been present.
+	usize m_sustained = 0;
+
+	// Whether the current blob raises (1) or lowers (-1) the values.
+	i32 m_sign = 0;
+
+public:
+	HeatmapAutodetect(const f64 deviations) : m_deviations {deviations} {};
+
+	/*!
+	 * Feeds a normalized heatmap into the detector.
+	 *
+	 * @param[in] heatmap The heatmap, normalized to range [0, 1], but not inverted.
+	 * @return The detected values, once the detection has finished.
+	 */
+	std::optional<Result> input(const Image<f64> &heatmap)
+	{
+		if (m_frames < BASELINE_FRAMES) {
+			this->record_baseline(heatmap);
+			return std::nullopt;
+		}
+
+		const f64 above = heatmap.maxCoeff() - m_mean;
+		const f64 below = m_mean - heatmap.minCoeff();
+
+		const f64 deviation = std::max(above, below);
+		const i32 sign = above > below ? 1 : -1;
+
+		if (deviation <= m_deviations * m_stddev) {
+			m_sustained = 0;
+			return std::nullopt;
+		}
+
+		if (sign != m_sign) {
+			m_sign = sign;
+			m_sustained = 0;
+		}
+
+		m_sustained++;
+
+		if (m_sustained < SUSTAIN_FRAMES)
+			return std::nullopt;
+
+		Result result {};
+		result.inverted = m_sign < 0;
+		result.threshold = m_deviations * m_stddev * 255;
+
+		return result;
+	}
+
+private:
+	/*!
+	 * Adds a frame to the baseline distribution.
+	 *
+	 * @param[in] heatmap The normalized heatmap.
+	 */
+	void record_baseline(const Image<f64> &heatmap)
+	{
+		m_sum += heatmap.sum();
+		m_sum_sq += heatmap.square().sum();
+		m_count += casts::to<f64>(heatmap.size());
+
+		m_frames++;
+
+		if (m_frames < BASELINE_FRAMES || m_count == 0)
+			return;
+
+		m_mean = m_sum / m_count;
+
+		const f64 variance = (m_sum_sq / m_count) - (m_mean * m_mean);
+
+		// Perfectly flat heatmaps would make every change a contact.
+		m_stddev = std::max(std::sqrt(std::max(variance, 0.0)), 1.0 / 255);
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_AUTODETECT_HPP
@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_BASELINE_HPP
+#define IPTSD_CORE_GENERIC_BASELINE_HPP
+
+#include "config.hpp"
+
+#include <common/types.hpp>
+
+#include <utility>
+
+namespace iptsd::core {
+
+/*
+ * Removes slow drift of individual cells from the heatmap.
+ *
+ * The values that the sensor reports without any touch change with temperature and humidity.
+ * The neutral value only follows the whole heatmap, but single cells can drift on their own,
+ * until they are detected as phantom touches. The baseline follows every cell slowly, and its
+ * deviation from the average is removed before contacts are searched.
+ *
+ * Cells that look like they are touched don't update the baseline, so that static touches
+ * are not removed.
+ */
+class HeatmapBaseline {
+private:
+	Config m_config;
+
+	// The estimated value of every cell while it is not touched.
+	Image<f64> m_baseline {};
+
+public:
+	HeatmapBaseline(Config config) : m_config {std::move(config)} {};
+
+	/*!
+	 * Updates the baseline and removes it from a heatmap.
+	 *
+	 * @param[in,out] heatmap The normalized heatmap, with contacts raising the values.
+	 * @param[in] threshold How much a cell must exceed the baseline to be a touch.
+	 */
+	void apply(Image<f64> &heatmap, const f64 threshold)
+	{
+		if (!m_config.contacts_baseline)
+			return;
+
+		// Start from a flat baseline, since the screen could be touched already.
+		if (m_baseline.rows() != heatmap.rows() || m_baseline.cols() != heatmap.cols()) {
+			m_baseline.resize(heatmap.rows(), heatmap.cols());
+			m_baseline.setConstant(heatmap.mean());
+		}
+
+		const Image<f64> deviation = heatmap - m_baseline;
+		const Image<f64> idle = (deviation < threshold).cast<f64>();
+
+		m_baseline += m_config.contacts_baseline_rate * idle * deviation;
+
+		// The average is kept, so that the neutral value can still be determined as before.
+		heatmap = (heatmap - m_baseline + m_baseline.mean()).cwiseMax(0.0).cwiseMin(1.0);
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_BASELINE_HPP
@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_CALIBRATION_HPP
+#define IPTSD_CORE_GENERIC_CALIBRATION_HPP
+
+#include "autodetect.hpp"
+#include "config.hpp"
+#include "errors.hpp"
+
+#include <common/error.hpp>
+#include <common/types.hpp>
+
+#include <spdlog/spdlog.h>
+
+#include <fstream>
+#include <optional>
+#include <string>
+
+namespace iptsd::core {
+
+/*
+ * Brings the heatmap into the polarity that the contact finder expects.
+ *
+ * The polarity and the activation threshold are either taken from the config, or detected
+ * from the first touch. Once they are detected, they replace the values in the config and
+ * are written to the state file, if one is configured, so that the next run can skip the
+ * detection.
+ */
+class HeatmapCalibration {
+private:
+	// Whether touches lower the values of the heatmap.
+	bool m_inverted = true;
+
+	// Detects heatmap polarity and thresholds, if enabled and not finished yet.
+	std::optional<HeatmapAutodetect> m_autodetect = std::nullopt;
+
+public:
+	HeatmapCalibration(const Config &config)
+	{
+		const std::string &polarity = config.contacts_polarity;
+
+		if (polarity == "normal")
+			m_inverted = false;
+		else if (polarity != "inverted" && polarity != "auto")
+			throw common::Error<Error::InvalidHeatmapPolarity> {};
+
+		if (polarity == "auto" || config.contacts_auto_threshold)
+			m_autodetect.emplace(config.contacts_auto_deviations);
+	}
+
+	/*!
+	 * Whether touches lower the values of the heatmap.
+	 *
+	 * @return true if the heatmap is inverted before contacts are searched.
+	 */
+	[[nodiscard]] bool inverted() const
+	{
+		return m_inverted;
+	}
+
+	/*!
+	 * Whether the polarity or the threshold are still being detected.
+	 *
+	 * @return true if the detection has not finished yet.
+	 */
+	[[nodiscard]] bool detecting() const
+	{
+		return m_autodetect.has_value();
+	}
+
+	/*!
+	 * Feeds a heatmap into the detection, and inverts it if touches lower its values.
+	 *
+	 * Only the options that were configured to be detected automatically are changed.
+	 *
+	 * @param[in,out] heatmap The heatmap, normalized to range [0, 1].
+	 * @param[in,out] config The config that the detected values are applied to.
+	 * @return true if the thresholds of the contact finder changed.
+	 */
+	bool apply(Image<f64> &heatmap, Config &config)
+	{
+		bool changed = false;
+
+		if (m_autodetect.has_value()) {
+			const auto result = m_autodetect->input(heatmap);
+
+			if (result.has_value())
+				changed = this->detected(result.value(), config);
+		}
+
+		// IPTS usually sends inverted heatmaps
+		if (m_inverted)
+			heatmap = 1.0 - heatmap;
+
+		return changed;
+	}
+
+private:
+	/*!
+	 * Applies the values that were detected from the heatmap.
+	 *
+	 * @param[in] result The detected polarity and threshold.
+	 * @param[in,out] config The config that the detected values are applied to.
+	 * @return true if the thresholds of the contact finder changed.
+	 */
+	bool detected(const HeatmapAutodetect::Result &result, Config &config)
+	{
+		m_autodetect.reset();
+
+		if (config.contacts_polarity == "auto") {
+			m_inverted = result.inverted;
+			config.contacts_polarity = m_inverted ? "inverted" : "normal";
+
+			spdlog::info("Detected heatmap polarity: {}", config.contacts_polarity);
+		}
+
+		const bool threshold = config.contacts_auto_threshold;
+
+		if (threshold) {
+			// Keep the ratio between the default thresholds.
+			config.contacts_activation_threshold = result.threshold;
+			config.contacts_deactivation_threshold = result.threshold * 0.9;
+			config.contacts_auto_threshold = false;
+
+			spdlog::info("Detected activation threshold: {:.2f}", result.threshold);
+		}
+
+		if (!config.contacts_auto_state_file.empty())
+			write(config);
+
+		return threshold;
+	}
+
+	/*!
+	 * Stores the detected values, so that the next run can skip the detection.
+	 *
+	 * The file is only read for the options that are still set to be detected.
+	 *
+	 * @param[in] config The config with the detected values.
+	 */
+	static void write(const Config &config)
+	{
+		std::ofstream file {config.contacts_auto_state_file};
+
+		file << "[Contacts]\n";
+		file << "Polarity = " << config.contacts_polarity << "\n";
+		file << "ActivationThreshold = " << config.contacts_activation_threshold << "\n";
+		file << "DeactivationThreshold = " << config.contacts_deactivation_threshold
+		     << "\n";
+		file << "AutoThreshold = false\n";
+
+		if (!file)
+			spdlog::warn("Failed to write {}", config.contacts_auto_state_file);
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_CALIBRATION_HPP
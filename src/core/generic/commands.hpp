@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_COMMANDS_HPP
+#define IPTSD_CORE_GENERIC_COMMANDS_HPP
+
+#include <common/types.hpp>
+
+#include <atomic>
+
+namespace iptsd::core {
+
+/*
+ * Requests that external actors (e.g. signal handlers) can send to a running application.
+ */
+enum class Command : u8 {
+	// Write the current state of the application to a file.
+	DumpState,
+
+	// Enable the touch input if it is disabled, or disable it if it is enabled.
+	ToggleTouch,
+
+	// Lift all contacts and forget about previous frames.
+	ResetContacts,
+
+	// Load the config again and create the application again with it.
+	Reload,
+
+	// The number of commands, must be the last entry.
+	Count,
+};
+
+/*
+ * Collects commands until the loop that owns the application is ready to execute them.
+ *
+ * Pending commands are stored as bits of a single atomic integer. This makes sending commands
+ * safe from signal handlers and other threads, without any locking. Sending the same command
+ * multiple times before it was executed will only execute it once.
+ */
+class CommandQueue {
+private:
+	static_assert(static_cast<u8>(Command::Count) <= 32);
+
+	// The commands that were sent but not executed yet.
+	std::atomic<u32> m_pending = 0;
+
+public:
+	/*!
+	 * Sends a command to the application.
+	 *
+	 * This function is designed to be called from a signal handler.
+	 *
+	 * @param[in] command The command to send.
+	 */
+	void push(const Command command)
+	{
+		m_pending.fetch_or(bit(command));
+	}
+
+	/*!
+	 * Executes all pending commands.
+	 *
+	 * This must only be called by the loop that owns the application, between two buffers.
+	 *
+	 * @param[in] handler The function that executes a command.
+	 */
+	template <class F>
+	void drain(F &&handler)
+	{
+		const u32 pending = m_pending.exchange(0);
+
+		if (pending == 0)
+			return;
+
+		for (u8 i = 0; i < static_cast<u8>(Command::Count); i++) {
+			const auto command = static_cast<Command>(i);
+
+			if ((pending & bit(command)) != 0)
+				handler(command);
+		}
+	}
+
+private:
+	[[nodiscard]] static u32 bit(const Command command)
+	{
+		return 1U << static_cast<u8>(command);
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_COMMANDS_HPP
@@ -6,15 +6,36 @@
 #include "errors.hpp"
 
 #include <common/error.hpp>
+#include <common/json.hpp>
 #include <common/types.hpp>
 #include <contacts/config.hpp>
 #include <ipts/parser.hpp>
 
 #include <optional>
 #include <string>
+#include <vector>
 
 namespace iptsd::core {
 
+/*
+ * Stylus options that replace the ones from [Stylus] while a certain stylus is used.
+ */
+struct StylusProfile {
+	// The name of the profile, from its section [StylusProfile.NAME].
+	std::string name {};
+
+	// The serial numbers of the styli that use this profile.
+	std::vector<u32> serials {};
+
+	bool smoothing = false;
+	f64 smoothing_factor = 0.2;
+	f64 smoothing_speed_min = 1;
+	f64 smoothing_speed_max = 20;
+	bool pressure_smoothing = false;
+	f64 pressure_smoothing_factor = 0.3;
+	std::string pressure_curve = "linear";
+};
+
 class Config {
 public:
 	// [Config]
@@ -24,19 +45,61 @@ public:
 	f64 width = 0;
 	f64 height = 0;
 
+	bool ignore_metadata = false;
+
+	std::string parse_errors = "skip";
+
+	f64 settle_time = 0.5;
+	usize settle_frames = 10;
+
 	// [Touchscreen]
 	bool touchscreen_disable = false;
 	bool touchscreen_disable_on_palm = false;
 	bool touchscreen_disable_on_stylus = false;
+	bool touchscreen_disable_on_stylus_firmware = false;
 	f64 touchscreen_overshoot = 0.5;
+	f64 touchscreen_drag_threshold = 0;
+	std::string touchscreen_mode = "absolute";
+	f64 touchscreen_pointer_speed = 4;
+	f64 touchscreen_pointer_acceleration = 0;
+	std::string touchscreen_output_device {};
+	std::string touchscreen_protocol = "B";
+	std::string touchscreen_out_of_range = "clamp";
+	bool touchscreen_emit_width = false;
+	bool touchscreen_emit_pressure = false;
+	std::string touchscreen_pressure_curve = "linear";
+	bool touchscreen_hardware_timestamps = false;
+	std::string touchscreen_toggle_device {};
+	std::string touchscreen_toggle_keys {};
 
 	// [Touchpad]
 	bool touchpad_disable = false;
 	bool touchpad_disable_on_palm = false;
 	f64 touchpad_overshoot = 0.5;
+	f64 touchpad_drag_threshold = 0;
+	usize touchpad_count_debounce = 0;
+	std::string touchpad_output_device {};
+	std::string touchpad_protocol = "B";
+	std::string touchpad_out_of_range = "clamp";
+	bool touchpad_emit_width = false;
+	bool touchpad_emit_pressure = false;
+	std::string touchpad_pressure_curve = "linear";
+	bool touchpad_hardware_timestamps = false;
+
+	// [TabletMode]
+	std::string tablet_mode_device {};
+	bool tablet_mode_disable_on_palm = true;
+	f64 tablet_mode_overshoot = 0;
+
+	// [Idle]
+	bool idle_inhibit = false;
+	f64 idle_interval = 5;
 
 	// [Contacts]
 	std::string contacts_neutral = "mode";
+	std::string contacts_blobs = "maximas";
+	std::string contacts_detection = "gaussian";
+	std::string contacts_position = "centroid";
 	f64 contacts_neutral_value = 0;
 	f64 contacts_activation_threshold = 40;
 	f64 contacts_deactivation_threshold = 36;
@@ -48,12 +111,95 @@ public:
 	f64 contacts_orientation_thresh_max = 15;
 	f64 contacts_size_min = 0.2;
 	f64 contacts_size_max = 2;
+	f64 contacts_size_hysteresis = 0.1;
+	bool contacts_noise_gate = false;
+	f64 contacts_noise_gate_deviation = 1;
+	usize contacts_noise_gate_frames = 3;
+	bool contacts_intensity_hysteresis = false;
+	f64 contacts_intensity_activation = 48;
+	f64 contacts_intensity_deactivation = 42;
+	usize contacts_retain_frames = 0;
+	f64 contacts_retain_distance = 1;
+	bool contacts_pinch_smoothing = false;
+	f64 contacts_pinch_smoothing_factor = 0.3;
 	f64 contacts_aspect_min = 1;
 	f64 contacts_aspect_max = 2.5;
+	usize contacts_hold_frames = 2;
+	usize contacts_max = 16;
+	usize contacts_stale_frames = 5;
+	std::string contacts_polarity = "inverted";
+	bool contacts_heatmap_transpose = false;
+	bool contacts_heatmap_flip_x = false;
+	bool contacts_heatmap_flip_y = false;
+	f64 contacts_mask_corner_radius = 0;
+	std::string contacts_active_area {};
+	bool contacts_baseline = false;
+	f64 contacts_baseline_rate = 0.0001;
+	bool contacts_adaptive_decimation = false;
+	f64 contacts_adaptive_drop_ratio = 0.1;
+	usize contacts_adaptive_max_decimation = 4;
+	bool contacts_auto_threshold = false;
+	f64 contacts_auto_deviations = 4;
+	std::string contacts_auto_state_file {};
+	std::string contacts_lifetime_file {};
 
 	// [Stylus]
 	bool stylus_disable = false;
 	f64 stylus_tip_distance = 0;
+	bool stylus_instant_lift = false;
+	bool stylus_rubber_as_pen = false;
+	u16 stylus_rubber_key = 0x14C; // BTN_STYLUS2
+	bool stylus_separate_rubber = false;
+	bool stylus_arm_rubber = false;
+	bool stylus_drop_duplicates = false;
+	bool stylus_smoothing = false;
+	f64 stylus_smoothing_factor = 0.2;
+	f64 stylus_smoothing_speed_min = 1;
+	f64 stylus_smoothing_speed_max = 20;
+	bool stylus_pressure_smoothing = false;
+	f64 stylus_pressure_smoothing_factor = 0.3;
+	bool stylus_pressure_interpolation = false;
+	u32 stylus_pressure_levels = 0;
+	std::string stylus_output_device {};
+	std::string stylus_button_out_of_proximity = "pass";
+	std::string stylus_out_of_range = "clamp";
+	std::string stylus_emit_errors = "recreate";
+	u32 stylus_max_pressure = 0;
+	std::string stylus_pressure_curve = "linear";
+	std::string stylus_rubber_pressure_curve {};
+	bool stylus_delay_contact = false;
+	f64 stylus_prediction = 0;
+	bool stylus_double_tap = false;
+	u16 stylus_double_tap_key = 0x110; // BTN_LEFT
+	u32 stylus_double_tap_timeout = 300;
+	f64 stylus_double_tap_distance = 0.3;
+	bool stylus_button_scroll = false;
+	f64 stylus_button_scroll_speed = 1;
+	usize stylus_serial_churn_threshold = 5;
+	u32 stylus_serial_churn_window = 1000;
+	bool stylus_serial_churn_lock = false;
+	u32 stylus_serial_churn_cooldown = 5000;
+	std::string stylus_ignore_regions {};
+	std::string stylus_ignore_region_mode = "lift";
+	u32 stylus_fuzz_x = 4;
+	u32 stylus_fuzz_y = 4;
+	u32 stylus_fuzz_pressure = 4;
+	u32 stylus_fuzz_tilt = 50;
+	bool stylus_invert_tilt_x = false;
+	bool stylus_invert_tilt_y = false;
+	bool stylus_disable_tilt = false;
+	bool stylus_auto_tilt = false;
+	usize stylus_auto_tilt_samples = 500;
+	usize stylus_tilt_interval = 1;
+	f64 stylus_tilt_threshold = 0;
+	bool stylus_hardware_timestamps = false;
+	f64 stylus_resample_rate = 0;
+	std::string stylus_remote {};
+	std::string stylus_remote_token {};
+	std::string stylus_profiles {};
+
+	// The profiles that were listed in stylus_profiles, with their options.
+	std::vector<StylusProfile> profiles {};
 
 	// [DFT]
 	usize dft_position_min_amp = 50;
@@ -95,6 +241,35 @@ public:
 		else
 			throw common::Error<Error::InvalidNeutralValueAlgorithm> {};
 
+		using Blobs = contacts::detection::blobs::Algorithm;
+
+		if (this->contacts_blobs == "maximas")
+			config.detection.blob_algorithm = Blobs::MAXIMAS;
+		else if (this->contacts_blobs == "components")
+			config.detection.blob_algorithm = Blobs::COMPONENTS;
+		else
+			throw common::Error<Error::InvalidBlobAlgorithm> {};
+
+		using Fitting = contacts::detection::fitting::Algorithm;
+
+		if (this->contacts_detection == "gaussian")
+			config.detection.fitting_algorithm = Fitting::GAUSSIAN;
+		else if (this->contacts_detection == "moments")
+			config.detection.fitting_algorithm = Fitting::MOMENTS;
+		else
+			throw common::Error<Error::InvalidDetectionAlgorithm> {};
+
+		using Position = contacts::detection::position::Algorithm;
+
+		if (this->contacts_position == "centroid")
+			config.detection.position_algorithm = Position::CENTROID;
+		else if (this->contacts_position == "peak")
+			config.detection.position_algorithm = Position::PEAK;
+		else if (this->contacts_position == "parabolic")
+			config.detection.position_algorithm = Position::PARABOLIC;
+		else
+			throw common::Error<Error::InvalidPositionAlgorithm> {};
+
 		const f64 nval_offset = this->contacts_neutral_value;
 
 		config.detection.neutral_value_offset = nval_offset / 255.0;
@@ -102,16 +277,34 @@ public:
 
 		const f64 diagonal = std::hypot(this->width, this->height);
 
-		config.validation.track_validity = true;
+		config.validation.track_validity = false;
 		config.validation.size_limits = Vector2<f64> {
 			this->contacts_size_min / diagonal,
 			this->contacts_size_max / diagonal,
 		};
+		config.validation.size_hysteresis = this->contacts_size_hysteresis / diagonal;
+		config.validation.intensity_frames = this->contacts_noise_gate_frames;
+
+		if (this->contacts_noise_gate) {
+			config.validation.intensity_deviation =
+				this->contacts_noise_gate_deviation / 255.0;
+		}
+
+		if (this->contacts_intensity_hysteresis) {
+			config.validation.intensity_thresholds = Vector2<f64> {
+				this->contacts_intensity_deactivation / 255.0,
+				this->contacts_intensity_activation / 255.0,
+			};
+		}
+
 		config.validation.aspect_limits = Vector2<f64> {
 			this->contacts_aspect_min,
 			this->contacts_aspect_max,
 		};
 
+		config.tracking.retain_frames = this->contacts_retain_frames;
+		config.tracking.retain_distance = this->contacts_retain_distance / diagonal;
+
 		config.stability.size_threshold = Vector2<f64> {
 			this->contacts_size_thresh_min / diagonal,
 			this->contacts_size_thresh_max / diagonal,
@@ -125,8 +318,238 @@ public:
 			this->contacts_orientation_thresh_max / 180,
 		};
 
+		if (this->contacts_pinch_smoothing)
+			config.stability.pinch_smoothing = this->contacts_pinch_smoothing_factor;
+
 		return config;
 	}
+
+	/*!
+	 * Collects the options of the stylus that can be replaced by a profile.
+	 *
+	 * @return A profile with the current options and without a name or serials.
+	 */
+	[[nodiscard]] StylusProfile stylus_profile() const
+	{
+		StylusProfile profile {};
+
+		profile.smoothing = this->stylus_smoothing;
+		profile.smoothing_factor = this->stylus_smoothing_factor;
+		profile.smoothing_speed_min = this->stylus_smoothing_speed_min;
+		profile.smoothing_speed_max = this->stylus_smoothing_speed_max;
+		profile.pressure_smoothing = this->stylus_pressure_smoothing;
+		profile.pressure_smoothing_factor = this->stylus_pressure_smoothing_factor;
+		profile.pressure_curve = this->stylus_pressure_curve;
+
+		return profile;
+	}
+
+	/*!
+	 * Replaces the options of the stylus with the ones of a profile.
+	 *
+	 * @param[in] profile The profile to apply.
+	 */
+	void apply(const StylusProfile &profile)
+	{
+		this->stylus_smoothing = profile.smoothing;
+		this->stylus_smoothing_factor = profile.smoothing_factor;
+		this->stylus_smoothing_speed_min = profile.smoothing_speed_min;
+		this->stylus_smoothing_speed_max = profile.smoothing_speed_max;
+		this->stylus_pressure_smoothing = profile.pressure_smoothing;
+		this->stylus_pressure_smoothing_factor = profile.pressure_smoothing_factor;
+		this->stylus_pressure_curve = profile.pressure_curve;
+	}
+
+	/*!
+	 * Serializes the configuration, grouped by the sections of the config file.
+	 *
+	 * @return A JSON object containing all options.
+	 */
+	[[nodiscard]] common::Json json() const
+	{
+		common::Json config {};
+		common::Json touchscreen {};
+		common::Json touchpad {};
+		common::Json tablet_mode {};
+		common::Json idle {};
+		common::Json contacts {};
+		common::Json stylus {};
+		common::Json dft {};
+
+		config.add("InvertX", this->invert_x)
+			.add("InvertY", this->invert_y)
+			.add("Width", this->width)
+			.add("Height", this->height)
+			.add("IgnoreMetadata", this->ignore_metadata)
+			.add("ParseErrors", this->parse_errors)
+			.add("SettleTime", this->settle_time)
+			.add("SettleFrames", this->settle_frames);
+
+		touchscreen.add("Disable", this->touchscreen_disable)
+			.add("DisableOnPalm", this->touchscreen_disable_on_palm)
+			.add("DisableOnStylus", this->touchscreen_disable_on_stylus)
+			.add("DisableOnStylusFirmware",
+			     this->touchscreen_disable_on_stylus_firmware)
+			.add("Overshoot", this->touchscreen_overshoot)
+			.add("DragThreshold", this->touchscreen_drag_threshold)
+			.add("Mode", this->touchscreen_mode)
+			.add("PointerSpeed", this->touchscreen_pointer_speed)
+			.add("PointerAcceleration", this->touchscreen_pointer_acceleration)
+			.add("OutputDevice", this->touchscreen_output_device)
+			.add("Protocol", this->touchscreen_protocol)
+			.add("OutOfRange", this->touchscreen_out_of_range)
+			.add("EmitWidth", this->touchscreen_emit_width)
+			.add("EmitPressure", this->touchscreen_emit_pressure)
+			.add("PressureCurve", this->touchscreen_pressure_curve)
+			.add("HardwareTimestamps", this->touchscreen_hardware_timestamps)
+			.add("ToggleDevice", this->touchscreen_toggle_device)
+			.add("ToggleKeys", this->touchscreen_toggle_keys);
+
+		touchpad.add("Disable", this->touchpad_disable)
+			.add("DisableOnPalm", this->touchpad_disable_on_palm)
+			.add("Overshoot", this->touchpad_overshoot)
+			.add("DragThreshold", this->touchpad_drag_threshold)
+			.add("CountDebounce", this->touchpad_count_debounce)
+			.add("OutputDevice", this->touchpad_output_device)
+			.add("Protocol", this->touchpad_protocol)
+			.add("OutOfRange", this->touchpad_out_of_range)
+			.add("EmitWidth", this->touchpad_emit_width)
+			.add("EmitPressure", this->touchpad_emit_pressure)
+			.add("PressureCurve", this->touchpad_pressure_curve)
+			.add("HardwareTimestamps", this->touchpad_hardware_timestamps);
+
+		tablet_mode.add("Device", this->tablet_mode_device)
+			.add("DisableOnPalm", this->tablet_mode_disable_on_palm)
+			.add("Overshoot", this->tablet_mode_overshoot);
+
+		idle.add("Inhibit", this->idle_inhibit)
+			.add("Interval", this->idle_interval);
+
+		contacts.add("Neutral", this->contacts_neutral)
+			.add("Blobs", this->contacts_blobs)
+			.add("Detection", this->contacts_detection)
+			.add("Position", this->contacts_position)
+			.add("NeutralValue", this->contacts_neutral_value)
+			.add("ActivationThreshold", this->contacts_activation_threshold)
+			.add("DeactivationThreshold", this->contacts_deactivation_threshold)
+			.add("SizeThresholdMin", this->contacts_size_thresh_min)
+			.add("SizeThresholdMax", this->contacts_size_thresh_max)
+			.add("PositionThresholdMin", this->contacts_position_thresh_min)
+			.add("PositionThresholdMax", this->contacts_position_thresh_max)
+			.add("OrientationThresholdMin", this->contacts_orientation_thresh_min)
+			.add("OrientationThresholdMax", this->contacts_orientation_thresh_max)
+			.add("SizeMin", this->contacts_size_min)
+			.add("SizeMax", this->contacts_size_max)
+			.add("SizeHysteresis", this->contacts_size_hysteresis)
+			.add("NoiseGate", this->contacts_noise_gate)
+			.add("NoiseGateDeviation", this->contacts_noise_gate_deviation)
+			.add("NoiseGateFrames", this->contacts_noise_gate_frames)
+			.add("IntensityHysteresis", this->contacts_intensity_hysteresis)
+			.add("IntensityActivation", this->contacts_intensity_activation)
+			.add("IntensityDeactivation", this->contacts_intensity_deactivation)
+			.add("RetainFrames", this->contacts_retain_frames)
+			.add("RetainDistance", this->contacts_retain_distance)
+			.add("PinchSmoothing", this->contacts_pinch_smoothing)
+			.add("PinchSmoothingFactor", this->contacts_pinch_smoothing_factor)
+			.add("AspectMin", this->contacts_aspect_min)
+			.add("AspectMax", this->contacts_aspect_max)
+			.add("HoldFrames", this->contacts_hold_frames)
+			.add("MaxContacts", this->contacts_max)
+			.add("StaleFrames", this->contacts_stale_frames)
+			.add("Polarity", this->contacts_polarity)
+			.add("HeatmapTranspose", this->contacts_heatmap_transpose)
+			.add("HeatmapFlipX", this->contacts_heatmap_flip_x)
+			.add("HeatmapFlipY", this->contacts_heatmap_flip_y)
+			.add("MaskCornerRadius", this->contacts_mask_corner_radius)
+			.add("ActiveArea", this->contacts_active_area)
+			.add("Baseline", this->contacts_baseline)
+			.add("BaselineRate", this->contacts_baseline_rate)
+			.add("AdaptiveDecimation", this->contacts_adaptive_decimation)
+			.add("AdaptiveDropRatio", this->contacts_adaptive_drop_ratio)
+			.add("AdaptiveMaxDecimation", this->contacts_adaptive_max_decimation)
+			.add("AutoThreshold", this->contacts_auto_threshold)
+			.add("AutoDeviations", this->contacts_auto_deviations)
+			.add("AutoStateFile", this->contacts_auto_state_file)
+			.add("LifetimeFile", this->contacts_lifetime_file);
+
+		stylus.add("Disable", this->stylus_disable)
+			.add("TipDistance", this->stylus_tip_distance)
+			.add("InstantLift", this->stylus_instant_lift)
+			.add("RubberAsPen", this->stylus_rubber_as_pen)
+			.add("RubberKey", this->stylus_rubber_key)
+			.add("SeparateRubber", this->stylus_separate_rubber)
+			.add("ArmRubber", this->stylus_arm_rubber)
+			.add("DropDuplicates", this->stylus_drop_duplicates)
+			.add("Smoothing", this->stylus_smoothing)
+			.add("SmoothingFactor", this->stylus_smoothing_factor)
+			.add("SmoothingSpeedMin", this->stylus_smoothing_speed_min)
+			.add("SmoothingSpeedMax", this->stylus_smoothing_speed_max)
+			.add("PressureSmoothing", this->stylus_pressure_smoothing)
+			.add("PressureSmoothingFactor", this->stylus_pressure_smoothing_factor)
+			.add("PressureInterpolation", this->stylus_pressure_interpolation)
+			.add("PressureLevels", this->stylus_pressure_levels)
+			.add("OutputDevice", this->stylus_output_device)
+			.add("ButtonOutOfProximity", this->stylus_button_out_of_proximity)
+			.add("OutOfRange", this->stylus_out_of_range)
+			.add("EmitErrors", this->stylus_emit_errors)
+			.add("MaxPressure", this->stylus_max_pressure)
+			.add("PressureCurve", this->stylus_pressure_curve)
+			.add("RubberPressureCurve", this->stylus_rubber_pressure_curve)
+			.add("DelayContact", this->stylus_delay_contact)
+			.add("Prediction", this->stylus_prediction)
+			.add("DoubleTap", this->stylus_double_tap)
+			.add("DoubleTapKey", this->stylus_double_tap_key)
+			.add("DoubleTapTimeout", this->stylus_double_tap_timeout)
+			.add("DoubleTapDistance", this->stylus_double_tap_distance)
+			.add("ButtonScroll", this->stylus_button_scroll)
+			.add("ButtonScrollSpeed", this->stylus_button_scroll_speed)
+			.add("SerialChurnThreshold", this->stylus_serial_churn_threshold)
+			.add("SerialChurnWindow", this->stylus_serial_churn_window)
+			.add("SerialChurnLock", this->stylus_serial_churn_lock)
+			.add("SerialChurnCooldown", this->stylus_serial_churn_cooldown)
+			.add("IgnoreRegions", this->stylus_ignore_regions)
+			.add("IgnoreRegionMode", this->stylus_ignore_region_mode)
+			.add("FuzzX", this->stylus_fuzz_x)
+			.add("FuzzY", this->stylus_fuzz_y)
+			.add("FuzzPressure", this->stylus_fuzz_pressure)
+			.add("FuzzTilt", this->stylus_fuzz_tilt)
+			.add("InvertTiltX", this->stylus_invert_tilt_x)
+			.add("InvertTiltY", this->stylus_invert_tilt_y)
+			.add("DisableTilt", this->stylus_disable_tilt)
+			.add("AutoTilt", this->stylus_auto_tilt)
+			.add("AutoTiltSamples", this->stylus_auto_tilt_samples)
+			.add("TiltInterval", this->stylus_tilt_interval)
+			.add("TiltThreshold", this->stylus_tilt_threshold)
+			.add("HardwareTimestamps", this->stylus_hardware_timestamps)
+			.add("ResampleRate", this->stylus_resample_rate)
+			.add("Remote", this->stylus_remote)
+			.add("RemoteToken", this->stylus_remote_token.empty() ? "" : "<hidden>")
+			.add("Profiles", this->stylus_profiles);
+
+		dft.add("PositionMinAmp", this->dft_position_min_amp)
+			.add("PositionMinMag", this->dft_position_min_mag)
+			.add("PositionExp", this->dft_position_exp)
+			.add("ButtonMinMag", this->dft_button_min_mag)
+			.add("FreqMinMag", this->dft_freq_min_mag)
+			.add("TiltMinMag", this->dft_tilt_min_mag)
+			.add("Mpp2ButtonMinMag", this->dft_mpp2_button_min_mag)
+			.add("Mpp2ContactMinMag", this->dft_mpp2_contact_min_mag)
+			.add("TiltDistance", this->dft_tilt_distance)
+			.add("AllowSplitEvents", this->dft_allow_split_events);
+
+		common::Json json {};
+
+		json.add("Config", config)
+			.add("Touchscreen", touchscreen)
+			.add("Touchpad", touchpad)
+			.add("TabletMode", tablet_mode)
+			.add("Idle", idle)
+			.add("Contacts", contacts)
+			.add("Stylus", stylus)
+			.add("DFT", dft);
+
+		return json;
+	}
 };
 
 } // namespace iptsd::core
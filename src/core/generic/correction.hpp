@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_CORRECTION_HPP
+#define IPTSD_CORE_GENERIC_CORRECTION_HPP
+
+#include "config.hpp"
+#include "errors.hpp"
+
+#include <common/error.hpp>
+#include <common/types.hpp>
+#include <ipts/samples/stylus.hpp>
+
+#include <cmath>
+#include <string>
+#include <utility>
+
+namespace iptsd::core {
+
+/*
+ * Corrects the stylus samples for the quirks of the firmware and of the stylus.
+ *
+ * The firmware reports some states that no real stylus can produce, and the direction of
+ * the tilt or the position of the transmitter differ between styli. The samples are
+ * corrected as they are received, before they are filtered.
+ */
+class StylusCorrection {
+private:
+	Config m_config;
+
+public:
+	StylusCorrection(Config config) : m_config {std::move(config)}
+	{
+		const std::string &policy = m_config.stylus_button_out_of_proximity;
+
+		if (policy != "pass" && policy != "ignore")
+			throw common::Error<Error::InvalidStylusButtonPolicy> {};
+	}
+
+	/*!
+	 * Corrects a stylus sample, as it was received from the device.
+	 *
+	 * @param[in,out] stylus The stylus sample.
+	 */
+	void apply(ipts::samples::Stylus &stylus) const
+	{
+		// A button press while the stylus is out of range is usually spurious.
+		if (!stylus.proximity && m_config.stylus_button_out_of_proximity == "ignore")
+			stylus.button = false;
+
+		// Some firmware keeps the other bits set in the last report, but out of range the
+		// stylus can't touch the screen.
+		if (!stylus.proximity) {
+			stylus.contact = false;
+			stylus.pressure = 0;
+		}
+
+		// Mirroring the direction of the stylus on one axis changes the azimuth.
+		if (m_config.stylus_invert_tilt_x)
+			stylus.azimuth = M_PI - stylus.azimuth;
+
+		if (m_config.stylus_invert_tilt_y)
+			stylus.azimuth = -stylus.azimuth;
+
+		stylus.azimuth = std::fmod(stylus.azimuth + (2 * M_PI), 2 * M_PI);
+
+		// Correct position based on tip-transmitter distance, in the corrected direction.
+		const Vector2<f64> off = this->calculate_offset(stylus.altitude, stylus.azimuth);
+		stylus.x += off.x();
+		stylus.y += off.y();
+	}
+
+private:
+	/*!
+	 * Calculates the tilt-based offset of the stylus position.
+	 *
+	 * Some styli have the transmitter a few millimeters above the tip of the pen.
+	 * This means that the more you tilt the pen, the more the reported position will
+	 * diverge from the position of the pen tip.
+	 *
+	 * If the distance between transmitter and pen tip is known, this offset can be
+	 * calculated and added to the reported position.
+	 *
+	 * @param[in] altitude The altitude of the stylus.
+	 * @param[in] azimuth The azimuth of the stylus.
+	 * @return A Vector containing the offset on the X and Y axis.
+	 */
+	[[nodiscard]] Vector2<f64> calculate_offset(const f64 altitude, const f64 azimuth) const
+	{
+		if (altitude <= 0)
+			return Vector2<f64>::Zero();
+
+		if (m_config.stylus_tip_distance == 0)
+			return Vector2<f64>::Zero();
+
+		const f64 offset = std::sin(altitude) * m_config.stylus_tip_distance;
+
+		const f64 ox = offset * -std::cos(azimuth);
+		const f64 oy = offset * std::sin(azimuth);
+
+		return Vector2<f64> {ox / m_config.width, oy / m_config.height};
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_CORRECTION_HPP
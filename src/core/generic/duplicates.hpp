@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_DUPLICATES_HPP
+#define IPTSD_CORE_GENERIC_DUPLICATES_HPP
+
+#include "config.hpp"
+
+#include <ipts/samples/stylus.hpp>
+
+#include <utility>
+
+namespace iptsd::core {
+
+/*
+ * Drops stylus samples that repeat the previous one, if enabled.
+ *
+ * Some firmware sends every sample twice, which doubles the number of events. Apart from
+ * their timestamp, both samples are the same.
+ */
+class DuplicateFilter {
+private:
+	Config m_config;
+
+	// The last stylus sample that was received, before it was processed.
+	ipts::samples::Stylus m_last {};
+
+public:
+	DuplicateFilter(Config config) : m_config {std::move(config)} {};
+
+	/*!
+	 * Registers a stylus sample, as it was received from the device.
+	 *
+	 * @param[in] stylus The stylus sample.
+	 * @return true if the sample repeats the previous one and should be dropped.
+	 */
+	bool drop(const ipts::samples::Stylus &stylus)
+	{
+		if (m_config.stylus_drop_duplicates && is_duplicate(stylus, m_last))
+			return true;
+
+		m_last = stylus;
+		return false;
+	}
+
+private:
+	/*!
+	 * Checks if a stylus sample repeats the previous one, apart from its timestamp.
+	 *
+	 * @param[in] a The current stylus sample.
+	 * @param[in] b The previous stylus sample.
+	 * @return true if both samples describe the same state of the same stylus.
+	 */
+	[[nodiscard]] static bool is_duplicate(const ipts::samples::Stylus &a,
+	                                       const ipts::samples::Stylus &b)
+	{
+		if (a.serial != b.serial || a.proximity != b.proximity || a.contact != b.contact)
+			return false;
+
+		if (a.button != b.button || a.rubber != b.rubber)
+			return false;
+
+		if (a.x != b.x || a.y != b.y || a.pressure != b.pressure)
+			return false;
+
+		return a.altitude == b.altitude && a.azimuth == b.azimuth;
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_DUPLICATES_HPP
@@ -12,6 +12,17 @@ namespace iptsd::core {
 enum class Error : u8 {
 	InvalidScreenSize,
 	InvalidNeutralValueAlgorithm,
+	InvalidBlobAlgorithm,
+	InvalidDetectionAlgorithm,
+	InvalidPositionAlgorithm,
+	InvalidTouchscreenMode,
+	InvalidHeatmapPolarity,
+	InvalidStylusButtonPolicy,
+	InvalidCornerRadius,
+	InvalidParseErrorPolicy,
+	InvalidStylusRegion,
+	InvalidStylusRegionMode,
+	InvalidActiveArea,
 };
 
 inline std::string format_as(Error err)
@@ -21,6 +32,28 @@ inline std::string format_as(Error err)
 		return "core: The screen size is 0! Is your device supported?";
 	case Error::InvalidNeutralValueAlgorithm:
 		return "core: The selected neutral value algorithm is invalid!";
+	case Error::InvalidBlobAlgorithm:
+		return "core: The selected blob algorithm is invalid!";
+	case Error::InvalidDetectionAlgorithm:
+		return "core: The selected detection algorithm is invalid!";
+	case Error::InvalidPositionAlgorithm:
+		return "core: The selected position algorithm is invalid!";
+	case Error::InvalidTouchscreenMode:
+		return "core: The selected touchscreen mode is invalid!";
+	case Error::InvalidHeatmapPolarity:
+		return "core: The selected heatmap polarity is invalid!";
+	case Error::InvalidStylusButtonPolicy:
+		return "core: The selected stylus button policy is invalid!";
+	case Error::InvalidCornerRadius:
+		return "core: The corner radius {} cm is not between 0 and {} cm!";
+	case Error::InvalidParseErrorPolicy:
+		return "core: The selected parse error policy is invalid!";
+	case Error::InvalidStylusRegion:
+		return "core: Invalid stylus region {}, expected regions like 0:0.95:1:1!";
+	case Error::InvalidStylusRegionMode:
+		return "core: The selected stylus region mode is invalid!";
+	case Error::InvalidActiveArea:
+		return "core: Invalid active area {}, expected an area like 0.02:0:0.98:1!";
 	default:
 		return "core: Invalid error code!";
 	}
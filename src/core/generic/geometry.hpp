@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_GEOMETRY_HPP
+#define IPTSD_CORE_GENERIC_GEOMETRY_HPP
+
+#include "config.hpp"
+#include "device.hpp"
+
+#include <common/casts.hpp>
+#include <common/json.hpp>
+#include <common/types.hpp>
+#include <ipts/protocol/stylus.hpp>
+
+#include <utility>
+
+namespace iptsd::core {
+
+/*
+ * Describes the heatmap and how its cells map to the screen.
+ *
+ * The dimensions describe the heatmap after it was transposed and flipped, like it is
+ * passed to the contact finder. The transforms map a position on the heatmap, in cells,
+ * to a position on the screen:
+ *
+ *   x = xx * column + xy * row + x0
+ *   y = yx * column + yy * row + y0
+ *
+ * The transform maps to centimeters, the digitizer transform maps to the units of the
+ * digitizer, which the input devices use too. The positions of the contacts in the
+ * event stream are normalized, they are the digitizer position divided by its size.
+ */
+class HeatmapGeometry {
+private:
+	Config m_config;
+	DeviceInfo m_info;
+
+public:
+	HeatmapGeometry(Config config, DeviceInfo info)
+		: m_config {std::move(config)},
+		  m_info {std::move(info)} {};
+
+	/*!
+	 * Serializes the geometry, for the state and the event stream.
+	 *
+	 * The dimensions are taken from the metadata of the device, or from the last heatmap.
+	 * They are 0 while no heatmap was received yet on devices without metadata.
+	 *
+	 * @param[in] last The last heatmap that was passed to the contact finder.
+	 * @return A JSON object describing the geometry of the heatmap.
+	 */
+	[[nodiscard]] common::Json json(const Image<f64> &last) const
+	{
+		Eigen::Index rows = last.rows();
+		Eigen::Index cols = last.cols();
+
+		if ((rows == 0 || cols == 0) && m_info.meta.has_value()) {
+			const bool transpose = m_config.contacts_heatmap_transpose;
+
+			const ipts::Metadata &meta = m_info.meta.value();
+
+			rows = casts::to_eigen(transpose ? meta.columns : meta.rows);
+			cols = casts::to_eigen(transpose ? meta.rows : meta.columns);
+		}
+
+		common::Json heatmap {};
+		heatmap.add("rows", rows)
+			.add("columns", cols)
+			.add("transpose", m_config.contacts_heatmap_transpose)
+			.add("flip_x", m_config.contacts_heatmap_flip_x)
+			.add("flip_y", m_config.contacts_heatmap_flip_y);
+
+		common::Json screen {};
+		screen.add("width", m_config.width).add("height", m_config.height);
+
+		const auto max_x = casts::to<f64>(ipts::protocol::stylus::MAX_X);
+		const auto max_y = casts::to<f64>(ipts::protocol::stylus::MAX_Y);
+
+		common::Json digitizer {};
+		digitizer.add("width", max_x).add("height", max_y);
+
+		common::Json out {};
+		out.add("type", "geometry")
+			.add("heatmap", heatmap)
+			.add("screen", screen)
+			.add("digitizer", digitizer);
+
+		if (rows == 0 || cols == 0)
+			return out;
+
+		const auto columns = casts::to<f64>(cols);
+		const auto lines = casts::to<f64>(rows);
+
+		return out.add("transform", this->transform(m_config.width / columns,
+		                                             m_config.height / lines,
+		                                             m_config.width,
+		                                             m_config.height))
+			.add("digitizer_transform",
+			     this->transform(max_x / columns, max_y / lines, max_x, max_y));
+	}
+
+private:
+	/*!
+	 * Describes how the cells of the heatmap map to a position on the screen.
+	 *
+	 * @param[in] xx The width of a cell.
+	 * @param[in] yy The height of a cell.
+	 * @param[in] width The width of the screen.
+	 * @param[in] height The height of the screen.
+	 * @return A JSON object with the coefficients of the transform.
+	 */
+	[[nodiscard]] common::Json
+	transform(const f64 xx, const f64 yy, const f64 width, const f64 height) const
+	{
+		common::Json out {};
+		out.add("xx", m_config.invert_x ? -xx : xx)
+			.add("xy", 0.0)
+			.add("x0", m_config.invert_x ? width : 0.0)
+			.add("yx", 0.0)
+			.add("yy", m_config.invert_y ? -yy : yy)
+			.add("y0", m_config.invert_y ? height : 0.0);
+
+		return out;
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_GEOMETRY_HPP
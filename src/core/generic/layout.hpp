@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_LAYOUT_HPP
+#define IPTSD_CORE_GENERIC_LAYOUT_HPP
+
+#include "config.hpp"
+
+#include <common/casts.hpp>
+#include <common/types.hpp>
+#include <ipts/samples/touch.hpp>
+
+#include <utility>
+
+namespace iptsd::core {
+
+/*
+ * Normalizes the heatmap and matches its layout to the coordinate system of the screen.
+ *
+ * Some devices send the heatmap with the rows and columns swapped, or mirrored on one axis.
+ * Contact detection expects the first row and column at the top left corner of the screen.
+ */
+class HeatmapLayout {
+private:
+	Config m_config;
+
+public:
+	HeatmapLayout(Config config) : m_config {std::move(config)} {};
+
+	/*!
+	 * Copies a heatmap from the device into a normalized buffer.
+	 *
+	 * @param[in] data The heatmap that was received from the device.
+	 * @param[out] heatmap The buffer for the heatmap, it is resized to match the layout.
+	 */
+	void apply(const ipts::samples::Touch &data, Image<f64> &heatmap) const
+	{
+		const Eigen::Index rows = casts::to_eigen(data.rows);
+		const Eigen::Index cols = casts::to_eigen(data.columns);
+
+		const bool transpose = m_config.contacts_heatmap_transpose;
+
+		const Eigen::Index target_rows = transpose ? cols : rows;
+		const Eigen::Index target_cols = transpose ? rows : cols;
+
+		// Make sure the heatmap buffer has the right size
+		if (heatmap.rows() != target_rows || heatmap.cols() != target_cols)
+			heatmap.conservativeResize(target_rows, target_cols);
+
+		// Map the buffer to an Eigen container
+		const Eigen::Map<const Image<u8>> mapped {data.heatmap.data(), rows, cols};
+
+		const auto min = casts::to<f64>(data.min);
+		const auto max = casts::to<f64>(data.max);
+
+		// Normalize the heatmap to range [0, 1]
+		if (transpose)
+			heatmap = (mapped.transpose().cast<f64>() - min) / (max - min);
+		else
+			heatmap = (mapped.cast<f64>() - min) / (max - min);
+
+		if (m_config.contacts_heatmap_flip_x)
+			heatmap.rowwise().reverseInPlace();
+
+		if (m_config.contacts_heatmap_flip_y)
+			heatmap.colwise().reverseInPlace();
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_LAYOUT_HPP
@@ -0,0 +1,265 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_LIFETIMES_HPP
+#define IPTSD_CORE_GENERIC_LIFETIMES_HPP
+
+#include "config.hpp"
+
+#include <common/casts.hpp>
+#include <common/chrono.hpp>
+#include <common/json.hpp>
+#include <common/types.hpp>
+#include <contacts/contact.hpp>
+
+#include <spdlog/spdlog.h>
+
+#include <algorithm>
+#include <array>
+#include <cmath>
+#include <filesystem>
+#include <fstream>
+#include <utility>
+#include <vector>
+
+namespace iptsd::core {
+
+/*
+ * Collects statistics about the contacts that were seen, from when they appear until they
+ * are lifted.
+ *
+ * This gives users numbers to base their thresholds on, instead of guessing them. Only
+ * histograms are kept, so the memory used doesn't grow and no traces of the inputs are
+ * stored. Optionally, every finished contact is appended to a CSV file.
+ */
+class ContactLifetimes {
+private:
+	// How many bins every histogram has. The last bin also counts all larger values.
+	constexpr static usize BINS = 32;
+
+	// How many contacts can be tracked at the same time. Higher indices are ignored.
+	constexpr static usize MAX_CONTACTS = 64;
+
+	/*
+	 * Counts values in bins of the same width.
+	 */
+	struct Histogram {
+		// The width of a bin.
+		f64 width = 1;
+
+		// How many values fell into each bin.
+		std::array<u64, BINS> counts {};
+
+		/*!
+		 * Counts a value.
+		 *
+		 * @param[in] value The value to count, negative values are counted as 0.
+		 */
+		void add(const f64 value)
+		{
+			const f64 bin = std::floor(std::max(value, 0.0) / this->width);
+			const f64 last = casts::to<f64>(BINS - 1);
+
+			this->counts.at(casts::to<usize>(std::min(last, bin)))++;
+		}
+
+		[[nodiscard]] common::Json json() const
+		{
+			const std::vector<u64> counts {this->counts.begin(), this->counts.end()};
+
+			common::Json out {};
+			out.add("width", this->width).add("counts", counts);
+
+			return out;
+		}
+	};
+
+	/*
+	 * The values of a contact that is currently on the screen.
+	 */
+	struct Lifetime {
+		// Whether a contact with this index exists.
+		bool active = false;
+
+		// Whether the contact was part of the current frame.
+		bool seen = false;
+
+		// When the contact appeared.
+		chrono::steady_clock::time_point start {};
+
+		// In how many frames the contact was seen.
+		usize frames = 0;
+
+		// The largest area of the contact, in square centimeters.
+		f64 area = 0;
+
+		// The highest intensity of the contact.
+		f64 intensity = 0;
+
+		// How far the contact has moved, in centimeters.
+		f64 path = 0;
+
+		// The last position of the contact, normalized to the size of the screen.
+		Vector2<f64> position = Vector2<f64>::Zero();
+
+		// Whether the contact was rejected in any frame, e.g. because it is a palm.
+		bool rejected = false;
+	};
+
+private:
+	Config m_config;
+
+	// The contacts that are currently on the screen, by their index.
+	std::array<Lifetime, MAX_CONTACTS> m_lifetimes {};
+
+	// How many frames the contacts were seen in.
+	Histogram m_frames {1};
+
+	// How long the contacts were on the screen, in seconds.
+	Histogram m_duration {0.1};
+
+	// The largest area of the contacts, in square centimeters.
+	Histogram m_area {0.5};
+
+	// The highest intensity of the contacts.
+	Histogram m_intensity {1.0 / BINS};
+
+	// How far the contacts moved, in centimeters.
+	Histogram m_path {0.5};
+
+	// How many finished contacts were accepted.
+	u64 m_accepted = 0;
+
+	// How many finished contacts were rejected.
+	u64 m_rejected = 0;
+
+	// The file that finished contacts are appended to, if enabled.
+	std::ofstream m_file {};
+
+public:
+	ContactLifetimes(Config config) : m_config {std::move(config)}
+	{
+		const std::string &path = m_config.contacts_lifetime_file;
+
+		if (path.empty())
+			return;
+
+		const bool exists = std::filesystem::exists(path);
+		m_file.open(path, std::ios::out | std::ios::app);
+
+		if (!m_file) {
+			spdlog::warn("Failed to open {}", path);
+			return;
+		}
+
+		if (!exists)
+			m_file << "frames,duration,area,intensity,path,classification\n";
+	};
+
+	/*!
+	 * Updates the statistics with the contacts of a frame.
+	 *
+	 * Contacts that are not part of the frame anymore are finished and counted.
+	 *
+	 * @param[in] contacts The contacts of the frame, normalized and with their index.
+	 */
+	void update(const std::vector<contacts::Contact<f64>> &contacts)
+	{
+		const auto now = chrono::steady_clock::now();
+		const f64 diagonal = std::hypot(m_config.width, m_config.height);
+
+		for (Lifetime &lifetime : m_lifetimes)
+			lifetime.seen = false;
+
+		for (const contacts::Contact<f64> &contact : contacts) {
+			if (!contact.index.has_value() || contact.index.value() >= MAX_CONTACTS)
+				continue;
+
+			Lifetime &lifetime = m_lifetimes.at(contact.index.value());
+
+			if (!lifetime.active) {
+				lifetime = Lifetime {};
+				lifetime.active = true;
+				lifetime.start = now;
+				lifetime.position = contact.mean;
+			}
+
+			const Vector2<f64> delta = contact.mean - lifetime.position;
+
+			// The size of the contact is normalized to the diagonal of the screen.
+			const f64 major = contact.size.x() * diagonal;
+			const f64 minor = contact.size.y() * diagonal;
+
+			lifetime.seen = true;
+			lifetime.frames++;
+			lifetime.area = std::max(lifetime.area, M_PI / 4 * major * minor);
+			lifetime.intensity = std::max(lifetime.intensity, contact.intensity);
+			lifetime.path += std::hypot(delta.x() * m_config.width,
+			                            delta.y() * m_config.height);
+			lifetime.position = contact.mean;
+			lifetime.rejected = lifetime.rejected || contact.rejected();
+		}
+
+		for (Lifetime &lifetime : m_lifetimes) {
+			if (!lifetime.active || lifetime.seen)
+				continue;
+
+			this->finish(lifetime, now);
+			lifetime.active = false;
+		}
+	}
+
+	/*!
+	 * The distribution of the values of all finished contacts.
+	 *
+	 * @return A JSON object with a histogram for every value.
+	 */
+	[[nodiscard]] common::Json json() const
+	{
+		common::Json out {};
+		out.add("accepted", m_accepted)
+			.add("rejected", m_rejected)
+			.add("frames", m_frames.json())
+			.add("duration", m_duration.json())
+			.add("area", m_area.json())
+			.add("intensity", m_intensity.json())
+			.add("path", m_path.json());
+
+		return out;
+	}
+
+private:
+	/*!
+	 * Counts a contact that was lifted.
+	 *
+	 * @param[in] lifetime The values of the contact.
+	 * @param[in] now The time of the frame where the contact was not seen anymore.
+	 */
+	void finish(const Lifetime &lifetime, const chrono::steady_clock::time_point now)
+	{
+		const seconds<f64> duration = now - lifetime.start;
+
+		m_frames.add(casts::to<f64>(lifetime.frames));
+		m_duration.add(duration.count());
+		m_area.add(lifetime.area);
+		m_intensity.add(lifetime.intensity);
+		m_path.add(lifetime.path);
+
+		if (lifetime.rejected)
+			m_rejected++;
+		else
+			m_accepted++;
+
+		if (!m_file.is_open())
+			return;
+
+		m_file << lifetime.frames << ',' << duration.count() << ',' << lifetime.area << ','
+		       << lifetime.intensity << ',' << lifetime.path << ','
+		       << (lifetime.rejected ? "rejected" : "accepted") << '\n';
+
+		m_file.flush();
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_LIFETIMES_HPP
@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_LOAD_HPP
+#define IPTSD_CORE_GENERIC_LOAD_HPP
+
+#include "config.hpp"
+
+#include <common/casts.hpp>
+#include <common/chrono.hpp>
+#include <common/types.hpp>
+
+#include <spdlog/spdlog.h>
+
+#include <algorithm>
+#include <optional>
+#include <utility>
+
+namespace iptsd::core {
+
+/*
+ * Reduces the amount of processed heatmaps while buffers are lost because of overload.
+ *
+ * If the device writes buffers faster than they can be processed for a sustained period,
+ * the input starts to lag behind. Skipping heatmaps frees enough time to catch up again.
+ * Once no buffers are lost anymore, the skipping is reduced step by step.
+ */
+class LoadMonitor {
+private:
+	// The length of the window in which lost buffers are counted.
+	constexpr static auto WINDOW = 1s;
+
+	// For how many windows in a row buffers must be lost before reducing the load.
+	constexpr static usize SUSTAIN_WINDOWS = 3;
+
+	// For how many windows in a row no buffers must be lost before restoring the load.
+	constexpr static usize RECOVER_WINDOWS = 5;
+
+	Config m_config;
+
+	// When the current window was started.
+	std::optional<chrono::steady_clock::time_point> m_start = std::nullopt;
+
+	// How many buffers were processed and lost in the current window.
+	u64 m_processed = 0;
+	u64 m_dropped = 0;
+
+	// For how many windows in a row the device was overloaded or not.
+	usize m_overloaded = 0;
+	usize m_idle = 0;
+
+	// Only every n-th heatmap is processed.
+	usize m_decimation = 1;
+
+	// Counts the heatmaps, to select which ones are processed.
+	usize m_counter = 0;
+
+public:
+	LoadMonitor(Config config) : m_config {std::move(config)} {};
+
+	/*!
+	 * Registers a buffer that was processed.
+	 */
+	void processed()
+	{
+		m_processed++;
+		this->update();
+	}
+
+	/*!
+	 * Registers buffers that were lost.
+	 *
+	 * @param[in] gap How many buffers were lost.
+	 */
+	void dropped(const u32 gap)
+	{
+		m_dropped += gap;
+		this->update();
+	}
+
+	/*!
+	 * Whether the next heatmap should be skipped to reduce the load.
+	 *
+	 * @return true if the heatmap should not be processed.
+	 */
+	[[nodiscard]] bool skip()
+	{
+		if (m_decimation <= 1)
+			return false;
+
+		m_counter = (m_counter + 1) % m_decimation;
+		return m_counter != 0;
+	}
+
+	/*!
+	 * How many heatmaps are skipped.
+	 *
+	 * @return Only every n-th heatmap is processed.
+	 */
+	[[nodiscard]] usize decimation() const
+	{
+		return m_decimation;
+	}
+
+private:
+	/*!
+	 * Evaluates the current window, once it is over.
+	 */
+	void update()
+	{
+		if (!m_config.contacts_adaptive_decimation)
+			return;
+
+		const auto now = chrono::steady_clock::now();
+
+		if (!m_start.has_value())
+			m_start = now;
+
+		if (now - m_start.value() < WINDOW)
+			return;
+
+		const u64 total = m_processed + m_dropped;
+		const f64 ratio = total > 0 ? casts::to<f64>(m_dropped) / casts::to<f64>(total) : 0;
+
+		m_start = now;
+		m_processed = 0;
+		m_dropped = 0;
+
+		if (ratio >= m_config.contacts_adaptive_drop_ratio) {
+			m_idle = 0;
+			m_overloaded++;
+		} else if (ratio == 0) {
+			m_overloaded = 0;
+			m_idle++;
+		} else {
+			m_idle = 0;
+			m_overloaded = 0;
+		}
+
+		const usize max = std::max<usize>(m_config.contacts_adaptive_max_decimation, 1);
+
+		if (m_overloaded >= SUSTAIN_WINDOWS && m_decimation < max) {
+			m_decimation = std::min(m_decimation * 2, max);
+			m_overloaded = 0;
+
+			spdlog::warn("Overloaded, processing 1 of {} heatmaps", m_decimation);
+		}
+
+		if (m_idle >= RECOVER_WINDOWS && m_decimation > 1) {
+			m_decimation /= 2;
+			m_idle = 0;
+
+			if (m_decimation > 1)
+				spdlog::info("Load decreased, processing 1 of {} heatmaps",
+				             m_decimation);
+			else
+				spdlog::info("Load decreased, processing all heatmaps again");
+		}
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_LOAD_HPP
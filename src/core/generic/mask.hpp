@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_MASK_HPP
+#define IPTSD_CORE_GENERIC_MASK_HPP
+
+#include "config.hpp"
+#include "errors.hpp"
+
+#include <common/casts.hpp>
+#include <common/error.hpp>
+#include <common/types.hpp>
+
+#include <algorithm>
+#include <utility>
+
+namespace iptsd::core {
+
+/*
+ * Removes the parts of the heatmap that are outside of the active area of the screen.
+ *
+ * Screens with rounded corners still report the cells in the corners of the heatmap,
+ * but they can only contain noise. Masked cells are cleared before contacts are searched.
+ */
+class HeatmapMask {
+private:
+	Config m_config;
+
+	// The mask for the current heatmap size. Active cells are 1, masked cells are 0.
+	Image<f64> m_mask {};
+
+public:
+	HeatmapMask(Config config) : m_config {std::move(config)}
+	{
+		const f64 radius = m_config.contacts_mask_corner_radius;
+		const f64 limit = std::min(m_config.width, m_config.height) / 2;
+
+		if (radius < 0 || radius > limit)
+			throw common::Error<Error::InvalidCornerRadius> {radius, limit};
+	};
+
+	/*!
+	 * Clears all cells of a heatmap that are outside of the active area.
+	 *
+	 * @param[in,out] heatmap The normalized heatmap, with contacts raising the values.
+	 */
+	void apply(Image<f64> &heatmap)
+	{
+		if (m_config.contacts_mask_corner_radius == 0)
+			return;
+
+		// The mask has to be regenerated when the size of the heatmap changes.
+		if (m_mask.rows() != heatmap.rows() || m_mask.cols() != heatmap.cols())
+			this->build(heatmap.rows(), heatmap.cols());
+
+		heatmap *= m_mask;
+	}
+
+private:
+	/*!
+	 * Generates the mask for a heatmap size.
+	 *
+	 * A cell is masked if its center is outside of the rounded corners of the screen.
+	 *
+	 * @param[in] rows The number of rows of the heatmap.
+	 * @param[in] cols The number of columns of the heatmap.
+	 */
+	void build(const Eigen::Index rows, const Eigen::Index cols)
+	{
+		const f64 radius = m_config.contacts_mask_corner_radius;
+
+		const f64 width = casts::to<f64>(cols);
+		const f64 height = casts::to<f64>(rows);
+
+		// The radius of the corners, in cells.
+		const f64 rx = radius / m_config.width * width;
+		const f64 ry = radius / m_config.height * height;
+
+		m_mask.conservativeResize(rows, cols);
+
+		for (Eigen::Index y = 0; y < rows; y++) {
+			for (Eigen::Index x = 0; x < cols; x++) {
+				const f64 px = casts::to<f64>(x) + 0.5;
+				const f64 py = casts::to<f64>(y) + 0.5;
+
+				// The center of the closest corner, or the cell itself.
+				const f64 cx = std::clamp(px, rx, width - rx);
+				const f64 cy = std::clamp(py, ry, height - ry);
+
+				const f64 dx = (px - cx) / rx;
+				const f64 dy = (py - cy) / ry;
+
+				m_mask(y, x) = (dx * dx) + (dy * dy) > 1 ? 0 : 1;
+			}
+		}
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_MASK_HPP
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_MISSING_HPP
+#define IPTSD_CORE_GENERIC_MISSING_HPP
+
+#include "config.hpp"
+
+#include <common/casts.hpp>
+#include <common/chrono.hpp>
+#include <common/types.hpp>
+
+#include <algorithm>
+#include <cmath>
+#include <optional>
+#include <utility>
+
+namespace iptsd::core {
+
+/*
+ * Decides how long contacts are held while no usable touch frame arrives.
+ *
+ * A missing frame is not the same as a frame without contacts, so the contacts are held
+ * at their last position for a few frames. If no usable frame arrives in that time,
+ * all contacts have to be lifted.
+ */
+class MissingFrames {
+private:
+	Config m_config;
+
+	// How many buffers in a row could not be parsed since the last touch frame.
+	usize m_frames = 0;
+
+	// When the last touch frame was processed.
+	std::optional<chrono::steady_clock::time_point> m_last = std::nullopt;
+
+public:
+	MissingFrames(Config config) : m_config {std::move(config)} {};
+
+	/*!
+	 * Registers a touch frame that was received.
+	 */
+	void received()
+	{
+		m_frames = 0;
+		m_last = chrono::steady_clock::now();
+	}
+
+	/*!
+	 * Registers a buffer that could not be parsed.
+	 *
+	 * If errors are passed on, the device is not read for a moment after every error.
+	 * The frames that the device sent in that time are missing too, so once its rate is
+	 * known, they are counted as well.
+	 *
+	 * @param[in] rate How many buffers the device sends per second, if it is known.
+	 * @return true if more frames are missing than contacts can be held for.
+	 */
+	bool missed(const std::optional<f64> rate)
+	{
+		m_frames++;
+
+		usize missing = m_frames;
+
+		if (m_last.has_value() && rate.has_value()) {
+			const auto now = chrono::steady_clock::now();
+			const seconds<f64> elapsed = now - m_last.value();
+			const f64 frames = std::floor(elapsed.count() * rate.value());
+
+			missing = std::max(missing, casts::to<usize>(frames));
+		}
+
+		return missing > m_config.contacts_hold_frames;
+	}
+
+	/*!
+	 * How many buffers in a row could not be parsed since the last touch frame.
+	 *
+	 * @return The number of buffers that could not be parsed.
+	 */
+	[[nodiscard]] usize frames() const
+	{
+		return m_frames;
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_MISSING_HPP
@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_PRESSURE_HPP
+#define IPTSD_CORE_GENERIC_PRESSURE_HPP
+
+#include "config.hpp"
+
+#include <common/casts.hpp>
+#include <common/types.hpp>
+#include <ipts/samples/stylus.hpp>
+
+#include <algorithm>
+#include <cmath>
+#include <optional>
+#include <utility>
+
+namespace iptsd::core {
+
+/*
+ * Smoothes the pressure of the stylus, independently from its position.
+ *
+ * The filter starts over with every stroke, so that the pressure doesn't fade in when the tip
+ * touches the screen, or fade out when it is lifted.
+ */
+class PressureSmoothing {
+private:
+	Config m_config;
+
+	// The last filtered pressure, if the tip is on the screen.
+	std::optional<f64> m_pressure = std::nullopt;
+
+	// The serial number of the stylus that the filtered pressure belongs to.
+	u32 m_serial = 0;
+
+public:
+	PressureSmoothing(Config config) : m_config {std::move(config)} {};
+
+	/*!
+	 * Smoothes the pressure of a stylus sample.
+	 *
+	 * @param[in,out] stylus The stylus sample to smooth.
+	 */
+	void filter(ipts::samples::Stylus &stylus)
+	{
+		// The pressure of one stylus must not be mixed with the pressure of another one.
+		if (!stylus.proximity || !stylus.contact || stylus.serial != m_serial)
+			this->reset();
+
+		m_serial = stylus.serial;
+
+		if (!stylus.contact)
+			return;
+
+		if (!m_pressure.has_value()) {
+			m_pressure = stylus.pressure;
+			return;
+		}
+
+		const f64 alpha = std::clamp(m_config.stylus_pressure_smoothing_factor, 0.0, 1.0);
+		m_pressure = m_pressure.value() + (alpha * (stylus.pressure - m_pressure.value()));
+
+		stylus.pressure = m_pressure.value();
+	}
+
+	/*!
+	 * Whether the filter is currently following the pressure.
+	 *
+	 * @return true if previous samples are influencing the pressure.
+	 */
+	[[nodiscard]] bool active() const
+	{
+		return m_pressure.has_value();
+	}
+
+	/*!
+	 * Forgets the previous samples, e.g. because the stylus was lifted.
+	 */
+	void reset()
+	{
+		m_pressure = std::nullopt;
+	}
+};
+
+/*
+ * Fills the steps between the pressure levels that the stylus can report.
+ *
+ * Styli with few pressure levels (e.g. 1024 with MaxPressure 4096) make slow changes of the
+ * pressure look like a staircase. The pressure keeps moving with the speed at which it
+ * changed between the last two levels, for at most half a level. This needs no future
+ * samples, so it adds no latency, and the error is less than half a level.
+ */
+class PressureInterpolation {
+private:
+	Config m_config;
+
+	// The pressure that was reported by the stylus for the last sample.
+	std::optional<f64> m_raw = std::nullopt;
+
+	// How much the pressure changed per sample between the last two levels.
+	f64 m_slope = 0;
+
+	// How many samples passed since the reported pressure changed.
+	usize m_since = 0;
+
+	// The serial number of the stylus that the pressure belongs to.
+	u32 m_serial = 0;
+
+public:
+	PressureInterpolation(Config config) : m_config {std::move(config)} {};
+
+	/*!
+	 * Interpolates the pressure of a stylus sample.
+	 *
+	 * @param[in,out] stylus The stylus sample.
+	 */
+	void filter(ipts::samples::Stylus &stylus)
+	{
+		if (!stylus.proximity || !stylus.contact || stylus.serial != m_serial)
+			this->reset();
+
+		m_serial = stylus.serial;
+
+		if (!stylus.contact)
+			return;
+
+		// Without a configured number, the levels of the report are used.
+		const u32 levels = m_config.stylus_pressure_levels != 0
+		                           ? m_config.stylus_pressure_levels
+		                           : stylus.pressure_levels;
+
+		// Pressure that is not quantized has no steps that could be filled.
+		if (levels == 0)
+			return;
+
+		const f64 raw = stylus.pressure;
+		const f64 step = 1.0 / casts::to<f64>(levels);
+
+		if (!m_raw.has_value()) {
+			m_raw = raw;
+			return;
+		}
+
+		m_since++;
+
+		if (raw != m_raw.value()) {
+			const f64 change = raw - m_raw.value();
+			const bool neighbour = std::abs(change) <= step * 1.5;
+
+			// Only neighbouring levels describe a slow change, faster ones are passed.
+			m_slope = neighbour ? change / casts::to<f64>(m_since) : 0;
+
+			m_raw = raw;
+			m_since = 0;
+			return;
+		}
+
+		// Within half a level of the raw pressure, the error stays below the rounding.
+		const f64 limit = step / 2;
+		const f64 offset = std::clamp(m_slope * casts::to<f64>(m_since), -limit, limit);
+
+		stylus.pressure = std::clamp(raw + offset, 0.0, 1.0);
+	}
+
+	/*!
+	 * Forgets the previous samples, e.g. because the stylus was lifted.
+	 */
+	void reset()
+	{
+		m_raw = std::nullopt;
+		m_slope = 0;
+		m_since = 0;
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_PRESSURE_HPP
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_PROFILES_HPP
+#define IPTSD_CORE_GENERIC_PROFILES_HPP
+
+#include "config.hpp"
+
+#include <common/types.hpp>
+
+#include <spdlog/spdlog.h>
+
+#include <algorithm>
+#include <optional>
+#include <set>
+#include <string>
+#include <vector>
+
+namespace iptsd::core {
+
+/*
+ * Selects the stylus options by the serial number of the stylus that is used.
+ *
+ * Styli without a profile use the options from [Stylus]. A profile only has to be applied
+ * when a different stylus starts reporting, and the options of the new stylus differ from
+ * the ones that are in use.
+ */
+class StylusProfiles {
+private:
+	// The profiles that were configured, with the serial numbers they apply to.
+	std::vector<StylusProfile> m_profiles;
+
+	// The stylus options from [Stylus], for styli that don't have a profile.
+	StylusProfile m_default;
+
+	// The name of the profile that is in use. Empty if the default options are used.
+	std::string m_name {};
+
+	// The serial number of the stylus that the profile was selected for.
+	u32 m_serial = 0;
+
+	// The styli whose profile was already logged.
+	std::set<u32> m_logged {};
+
+public:
+	StylusProfiles(const Config &config)
+		: m_profiles {config.profiles},
+		  m_default {config.stylus_profile()} {};
+
+	/*!
+	 * Registers the serial number of a stylus sample.
+	 *
+	 * The profile of every stylus is logged the first time the stylus is used.
+	 *
+	 * @param[in] serial The serial number of the stylus.
+	 * @return The profile that should be applied, if it differs from the one in use.
+	 */
+	std::optional<StylusProfile> select(const u32 serial)
+	{
+		if (m_profiles.empty() || serial == 0 || serial == m_serial)
+			return std::nullopt;
+
+		m_serial = serial;
+
+		const auto it = std::find_if(m_profiles.cbegin(),
+		                             m_profiles.cend(),
+		                             [&](const StylusProfile &profile) {
+			                             const auto &serials = profile.serials;
+			                             return std::find(serials.cbegin(),
+			                                              serials.cend(),
+			                                              serial) != serials.cend();
+		                             });
+
+		const StylusProfile &profile = it != m_profiles.cend() ? *it : m_default;
+
+		const bool first = m_logged.insert(serial).second;
+
+		if (first && profile.name.empty())
+			spdlog::info("Stylus {:08X} has no profile, using [Stylus]", serial);
+		else if (first)
+			spdlog::info("Stylus {:08X} uses profile {}", serial, profile.name);
+
+		if (profile.name == m_name)
+			return std::nullopt;
+
+		m_name = profile.name;
+		return profile;
+	}
+
+	/*!
+	 * The name of the profile that is in use.
+	 *
+	 * @return The name of the profile, or "default" if the options from [Stylus] are used.
+	 */
+	[[nodiscard]] std::string name() const
+	{
+		return m_name.empty() ? "default" : m_name;
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_PROFILES_HPP
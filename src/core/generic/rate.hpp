@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_RATE_HPP
+#define IPTSD_CORE_GENERIC_RATE_HPP
+
+#include <common/casts.hpp>
+#include <common/chrono.hpp>
+#include <common/types.hpp>
+
+#include <spdlog/spdlog.h>
+
+#include <algorithm>
+#include <optional>
+#include <vector>
+
+namespace iptsd::core {
+
+/*
+ * Measures how often the device sends buffers.
+ *
+ * The metadata of the device doesn't contain the report rate, so it is estimated from the
+ * intervals between the first buffers. Devices only send data while the screen is touched,
+ * so long intervals are pauses and not counted. The median of the remaining intervals
+ * is used, because single buffers can be delayed by the scheduler.
+ */
+class RateEstimator {
+private:
+	// How many intervals are measured before the rate is estimated.
+	constexpr static usize SAMPLES = 100;
+
+	// Intervals above this are pauses between two inputs.
+	constexpr static auto MAX_INTERVAL = 100ms;
+
+	// When the last buffer was received.
+	std::optional<chrono::steady_clock::time_point> m_last = std::nullopt;
+
+	// The intervals that were measured so far, in seconds.
+	std::vector<f64> m_intervals {};
+
+	// The estimated rate, in buffers per second.
+	std::optional<f64> m_rate = std::nullopt;
+
+public:
+	/*!
+	 * Registers that a buffer was received.
+	 */
+	void input()
+	{
+		if (m_rate.has_value())
+			return;
+
+		const auto now = chrono::steady_clock::now();
+
+		if (m_last.has_value() && now - m_last.value() <= MAX_INTERVAL)
+			m_intervals.push_back(seconds<f64> {now - m_last.value()}.count());
+
+		m_last = now;
+
+		if (m_intervals.size() < SAMPLES)
+			return;
+
+		const auto middle = m_intervals.begin() + casts::to_signed(m_intervals.size() / 2);
+		std::nth_element(m_intervals.begin(), middle, m_intervals.end());
+
+		// Buffers that were received at the same time don't say anything about the rate.
+		if (*middle > 0)
+			m_rate = 1.0 / *middle;
+
+		m_intervals.clear();
+		m_intervals.shrink_to_fit();
+
+		if (m_rate.has_value())
+			spdlog::info("Device is sending {:.1f} buffers per second", m_rate.value());
+	}
+
+	/*!
+	 * The estimated rate of the device.
+	 *
+	 * @return How many buffers the device sends per second, once enough were received.
+	 */
+	[[nodiscard]] std::optional<f64> rate() const
+	{
+		return m_rate;
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_RATE_HPP
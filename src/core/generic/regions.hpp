@@ -0,0 +1,194 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_REGIONS_HPP
+#define IPTSD_CORE_GENERIC_REGIONS_HPP
+
+#include "config.hpp"
+#include "errors.hpp"
+
+#include <common/error.hpp>
+#include <common/types.hpp>
+#include <ipts/samples/stylus.hpp>
+
+#include <algorithm>
+#include <array>
+#include <exception>
+#include <limits>
+#include <sstream>
+#include <string>
+#include <utility>
+#include <vector>
+
+namespace iptsd::core {
+
+/*
+ * Suppresses stylus inputs inside of configurable areas of the screen.
+ *
+ * Some areas (e.g. the edges of the screen, or a taskbar that is touched often) can cause
+ * the stylus to misbehave. Inside of these areas, the stylus is either lifted, or held at
+ * the closest edge of the area.
+ */
+class StylusRegions {
+private:
+	struct Region {
+		// The upper left corner of the region, normalized to the size of the screen.
+		Vector2<f64> min = Vector2<f64>::Zero();
+
+		// The lower right corner of the region, normalized to the size of the screen.
+		Vector2<f64> max = Vector2<f64>::Zero();
+	};
+
+private:
+	Config m_config;
+
+	// The areas where the stylus is suppressed.
+	std::vector<Region> m_regions {};
+
+	// Whether the stylus is held at the edge of a region instead of being lifted.
+	bool m_clamp = false;
+
+public:
+	StylusRegions(Config config) : m_config {std::move(config)}
+	{
+		const std::string &mode = m_config.stylus_ignore_region_mode;
+
+		if (mode == "clamp")
+			m_clamp = true;
+		else if (mode != "lift")
+			throw common::Error<Error::InvalidStylusRegionMode> {};
+
+		const std::string &regions = m_config.stylus_ignore_regions;
+
+		std::istringstream stream {regions};
+		std::string region {};
+
+		while (std::getline(stream, region, ','))
+			m_regions.push_back(parse(regions, region));
+	};
+
+	/*!
+	 * Whether any regions are configured.
+	 *
+	 * @return true if stylus inputs can be suppressed.
+	 */
+	[[nodiscard]] bool active() const
+	{
+		return !m_regions.empty();
+	}
+
+	/*!
+	 * Suppresses a stylus sample if it is inside of a region.
+	 *
+	 * @param[in,out] stylus The stylus sample, with its corrected position.
+	 */
+	void filter(ipts::samples::Stylus &stylus) const
+	{
+		if (!stylus.proximity)
+			return;
+
+		for (const Region &region : m_regions) {
+			if (!contains(region, stylus))
+				continue;
+
+			if (m_clamp) {
+				clamp(region, stylus);
+				continue;
+			}
+
+			stylus.proximity = false;
+			stylus.contact = false;
+			stylus.button = false;
+			stylus.rubber = false;
+			stylus.pressure = 0;
+
+			return;
+		}
+	}
+
+private:
+	/*!
+	 * Parses a single region like "0:0.95:1:1".
+	 *
+	 * @param[in] regions All configured regions, for the error message.
+	 * @param[in] region The region to parse.
+	 * @return The parsed region.
+	 */
+	static Region parse(const std::string &regions, const std::string &region)
+	{
+		std::array<f64, 4> values {};
+
+		std::istringstream stream {region};
+		std::string value {};
+
+		usize count = 0;
+
+		while (std::getline(stream, value, ':')) {
+			if (count >= values.size())
+				throw common::Error<Error::InvalidStylusRegion> {regions};
+
+			try {
+				values.at(count++) = std::stod(value);
+			} catch (const std::logic_error & /* unused */) {
+				throw common::Error<Error::InvalidStylusRegion> {regions};
+			}
+		}
+
+		if (count != values.size())
+			throw common::Error<Error::InvalidStylusRegion> {regions};
+
+		Region parsed {};
+		parsed.min = Vector2<f64> {values[0], values[1]};
+		parsed.max = Vector2<f64> {values[2], values[3]};
+
+		if (parsed.min.minCoeff() < 0 || parsed.max.maxCoeff() > 1)
+			throw common::Error<Error::InvalidStylusRegion> {regions};
+
+		if (parsed.min.x() >= parsed.max.x() || parsed.min.y() >= parsed.max.y())
+			throw common::Error<Error::InvalidStylusRegion> {regions};
+
+		return parsed;
+	}
+
+	static bool contains(const Region &region, const ipts::samples::Stylus &stylus)
+	{
+		if (stylus.x < region.min.x() || stylus.x > region.max.x())
+			return false;
+
+		return stylus.y >= region.min.y() && stylus.y <= region.max.y();
+	}
+
+	/*!
+	 * Moves a stylus sample inside of a region to the closest edge of the region.
+	 *
+	 * @param[in] region The region that contains the stylus.
+	 * @param[in,out] stylus The stylus sample.
+	 */
+	static void clamp(const Region &region, ipts::samples::Stylus &stylus)
+	{
+		// Edges of the region that are also edges of the screen can't be left.
+		const f64 none = std::numeric_limits<f64>::infinity();
+
+		const f64 left = region.min.x() > 0 ? stylus.x - region.min.x() : none;
+		const f64 right = region.max.x() < 1 ? region.max.x() - stylus.x : none;
+		const f64 top = region.min.y() > 0 ? stylus.y - region.min.y() : none;
+		const f64 bottom = region.max.y() < 1 ? region.max.y() - stylus.y : none;
+
+		const f64 closest = std::min({left, right, top, bottom});
+
+		if (closest == none)
+			return;
+
+		if (closest == left)
+			stylus.x = region.min.x();
+		else if (closest == right)
+			stylus.x = region.max.x();
+		else if (closest == top)
+			stylus.y = region.min.y();
+		else
+			stylus.y = region.max.y();
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_REGIONS_HPP
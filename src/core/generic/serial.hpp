@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_SERIAL_HPP
+#define IPTSD_CORE_GENERIC_SERIAL_HPP
+
+#include "config.hpp"
+
+#include <common/chrono.hpp>
+#include <common/types.hpp>
+
+#include <spdlog/spdlog.h>
+
+#include <deque>
+#include <optional>
+#include <utility>
+
+namespace iptsd::core {
+
+/*
+ * Watches the serial number of the stylus for changes that happen too often.
+ *
+ * A faulty pen can report inconsistent serial numbers, which makes it look like
+ * multiple styli are quickly replacing each other. If enabled, the serial number of
+ * the stylus that was active before the changes started is kept for a while.
+ */
+class SerialMonitor {
+private:
+	Config m_config;
+
+	// The serial number of the active stylus.
+	u32 m_serial = 0;
+
+	// When the serial number changed, within the current window.
+	std::deque<chrono::steady_clock::time_point> m_changes {};
+
+	// Until when the serial number is locked, if it is locked.
+	std::optional<chrono::steady_clock::time_point> m_locked = std::nullopt;
+
+public:
+	SerialMonitor(Config config) : m_config {std::move(config)} {};
+
+	/*!
+	 * Registers the serial number of a stylus sample.
+	 *
+	 * @param[in] serial The serial number that was reported by the stylus.
+	 * @return The serial number that should be used for the sample.
+	 */
+	u32 filter(const u32 serial)
+	{
+		// Unknown serial numbers can't change.
+		if (serial == 0 || m_config.stylus_serial_churn_threshold == 0)
+			return serial;
+
+		const auto now = chrono::steady_clock::now();
+
+		if (m_locked.has_value()) {
+			if (now < m_locked.value())
+				return m_serial;
+
+			spdlog::info("Unlocked stylus serial {:08X}", m_serial);
+			m_locked = std::nullopt;
+		}
+
+		if (m_serial == 0 || m_serial == serial) {
+			m_serial = serial;
+			return serial;
+		}
+
+		const milliseconds<f64> window {m_config.stylus_serial_churn_window};
+
+		m_changes.push_back(now);
+
+		while (now - m_changes.front() > window)
+			m_changes.pop_front();
+
+		if (m_changes.size() < m_config.stylus_serial_churn_threshold) {
+			m_serial = serial;
+			return serial;
+		}
+
+		spdlog::warn("Stylus serial changed {} times within {} ms, the stylus may be faulty",
+		             m_changes.size(),
+		             m_config.stylus_serial_churn_window);
+
+		m_changes.clear();
+
+		if (!m_config.stylus_serial_churn_lock) {
+			m_serial = serial;
+			return serial;
+		}
+
+		spdlog::warn("Locking stylus serial {:08X} for {} ms",
+		             m_serial,
+		             m_config.stylus_serial_churn_cooldown);
+
+		m_locked = now + milliseconds<i64> {m_config.stylus_serial_churn_cooldown};
+		return m_serial;
+	}
+
+	/*!
+	 * Whether the serial number is currently locked.
+	 *
+	 * @return true if changes of the serial number are suppressed.
+	 */
+	[[nodiscard]] bool locked() const
+	{
+		return m_locked.has_value();
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_SERIAL_HPP
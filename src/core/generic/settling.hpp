@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_SETTLING_HPP
+#define IPTSD_CORE_GENERIC_SETTLING_HPP
+
+#include "config.hpp"
+
+#include <common/chrono.hpp>
+#include <common/types.hpp>
+#include <ipts/samples/stylus.hpp>
+
+#include <spdlog/spdlog.h>
+
+#include <optional>
+#include <utility>
+
+namespace iptsd::core {
+
+/*
+ * Ignores the first inputs after the device returned, until the sensor has settled.
+ *
+ * After a resume or a reset, some devices send garbage for a short time (extreme values,
+ * phantom contacts) before the sensor is calibrated again. While settling, touch contacts
+ * are not emitted and stylus samples that can't be real are discarded. The window ends
+ * after a configurable time, or once enough sane frames in a row were received.
+ */
+class SettlingWindow {
+private:
+	Config m_config;
+
+	// When the window started, if it is active.
+	std::optional<chrono::steady_clock::time_point> m_start = std::nullopt;
+
+	// How many sane frames were received in a row.
+	usize m_sane = 0;
+
+	// How many touch frames were skipped.
+	usize m_touch = 0;
+
+	// How many stylus samples were discarded.
+	usize m_stylus = 0;
+
+public:
+	SettlingWindow(Config config) : m_config {std::move(config)} {};
+
+	/*!
+	 * Starts ignoring inputs, e.g. because the device was reconnected.
+	 */
+	void start()
+	{
+		if (m_config.settle_time <= 0)
+			return;
+
+		m_start = chrono::steady_clock::now();
+		m_sane = 0;
+		m_touch = 0;
+		m_stylus = 0;
+	}
+
+	/*!
+	 * Whether inputs are currently ignored.
+	 *
+	 * @return true if the sensor has not settled yet.
+	 */
+	[[nodiscard]] bool active() const
+	{
+		return m_start.has_value();
+	}
+
+	/*!
+	 * Registers a touch frame.
+	 *
+	 * @param[in] sane Whether the contacts of the frame look like they could be real.
+	 * @return true if the contacts of the frame should not be emitted.
+	 */
+	bool touch(const bool sane)
+	{
+		if (!this->update(sane))
+			return false;
+
+		m_touch++;
+		return true;
+	}
+
+	/*!
+	 * Registers a stylus sample.
+	 *
+	 * @param[in] stylus The stylus sample, as it was received from the device.
+	 * @return true if the sample should be discarded.
+	 */
+	bool stylus(const ipts::samples::Stylus &stylus)
+	{
+		bool sane = !stylus.proximity;
+		sane |= stylus.x >= 0 && stylus.x <= 1 && stylus.y >= 0 && stylus.y <= 1;
+		sane &= stylus.pressure >= 0 && stylus.pressure <= 1;
+
+		if (!this->update(sane) || sane)
+			return false;
+
+		m_stylus++;
+		return true;
+	}
+
+private:
+	/*!
+	 * Counts a frame and ends the window if the sensor has settled.
+	 *
+	 * @param[in] sane Whether the frame looks like it could be real.
+	 * @return true if the window is still active.
+	 */
+	bool update(const bool sane)
+	{
+		if (!m_start.has_value())
+			return false;
+
+		m_sane = sane ? m_sane + 1 : 0;
+
+		const usize frames = m_config.settle_frames;
+		const seconds<f64> elapsed = chrono::steady_clock::now() - m_start.value();
+
+		if (elapsed.count() < m_config.settle_time && (frames == 0 || m_sane < frames))
+			return true;
+
+		spdlog::info("Sensor settled after {:.2f}s, skipped {} touch and {} stylus frames",
+		             elapsed.count(),
+		             m_touch,
+		             m_stylus);
+
+		m_start = std::nullopt;
+		return false;
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_SETTLING_HPP
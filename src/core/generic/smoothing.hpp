@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_SMOOTHING_HPP
+#define IPTSD_CORE_GENERIC_SMOOTHING_HPP
+
+#include "config.hpp"
+
+#include <common/chrono.hpp>
+#include <common/clock.hpp>
+#include <common/types.hpp>
+#include <common/unwrap.hpp>
+#include <ipts/samples/stylus.hpp>
+
+#include <algorithm>
+#include <optional>
+#include <utility>
+
+namespace iptsd::core {
+
+/*
+ * Smoothes the position of the stylus, depending on how fast it is moving.
+ *
+ * Slow movements (e.g. while hovering) are smoothed heavily to remove jitter,
+ * while fast movements pass through almost unchanged to keep drawing responsive.
+ */
+class StylusSmoothing {
+private:
+	Config m_config;
+
+	// The last unfiltered position of the stylus, in centimeters.
+	Vector2<f64> m_raw = Vector2<f64>::Zero();
+
+	// The last filtered position of the stylus, in centimeters.
+	Vector2<f64> m_filtered = Vector2<f64>::Zero();
+
+	// Estimates when the samples were generated, since they can arrive in bursts.
+	common::Unwrapper<u16> m_unwrapper {};
+	common::CounterClock m_clock {};
+
+	// When the last sample was generated.
+	std::optional<chrono::steady_clock::time_point> m_time = std::nullopt;
+
+public:
+	StylusSmoothing(Config config) : m_config {std::move(config)} {};
+
+	/*!
+	 * Smoothes the position of a stylus sample.
+	 *
+	 * @param[in,out] stylus The stylus sample to smooth.
+	 */
+	void filter(ipts::samples::Stylus &stylus)
+	{
+		if (!stylus.proximity) {
+			this->reset();
+			return;
+		}
+
+		const auto time = m_clock.input(m_unwrapper.unwrap(stylus.timestamp),
+		                                chrono::steady_clock::now());
+
+		const Vector2<f64> pos {stylus.x * m_config.width, stylus.y * m_config.height};
+
+		if (!m_time.has_value()) {
+			m_raw = pos;
+			m_filtered = pos;
+			m_time = time;
+			return;
+		}
+
+		// Avoid dividing by zero if two samples have the same time.
+		const f64 dt = std::max(seconds<f64> {time - m_time.value()}.count(), 0.001);
+		const f64 speed = (pos - m_raw).norm() / dt;
+
+		const f64 alpha = this->factor(speed);
+		m_filtered += alpha * (pos - m_filtered);
+
+		m_raw = pos;
+		m_time = time;
+
+		stylus.x = m_filtered.x() / m_config.width;
+		stylus.y = m_filtered.y() / m_config.height;
+	}
+
+	/*!
+	 * Whether the filter is currently following the stylus.
+	 *
+	 * @return true if previous samples are influencing the position.
+	 */
+	[[nodiscard]] bool active() const
+	{
+		return m_time.has_value();
+	}
+
+	/*!
+	 * Forgets the previous samples, e.g. because the stylus left proximity.
+	 */
+	void reset()
+	{
+		m_unwrapper.reset();
+		m_clock.reset();
+
+		m_time = std::nullopt;
+	}
+
+private:
+	/*!
+	 * Calculates how much a new sample contributes to the filtered position.
+	 *
+	 * Between the minimum and maximum speed, the factor is interpolated linearly.
+	 *
+	 * @param[in] speed How fast the stylus is moving, in centimeters per second.
+	 * @return The weight of the new sample. 1 means no smoothing.
+	 */
+	[[nodiscard]] f64 factor(const f64 speed) const
+	{
+		const f64 min = m_config.stylus_smoothing_speed_min;
+		const f64 max = m_config.stylus_smoothing_speed_max;
+		const f64 factor = std::clamp(m_config.stylus_smoothing_factor, 0.0, 1.0);
+
+		if (speed <= min)
+			return factor;
+
+		if (speed >= max)
+			return 1.0;
+
+		return factor + ((1.0 - factor) * (speed - min) / (max - min));
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_SMOOTHING_HPP
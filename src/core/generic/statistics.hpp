@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_STATISTICS_HPP
+#define IPTSD_CORE_GENERIC_STATISTICS_HPP
+
+#include <common/types.hpp>
+
+namespace iptsd::core {
+
+/*
+ * Counters that describe the health of the data stream an application is receiving.
+ */
+struct Statistics {
+	// How many buffers were processed.
+	u64 buffers = 0;
+
+	// How many buffers were lost because they were overwritten before they could be read.
+	u64 dropped = 0;
+
+	// How many buffers could not be parsed, e.g. because they were corrupted or truncated.
+	u64 invalid = 0;
+
+	// How many reports were skipped because they could not be parsed, while the rest of
+	// their buffer was processed.
+	u64 skipped = 0;
+
+	// How many frames were skipped because their type is unknown.
+	u64 unknown = 0;
+
+	// How many stylus samples were dropped because they repeated the previous one.
+	u64 duplicates = 0;
+
+	// How many stylus reports claimed more samples than expected.
+	u64 truncated = 0;
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_STATISTICS_HPP
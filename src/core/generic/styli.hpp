@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_STYLI_HPP
+#define IPTSD_CORE_GENERIC_STYLI_HPP
+
+#include <common/types.hpp>
+#include <ipts/samples/stylus.hpp>
+
+#include <map>
+
+namespace iptsd::core {
+
+/*
+ * Remembers the last sample of every stylus that was seen so far, for the state.
+ *
+ * Only one stylus can be in proximity at a time. When a stylus reports, all others
+ * are marked as out of proximity, but keep their last position.
+ */
+class StylusHistory {
+private:
+	// The last sample of all styli, by their serial number.
+	std::map<u32, ipts::samples::Stylus> m_styli {};
+
+public:
+	/*!
+	 * Registers a processed stylus sample.
+	 *
+	 * Samples without a serial number can't be assigned to a stylus, so they are ignored.
+	 *
+	 * @param[in] stylus The processed stylus sample.
+	 */
+	void update(const ipts::samples::Stylus &stylus)
+	{
+		if (stylus.serial == 0)
+			return;
+
+		for (auto &[serial, last] : m_styli) {
+			if (serial == stylus.serial)
+				continue;
+
+			last.proximity = false;
+			last.contact = false;
+			last.pressure = 0;
+		}
+
+		m_styli[stylus.serial] = stylus;
+	}
+
+	/*!
+	 * The last sample of all styli that were seen so far.
+	 *
+	 * @return The samples, by the serial number of their stylus.
+	 */
+	[[nodiscard]] const std::map<u32, ipts::samples::Stylus> &styli() const
+	{
+		return m_styli;
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_STYLI_HPP
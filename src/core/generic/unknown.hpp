@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_UNKNOWN_HPP
+#define IPTSD_CORE_GENERIC_UNKNOWN_HPP
+
+#include <common/types.hpp>
+#include <ipts/samples/unknown.hpp>
+
+#include <spdlog/spdlog.h>
+
+#include <set>
+#include <string_view>
+#include <utility>
+
+namespace iptsd::core {
+
+/*
+ * Reports the frames that were skipped because their type is not known.
+ *
+ * Every new type is logged once, so that users can help with supporting new hardware.
+ * Devices send the same frames over and over, so repeating them would flood the log.
+ */
+class UnknownFrames {
+private:
+	// The unknown frame types that were already reported.
+	std::set<std::pair<ipts::samples::Unknown::Source, u16>> m_reported {};
+
+public:
+	/*!
+	 * Registers a frame that was skipped, and logs its type if it was not seen before.
+	 *
+	 * @param[in] data The type and size of the skipped frame.
+	 * @return true if the type of the frame was not seen before.
+	 */
+	bool report(const ipts::samples::Unknown &data)
+	{
+		if (!m_reported.emplace(data.source, data.type).second)
+			return false;
+
+		spdlog::info("Skipping unknown {} type 0x{:02X} ({} bytes), please report this!",
+		             name(data.source),
+		             data.type,
+		             data.size);
+
+		return true;
+	}
+
+private:
+	/*!
+	 * Describes the kind of frame that contained the data.
+	 *
+	 * @param[in] source The kind of frame.
+	 * @return The name of the kind of frame, for the log.
+	 */
+	[[nodiscard]] static std::string_view name(const ipts::samples::Unknown::Source source)
+	{
+		switch (source) {
+		case ipts::samples::Unknown::Source::Hid:
+			return "HID frame";
+		case ipts::samples::Unknown::Source::Legacy:
+			return "legacy report group";
+		case ipts::samples::Unknown::Source::Report:
+			return "report frame";
+		}
+
+		return "frame";
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_UNKNOWN_HPP
@@ -16,21 +16,34 @@
 #include <fmt/format.h>
 #include <spdlog/spdlog.h>
 
+#include <algorithm>
+#include <cmath>
+#include <exception>
 #include <filesystem>
 #include <optional>
 #include <set>
+#include <sstream>
 #include <string>
 #include <type_traits>
+#include <utility>
+#include <vector>
 
 namespace iptsd::core::linux {
 
 class ConfigLoader {
+private:
+	// The highest rate at which the stylus can be resampled, in Hz.
+	constexpr static f64 MAX_RESAMPLE_RATE = 1000;
+
 private:
 	Config m_config {};
 	DeviceInfo m_info;
 
 	bool m_loaded_config = false;
 
+	// The config files that were loaded, in the order in which they were loaded.
+	std::vector<std::filesystem::path> m_files {};
+
 public:
 	ConfigLoader(const DeviceInfo &info) : m_info {info}
 	{
@@ -52,14 +65,17 @@ public:
 		 */
 		if (const char *config_file_path = std::getenv("IPTSD_CONFIG_FILE")) {
 			this->load_file(config_file_path);
-			return;
-		}
+		} else {
+			this->load_file(common::buildopts::ConfigFile);
+			this->load_dir(common::buildopts::ConfigDir);
 
-		this->load_file(common::buildopts::ConfigFile);
-		this->load_dir(common::buildopts::ConfigDir);
+			if (!m_loaded_config)
+				spdlog::info("No config file loaded, using default values.");
+		}
 
-		if (!m_loaded_config)
-			spdlog::info("No config file loaded, using default values.");
+		this->load_autodetect_state(m_config.contacts_auto_state_file);
+		this->load_profiles();
+		this->limit_resample_rate();
 	}
 
 	/*!
@@ -72,6 +88,16 @@ public:
 		return m_config;
 	}
 
+	/*!
+	 * The config files that were loaded.
+	 *
+	 * @return The paths of all loaded files, including device presets.
+	 */
+	[[nodiscard]] const std::vector<std::filesystem::path> &files() const
+	{
+		return m_files;
+	}
+
 private:
 	/*!
 	 * Load all configuration files from a directory.
@@ -139,6 +165,7 @@ private:
 			return;
 
 		spdlog::info("Loading config {}.", path.c_str());
+		m_files.push_back(path);
 
 		const INIReader ini {path};
 
@@ -149,35 +176,152 @@ private:
 
 		this->get(ini, "Config", "InvertX", m_config.invert_x);
 		this->get(ini, "Config", "InvertY", m_config.invert_y);
-		this->get(ini, "Config", "Width", m_config.width);
-		this->get(ini, "Config", "Height", m_config.height);
+		this->get_length(ini, "Config", "Width", m_config.width);
+		this->get_length(ini, "Config", "Height", m_config.height);
+		this->get(ini, "Config", "IgnoreMetadata", m_config.ignore_metadata);
+		this->get(ini, "Config", "ParseErrors", m_config.parse_errors);
+		this->get(ini, "Config", "SettleTime", m_config.settle_time);
+		this->get(ini, "Config", "SettleFrames", m_config.settle_frames);
 
 		this->get(ini, "Touchscreen", "Disable", m_config.touchscreen_disable);
 		this->get(ini, "Touchscreen", "DisableOnPalm", m_config.touchscreen_disable_on_palm);
 		this->get(ini, "Touchscreen", "DisableOnStylus", m_config.touchscreen_disable_on_stylus);
-		this->get(ini, "Touchscreen", "Overshoot", m_config.touchscreen_overshoot);
+		this->get(ini, "Touchscreen", "DisableOnStylusFirmware", m_config.touchscreen_disable_on_stylus_firmware);
+		this->get_length(ini, "Touchscreen", "Overshoot", m_config.touchscreen_overshoot);
+		this->get_length(ini, "Touchscreen", "DragThreshold", m_config.touchscreen_drag_threshold);
+		this->get(ini, "Touchscreen", "Mode", m_config.touchscreen_mode);
+		this->get(ini, "Touchscreen", "PointerSpeed", m_config.touchscreen_pointer_speed);
+		this->get(ini, "Touchscreen", "PointerAcceleration", m_config.touchscreen_pointer_acceleration);
+		this->get(ini, "Touchscreen", "OutputDevice", m_config.touchscreen_output_device);
+		this->get(ini, "Touchscreen", "Protocol", m_config.touchscreen_protocol);
+		this->get(ini, "Touchscreen", "OutOfRange", m_config.touchscreen_out_of_range);
+		this->get(ini, "Touchscreen", "EmitWidth", m_config.touchscreen_emit_width);
+		this->get(ini, "Touchscreen", "EmitPressure", m_config.touchscreen_emit_pressure);
+		this->get(ini, "Touchscreen", "PressureCurve", m_config.touchscreen_pressure_curve);
+		this->get(ini, "Touchscreen", "HardwareTimestamps", m_config.touchscreen_hardware_timestamps);
+		this->get(ini, "Touchscreen", "ToggleDevice", m_config.touchscreen_toggle_device);
+		this->get(ini, "Touchscreen", "ToggleKeys", m_config.touchscreen_toggle_keys);
 
 		this->get(ini, "Touchpad", "Disable", m_config.touchpad_disable);
 		this->get(ini, "Touchpad", "DisableOnPalm", m_config.touchpad_disable_on_palm);
-		this->get(ini, "Touchpad", "Overshoot", m_config.touchpad_overshoot);
+		this->get_length(ini, "Touchpad", "Overshoot", m_config.touchpad_overshoot);
+		this->get_length(ini, "Touchpad", "DragThreshold", m_config.touchpad_drag_threshold);
+		this->get(ini, "Touchpad", "CountDebounce", m_config.touchpad_count_debounce);
+		this->get(ini, "Touchpad", "OutputDevice", m_config.touchpad_output_device);
+		this->get(ini, "Touchpad", "Protocol", m_config.touchpad_protocol);
+		this->get(ini, "Touchpad", "OutOfRange", m_config.touchpad_out_of_range);
+		this->get(ini, "Touchpad", "EmitWidth", m_config.touchpad_emit_width);
+		this->get(ini, "Touchpad", "EmitPressure", m_config.touchpad_emit_pressure);
+		this->get(ini, "Touchpad", "PressureCurve", m_config.touchpad_pressure_curve);
+		this->get(ini, "Touchpad", "HardwareTimestamps", m_config.touchpad_hardware_timestamps);
+
+		this->get(ini, "TabletMode", "Device", m_config.tablet_mode_device);
+		this->get(ini, "TabletMode", "DisableOnPalm", m_config.tablet_mode_disable_on_palm);
+		this->get_length(ini, "TabletMode", "Overshoot", m_config.tablet_mode_overshoot);
+
+		this->get(ini, "Idle", "Inhibit", m_config.idle_inhibit);
+		this->get(ini, "Idle", "Interval", m_config.idle_interval);
 
 		this->get(ini, "Contacts", "Neutral", m_config.contacts_neutral);
+		this->get(ini, "Contacts", "Blobs", m_config.contacts_blobs);
+		this->get(ini, "Contacts", "Detection", m_config.contacts_detection);
+		this->get(ini, "Contacts", "Position", m_config.contacts_position);
 		this->get(ini, "Contacts", "NeutralValue", m_config.contacts_neutral_value);
 		this->get(ini, "Contacts", "ActivationThreshold", m_config.contacts_activation_threshold);
 		this->get(ini, "Contacts", "DeactivationThreshold", m_config.contacts_deactivation_threshold);
-		this->get(ini, "Contacts", "SizeThresholdMin", m_config.contacts_size_thresh_min);
-		this->get(ini, "Contacts", "SizeThresholdMax", m_config.contacts_size_thresh_max);
-		this->get(ini, "Contacts", "PositionThresholdMin", m_config.contacts_position_thresh_min);
-		this->get(ini, "Contacts", "PositionThresholdMax", m_config.contacts_position_thresh_max);
+		this->get_length(ini, "Contacts", "SizeThresholdMin", m_config.contacts_size_thresh_min);
+		this->get_length(ini, "Contacts", "SizeThresholdMax", m_config.contacts_size_thresh_max);
+		this->get_length(ini, "Contacts", "PositionThresholdMin", m_config.contacts_position_thresh_min);
+		this->get_length(ini, "Contacts", "PositionThresholdMax", m_config.contacts_position_thresh_max);
 		this->get(ini, "Contacts", "OrientationThresholdMin", m_config.contacts_orientation_thresh_min);
 		this->get(ini, "Contacts", "OrientationThresholdMax", m_config.contacts_orientation_thresh_max);
-		this->get(ini, "Contacts", "SizeMin", m_config.contacts_size_min);
-		this->get(ini, "Contacts", "SizeMax", m_config.contacts_size_max);
+		this->get_size(ini, "Contacts", "SizeMin", m_config.contacts_size_min);
+		this->get_size(ini, "Contacts", "SizeMax", m_config.contacts_size_max);
+		this->get_length(ini, "Contacts", "SizeHysteresis", m_config.contacts_size_hysteresis);
+		this->get(ini, "Contacts", "NoiseGate", m_config.contacts_noise_gate);
+		this->get(ini, "Contacts", "NoiseGateDeviation", m_config.contacts_noise_gate_deviation);
+		this->get(ini, "Contacts", "NoiseGateFrames", m_config.contacts_noise_gate_frames);
+		this->get(ini, "Contacts", "IntensityHysteresis", m_config.contacts_intensity_hysteresis);
+		this->get(ini, "Contacts", "IntensityActivation", m_config.contacts_intensity_activation);
+		this->get(ini, "Contacts", "IntensityDeactivation", m_config.contacts_intensity_deactivation);
+		this->get(ini, "Contacts", "RetainFrames", m_config.contacts_retain_frames);
+		this->get_length(ini, "Contacts", "RetainDistance", m_config.contacts_retain_distance);
+		this->get(ini, "Contacts", "PinchSmoothing", m_config.contacts_pinch_smoothing);
+		this->get(ini, "Contacts", "PinchSmoothingFactor", m_config.contacts_pinch_smoothing_factor);
 		this->get(ini, "Contacts", "AspectMin", m_config.contacts_aspect_max);
 		this->get(ini, "Contacts", "AspectMax", m_config.contacts_aspect_max);
+		this->get(ini, "Contacts", "HoldFrames", m_config.contacts_hold_frames);
+		this->get(ini, "Contacts", "MaxContacts", m_config.contacts_max);
+		this->get(ini, "Contacts", "StaleFrames", m_config.contacts_stale_frames);
+		this->get(ini, "Contacts", "Polarity", m_config.contacts_polarity);
+		this->get(ini, "Contacts", "HeatmapTranspose", m_config.contacts_heatmap_transpose);
+		this->get(ini, "Contacts", "HeatmapFlipX", m_config.contacts_heatmap_flip_x);
+		this->get(ini, "Contacts", "HeatmapFlipY", m_config.contacts_heatmap_flip_y);
+		this->get_length(ini, "Contacts", "MaskCornerRadius", m_config.contacts_mask_corner_radius);
+		this->get(ini, "Contacts", "ActiveArea", m_config.contacts_active_area);
+		this->get(ini, "Contacts", "Baseline", m_config.contacts_baseline);
+		this->get(ini, "Contacts", "BaselineRate", m_config.contacts_baseline_rate);
+		this->get(ini, "Contacts", "AdaptiveDecimation", m_config.contacts_adaptive_decimation);
+		this->get(ini, "Contacts", "AdaptiveDropRatio", m_config.contacts_adaptive_drop_ratio);
+		this->get(ini, "Contacts", "AdaptiveMaxDecimation", m_config.contacts_adaptive_max_decimation);
+		this->get(ini, "Contacts", "AutoThreshold", m_config.contacts_auto_threshold);
+		this->get(ini, "Contacts", "AutoDeviations", m_config.contacts_auto_deviations);
+		this->get(ini, "Contacts", "AutoStateFile", m_config.contacts_auto_state_file);
+		this->get(ini, "Contacts", "LifetimeFile", m_config.contacts_lifetime_file);
 
 		this->get(ini, "Stylus", "Disable", m_config.stylus_disable);
-		this->get(ini, "Stylus", "TipDistance", m_config.stylus_tip_distance);
+		this->get_length(ini, "Stylus", "TipDistance", m_config.stylus_tip_distance);
+		this->get(ini, "Stylus", "InstantLift", m_config.stylus_instant_lift);
+		this->get(ini, "Stylus", "RubberAsPen", m_config.stylus_rubber_as_pen);
+		this->get(ini, "Stylus", "RubberKey", m_config.stylus_rubber_key);
+		this->get(ini, "Stylus", "SeparateRubber", m_config.stylus_separate_rubber);
+		this->get(ini, "Stylus", "ArmRubber", m_config.stylus_arm_rubber);
+		this->get(ini, "Stylus", "DropDuplicates", m_config.stylus_drop_duplicates);
+		this->get(ini, "Stylus", "Smoothing", m_config.stylus_smoothing);
+		this->get(ini, "Stylus", "SmoothingFactor", m_config.stylus_smoothing_factor);
+		this->get_length(ini, "Stylus", "SmoothingSpeedMin", m_config.stylus_smoothing_speed_min, "/s");
+		this->get_length(ini, "Stylus", "SmoothingSpeedMax", m_config.stylus_smoothing_speed_max, "/s");
+		this->get(ini, "Stylus", "PressureSmoothing", m_config.stylus_pressure_smoothing);
+		this->get(ini, "Stylus", "PressureSmoothingFactor", m_config.stylus_pressure_smoothing_factor);
+		this->get(ini, "Stylus", "PressureInterpolation", m_config.stylus_pressure_interpolation);
+		this->get(ini, "Stylus", "PressureLevels", m_config.stylus_pressure_levels);
+		this->get(ini, "Stylus", "OutputDevice", m_config.stylus_output_device);
+		this->get(ini, "Stylus", "ButtonOutOfProximity", m_config.stylus_button_out_of_proximity);
+		this->get(ini, "Stylus", "OutOfRange", m_config.stylus_out_of_range);
+		this->get(ini, "Stylus", "EmitErrors", m_config.stylus_emit_errors);
+		this->get(ini, "Stylus", "MaxPressure", m_config.stylus_max_pressure);
+		this->get(ini, "Stylus", "PressureCurve", m_config.stylus_pressure_curve);
+		this->get(ini, "Stylus", "RubberPressureCurve", m_config.stylus_rubber_pressure_curve);
+		this->get(ini, "Stylus", "DelayContact", m_config.stylus_delay_contact);
+		this->get(ini, "Stylus", "Prediction", m_config.stylus_prediction);
+		this->get(ini, "Stylus", "DoubleTap", m_config.stylus_double_tap);
+		this->get(ini, "Stylus", "DoubleTapKey", m_config.stylus_double_tap_key);
+		this->get(ini, "Stylus", "DoubleTapTimeout", m_config.stylus_double_tap_timeout);
+		this->get_length(ini, "Stylus", "DoubleTapDistance", m_config.stylus_double_tap_distance);
+		this->get(ini, "Stylus", "ButtonScroll", m_config.stylus_button_scroll);
+		this->get(ini, "Stylus", "ButtonScrollSpeed", m_config.stylus_button_scroll_speed);
+		this->get(ini, "Stylus", "SerialChurnThreshold", m_config.stylus_serial_churn_threshold);
+		this->get(ini, "Stylus", "SerialChurnWindow", m_config.stylus_serial_churn_window);
+		this->get(ini, "Stylus", "SerialChurnLock", m_config.stylus_serial_churn_lock);
+		this->get(ini, "Stylus", "SerialChurnCooldown", m_config.stylus_serial_churn_cooldown);
+		this->get(ini, "Stylus", "IgnoreRegions", m_config.stylus_ignore_regions);
+		this->get(ini, "Stylus", "IgnoreRegionMode", m_config.stylus_ignore_region_mode);
+		this->get(ini, "Stylus", "FuzzX", m_config.stylus_fuzz_x);
+		this->get(ini, "Stylus", "FuzzY", m_config.stylus_fuzz_y);
+		this->get(ini, "Stylus", "FuzzPressure", m_config.stylus_fuzz_pressure);
+		this->get(ini, "Stylus", "FuzzTilt", m_config.stylus_fuzz_tilt);
+		this->get(ini, "Stylus", "InvertTiltX", m_config.stylus_invert_tilt_x);
+		this->get(ini, "Stylus", "InvertTiltY", m_config.stylus_invert_tilt_y);
+		this->get(ini, "Stylus", "DisableTilt", m_config.stylus_disable_tilt);
+		this->get(ini, "Stylus", "AutoTilt", m_config.stylus_auto_tilt);
+		this->get(ini, "Stylus", "AutoTiltSamples", m_config.stylus_auto_tilt_samples);
+		this->get(ini, "Stylus", "TiltInterval", m_config.stylus_tilt_interval);
+		this->get(ini, "Stylus", "TiltThreshold", m_config.stylus_tilt_threshold);
+		this->get(ini, "Stylus", "HardwareTimestamps", m_config.stylus_hardware_timestamps);
+		this->get(ini, "Stylus", "ResampleRate", m_config.stylus_resample_rate);
+		this->get(ini, "Stylus", "Remote", m_config.stylus_remote);
+		this->get(ini, "Stylus", "RemoteToken", m_config.stylus_remote_token);
+		this->get(ini, "Stylus", "Profiles", m_config.stylus_profiles);
 
 		this->get(ini, "DFT", "PositionMinAmp", m_config.dft_position_min_amp);
 		this->get(ini, "DFT", "PositionMinMag", m_config.dft_position_min_mag);
@@ -185,23 +329,257 @@ private:
 		this->get(ini, "DFT", "ButtonMinMag", m_config.dft_button_min_mag);
 		this->get(ini, "DFT", "FreqMinMag", m_config.dft_freq_min_mag);
 		this->get(ini, "DFT", "TiltMinMag", m_config.dft_tilt_min_mag);
-		this->get(ini, "DFT", "TiltDistance", m_config.dft_tilt_distance);
+		this->get_length(ini, "DFT", "TiltDistance", m_config.dft_tilt_distance);
 		this->get(ini, "DFT", "Mpp2ContactMinMag", m_config.dft_mpp2_contact_min_mag);
 		this->get(ini, "DFT", "Mpp2ButtonMinMag", m_config.dft_mpp2_button_min_mag);
 		this->get(ini, "DFT", "AllowSplitEvents", m_config.dft_allow_split_events);
 
 		// Legacy options that are kept for compatibility
-		this->get(ini, "DFT", "TipDistance", m_config.stylus_tip_distance);
-		this->get(ini, "Contacts", "SizeThreshold", m_config.contacts_size_thresh_max);
+		this->get_length(ini, "DFT", "TipDistance", m_config.stylus_tip_distance);
+		this->get_length(ini, "Contacts", "SizeThreshold", m_config.contacts_size_thresh_max);
 		this->get(ini, "Touch", "Disable", m_config.touchscreen_disable);
 		this->get(ini, "Touch", "DisableOnPalm", m_config.touchscreen_disable_on_palm);
 		this->get(ini, "Touch", "DisableOnStylus", m_config.touchscreen_disable_on_stylus);
-		this->get(ini, "Touch", "Overshoot", m_config.touchscreen_overshoot);
+		this->get_length(ini, "Touch", "Overshoot", m_config.touchscreen_overshoot);
 
 		// clang-format on
 		m_loaded_config = true;
 	}
 
+	/*!
+	 * Loads the values that were automatically detected during a previous run.
+	 *
+	 * Only options that are set to be detected automatically will be replaced,
+	 * so explicitly configured values always take precedence.
+	 *
+	 * @param[in] path The state file that was written by the detection.
+	 */
+	void load_autodetect_state(const std::filesystem::path &path)
+	{
+		if (path.empty() || !std::filesystem::exists(path))
+			return;
+
+		const INIReader ini {path};
+
+		if (ini.ParseError() != 0)
+			throw common::Error<Error::ParsingFailed> {path.c_str()};
+
+		// clang-format off
+
+		if (m_config.contacts_polarity == "auto")
+			this->get(ini, "Contacts", "Polarity", m_config.contacts_polarity);
+
+		if (m_config.contacts_auto_threshold) {
+			this->get(ini, "Contacts", "ActivationThreshold", m_config.contacts_activation_threshold);
+			this->get(ini, "Contacts", "DeactivationThreshold", m_config.contacts_deactivation_threshold);
+			this->get(ini, "Contacts", "AutoThreshold", m_config.contacts_auto_threshold);
+		}
+
+		// clang-format on
+	}
+
+	/*!
+	 * Limits the rate at which the stylus is resampled.
+	 *
+	 * At very high rates, the time between two samples rounds down to zero, and the
+	 * resampler would never catch up with the stylus.
+	 */
+	void limit_resample_rate()
+	{
+		if (m_config.stylus_resample_rate <= MAX_RESAMPLE_RATE)
+			return;
+
+		spdlog::warn("ResampleRate {} is too high, using {} instead",
+		             m_config.stylus_resample_rate,
+		             MAX_RESAMPLE_RATE);
+
+		m_config.stylus_resample_rate = MAX_RESAMPLE_RATE;
+	}
+
+	/*!
+	 * Loads the stylus profiles that are listed in the config.
+	 *
+	 * Every profile starts with the options from [Stylus], so it only has to contain the
+	 * options that are different. Its section can be in any of the loaded files.
+	 */
+	void load_profiles()
+	{
+		std::istringstream stream {m_config.stylus_profiles};
+		std::string name {};
+
+		while (std::getline(stream, name, ',')) {
+			name.erase(0, std::min(name.find_first_not_of(' '), name.size()));
+			name.erase(name.find_last_not_of(' ') + 1);
+
+			if (name.empty())
+				continue;
+
+			StylusProfile profile = m_config.stylus_profile();
+			profile.name = name;
+
+			const std::string section = "StylusProfile." + name;
+
+			for (const std::filesystem::path &path : m_files) {
+				const INIReader ini {path};
+
+				if (ini.ParseError() != 0)
+					throw common::Error<Error::ParsingFailed> {path.c_str()};
+
+				// clang-format off
+
+				this->get_serials(ini, section, profile.serials);
+				this->get(ini, section, "Smoothing", profile.smoothing);
+				this->get(ini, section, "SmoothingFactor", profile.smoothing_factor);
+				this->get_length(ini, section, "SmoothingSpeedMin", profile.smoothing_speed_min, "/s");
+				this->get_length(ini, section, "SmoothingSpeedMax", profile.smoothing_speed_max, "/s");
+				this->get(ini, section, "PressureSmoothing", profile.pressure_smoothing);
+				this->get(ini, section, "PressureSmoothingFactor", profile.pressure_smoothing_factor);
+				this->get(ini, section, "PressureCurve", profile.pressure_curve);
+
+				// clang-format on
+			}
+
+			if (profile.serials.empty())
+				spdlog::warn("Stylus profile {} has no serial numbers", name);
+
+			m_config.profiles.push_back(profile);
+		}
+	}
+
+	/*!
+	 * Loads the serial numbers of the styli that use a profile.
+	 *
+	 * @param[in] ini The loaded file.
+	 * @param[in] section The section of the profile.
+	 * @param[in,out] serials The previous serial numbers, replaced if the file contains any.
+	 */
+	void get_serials(const INIReader &ini,
+	                 const std::string &section,
+	                 std::vector<u32> &serials) const
+	{
+		const std::string text = ini.GetString(section, "Serials", "");
+
+		if (text.empty())
+			return;
+
+		std::istringstream stream {text};
+		std::string serial {};
+
+		serials.clear();
+
+		while (std::getline(stream, serial, ',')) {
+			try {
+				serials.push_back(casts::to<u32>(std::stoul(serial, nullptr, 0)));
+			} catch (const std::exception & /* unused */) {
+				throw common::Error<Error::InvalidStylusSerial> {section, text};
+			}
+		}
+	}
+
+	/*!
+	 * Loads a length in centimeters from a config file.
+	 *
+	 * Lengths can also be given in millimeters, e.g. "5mm", or explicitly in centimeters.
+	 * Values without a unit are centimeters, like before units were supported.
+	 *
+	 * @param[in] ini The loaded file.
+	 * @param[in] section The section where the option is found.
+	 * @param[in] name The name of the config option.
+	 * @param[in,out] value The default value as well as the destination of the new value.
+	 * @param[in] per The time unit of a speed, e.g. "/s". Empty for lengths.
+	 */
+	void get_length(const INIReader &ini,
+	                const std::string &section,
+	                const std::string &name,
+	                f64 &value,
+	                const std::string &per = "") const
+	{
+		const std::string text = ini.GetString(section, name, "");
+
+		if (text.empty())
+			return;
+
+		const auto [number, unit] = split_unit(text);
+
+		if (!number.has_value())
+			throw common::Error<Error::InvalidLength> {section, name, text};
+
+		if (unit.empty() || unit == "cm" + per)
+			value = number.value();
+		else if (unit == "mm" + per)
+			value = number.value() / 10;
+		else
+			throw common::Error<Error::InvalidLength> {section, name, text};
+	}
+
+	/*!
+	 * Loads the size of a contact in centimeters from a config file.
+	 *
+	 * Sizes are diameters, so they accept the same units as lengths. They can also be given
+	 * as the area of a round contact in mm² or cm², e.g. "20mm2", which is converted to the
+	 * diameter of a circle with that area.
+	 *
+	 * @param[in] ini The loaded file.
+	 * @param[in] section The section where the option is found.
+	 * @param[in] name The name of the config option.
+	 * @param[in,out] value The default value as well as the destination of the new value.
+	 */
+	void get_size(const INIReader &ini,
+	              const std::string &section,
+	              const std::string &name,
+	              f64 &value) const
+	{
+		const std::string text = ini.GetString(section, name, "");
+
+		if (text.empty())
+			return;
+
+		const auto [number, unit] = split_unit(text);
+
+		if (!number.has_value())
+			throw common::Error<Error::InvalidSize> {section, name, text};
+
+		std::optional<f64> area = std::nullopt;
+
+		if (unit == "mm2" || unit == "mm²")
+			area = number.value() / 100;
+		else if (unit == "cm2" || unit == "cm²")
+			area = number.value();
+
+		if (!area.has_value()) {
+			this->get_length(ini, section, name, value);
+			return;
+		}
+
+		if (area.value() < 0)
+			throw common::Error<Error::InvalidSize> {section, name, text};
+
+		value = 2 * std::sqrt(area.value() / M_PI);
+	}
+
+	/*!
+	 * Splits a value into its number and its unit.
+	 *
+	 * @param[in] text The value from the config file.
+	 * @return The number, if the value starts with one, and the unit that follows it.
+	 */
+	static std::pair<std::optional<f64>, std::string> split_unit(const std::string &text)
+	{
+		usize end = 0;
+		f64 number = 0;
+
+		try {
+			number = std::stod(text, &end);
+		} catch (const std::logic_error & /* unused */) {
+			return {std::nullopt, ""};
+		}
+
+		std::string unit = text.substr(end);
+		unit.erase(0, std::min(unit.find_first_not_of(' '), unit.size()));
+
+		return {number, unit};
+	}
+
 	/*!
 	 * Loads a value from a config file.
 	 *
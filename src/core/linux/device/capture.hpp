@@ -3,6 +3,7 @@
 #ifndef IPTSD_CORE_LINUX_DEVICE_CAPTURE_HPP
 #define IPTSD_CORE_LINUX_DEVICE_CAPTURE_HPP
 
+#include "file.hpp"
 #include "hidraw.hpp"
 
 #include <common/casts.hpp>
@@ -31,6 +32,9 @@ private:
 private:
 	std::ofstream m_writer {};
 
+	// When the capture was started.
+	chrono::steady_clock::time_point m_started = chrono::steady_clock::now();
+
 public:
 	Capture(const std::filesystem::path &path) : Hidraw(path)
 	{
@@ -49,6 +53,8 @@ public:
 
 		spdlog::info("Capturing HID traffic to {}", outpath.c_str());
 
+		common::write_to_stream(m_writer, CAPTURE_MAGIC);
+		common::write_to_stream(m_writer, CAPTURE_VERSION);
 		common::write_to_stream(m_writer, m_devinfo);
 		common::write_to_stream(m_writer, m_desc.size);
 		common::write_to_stream(m_writer, gsl::span<u8> {&m_desc.value[0], m_desc.size});
@@ -68,9 +74,7 @@ public:
 	usize read(gsl::span<u8> buffer) override
 	{
 		const usize size = Hidraw::read(buffer);
-
-		common::write_to_stream(m_writer, casts::to<u64>(size));
-		common::write_to_stream(m_writer, buffer.first(size));
+		this->write(buffer.first(size));
 
 		return size;
 	}
@@ -83,7 +87,21 @@ public:
 	void get_feature(gsl::span<u8> report) override
 	{
 		Hidraw::get_feature(report);
+		this->write(report);
+	}
+
+private:
+	/*!
+	 * Writes a report to the capture file, together with the time when it was received.
+	 *
+	 * @param[in] report The data of the report.
+	 */
+	void write(const gsl::span<u8> report)
+	{
+		const auto elapsed = chrono::steady_clock::now() - m_started;
+		const auto timestamp = chrono::duration_cast<nanoseconds<u64>>(elapsed).count();
 
+		common::write_to_stream(m_writer, casts::to<u64>(timestamp));
 		common::write_to_stream(m_writer, casts::to<u64>(report.size()));
 		common::write_to_stream(m_writer, report);
 	}
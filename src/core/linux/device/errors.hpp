@@ -11,6 +11,7 @@ namespace iptsd::core::linux::device {
 
 enum class Error : u8 {
 	EndOfData,
+	UnsupportedCaptureVersion,
 };
 
 inline std::string format_as(Error err)
@@ -18,6 +19,8 @@ inline std::string format_as(Error err)
 	switch (err) {
 	case Error::EndOfData:
 		return "core: linux: devices: No further data available!";
+	case Error::UnsupportedCaptureVersion:
+		return "core: linux: devices: Unsupported capture format version {}!";
 	default:
 		return "core: linux: devices: Invalid error code!";
 	}
@@ -6,6 +6,7 @@
 #include "errors.hpp"
 
 #include <common/casts.hpp>
+#include <common/chrono.hpp>
 #include <common/error.hpp>
 #include <common/file.hpp>
 #include <common/reader.hpp>
@@ -16,14 +17,29 @@
 #include <ipts/parser.hpp>
 
 #include <gsl/gsl>
+#include <spdlog/spdlog.h>
 
 #include <linux/hidraw.h>
 
 #include <filesystem>
+#include <optional>
+#include <thread>
 
 namespace iptsd::core::linux::device {
 
+/*
+ * Captures that start with this value store a timestamp with every report.
+ * Older captures without timestamps start with the device info directly.
+ */
+constexpr u32 CAPTURE_MAGIC = 0x53545049; // "IPTS"
+
+// The version of the capture format that is written.
+constexpr u32 CAPTURE_VERSION = 2;
+
 class File : public hid::Device {
+private:
+	using clock = chrono::steady_clock;
+
 protected:
 	Reader m_data;
 	std::filesystem::path m_path {};
@@ -31,14 +47,33 @@ protected:
 	// The index at which the actual data starts.
 	usize m_start = 0;
 
+	// The version of the capture format.
+	u32 m_version = 1;
+
 	struct hidraw_devinfo m_devinfo {};
 	struct hidraw_report_descriptor m_desc {};
 
+private:
+	// How much faster than captured the reports are replayed. 0 disables the timing.
+	f64 m_speed = 0;
+
+	// When the first report was replayed with timing, shifted by its timestamp.
+	std::optional<clock::time_point> m_replay_start = std::nullopt;
+
 public:
 	File(const std::filesystem::path &path)
 		: m_data {common::read_all_bytes(path)},
 		  m_path {path}
 	{
+		if (m_data.read<u32>() == CAPTURE_MAGIC) {
+			m_version = m_data.read<u32>();
+
+			if (m_version != CAPTURE_VERSION)
+				throw common::Error<Error::UnsupportedCaptureVersion> {m_version};
+		} else {
+			m_data.seek(0);
+		}
+
 		m_devinfo = m_data.read<struct hidraw_devinfo>();
 		m_desc.size = m_data.read<u32>();
 		m_data.read(gsl::span<u8> {&m_desc.value[0], m_desc.size});
@@ -50,6 +85,21 @@ public:
 		m_start = m_data.index(); // NOLINT(cppcoreguidelines-prefer-member-initializer)
 	}
 
+	/*!
+	 * Replays the reports with the timing in which they were captured.
+	 *
+	 * @param[in] speed How much faster than captured the reports are replayed.
+	 *                  0 replays them as fast as possible.
+	 */
+	void set_speed(const f64 speed)
+	{
+		if (speed > 0 && m_version < 2)
+			spdlog::warn("{} has no timestamps, replaying without timing", m_path.c_str());
+
+		m_speed = speed;
+		m_replay_start = std::nullopt;
+	}
+
 	/*!
 	 * The "name", aka. the path to the source file.
 	 */
@@ -99,12 +149,20 @@ public:
 	usize read(gsl::span<u8> buffer) override
 	{
 		try {
+			const std::optional<u64> timestamp = this->read_timestamp();
+
 			const auto size = casts::to<usize>(m_data.read<u64>());
 			m_data.read(buffer.first(size));
+
+			if (timestamp.has_value())
+				this->wait(timestamp.value());
+
 			return size;
 		} catch (const common::Error<Reader::Error::EndOfData> & /* unused */) {
 			// Allow looping calls to the file based HID source
 			m_data.seek(m_start);
+			m_replay_start = std::nullopt;
+
 			throw common::Error<Error::EndOfData> {};
 		}
 	}
@@ -117,11 +175,15 @@ public:
 	void get_feature(gsl::span<u8> report) override
 	{
 		try {
+			this->read_timestamp();
+
 			const auto size = casts::to<usize>(m_data.read<u64>());
 			m_data.read(report.first(size));
 		} catch (const common::Error<Reader::Error::EndOfData> & /* unused */) {
 			// Allow looping calls to the file based HID source
 			m_data.seek(m_start);
+			m_replay_start = std::nullopt;
+
 			throw common::Error<Error::EndOfData> {};
 		}
 	}
@@ -139,6 +201,39 @@ public:
 	void set_feature(const gsl::span<u8> /* unused */) override
 	{
 	}
+
+private:
+	/*!
+	 * Reads the timestamp of the next report, if the capture format stores them.
+	 *
+	 * @return When the report was captured, in nanoseconds since the capture was started.
+	 */
+	std::optional<u64> read_timestamp()
+	{
+		if (m_version < 2)
+			return std::nullopt;
+
+		return m_data.read<u64>();
+	}
+
+	/*!
+	 * Waits until a report is due, if the reports are replayed with timing.
+	 *
+	 * @param[in] timestamp When the report was captured, in nanoseconds.
+	 */
+	void wait(const u64 timestamp)
+	{
+		if (m_speed <= 0)
+			return;
+
+		const nanoseconds<f64> offset {casts::to<f64>(timestamp) / m_speed};
+		const auto delay = chrono::duration_cast<clock::duration>(offset);
+
+		if (!m_replay_start.has_value())
+			m_replay_start = clock::now() - delay;
+
+		std::this_thread::sleep_until(m_replay_start.value() + delay);
+	}
 };
 
 } // namespace iptsd::core::linux::device
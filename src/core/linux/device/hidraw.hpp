@@ -3,9 +3,11 @@
 #ifndef IPTSD_CORE_LINUX_DEVICE_HIDRAW_HPP
 #define IPTSD_CORE_LINUX_DEVICE_HIDRAW_HPP
 
+#include "../errors.hpp"
 #include "../syscalls.hpp"
 
 #include <common/casts.hpp>
+#include <common/error.hpp>
 #include <common/types.hpp>
 #include <hid/device.hpp>
 #include <hid/parser.hpp>
@@ -15,7 +17,9 @@
 #include <gsl/gsl>
 
 #include <linux/hidraw.h>
+#include <poll.h>
 
+#include <array>
 #include <filesystem>
 
 namespace iptsd::core::linux::device {
@@ -25,6 +29,9 @@ protected:
 	int m_fd = -1;
 	std::filesystem::path m_path {};
 
+	// Interrupts waiting for a report when it becomes readable. Not used if it is -1.
+	int m_wakeup = -1;
+
 	struct hidraw_devinfo m_devinfo {};
 	struct hidraw_report_descriptor m_desc {};
 
@@ -91,6 +98,19 @@ public:
 		return gsl::span<u8> {&m_desc.value[0], m_desc.size};
 	}
 
+	/*!
+	 * Lets a file descriptor interrupt the wait for the next report.
+	 *
+	 * Once it becomes readable, reading throws until it is cleared. This way, the caller can
+	 * handle requests from other threads and signal handlers while the device is idle.
+	 *
+	 * @param[in] fd The file descriptor, e.g. of an eventfd. -1 disables this.
+	 */
+	void set_wakeup(const int fd)
+	{
+		m_wakeup = fd;
+	}
+
 	/*!
 	 * Reads a report from the HID device.
 	 *
@@ -99,6 +119,9 @@ public:
 	 */
 	usize read(gsl::span<u8> buffer) override
 	{
+		if (m_wakeup != -1 && !this->wait(-1))
+			throw common::Error<linux::Error::SyscallReadInterrupted> {};
+
 		return syscalls::read(m_fd, buffer);
 	}
 
@@ -121,6 +144,32 @@ public:
 	{
 		syscalls::ioctl(m_fd, HIDIOCSFEATURE(report.size()), report.data());
 	}
+
+protected:
+	/*!
+	 * Waits until a report can be read from the device.
+	 *
+	 * @param[in] timeout How long to wait at most, in milliseconds. -1 waits forever.
+	 * @return Whether a report can be read. Returns false if a signal interrupted the wait.
+	 */
+	bool wait(const int timeout) const
+	{
+		std::array<struct pollfd, 2> fds {};
+		fds[0].fd = m_fd;
+		fds[0].events = POLLIN;
+
+		// poll ignores negative file descriptors, so this also works without a wakeup.
+		fds[1].fd = m_wakeup;
+		fds[1].events = POLLIN;
+
+		if (!syscalls::poll(gsl::span {fds}, timeout))
+			return false;
+
+		if (fds[1].revents != 0)
+			throw common::Error<linux::Error::SyscallReadInterrupted> {};
+
+		return fds[0].revents != 0;
+	}
 };
 
 } // namespace iptsd::core::linux::device
@@ -0,0 +1,297 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_LINUX_DEVICE_JOURNAL_HPP
+#define IPTSD_CORE_LINUX_DEVICE_JOURNAL_HPP
+
+#include "file.hpp"
+
+#include <common/casts.hpp>
+#include <common/chrono.hpp>
+#include <common/file.hpp>
+#include <common/types.hpp>
+#include <hid/device.hpp>
+
+#include <gsl/gsl>
+
+#include <linux/hidraw.h>
+
+#include <algorithm>
+#include <filesystem>
+#include <fstream>
+#include <memory>
+#include <string_view>
+#include <utility>
+#include <vector>
+
+namespace iptsd::core::linux::device {
+
+/*
+ * Keeps the last reports of a device in memory, so that they can be written to a capture.
+ *
+ * Feature reports (e.g. the metadata) are always kept, because they are only read once and
+ * the capture can't be replayed without them. Input reports are kept in a ring buffer that
+ * is allocated once. The runner reads reports directly into it, so recording costs nothing.
+ */
+class Journal : public hid::Device {
+private:
+	struct Record {
+		// When the report was received, relative to the creation of the journal.
+		u64 timestamp = 0;
+
+		// The data of the report. Only the first size bytes are valid.
+		std::vector<u8> data {};
+		usize size = 0;
+	};
+
+public:
+	/*
+	 * A copy of the recorded reports, that can be written while the device keeps being read.
+	 */
+	struct Snapshot {
+		// The IDs of the device that was recorded.
+		struct hidraw_devinfo devinfo {};
+
+		// The HID descriptor of the device that was recorded.
+		std::vector<u8> descriptor {};
+
+		// The feature reports, followed by the input reports, in the order they were read.
+		std::vector<Record> records {};
+
+		/*!
+		 * Writes the reports in the same format as iptsd-dump.
+		 *
+		 * @param[in] path The file to write to.
+		 */
+		void write(const std::filesystem::path &path) const
+		{
+			std::ofstream writer {};
+			writer.exceptions(std::ios::badbit | std::ios::failbit);
+			writer.open(path, std::ios::out | std::ios::binary);
+
+			const gsl::span<const u8> desc {this->descriptor};
+
+			common::write_to_stream(writer, CAPTURE_MAGIC);
+			common::write_to_stream(writer, CAPTURE_VERSION);
+			common::write_to_stream(writer, this->devinfo);
+			common::write_to_stream(writer, casts::to<u32>(desc.size()));
+			common::write_to_stream(writer, desc);
+
+			for (const Record &record : this->records)
+				write_record(writer, record);
+		}
+	};
+
+private:
+	// The device that is being recorded.
+	std::shared_ptr<hid::Device> m_device;
+
+	// The feature reports that were read from the device.
+	std::vector<Record> m_features {};
+
+	// The last input reports that were read from the device.
+	std::vector<Record> m_ring {};
+
+	// The index in the ring buffer that is overwritten next.
+	usize m_next = 0;
+
+	// How many input reports are stored in the ring buffer.
+	usize m_count = 0;
+
+	// When the journal was created.
+	chrono::steady_clock::time_point m_started = chrono::steady_clock::now();
+
+public:
+	Journal(std::shared_ptr<hid::Device> device) : m_device {std::move(device)} {};
+
+	/*!
+	 * Starts keeping the last input reports.
+	 *
+	 * @param[in] reports How many input reports are kept. 0 disables recording them.
+	 * @param[in] size The maximum size of a single report.
+	 */
+	void keep(const usize reports, const usize size)
+	{
+		m_ring.clear();
+		m_ring.resize(reports);
+
+		for (Record &record : m_ring)
+			record.data.resize(size);
+
+		m_next = 0;
+		m_count = 0;
+	}
+
+	/*!
+	 * Replaces the device that is being recorded, e.g. after it was connected again.
+	 *
+	 * The reports of the previous device are forgotten.
+	 *
+	 * @param[in] device The new device.
+	 */
+	void attach(std::shared_ptr<hid::Device> device)
+	{
+		m_device = std::move(device);
+		m_features.clear();
+
+		m_next = 0;
+		m_count = 0;
+	}
+
+	/*!
+	 * Copies the recorded reports, so that they can be written later.
+	 *
+	 * Only the valid part of every report is copied, the ring buffer stays as it is.
+	 *
+	 * @return The recorded reports, in the order they were read.
+	 */
+	[[nodiscard]] Snapshot snapshot() const
+	{
+		Snapshot snapshot {};
+		snapshot.devinfo.vendor = casts::to<i16>(m_device->vendor());
+		snapshot.devinfo.product = casts::to<i16>(m_device->product());
+
+		const gsl::span<u8> desc = m_device->raw_descriptor();
+		snapshot.descriptor.assign(desc.begin(), desc.end());
+
+		snapshot.records = m_features;
+
+		if (m_ring.empty())
+			return snapshot;
+
+		// Until the ring buffer is full, the oldest report is at the start.
+		const usize first = m_count < m_ring.size() ? 0 : m_next;
+
+		for (usize i = 0; i < m_count; i++) {
+			const Record &record = m_ring.at((first + i) % m_ring.size());
+			const auto end = record.data.begin() + casts::to_signed(record.size);
+
+			Record copy {};
+			copy.timestamp = record.timestamp;
+			copy.data.assign(record.data.begin(), end);
+			copy.size = record.size;
+
+			snapshot.records.push_back(std::move(copy));
+		}
+
+		return snapshot;
+	}
+
+	/*!
+	 * Writes the recorded reports in the same format as iptsd-dump.
+	 *
+	 * @param[in] path The file to write to.
+	 */
+	void write(const std::filesystem::path &path) const
+	{
+		this->snapshot().write(path);
+	}
+
+	std::string_view name() override
+	{
+		return m_device->name();
+	}
+
+	u16 vendor() override
+	{
+		return m_device->vendor();
+	}
+
+	u16 product() override
+	{
+		return m_device->product();
+	}
+
+	gsl::span<u8> raw_descriptor() override
+	{
+		return m_device->raw_descriptor();
+	}
+
+	/*!
+	 * Reads a report from the device, and keeps a copy of it.
+	 *
+	 * @param[in] buffer The target storage for the report.
+	 * @return The size of the report that was read in bytes.
+	 */
+	usize read(gsl::span<u8> buffer) override
+	{
+		const gsl::span<u8> data = this->read_next(buffer);
+
+		if (data.data() != buffer.data())
+			std::copy(data.begin(), data.end(), buffer.begin());
+
+		return data.size();
+	}
+
+	/*!
+	 * Reads a report from the device directly into the ring buffer, so it is not copied.
+	 *
+	 * The data stays valid until as many reports as the ring buffer holds were read.
+	 * If no input reports are kept, the report is read into the given buffer instead.
+	 *
+	 * @param[in] buffer The storage that is used if no input reports are kept.
+	 * @return The data of the report that was read.
+	 */
+	gsl::span<u8> read_next(gsl::span<u8> buffer)
+	{
+		if (m_ring.empty())
+			return buffer.subspan(0, m_device->read(buffer));
+
+		Record &record = m_ring.at(m_next);
+
+		// The reports of a device that was attached can be larger.
+		if (record.data.size() < buffer.size())
+			record.data.resize(buffer.size());
+
+		const usize size = m_device->read(record.data);
+
+		record.size = size;
+		record.timestamp = this->timestamp();
+
+		m_next = (m_next + 1) % m_ring.size();
+		m_count = std::min(m_count + 1, m_ring.size());
+
+		return gsl::span<u8> {record.data.data(), size};
+	}
+
+	/*!
+	 * Gets the data of a HID feature report, and keeps a copy of it.
+	 *
+	 * @param[in] report The report ID to get, followed by enough space to fit the data.
+	 */
+	void get_feature(gsl::span<u8> report) override
+	{
+		m_device->get_feature(report);
+
+		Record record {};
+		record.timestamp = this->timestamp();
+		record.data.assign(report.begin(), report.end());
+		record.size = report.size();
+
+		m_features.push_back(std::move(record));
+	}
+
+	void set_feature(gsl::span<u8> report) override
+	{
+		m_device->set_feature(report);
+	}
+
+private:
+	[[nodiscard]] u64 timestamp() const
+	{
+		const auto elapsed = chrono::steady_clock::now() - m_started;
+		return casts::to<u64>(chrono::duration_cast<nanoseconds<u64>>(elapsed).count());
+	}
+
+	static void write_record(std::ofstream &writer, const Record &record)
+	{
+		const gsl::span<const u8> data {record.data.data(), record.size};
+
+		common::write_to_stream(writer, record.timestamp);
+		common::write_to_stream(writer, casts::to<u64>(record.size));
+		common::write_to_stream(writer, data);
+	}
+};
+
+} // namespace iptsd::core::linux::device
+
+#endif // IPTSD_CORE_LINUX_DEVICE_JOURNAL_HPP
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_LINUX_DEVICE_TIMED_HIDRAW_HPP
+#define IPTSD_CORE_LINUX_DEVICE_TIMED_HIDRAW_HPP
+
+#include "../syscalls.hpp"
+#include "errors.hpp"
+#include "hidraw.hpp"
+
+#include <common/casts.hpp>
+#include <common/chrono.hpp>
+#include <common/error.hpp>
+#include <common/types.hpp>
+
+#include <gsl/gsl>
+
+#include <filesystem>
+#include <optional>
+
+namespace iptsd::core::linux::device {
+
+/*
+ * A hidraw device that stops delivering data once a deadline has passed.
+ *
+ * Devices only send data while they are used, so a normal read could block forever.
+ * Once the deadline has passed, the end of the data is signaled to the runner.
+ */
+class TimedHidraw : public Hidraw {
+private:
+	// When the device stops delivering data.
+	std::optional<chrono::steady_clock::time_point> m_deadline = std::nullopt;
+
+public:
+	TimedHidraw(const std::filesystem::path &path) : Hidraw {path} {};
+
+	/*!
+	 * Stops delivering data after the given time.
+	 *
+	 * @param[in] duration For how long data is read from now on.
+	 */
+	void set_duration(const seconds<f64> duration)
+	{
+		const auto now = chrono::steady_clock::now();
+		m_deadline = now + chrono::duration_cast<chrono::steady_clock::duration>(duration);
+	}
+
+	usize read(gsl::span<u8> buffer) override
+	{
+		if (!m_deadline.has_value())
+			return Hidraw::read(buffer);
+
+		while (true) {
+			const auto remaining = m_deadline.value() - chrono::steady_clock::now();
+			const auto ms = chrono::duration_cast<milliseconds<i64>>(remaining).count();
+
+			if (ms <= 0)
+				throw common::Error<Error::EndOfData> {};
+
+			if (this->wait(casts::to<int>(ms)))
+				return syscalls::read(m_fd, buffer);
+		}
+	}
+};
+
+} // namespace iptsd::core::linux::device
+
+#endif // IPTSD_CORE_LINUX_DEVICE_TIMED_HIDRAW_HPP
@@ -13,13 +13,22 @@ enum class Error : u8 {
 	ParsingFailed,
 	ParsingTypeNotImplemented,
 	RunnerInitError,
+	InvalidDeviceInfo,
+	HandshakeFailed,
+	InvalidLength,
+	InvalidSize,
+	InvalidStylusSerial,
 
 	SyscallOpenFailed,
 	SyscallReadFailed,
+	SyscallReadInterrupted,
 	SyscallWriteFailed,
+	SyscallWriteNoDevice,
 	SyscallCloseFailed,
 	SyscallIoctlFailed,
 	SyscallSigactionFailed,
+	SyscallPollFailed,
+	SyscallSocketFailed,
 };
 
 inline std::string format_as(Error err)
@@ -31,18 +40,36 @@ inline std::string format_as(Error err)
 		return "core: linux: Parsing not implemented for type {}!";
 	case Error::RunnerInitError:
 		return "core: linux: Runner initialization failed!";
+	case Error::InvalidDeviceInfo:
+		return "core: linux: Implausible device info: {}";
+	case Error::HandshakeFailed:
+		return "core: linux: The device did not answer after {} attempts!";
+	case Error::InvalidLength:
+		return "core: linux: [{}] {} = {} is not a valid length in cm or mm!";
+	case Error::InvalidSize:
+		return "core: linux: [{}] {} = {} is not a valid diameter or area in cm or mm!";
+	case Error::InvalidStylusSerial:
+		return "core: linux: [{}] Serials = {} is not a list of serial numbers!";
 	case Error::SyscallOpenFailed:
 		return "core: linux: Opening file {} failed: {}";
 	case Error::SyscallReadFailed:
 		return "core: linux: Reading from file failed: {}";
+	case Error::SyscallReadInterrupted:
+		return "core: linux: Reading from file was interrupted!";
 	case Error::SyscallWriteFailed:
 		return "core: linux: Writing to file failed: {}";
+	case Error::SyscallWriteNoDevice:
+		return "core: linux: Writing to file failed, the device is gone: {}";
 	case Error::SyscallCloseFailed:
 		return "core: linux: Closing file failed: {}";
 	case Error::SyscallIoctlFailed:
 		return "core: linux: IOCTL {} failed: {}";
 	case Error::SyscallSigactionFailed:
 		return "core: linux: Sigaction for signal {} failed: {}";
+	case Error::SyscallPollFailed:
+		return "core: linux: Polling file failed: {}";
+	case Error::SyscallSocketFailed:
+		return "core: linux: Socket operation {} failed: {}";
 	default:
 		return "core: linux: Invalid error code!";
 	}
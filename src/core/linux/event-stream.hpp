@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_LINUX_EVENT_STREAM_HPP
+#define IPTSD_CORE_LINUX_EVENT_STREAM_HPP
+
+#include "syscalls.hpp"
+
+#include <common/casts.hpp>
+#include <common/types.hpp>
+
+#include <spdlog/spdlog.h>
+
+#include <sys/socket.h>
+
+#include <exception>
+#include <filesystem>
+#include <functional>
+#include <string>
+#include <system_error>
+#include <vector>
+
+namespace iptsd::core::linux {
+
+/*
+ * Streams events to all clients that are connected to a unix socket.
+ *
+ * Every event is written as a single line of JSON. The stream never waits for a client:
+ * If a client doesn't read fast enough and its buffer is full, it is disconnected.
+ *
+ * New clients first receive a greeting, e.g. to describe the data that will follow.
+ */
+class EventStream {
+private:
+	// How many connections can wait for being accepted.
+	constexpr static int BACKLOG = 8;
+
+	// Where the socket was created.
+	std::filesystem::path m_path;
+
+	// The file descriptor of the listening socket.
+	int m_fd = -1;
+
+	// The file descriptors of the connected clients.
+	std::vector<int> m_clients {};
+
+public:
+	EventStream(const std::filesystem::path &path) : m_path {path}
+	{
+		// A socket that is left over from a previous run would make binding fail.
+		std::error_code ec {};
+		std::filesystem::remove(m_path, ec);
+
+		m_fd = syscalls::listen_unix(m_path, BACKLOG);
+		spdlog::info("Streaming events to {}", m_path.string());
+	}
+
+	EventStream(const EventStream &) = delete;
+	EventStream &operator=(const EventStream &) = delete;
+
+	~EventStream()
+	{
+		for (const int client : m_clients)
+			close(client);
+
+		close(m_fd);
+
+		std::error_code ec {};
+		std::filesystem::remove(m_path, ec);
+	}
+
+	/*!
+	 * Accepts all clients that connected since the last call.
+	 *
+	 * @param[in] greeting Creates the event that every new client receives first.
+	 */
+	void accept(const std::function<std::string()> &greeting)
+	{
+		while (true) {
+			const int client = syscalls::accept(m_fd);
+
+			if (client == -1)
+				break;
+
+			if (!send(client, greeting() + "\n")) {
+				close(client);
+				continue;
+			}
+
+			m_clients.push_back(client);
+		}
+	}
+
+	/*!
+	 * Whether any clients are connected.
+	 *
+	 * @return true if events are delivered to at least one client.
+	 */
+	[[nodiscard]] bool subscribed() const
+	{
+		return !m_clients.empty();
+	}
+
+	/*!
+	 * Sends an event to all connected clients.
+	 *
+	 * Clients that disconnected or can't accept the whole event are removed.
+	 *
+	 * @param[in] event The serialized event, without a trailing newline.
+	 */
+	void publish(const std::string &event)
+	{
+		const std::string line = event + "\n";
+
+		auto it = m_clients.begin();
+
+		while (it != m_clients.end()) {
+			if (send(*it, line)) {
+				it++;
+				continue;
+			}
+
+			spdlog::info("Disconnecting client from {}", m_path.string());
+
+			close(*it);
+			it = m_clients.erase(it);
+		}
+	}
+
+private:
+	/*!
+	 * Writes a line to a client, without waiting for it.
+	 *
+	 * @param[in] fd The socket of the client.
+	 * @param[in] line The data to write.
+	 * @return Whether the whole line was written.
+	 */
+	static bool send(const int fd, const std::string &line)
+	{
+		const isize ret = ::send(fd, line.data(), line.size(), MSG_DONTWAIT | MSG_NOSIGNAL);
+		return ret == casts::to_signed(line.size());
+	}
+
+	static void close(const int fd)
+	{
+		try {
+			syscalls::close(fd);
+		} catch (const std::exception & /* unused */) {
+			// ignored
+		}
+	}
+};
+
+} // namespace iptsd::core::linux
+
+#endif // IPTSD_CORE_LINUX_EVENT_STREAM_HPP
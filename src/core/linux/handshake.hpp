@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_LINUX_HANDSHAKE_HPP
+#define IPTSD_CORE_LINUX_HANDSHAKE_HPP
+
+#include "errors.hpp"
+
+#include <common/chrono.hpp>
+#include <common/error.hpp>
+#include <common/types.hpp>
+#include <hid/device.hpp>
+#include <ipts/device.hpp>
+
+#include <spdlog/spdlog.h>
+
+#include <exception>
+#include <future>
+#include <memory>
+#include <thread>
+#include <tuple>
+#include <utility>
+
+namespace iptsd::core::linux {
+
+/*
+ * How long the runner waits for the device to answer its first request.
+ *
+ * Right after booting, the firmware can take a long time to initialize. Until then, it might
+ * not answer requests at all.
+ */
+struct Handshake {
+	// How long to wait before a failed request is sent again. Doubles with every attempt.
+	constexpr static auto BACKOFF = 500ms;
+
+	// How long the device has to answer, before the request is tried again.
+	seconds<f64> timeout {10};
+
+	// How often the request is tried again, before giving up.
+	usize retries = 3;
+
+	/*!
+	 * Waits until the device answers requests.
+	 *
+	 * The metadata is requested from a separate thread, so that waiting for the answer can
+	 * time out. The answer itself is discarded, it is requested again once the device works.
+	 *
+	 * A request that failed is sent again after an increasing delay. A request that timed out
+	 * is still being processed by the kernel, so instead of sending it again, the runner keeps
+	 * waiting for it.
+	 *
+	 * @param[in] device The device that should answer.
+	 */
+	void wait(const std::shared_ptr<hid::Device> &device) const
+	{
+		const usize attempts = this->retries + 1;
+
+		std::future<void> request {};
+		auto backoff = BACKOFF;
+
+		for (usize attempt = 1; attempt <= attempts; attempt++) {
+			if (!request.valid()) {
+				// The thread shares the device, in case it outlives the runner.
+				std::packaged_task<void()> task {[device]() {
+					const ipts::Device ipts {device};
+					std::ignore = ipts.metadata();
+				}};
+
+				request = task.get_future();
+				std::thread {std::move(task)}.detach();
+			}
+
+			if (request.wait_for(this->timeout) == std::future_status::ready) {
+				try {
+					request.get();
+					return;
+				} catch (const std::exception &e) {
+					spdlog::warn("Handshake {} of {} failed: {}",
+					             attempt,
+					             attempts,
+					             e.what());
+				}
+			} else {
+				spdlog::warn("Device did not answer within {}s ({} of {})",
+				             this->timeout.count(),
+				             attempt,
+				             attempts);
+			}
+
+			if (attempt == attempts)
+				break;
+
+			std::this_thread::sleep_for(backoff);
+			backoff *= 2;
+		}
+
+		throw common::Error<Error::HandshakeFailed> {attempts};
+	}
+};
+
+} // namespace iptsd::core::linux
+
+#endif // IPTSD_CORE_LINUX_HANDSHAKE_HPP
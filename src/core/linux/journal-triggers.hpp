@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_LINUX_JOURNAL_TRIGGERS_HPP
+#define IPTSD_CORE_LINUX_JOURNAL_TRIGGERS_HPP
+
+#include <common/types.hpp>
+
+#include <fmt/format.h>
+
+#include <optional>
+#include <set>
+#include <string>
+
+namespace iptsd::core::linux {
+
+/*
+ * Decides when the journal is written because of something the application detected.
+ *
+ * The application reports anomalies and rejected buffers while it processes a buffer.
+ * They are collected here, and the runner writes the journal once the buffer is done.
+ * Every reason is only written once, subsequent ones are usually caused by the same problem.
+ */
+class JournalTriggers {
+public:
+	/*
+	 * A journal that should be written.
+	 */
+	struct Trigger {
+		// Why the journal is written, this becomes part of its name.
+		std::string reason {};
+
+		// What was detected, for the log.
+		std::string message {};
+
+		// How many journals the directory can contain before this one is dropped.
+		usize limit = 0;
+	};
+
+private:
+	// How many journals can be in the journal directory before anomalies are not written.
+	usize m_limit = 0;
+
+	// The last anomaly that was reported by the application, and not written yet.
+	std::optional<std::string> m_anomaly = std::nullopt;
+
+	// Whether the application rejected a buffer that the journal wasn't written for yet.
+	bool m_invalid = false;
+
+	// The reasons that journals were written for already.
+	std::set<std::string> m_written {};
+
+public:
+	/*!
+	 * Also writes the journal for anomalies, until the directory contains some journals.
+	 *
+	 * @param[in] limit How many journals the directory can contain. 0 disables this.
+	 */
+	void set_limit(const usize limit)
+	{
+		m_limit = limit;
+	}
+
+	/*!
+	 * Registers an anomaly that was reported by the application.
+	 *
+	 * Only the first anomaly of a buffer is kept.
+	 *
+	 * @param[in] name The name of the check that detected the anomaly.
+	 */
+	void anomaly(const std::string &name)
+	{
+		if (m_limit > 0 && !m_anomaly.has_value())
+			m_anomaly = name;
+	}
+
+	/*!
+	 * Registers a buffer that the application rejected.
+	 *
+	 * Unlike anomalies, this doesn't depend on the limit, since the buffer is lost or stops
+	 * the runner.
+	 */
+	void invalid()
+	{
+		m_invalid = true;
+	}
+
+	/*!
+	 * Takes the next journal that should be written.
+	 *
+	 * @return The journal, if one was triggered for a reason that wasn't written yet.
+	 */
+	std::optional<Trigger> next()
+	{
+		if (m_invalid) {
+			m_invalid = false;
+
+			if (m_written.insert("parse_error").second)
+				return Trigger {"parse_error", "Failed to parse a buffer", 0};
+		}
+
+		if (!m_anomaly.has_value())
+			return std::nullopt;
+
+		const std::string anomaly = m_anomaly.value();
+		m_anomaly.reset();
+
+		if (!m_written.insert(anomaly).second)
+			return std::nullopt;
+
+		return Trigger {anomaly, fmt::format("Detected anomaly {}", anomaly), m_limit};
+	}
+};
+
+} // namespace iptsd::core::linux
+
+#endif // IPTSD_CORE_LINUX_JOURNAL_TRIGGERS_HPP
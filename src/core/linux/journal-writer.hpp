@@ -0,0 +1,224 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_LINUX_JOURNAL_WRITER_HPP
+#define IPTSD_CORE_LINUX_JOURNAL_WRITER_HPP
+
+#include "device/journal.hpp"
+
+#include <common/types.hpp>
+
+#include <spdlog/spdlog.h>
+
+#include <condition_variable>
+#include <csignal>
+#include <deque>
+#include <exception>
+#include <filesystem>
+#include <fstream>
+#include <limits>
+#include <memory>
+#include <mutex>
+#include <pthread.h>
+#include <string>
+#include <thread>
+#include <utility>
+
+namespace iptsd::core::linux {
+
+/*
+ * Writes journals to the disk without blocking the thread that reads from the device.
+ *
+ * Writing a journal can take a while on slow storage, and every buffer that arrives in the
+ * meantime would be lost. The reports and the state are copied by the runner, and the files
+ * are written on a separate thread.
+ */
+class JournalWriter {
+public:
+	/*
+	 * A journal that should be written.
+	 */
+	struct Job {
+		// The directory that the journal is written to.
+		std::filesystem::path dir {};
+
+		// The name of the files, without their extension.
+		std::string name {};
+
+		// The reports that are written to the capture.
+		device::Journal::Snapshot capture {};
+
+		// The state of the application, as JSON.
+		std::string state {};
+
+		// Drops the journal if the directory has this many already. 0 disables this.
+		usize limit = 0;
+	};
+
+private:
+	/*
+	 * The state that is shared with the thread that writes the journals.
+	 *
+	 * The thread is detached, because it can't be interrupted while it writes a file.
+	 */
+	struct Queue {
+		std::mutex mutex {};
+		std::condition_variable wakeup {};
+
+		// Whether the thread should stop.
+		bool should_stop = false;
+
+		// Whether the thread is writing a journal right now.
+		bool busy = false;
+
+		// The journals that were not written yet.
+		std::deque<Job> jobs {};
+	};
+
+	// The state of the thread that writes the journals, once it was started.
+	std::shared_ptr<Queue> m_queue = nullptr;
+
+public:
+	JournalWriter() = default;
+
+	JournalWriter(const JournalWriter &) = delete;
+	JournalWriter &operator=(const JournalWriter &) = delete;
+
+	~JournalWriter()
+	{
+		if (!m_queue)
+			return;
+
+		const std::lock_guard<std::mutex> lock {m_queue->mutex};
+
+		m_queue->should_stop = true;
+		m_queue->wakeup.notify_all();
+	}
+
+	/*!
+	 * Asks the thread to write a journal.
+	 *
+	 * The first call starts the thread, it never blocks.
+	 *
+	 * @param[in] job The journal to write.
+	 */
+	void write(Job job)
+	{
+		if (!m_queue) {
+			m_queue = std::make_shared<Queue>();
+
+			std::thread thread {run, m_queue};
+			thread.detach();
+		}
+
+		const std::lock_guard<std::mutex> lock {m_queue->mutex};
+
+		m_queue->jobs.push_back(std::move(job));
+		m_queue->wakeup.notify_all();
+	}
+
+	/*!
+	 * Waits until all journals that were requested so far are written.
+	 *
+	 * This should be called before the process exits, so that no journal is lost.
+	 */
+	void flush() const
+	{
+		if (!m_queue)
+			return;
+
+		std::unique_lock<std::mutex> lock {m_queue->mutex};
+
+		m_queue->wakeup.wait(lock, [&] { return m_queue->jobs.empty() && !m_queue->busy; });
+	}
+
+private:
+	/*!
+	 * Writes the journals that are requested, until it is asked to stop.
+	 *
+	 * @param[in] queue The state that is shared with the writer.
+	 */
+	static void run(const std::shared_ptr<Queue> &queue)
+	{
+		// Signals should be handled by the main thread, which reads from the device.
+		sigset_t signals {};
+		sigfillset(&signals);
+		pthread_sigmask(SIG_BLOCK, &signals, nullptr);
+
+		std::unique_lock<std::mutex> lock {queue->mutex};
+
+		while (!queue->should_stop) {
+			if (queue->jobs.empty()) {
+				queue->wakeup.wait(lock);
+				continue;
+			}
+
+			const Job job = std::move(queue->jobs.front());
+			queue->jobs.pop_front();
+			queue->busy = true;
+
+			lock.unlock();
+
+			write_job(job);
+
+			lock.lock();
+
+			queue->busy = false;
+			queue->wakeup.notify_all();
+		}
+	}
+
+	/*!
+	 * Writes the capture and the state of a journal.
+	 *
+	 * @param[in] job The journal to write.
+	 */
+	static void write_job(const Job &job)
+	{
+		if (job.limit > 0 && count_journals(job.dir) >= job.limit)
+			return;
+
+		const std::filesystem::path capture = job.dir / (job.name + ".bin");
+		const std::filesystem::path state = job.dir / (job.name + ".json");
+
+		try {
+			job.capture.write(capture);
+
+			std::ofstream file {state};
+			file << job.state << "\n";
+		} catch (const std::exception &e) {
+			spdlog::error("Failed to write {}: {}", capture.string(), e.what());
+			return;
+		}
+
+		spdlog::info("Wrote the last buffers to {}", capture.string());
+	}
+
+	/*!
+	 * Counts the journals that were written to a directory so far.
+	 *
+	 * @param[in] dir The directory that the journals are written to.
+	 * @return How many captures are in the directory.
+	 */
+	static usize count_journals(const std::filesystem::path &dir)
+	{
+		usize count = 0;
+
+		try {
+			const std::filesystem::directory_iterator files {dir};
+
+			for (const auto &entry : files) {
+				if (entry.path().extension() == ".bin")
+					count++;
+			}
+		} catch (const std::exception &e) {
+			spdlog::warn("Failed to read {}: {}", dir.string(), e.what());
+			return std::numeric_limits<usize>::max();
+		}
+
+		return count;
+	}
+};
+
+} // namespace iptsd::core::linux
+
+#endif // IPTSD_CORE_LINUX_JOURNAL_WRITER_HPP
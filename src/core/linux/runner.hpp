@@ -5,21 +5,39 @@
 
 #include "config-loader.hpp"
 #include "device/errors.hpp"
+#include "device/hidraw.hpp"
+#include "device/journal.hpp"
 #include "errors.hpp"
-
+#include "event-stream.hpp"
+#include "handshake.hpp"
+#include "journal-triggers.hpp"
+#include "journal-writer.hpp"
+#include "validation.hpp"
+#include "wakeup.hpp"
+
+#include <common/buildopts.hpp>
 #include <common/casts.hpp>
 #include <common/chrono.hpp>
 #include <common/error.hpp>
+#include <common/json.hpp>
 #include <core/generic/application.hpp>
+#include <core/generic/commands.hpp>
 #include <ipts/device.hpp>
 
+#include <fmt/ranges.h>
 #include <spdlog/spdlog.h>
 
 #include <atomic>
+#include <exception>
 #include <filesystem>
+#include <fstream>
+#include <functional>
 #include <memory>
+#include <optional>
+#include <string>
 #include <thread>
 #include <type_traits>
+#include <utility>
 #include <vector>
 
 namespace iptsd::core::linux {
@@ -37,16 +55,60 @@ private:
 	static_assert(std::is_base_of_v<Application, App>);
 	static_assert(std::is_base_of_v<hid::Device, Device>);
 
+private:
+	// How often the device node is checked while it is gone.
+	constexpr static auto RECONNECT_INTERVAL = 500ms;
+
 private:
 	// The hidraw device serving as the source of data.
 	std::shared_ptr<hid::Device> m_device;
 
+	// Keeps the last reports of the device, to write them to a capture after a fatal error.
+	std::shared_ptr<device::Journal> m_journal;
+
 	// The IPTS touchscreen interface
 	ipts::Device m_ipts;
 
 	// Whether the loop for reading from the device should stop.
 	std::atomic_bool m_should_stop = false;
 
+	// The commands that were sent to the application and wait for the next buffer.
+	CommandQueue m_commands {};
+
+	// Wakes up the loop while it waits for the device, once a command was sent.
+	Wakeup m_wakeup {};
+
+	// Where the state of the application is written to.
+	std::filesystem::path m_state_file {};
+
+	// Where the journal is written to after a fatal error. If empty, it is not written.
+	std::filesystem::path m_journal_dir {};
+
+	// Decides when the journal is written because of something the application detected.
+	JournalTriggers m_triggers {};
+
+	// Writes the journals, without blocking the loop that reads from the device.
+	JournalWriter m_writer {};
+
+	// The socket that processed events are streamed to, if enabled.
+	std::optional<EventStream> m_events = std::nullopt;
+
+	// The path of the device that is being read from.
+	std::filesystem::path m_path;
+
+	// The device, as it was detected. The metadata is kept, even if the config ignores it.
+	// The vendor and product ID are used to find the device again after it disappeared.
+	DeviceInfo m_info {};
+
+	// The config files that were loaded for the device.
+	std::vector<std::string> m_config_files {};
+
+	// How often the device disappeared and was connected again.
+	u64 m_reconnects = 0;
+
+	// Checks the device info and the config, before the application is created.
+	DeviceValidator m_validator {};
+
 	// The target buffer for reading HID reports.
 	std::vector<u8> m_buffer {};
 
@@ -57,27 +119,60 @@ private:
 	// The application that is being executed.
 	std::optional<App> m_application = std::nullopt;
 
+	// Creates the application, with the arguments that were passed to the runner.
+	std::function<void(const Config &, const DeviceInfo &)> m_create {};
+
+	// Configures the application every time it was created.
+	std::function<void(App &)> m_setup {};
+
 public:
 	template <class... Args>
 	Runner(const std::filesystem::path &path, Args... args)
+		: Runner {path, Handshake {}, args...} {};
+
+	template <class... Args>
+	Runner(const std::filesystem::path &path, const Handshake &handshake, Args... args)
 		: m_device {std::make_shared<Device>(path)},
-		  m_ipts {m_device}
+		  m_journal {std::make_shared<device::Journal>(m_device)},
+		  m_ipts {m_journal},
+		  m_path {path}
 	{
-		DeviceInfo info {};
-		info.vendor = m_device->vendor();
-		info.product = m_device->product();
-		info.type = m_ipts.type();
-		info.meta = m_ipts.metadata();
+		spdlog::info("iptsd {}", common::buildopts::Version);
+
+		handshake.wait(m_device);
+		this->connect_wakeup();
+
+		m_info.vendor = m_device->vendor();
+		m_info.product = m_device->product();
+		m_info.type = m_ipts.type();
+		m_info.meta = m_validator.query(m_ipts);
+
+		m_create = [this, args...](const Config &config, const DeviceInfo &info) {
+			m_application.emplace(config, info, args...);
+		};
+
+		const ConfigLoader loader = this->load_config();
+		const Config config = loader.config();
 
-		const ConfigLoader loader {info};
-		m_application.emplace(loader.config(), info, args...);
+		for (const std::filesystem::path &file : loader.files())
+			m_config_files.push_back(file.string());
+
+		this->create(config);
+
+		const DeviceInfo info = this->effective_info(config);
 
 		m_buffer.resize(m_ipts.buffer_size());
 
 		const u16 vendor = info.vendor;
 		const u16 product = info.product;
 
-		spdlog::info("Connected to device {:04X}:{:04X}", vendor, product);
+		spdlog::info("Connected to device {:04X}:{:04X} ({})",
+		             vendor,
+		             product,
+		             m_path.string());
+
+		if (info.meta.has_value())
+			spdlog::info("Heatmap size is {}x{}", info.meta->columns, info.meta->rows);
 
 		switch (info.type) {
 		case ipts::Device::Type::Touchscreen:
@@ -87,6 +182,11 @@ public:
 			spdlog::info("Running in Touchpad mode");
 			break;
 		}
+
+		const std::vector<std::string> quirks = m_application->quirks();
+
+		if (!quirks.empty())
+			spdlog::info("Active quirks: {}", fmt::join(quirks, ", "));
 	}
 
 	/*!
@@ -104,6 +204,18 @@ public:
 		return m_application.value();
 	}
 
+	/*!
+	 * The device that is being read from.
+	 *
+	 * Can be used to configure how the data source behaves.
+	 *
+	 * @return A reference to the HID data source.
+	 */
+	Device &device()
+	{
+		return static_cast<Device &>(*m_device);
+	}
+
 	/*!
 	 * Stops the loop that reads from the device.
 	 *
@@ -112,6 +224,83 @@ public:
 	void stop()
 	{
 		m_should_stop = true;
+		m_wakeup.notify();
+	}
+
+	/*!
+	 * Sends a command to the application.
+	 *
+	 * Commands are executed by the loop that reads from the device, between two buffers.
+	 * This way the application never has to be accessed from outside of the loop.
+	 * If the loop is waiting for the device, it is woken up to execute the command.
+	 * This function is designed to be called from a signal handler or another thread.
+	 *
+	 * @param[in] command The command to execute.
+	 */
+	void send(const Command command)
+	{
+		m_commands.push(command);
+		m_wakeup.notify();
+	}
+
+	/*!
+	 * Configures the application, now and every time it is created again by a reload.
+	 *
+	 * @param[in] setup The function that configures the application.
+	 */
+	void set_setup(const std::function<void(App &)> &setup)
+	{
+		m_setup = setup;
+		m_setup(this->application());
+	}
+
+	/*!
+	 * Sets the file that the state of the application is written to by @ref Command::DumpState.
+	 *
+	 * @param[in] path The path of the file.
+	 */
+	void set_state_file(const std::filesystem::path &path)
+	{
+		m_state_file = path;
+	}
+
+	/*!
+	 * Keeps the last buffers of the device, to help with debugging fatal errors.
+	 *
+	 * If a buffer can't be parsed, or the runner gives up because of too many errors, the
+	 * buffers are written to a capture in the directory, that can be replayed with the other
+	 * tools. The state of the application is written next to it.
+	 *
+	 * @param[in] dir The directory that the journal is written to.
+	 * @param[in] buffers How many buffers are kept.
+	 */
+	void set_journal(const std::filesystem::path &dir, const usize buffers)
+	{
+		m_journal_dir = dir;
+		m_journal->keep(buffers, m_buffer.size());
+	}
+
+	/*!
+	 * Also writes the journal when the application detects something unusual in the data.
+	 *
+	 * Every kind of anomaly is only written once. Once the journal directory contains
+	 * the given number of journals, no more journals are written for anomalies.
+	 *
+	 * @param[in] limit How many journals the directory can contain. 0 disables this.
+	 */
+	void set_anomaly_limit(const usize limit)
+	{
+		m_triggers.set_limit(limit);
+	}
+
+	/*!
+	 * Streams the processed events of the application to clients of a unix socket.
+	 *
+	 * @param[in] path Where the socket is created.
+	 */
+	void set_event_socket(const std::filesystem::path &path)
+	{
+		m_events.emplace(path);
 	}
 
 	/*!
@@ -131,28 +320,50 @@ public:
 		// Signal the application that the data flow has started.
 		m_application->on_start();
 
+		using Interrupted = common::Error<Error::SyscallReadInterrupted>;
+
 		usize errors = 0;
 
 		while (!m_should_stop) {
+			// Commands that arrive after this will wake up the next wait again.
+			m_wakeup.clear();
+
+			m_commands.drain([&](const Command command) { this->execute(command); });
+			this->write_triggered();
+
+			if (m_events.has_value())
+				this->update_subscribers();
+
 			if (errors >= 50) {
 				spdlog::error("Encountered 50 continuous errors, aborting...");
+				this->write_journal("errors");
 				break;
 			}
 
 			try {
-				const usize size = m_device->read(m_buffer);
-				const gsl::span<u8> data {m_buffer.data(), size};
+				const gsl::span<u8> data = m_journal->read_next(m_buffer);
 
 				// Does this report contain touch data?
-				if (!m_ipts.is_touch_data(m_buffer))
+				if (!m_ipts.is_touch_data(data))
 					continue;
 
 				m_application->process(data);
 			} catch (const common::Error<device::Error::EndOfData> & /* unused */) {
 				break;
+			} catch (const Interrupted & /* unused */) {
+				// A command or a signal arrived while waiting for the device.
+				continue;
 			} catch (const std::exception &e) {
 				spdlog::warn(e.what());
 
+				// The node is gone while the driver is reloaded or the bus resets.
+				if (!std::filesystem::exists(m_path)) {
+					this->reconnect();
+
+					errors = 0;
+					continue;
+				}
+
 				// Sleep for a moment to let the device get back into normal state.
 				std::this_thread::sleep_for(100ms);
 
@@ -174,8 +385,369 @@ public:
 			spdlog::error(e.what());
 		}
 
+		// The process might exit after this, so the journals have to be on the disk.
+		m_writer.flush();
+
 		return m_should_stop;
 	}
+
+private:
+	/*!
+	 * Tells the device to stop or resume sending touch data.
+	 *
+	 * @param[in] enabled Whether the device should send touch data.
+	 * @return Whether the device supports this and accepted the request.
+	 */
+	bool set_hardware_touch(const bool enabled) const
+	{
+		try {
+			return m_ipts.set_touch(enabled);
+		} catch (const std::exception &e) {
+			spdlog::warn(e.what());
+			return false;
+		}
+	}
+
+	/*!
+	 * Lets commands interrupt the wait for the next report of the device.
+	 *
+	 * Other sources of data, like captures, never wait, so they don't need this.
+	 */
+	void connect_wakeup()
+	{
+		if constexpr (std::is_base_of_v<device::Hidraw, Device>)
+			this->device().set_wakeup(m_wakeup.fd());
+	}
+
+	/*!
+	 * Waits for the device to return and opens it again.
+	 *
+	 * The application and the devices it created are kept, so that clients don't notice
+	 * that the device was gone. Commands are still executed in the meantime.
+	 */
+	void reconnect()
+	{
+		spdlog::warn("{} disappeared, waiting for it to return", m_path.string());
+
+		m_application->lift();
+
+		while (!m_should_stop) {
+			m_commands.drain([&](const Command command) { this->execute(command); });
+			std::this_thread::sleep_for(RECONNECT_INTERVAL);
+
+			for (const std::filesystem::path &path : this->candidates()) {
+				if (!this->open(path))
+					continue;
+
+				m_reconnects++;
+				spdlog::info("Reconnected to {}", path.string());
+
+				m_path = path;
+				m_application->settle();
+
+				return;
+			}
+		}
+	}
+
+	/*!
+	 * Lists the nodes that the device can return on.
+	 *
+	 * The kernel can assign a different node to the device when it returns (e.g. hidraw3
+	 * instead of hidraw2), so all nodes of the same kind are candidates. The previous node
+	 * comes first.
+	 *
+	 * @return The paths of the nodes that exist.
+	 */
+	[[nodiscard]] std::vector<std::filesystem::path> candidates() const
+	{
+		std::vector<std::filesystem::path> paths {};
+
+		if (std::filesystem::exists(m_path))
+			paths.push_back(m_path);
+
+		// The kind of the node is its name without the trailing number.
+		std::string kind = m_path.filename().string();
+		kind.erase(kind.find_last_not_of("0123456789") + 1);
+
+		std::error_code ec {};
+		const std::filesystem::directory_iterator end {};
+
+		std::filesystem::directory_iterator it {m_path.parent_path(), ec};
+
+		for (; !ec && it != end; it.increment(ec)) {
+			const std::filesystem::path &path = it->path();
+
+			if (path != m_path && path.filename().string().rfind(kind, 0) == 0)
+				paths.push_back(path);
+		}
+
+		return paths;
+	}
+
+	/*!
+	 * Opens a node, if it belongs to the device that disappeared.
+	 *
+	 * @param[in] path The node to open.
+	 * @return Whether the node belongs to the device and was opened.
+	 */
+	bool open(const std::filesystem::path &path)
+	{
+		// The node can appear before the device is ready, so failures are retried.
+		try {
+			const auto device = std::make_shared<Device>(path);
+
+			const bool vendor = device->vendor() == m_info.vendor;
+			const bool product = device->product() == m_info.product;
+
+			if (!vendor || !product)
+				return false;
+
+			m_device = device;
+			m_journal->attach(m_device);
+			this->connect_wakeup();
+			m_ipts = ipts::Device {m_journal};
+
+			m_ipts.set_mode(ipts::Device::Mode::Multitouch);
+			m_buffer.resize(m_ipts.buffer_size());
+		} catch (const std::exception &e) {
+			spdlog::debug(e.what());
+			return false;
+		}
+
+		return true;
+	}
+
+	/*!
+	 * Accepts new clients of the event stream.
+	 *
+	 * New clients first receive the geometry of the heatmap, so that they can map contacts
+	 * to the screen. The application only serializes events while a client is connected.
+	 */
+	void update_subscribers()
+	{
+		try {
+			m_events->accept([&]() { return m_application->geometry().str(); });
+		} catch (const std::exception &e) {
+			spdlog::warn(e.what());
+		}
+
+		if (!m_events->subscribed()) {
+			m_application->publish = nullptr;
+			return;
+		}
+
+		if (m_application->publish)
+			return;
+
+		m_application->publish = [&](const common::Json &event) {
+			m_events->publish(event.str());
+		};
+	}
+
+	/*!
+	 * Executes a command that was sent to the application.
+	 *
+	 * @param[in] command The command to execute.
+	 */
+	void execute(const Command command)
+	{
+		try {
+			if (command == Command::DumpState)
+				this->write_state();
+			else if (command == Command::Reload)
+				this->reload();
+			else
+				m_application->on_command(command);
+		} catch (const std::exception &e) {
+			spdlog::warn(e.what());
+		}
+	}
+
+	/*!
+	 * Loads the config for the device.
+	 *
+	 * The metadata provides the defaults of the config, so if the config ignores the
+	 * metadata, it has to be loaded again without it.
+	 *
+	 * @return The loader, with the config and the files that it was loaded from.
+	 */
+	[[nodiscard]] ConfigLoader load_config() const
+	{
+		ConfigLoader loader {m_info};
+
+		if (!loader.config().ignore_metadata || !m_info.meta.has_value())
+			return loader;
+
+		spdlog::info("Ignoring device metadata, as requested by the config");
+		return ConfigLoader {this->effective_info(loader.config())};
+	}
+
+	/*!
+	 * The device, as the application sees it with a config.
+	 *
+	 * @param[in] config The config of the application.
+	 * @return The device, without its metadata if the config ignores it.
+	 */
+	[[nodiscard]] DeviceInfo effective_info(const Config &config) const
+	{
+		DeviceInfo info = m_info;
+
+		if (config.ignore_metadata)
+			info.meta = std::nullopt;
+
+		return info;
+	}
+
+	/*!
+	 * Creates the application and connects it to the runner.
+	 *
+	 * @param[in] config The config of the application.
+	 */
+	void create(const Config &config)
+	{
+		const DeviceInfo info = this->effective_info(config);
+
+		m_validator.validate(info, config);
+		m_create(config, info);
+
+		m_application->set_hardware_touch = [&](const bool enabled) {
+			return this->set_hardware_touch(enabled);
+		};
+		m_application->report_anomaly = [&](const std::string &name) {
+			m_triggers.anomaly(name);
+		};
+		m_application->report_invalid = [&]() { m_triggers.invalid(); };
+
+		if (m_setup)
+			m_setup(m_application.value());
+	}
+
+	/*!
+	 * Loads the config again and creates the application again with it.
+	 *
+	 * The devices of the application are created again too, so clients will notice.
+	 * If the application can't be created with the new config, the previous one is kept.
+	 */
+	void reload()
+	{
+		const ConfigLoader loader = this->load_config();
+		const Config config = loader.config();
+		const Config previous = m_application->config();
+
+		// The devices of the application are removed, they must not keep any inputs active.
+		m_application->lift();
+		m_application->on_stop();
+		m_application.reset();
+
+		try {
+			this->create(config);
+		} catch (const std::exception &e) {
+			spdlog::warn("Failed to reload the config, keeping the previous one: {}",
+			             e.what());
+
+			this->create(previous);
+			m_application->on_start();
+
+			return;
+		}
+
+		m_config_files.clear();
+
+		for (const std::filesystem::path &file : loader.files())
+			m_config_files.push_back(file.string());
+
+		m_application->on_start();
+		spdlog::info("Reloaded the config");
+	}
+
+	/*!
+	 * Collects the state of the application and of the runner.
+	 *
+	 * @return A JSON object describing the current state.
+	 */
+	[[nodiscard]] common::Json state() const
+	{
+		common::Json runner {};
+		runner.add("path", m_path.string())
+			.add("config_files", m_config_files)
+			.add("reconnects", m_reconnects);
+
+		common::Json state = m_application->state();
+		state.add("runner", runner);
+
+		return state;
+	}
+
+	/*!
+	 * Writes the state of the application to the state file.
+	 */
+	void write_state() const
+	{
+		if (m_state_file.empty())
+			return;
+
+		std::ofstream file {m_state_file};
+		file << this->state().str() << "\n";
+
+		if (!file) {
+			spdlog::warn("Failed to write state to {}", m_state_file.string());
+			return;
+		}
+
+		spdlog::info("Wrote state to {}", m_state_file.string());
+	}
+
+	/*!
+	 * Writes the journal if the application detected something while processing the buffer.
+	 */
+	void write_triggered()
+	{
+		const std::optional<JournalTriggers::Trigger> trigger = m_triggers.next();
+
+		if (!trigger.has_value() || m_journal_dir.empty())
+			return;
+
+		spdlog::info("{}, writing journal", trigger->message);
+		this->write_journal(trigger->reason, trigger->limit);
+	}
+
+	/*!
+	 * Writes the last buffers and the state of the application to the journal directory.
+	 *
+	 * The buffers and the state are copied right away, the files are written in the
+	 * background so that no buffers are lost in the meantime.
+	 *
+	 * @param[in] reason Why the journal is written, e.g. the name of an anomaly.
+	 * @param[in] limit How many journals the directory can contain before this one is
+	 *                  dropped. 0 disables this.
+	 */
+	void write_journal(const std::string &reason, const usize limit = 0)
+	{
+		if (m_journal_dir.empty())
+			return;
+
+		const auto now = chrono::system_clock::now().time_since_epoch();
+		const usize unix = chrono::duration_cast<seconds<usize>>(now).count();
+
+		const std::string name = fmt::format("iptsd_{:04X}_{:04X}_{}_{}",
+		                                     m_device->vendor(),
+		                                     m_device->product(),
+		                                     unix,
+		                                     reason);
+
+		common::Json state = this->state();
+		state.add("reason", reason);
+
+		JournalWriter::Job job {};
+		job.dir = m_journal_dir;
+		job.name = name;
+		job.capture = m_journal->snapshot();
+		job.state = state.str();
+		job.limit = limit;
+
+		m_writer.write(std::move(job));
+	}
 };
 
 } // namespace iptsd::core::linux
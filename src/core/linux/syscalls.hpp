@@ -12,12 +12,22 @@
 #include <gsl/gsl>
 
 #include <linux/input.h>
+#include <netdb.h>
+#include <poll.h>
+#include <sys/eventfd.h>
 #include <sys/ioctl.h>
+#include <sys/socket.h>
+#include <sys/un.h>
 
+#include <algorithm>
+#include <array>
 #include <cerrno>
 #include <csignal> // IWYU pragma: keep
+#include <cstring>
 #include <fcntl.h>
 #include <filesystem>
+#include <iterator>
+#include <string>
 #include <system_error>
 #include <unistd.h>
 
@@ -50,6 +60,9 @@ template <class T>
 inline usize read(const int fd, gsl::span<T> dest)
 {
 	const isize ret = ::read(fd, dest.data(), dest.size_bytes());
+	if (ret == -1 && errno == EINTR)
+		throw common::Error<Error::SyscallReadInterrupted> {};
+
 	if (ret == -1)
 		throw common::Error<Error::SyscallReadFailed> {impl::last_error()};
 
@@ -66,6 +79,9 @@ template <class T>
 inline usize write(const int fd, const gsl::span<T> data)
 {
 	const isize ret = ::write(fd, data.data(), data.size_bytes());
+	if (ret == -1 && errno == ENODEV)
+		throw common::Error<Error::SyscallWriteNoDevice> {impl::last_error()};
+
 	if (ret == -1)
 		throw common::Error<Error::SyscallWriteFailed> {impl::last_error()};
 
@@ -78,6 +94,21 @@ inline usize write(const int fd, const T &data)
 	return write(fd, gsl::span {&data, 1});
 }
 
+/*!
+ * Creates an eventfd, that can be used to wake up a thread that is waiting in @ref poll.
+ *
+ * @param[in] flags The flags of the eventfd, e.g. EFD_NONBLOCK.
+ * @return The file descriptor of the eventfd.
+ */
+inline int eventfd(const int flags)
+{
+	const int ret = ::eventfd(0, flags);
+	if (ret == -1)
+		throw common::Error<Error::SyscallOpenFailed> {"eventfd", impl::last_error()};
+
+	return ret;
+}
+
 inline int close(const int fd)
 {
 	const int ret = ::close(fd);
@@ -107,6 +138,188 @@ inline int sigaction(const int sig, const struct sigaction *act, struct sigactio
 	return ret;
 }
 
+/*!
+ * Waits until one of multiple file descriptors becomes readable.
+ *
+ * @param[in,out] fds The file descriptors and their events. The returned events are set.
+ * @param[in] timeout How long to wait at most, in milliseconds.
+ * @return Whether any of them is ready. Returns false if the call was interrupted by a signal.
+ */
+inline bool poll(const gsl::span<struct pollfd> fds, const int timeout)
+{
+	const int ret = ::poll(fds.data(), fds.size(), timeout);
+	if (ret == -1 && errno == EINTR)
+		return false;
+
+	if (ret == -1)
+		throw common::Error<Error::SyscallPollFailed> {impl::last_error()};
+
+	return ret > 0;
+}
+
+/*!
+ * Waits until a file descriptor becomes readable.
+ *
+ * @param[in] fd The file descriptor to wait for.
+ * @param[in] timeout How long to wait at most, in milliseconds.
+ * @return Whether data is available. Returns false if the call was interrupted by a signal.
+ */
+inline bool poll(const int fd, const int timeout)
+{
+	std::array<struct pollfd, 1> fds {};
+	fds[0].fd = fd;
+	fds[0].events = POLLIN;
+
+	return poll(gsl::span {fds}, timeout);
+}
+
+/*!
+ * Creates a unix stream socket that is listening on a path.
+ *
+ * @param[in] path Where the socket is created.
+ * @param[in] backlog How many connections can be pending at the same time.
+ * @return The file descriptor of the listening socket.
+ */
+inline int listen_unix(const std::filesystem::path &path, const int backlog)
+{
+	struct sockaddr_un addr {};
+	addr.sun_family = AF_UNIX;
+
+	const std::string &name = path.native();
+	if (name.size() >= sizeof(addr.sun_path)) {
+		throw common::Error<Error::SyscallSocketFailed> {"bind",
+		                                                 std::strerror(ENAMETOOLONG)};
+	}
+
+	std::copy(name.cbegin(), name.cend(), std::begin(addr.sun_path));
+
+	const int fd = ::socket(AF_UNIX, SOCK_STREAM | SOCK_NONBLOCK | SOCK_CLOEXEC, 0);
+	if (fd == -1)
+		throw common::Error<Error::SyscallSocketFailed> {"socket", impl::last_error()};
+
+	// NOLINTNEXTLINE(cppcoreguidelines-pro-type-reinterpret-cast)
+	const auto *sa = reinterpret_cast<const struct sockaddr *>(&addr);
+
+	if (::bind(fd, sa, sizeof(addr)) == -1 || ::listen(fd, backlog) == -1) {
+		const std::string error = impl::last_error();
+
+		::close(fd);
+		throw common::Error<Error::SyscallSocketFailed> {"bind", error};
+	}
+
+	return fd;
+}
+
+namespace impl {
+
+/*!
+ * Creates a TCP socket and binds or connects it to the first usable address.
+ *
+ * @param[in] host The name or address of the host.
+ * @param[in] port The port on the host.
+ * @param[in] passive Whether the socket is bound to listen for connections.
+ * @return The file descriptor of the socket.
+ */
+inline int open_tcp(const std::string &host, const u16 port, const bool passive)
+{
+	struct addrinfo hints {};
+	hints.ai_family = AF_UNSPEC;
+	hints.ai_socktype = SOCK_STREAM;
+	hints.ai_flags = passive ? AI_PASSIVE : 0;
+
+	struct addrinfo *result = nullptr;
+	const std::string service = std::to_string(port);
+
+	const int ret = ::getaddrinfo(host.c_str(), service.c_str(), &hints, &result);
+	if (ret != 0)
+		throw common::Error<Error::SyscallSocketFailed> {"getaddrinfo", gai_strerror(ret)};
+
+	std::string error = "No usable address";
+
+	for (struct addrinfo *ai = result; ai != nullptr; ai = ai->ai_next) {
+		const int type = ai->ai_socktype | SOCK_CLOEXEC;
+
+		const int fd = ::socket(ai->ai_family, type, ai->ai_protocol);
+		if (fd == -1) {
+			error = last_error();
+			continue;
+		}
+
+		const int reuse = 1;
+
+		if (passive)
+			::setsockopt(fd, SOL_SOCKET, SO_REUSEADDR, &reuse, sizeof(reuse));
+
+		const int status = passive ? ::bind(fd, ai->ai_addr, ai->ai_addrlen)
+		                           : ::connect(fd, ai->ai_addr, ai->ai_addrlen);
+
+		if (status == 0) {
+			::freeaddrinfo(result);
+			return fd;
+		}
+
+		error = last_error();
+		::close(fd);
+	}
+
+	::freeaddrinfo(result);
+	throw common::Error<Error::SyscallSocketFailed> {passive ? "bind" : "connect", error};
+}
+
+} // namespace impl
+
+/*!
+ * Connects to a TCP server.
+ *
+ * @param[in] host The name or address of the server.
+ * @param[in] port The port of the server.
+ * @return The file descriptor of the connection.
+ */
+inline int connect_tcp(const std::string &host, const u16 port)
+{
+	return impl::open_tcp(host, port, false);
+}
+
+/*!
+ * Creates a TCP socket that is listening for connections.
+ *
+ * @param[in] address The local address that the socket is bound to.
+ * @param[in] port The port that the socket is bound to.
+ * @param[in] backlog How many connections can be pending at the same time.
+ * @return The file descriptor of the listening socket.
+ */
+inline int listen_tcp(const std::string &address, const u16 port, const int backlog)
+{
+	const int fd = impl::open_tcp(address, port, true);
+
+	if (::listen(fd, backlog) == -1) {
+		const std::string error = impl::last_error();
+
+		::close(fd);
+		throw common::Error<Error::SyscallSocketFailed> {"listen", error};
+	}
+
+	return fd;
+}
+
+/*!
+ * Accepts a pending connection on a non-blocking socket.
+ *
+ * @param[in] fd The file descriptor of the listening socket.
+ * @return The file descriptor of the connection, or -1 if no connection is pending.
+ */
+inline int accept(const int fd)
+{
+	const int ret = ::accept4(fd, nullptr, nullptr, SOCK_NONBLOCK | SOCK_CLOEXEC);
+	if (ret == -1 && (errno == EAGAIN || errno == EWOULDBLOCK))
+		return -1;
+
+	if (ret == -1)
+		throw common::Error<Error::SyscallSocketFailed> {"accept", impl::last_error()};
+
+	return ret;
+}
+
 } // namespace iptsd::core::linux::syscalls
 
 #endif // IPTSD_CORE_LINUX_SYSCALLS_HPP
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_LINUX_VALIDATION_HPP
+#define IPTSD_CORE_LINUX_VALIDATION_HPP
+
+#include "errors.hpp"
+
+#include <common/chrono.hpp>
+#include <common/error.hpp>
+#include <common/types.hpp>
+#include <core/generic/config.hpp>
+#include <core/generic/device.hpp>
+#include <ipts/device.hpp>
+#include <ipts/metadata.hpp>
+
+#include <fmt/format.h>
+#include <spdlog/spdlog.h>
+
+#include <optional>
+#include <string>
+#include <thread>
+
+namespace iptsd::core::linux {
+
+/*
+ * Checks if the device info and the config make sense before any devices are created.
+ *
+ * Implausible metadata is not used. In that case, the config has to provide the size of the
+ * screen instead, otherwise the runner refuses to start with an error that names the field.
+ */
+class DeviceValidator {
+private:
+	// Why the metadata of the device was not used, if it was implausible.
+	std::optional<std::string> m_metadata_error = std::nullopt;
+
+public:
+	/*!
+	 * Queries the metadata of the device.
+	 *
+	 * If the device returns implausible values, the query is retried a few times.
+	 * If it still fails, the metadata is ignored and the values from the config are used.
+	 *
+	 * @param[in] device The device to query.
+	 * @return The metadata of the device, if it is available and plausible.
+	 */
+	std::optional<ipts::Metadata> query(const ipts::Device &device)
+	{
+		std::optional<std::string> error = std::nullopt;
+
+		for (usize i = 0; i < 3; i++) {
+			const std::optional<ipts::Metadata> meta = device.metadata();
+
+			if (!meta.has_value())
+				return std::nullopt;
+
+			error = check(meta.value());
+			if (!error.has_value())
+				return meta;
+
+			std::this_thread::sleep_for(100ms);
+		}
+
+		// Whether the config can replace the metadata is checked once it is loaded.
+		m_metadata_error = error;
+		return std::nullopt;
+	}
+
+	/*!
+	 * Checks if the device info and config make sense.
+	 *
+	 * If the metadata of the device was implausible, the config has to provide the size of
+	 * the screen instead.
+	 *
+	 * @param[in] info The information about the device.
+	 * @param[in] config The config that was loaded for the device.
+	 */
+	void validate(const DeviceInfo &info, const Config &config) const
+	{
+		if (info.vendor == 0)
+			throw common::Error<Error::InvalidDeviceInfo> {"The vendor ID is 0"};
+
+		// Microsoft and N-Trig
+		if (info.vendor != 0x045E && info.vendor != 0x1B96)
+			spdlog::warn("Unknown vendor ID {:04X}", info.vendor);
+
+		if (m_metadata_error.has_value() && (config.width <= 0 || config.height <= 0)) {
+			throw common::Error<Error::InvalidDeviceInfo> {fmt::format(
+				"Device metadata is implausible ({}), set Width and Height",
+				m_metadata_error.value())};
+		}
+
+		if (config.width <= 0 || config.height <= 0) {
+			throw common::Error<Error::InvalidDeviceInfo> {
+				fmt::format("Screen size is {}x{} cm, set Width and Height in the "
+				            "[Config] section",
+				            config.width,
+				            config.height)};
+		}
+
+		if (m_metadata_error.has_value()) {
+			spdlog::warn("Device metadata is implausible ({}), using a screen size of "
+			             "{}x{} cm from the config",
+			             m_metadata_error.value(),
+			             config.width,
+			             config.height);
+		}
+	}
+
+private:
+	/*!
+	 * Checks if the metadata returned by the device is plausible.
+	 *
+	 * @param[in] meta The metadata to check.
+	 * @return A description of the implausible field, if there is one.
+	 */
+	[[nodiscard]] static std::optional<std::string> check(const ipts::Metadata &meta)
+	{
+		if (meta.rows == 0 || meta.columns == 0)
+			return fmt::format("Heatmap size is {}x{}", meta.rows, meta.columns);
+
+		if (meta.width <= 0 || meta.height <= 0)
+			return fmt::format("Screen size is {}x{} cm", meta.width, meta.height);
+
+		return std::nullopt;
+	}
+};
+
+} // namespace iptsd::core::linux
+
+#endif // IPTSD_CORE_LINUX_VALIDATION_HPP
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_LINUX_WAKEUP_HPP
+#define IPTSD_CORE_LINUX_WAKEUP_HPP
+
+#include "syscalls.hpp"
+
+#include <common/types.hpp>
+
+#include <sys/eventfd.h>
+
+#include <unistd.h>
+
+namespace iptsd::core::linux {
+
+/*
+ * Wakes up a thread that is waiting for a file descriptor, e.g. when a command was sent.
+ *
+ * The thread waits for the descriptor of the wakeup together with its other descriptors.
+ * Once the wakeup was notified, the descriptor stays readable until it is cleared.
+ */
+class Wakeup {
+private:
+	// The eventfd that becomes readable when the wakeup is notified.
+	int m_fd = -1;
+
+public:
+	Wakeup() : m_fd {syscalls::eventfd(EFD_NONBLOCK | EFD_CLOEXEC)} {};
+
+	Wakeup(const Wakeup &) = delete;
+	Wakeup &operator=(const Wakeup &) = delete;
+
+	~Wakeup()
+	{
+		::close(m_fd);
+	}
+
+	/*!
+	 * The file descriptor that becomes readable when the wakeup was notified.
+	 *
+	 * @return The file descriptor of the eventfd.
+	 */
+	[[nodiscard]] int fd() const
+	{
+		return m_fd;
+	}
+
+	/*!
+	 * Wakes up the thread that is waiting.
+	 *
+	 * This function is designed to be called from a signal handler.
+	 */
+	void notify() const
+	{
+		const u64 value = 1;
+
+		// This can only fail if the counter overflows, and then it is still readable.
+		[[maybe_unused]] const isize ret = ::write(m_fd, &value, sizeof(value));
+	}
+
+	/*!
+	 * Makes the file descriptor unreadable again, until the next notification.
+	 */
+	void clear() const
+	{
+		u64 value = 0;
+
+		// This fails if the wakeup was not notified, which is fine.
+		[[maybe_unused]] const isize ret = ::read(m_fd, &value, sizeof(value));
+	}
+};
+
+} // namespace iptsd::core::linux
+
+#endif // IPTSD_CORE_LINUX_WAKEUP_HPP
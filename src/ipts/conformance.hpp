@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_IPTS_CONFORMANCE_HPP
+#define IPTSD_IPTS_CONFORMANCE_HPP
+
+#include <common/types.hpp>
+
+#include <array>
+#include <string_view>
+
+namespace iptsd::ipts {
+
+/*
+ * Remembers in which ways the data of a device deviates from the expected protocol.
+ *
+ * Firmware versions differ in small details, like fields that should be zero or sizes that
+ * don't match. None of these stop the data from being parsed, but knowing which of them a
+ * device shows helps to understand reports about it. For every check, only the offset of the
+ * first data that failed it is kept, together with how often it failed.
+ */
+class Conformance {
+public:
+	enum class Check : u8 {
+		// A reserved field contains data.
+		ReservedNonzero,
+
+		// A stylus report contains more data than its samples.
+		TrailingData,
+
+		// A stylus report contains no samples.
+		EmptyStylusReport,
+
+		// The coordinates of a stylus sample are outside of the screen.
+		CoordinatesOutOfRange,
+
+		// The pressure of a stylus sample is higher than the maximum.
+		PressureOutOfRange,
+
+		// The altitude of a stylus sample is more than 90 degrees.
+		AltitudeOutOfRange,
+
+		// The azimuth of a stylus sample is 360 degrees or more.
+		AzimuthOutOfRange,
+
+		// The timestamp of a stylus sample is behind the one of the previous sample.
+		TimestampNotMonotonic,
+
+		// The ranges of a heatmap dimensions report don't match its rows and columns.
+		DimensionsMismatch,
+
+		// The highest value of a heatmap dimensions report is 0.
+		ZeroMaximum,
+
+		// The size of a heatmap doesn't match its dimensions.
+		HeatmapSizeMismatch,
+	};
+
+	// How many checks there are.
+	constexpr static usize CHECKS = static_cast<usize>(Check::HeatmapSizeMismatch) + 1;
+
+	/*
+	 * How a check failed.
+	 */
+	struct Finding {
+		// Where the first data that failed the check was, relative to its buffer.
+		usize offset = 0;
+
+		// How often the check failed.
+		u64 count = 0;
+	};
+
+private:
+	// The results of all checks, by the check.
+	std::array<Finding, CHECKS> m_findings {};
+
+public:
+	/*!
+	 * Records that a check failed.
+	 *
+	 * @param[in] check The check that failed.
+	 * @param[in] offset Where the data that failed the check is, relative to its buffer.
+	 * @return Whether this is the first time that the check failed.
+	 */
+	bool record(const Check check, const usize offset)
+	{
+		Finding &finding = m_findings.at(static_cast<usize>(check));
+
+		if (finding.count++ != 0)
+			return false;
+
+		finding.offset = offset;
+		return true;
+	}
+
+	/*!
+	 * The results of all checks.
+	 *
+	 * @return The findings, indexed by the check. Checks that never failed have a count of 0.
+	 */
+	[[nodiscard]] const std::array<Finding, CHECKS> &findings() const
+	{
+		return m_findings;
+	}
+
+	/*!
+	 * A short name of a check, e.g. for statistics.
+	 *
+	 * @param[in] check The check.
+	 * @return The name of the check.
+	 */
+	static std::string_view name(const Check check)
+	{
+		switch (check) {
+		case Check::ReservedNonzero:
+			return "reserved_nonzero";
+		case Check::TrailingData:
+			return "trailing_data";
+		case Check::EmptyStylusReport:
+			return "empty_stylus_report";
+		case Check::CoordinatesOutOfRange:
+			return "coordinates_out_of_range";
+		case Check::PressureOutOfRange:
+			return "pressure_out_of_range";
+		case Check::AltitudeOutOfRange:
+			return "altitude_out_of_range";
+		case Check::AzimuthOutOfRange:
+			return "azimuth_out_of_range";
+		case Check::TimestampNotMonotonic:
+			return "timestamp_not_monotonic";
+		case Check::DimensionsMismatch:
+			return "dimensions_mismatch";
+		case Check::ZeroMaximum:
+			return "zero_maximum";
+		case Check::HeatmapSizeMismatch:
+			return "heatmap_size_mismatch";
+		default:
+			return "unknown";
+		}
+	}
+};
+
+} // namespace iptsd::ipts
+
+#endif // IPTSD_IPTS_CONFORMANCE_HPP
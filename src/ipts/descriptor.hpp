@@ -58,6 +58,19 @@ public:
 		return m_hid_descriptor.find_report(protocol::descriptor::is_metadata);
 	}
 
+	/*!
+	 * Searches for the selective reporting report in the HID descriptor.
+	 *
+	 * The selective reporting report is a feature report for telling the device
+	 * to stop sending touch data, and to start sending it again.
+	 *
+	 * @return The HID report for selective reporting if it exists, null otherwise.
+	 */
+	[[nodiscard]] std::optional<hid::Report> find_selective_reporting_report() const
+	{
+		return m_hid_descriptor.find_report(protocol::descriptor::is_selective_reporting);
+	}
+
 	/*!
 	 * Whether the HID descriptor indicates that this device is a touchscreen.
 	 */
@@ -7,9 +7,11 @@
 #include "metadata.hpp"
 #include "parser.hpp"
 
+#include <common/casts.hpp>
 #include <common/error.hpp>
 #include <common/types.hpp>
 #include <hid/device.hpp>
+#include <hid/field.hpp>
 #include <hid/report.hpp>
 
 #include <gsl/gsl>
@@ -181,6 +183,61 @@ public:
 		m_hid->set_feature(buffer);
 	}
 
+	/*!
+	 * Tells the device to stop or resume sending touch data.
+	 *
+	 * Only the touch surface is switched, buttons (e.g. of touchpads) keep working.
+	 *
+	 * @param[in] enabled Whether the device should send touch data.
+	 * @return Whether the device supports switching the touch data.
+	 */
+	bool set_touch(const bool enabled) const
+	{
+		namespace desc = protocol::descriptor;
+
+		const std::optional<hid::Report> report =
+			m_descriptor.find_selective_reporting_report();
+
+		if (!report.has_value())
+			return false;
+
+		const std::optional<u8> id = report->report_id;
+		if (!id.has_value())
+			return false;
+
+		std::vector<u8> buffer(report->bytes() + 1);
+		buffer[0] = id.value();
+
+		// The position of the current element in the report, in bits.
+		usize bit = 8;
+
+		for (const hid::Field &field : report->fields) {
+			for (u32 i = 0; i < field.count; i++, bit += field.size) {
+				// Check the usage of every single element of the field.
+				hid::Field element = field;
+				element.usage_min = std::nullopt;
+				element.usage_max = std::nullopt;
+
+				if (field.usage_min.has_value())
+					element.usage = field.usage_min.value() + i;
+
+				const u16 page = desc::USAGE_PAGE_DIGITIZER;
+				bool value = false;
+
+				if (element.has_usage(page, desc::USAGE_SURFACE_SWITCH))
+					value = enabled;
+				else if (element.has_usage(page, desc::USAGE_BUTTON_SWITCH))
+					value = true;
+
+				if (value)
+					buffer.at(bit / 8) |= casts::to<u8>(1 << (bit % 8));
+			}
+		}
+
+		m_hid->set_feature(buffer);
+		return true;
+	}
+
 	/*!
 	 * Checks whether a buffer contains IPTS touch data.
 	 *
@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_IPTS_ERRORS_HPP
+#define IPTSD_IPTS_ERRORS_HPP
+
+#include <common/types.hpp>
+
+#include <string>
+
+namespace iptsd::ipts {
+
+enum class Error : u8 {
+	NestingTooDeep,
+};
+
+inline std::string format_as(Error err)
+{
+	switch (err) {
+	case Error::NestingTooDeep:
+		return "ipts: HID frames are nested deeper than {} levels!";
+	default:
+		return "ipts: Invalid error code!";
+	}
+}
+
+} // namespace iptsd::ipts
+
+#endif // IPTSD_IPTS_ERRORS_HPP
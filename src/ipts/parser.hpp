@@ -3,6 +3,8 @@
 #ifndef IPTSD_IPTS_PARSER_HPP
 #define IPTSD_IPTS_PARSER_HPP
 
+#include "conformance.hpp"
+#include "errors.hpp"
 #include "metadata.hpp"
 #include "protocol/button.hpp"
 #include "protocol/dft.hpp"
@@ -16,19 +18,42 @@
 #include "samples/dft.hpp"
 #include "samples/stylus.hpp"
 #include "samples/touch.hpp"
+#include "samples/unknown.hpp"
 
 #include <common/casts.hpp>
+#include <common/error.hpp>
 #include <common/reader.hpp>
 #include <common/types.hpp>
 
 #include <gsl/gsl>
 
+#include <algorithm>
+#include <array>
+#include <exception>
 #include <functional>
+#include <limits>
 #include <optional>
+#include <vector>
 
 namespace iptsd::ipts {
 
 class Parser {
+public:
+	// How deep HID frames can be nested inside of each other.
+	constexpr static usize MAX_DEPTH = 8;
+
+public:
+	/*
+	 * How many report frames of one type were parsed.
+	 */
+	struct ReportCount {
+		// How many report frames were parsed.
+		u64 count = 0;
+
+		// The total size of their payloads, in bytes.
+		u64 bytes = 0;
+	};
+
 public:
 	// The callback that is invoked when stylus data was parsed.
 	std::function<void(const samples::Stylus &)> on_stylus;
@@ -45,10 +70,46 @@ public:
 	// The callback that is invoked when a metadata report was parsed.
 	std::function<void(const Metadata &)> on_metadata;
 
+	// The callback that is invoked when a gap in the frame counter was detected.
+	std::function<void(u32)> on_dropped;
+
+	// The callback that is invoked when a frame of an unknown type was skipped.
+	std::function<void(const samples::Unknown &)> on_unknown;
+
+	// The callback that is invoked when an invalid report was skipped, with its type.
+	std::function<void(u16, const std::exception &)> on_invalid;
+
+	// The callback that is invoked when a stylus report had too many samples, with their count.
+	std::function<void(u8)> on_truncated;
+
+	// The callback that is invoked when the data failed a conformance check for the first time.
+	std::function<void(Conformance::Check)> on_nonconformant;
+
 private:
 	protocol::heatmap::Dimensions m_dim {};
 	protocol::dft::Metadata m_dft_meta {};
 
+	// The counter of the last legacy frame that was parsed.
+	std::optional<u32> m_counter = std::nullopt;
+
+	// Where the regions of the data that were parsed are recorded to.
+	std::vector<ReaderRegion> *m_trace = nullptr;
+
+	// Whether invalid reports are skipped instead of aborting the whole buffer.
+	bool m_resync = false;
+
+	// How many report frames of every type were parsed, by their type.
+	std::array<ReportCount, 256> m_reports {};
+
+	// In which ways the data deviated from the protocol.
+	Conformance m_conformance {};
+
+	// The timestamp of the last stylus sample.
+	std::optional<u16> m_timestamp = std::nullopt;
+
+	// The timestamp of the HID report that is being parsed.
+	u16 m_report_timestamp = 0;
+
 public:
 	/*!
 	 * Parses IPTS touch data from a HID report buffer.
@@ -59,7 +120,10 @@ public:
 	 */
 	void parse(const gsl::span<u8> data)
 	{
-		this->parse<protocol::hid::ReportHeader>(data);
+		const auto header = Reader {data}.read<protocol::hid::ReportHeader>();
+		m_report_timestamp = header.timestamp;
+
+		this->parse_with_header(data, sizeof(protocol::hid::ReportHeader));
 	}
 
 	/*!
@@ -71,13 +135,96 @@ public:
 	template <class T>
 	void parse(const gsl::span<u8> data)
 	{
+		m_report_timestamp = 0;
 		this->parse_with_header(data, sizeof(T));
 	}
 
+	/*!
+	 * Records the regions of all data that is parsed from now on.
+	 *
+	 * @param[in] trace The list to record to. Recording is disabled if this is null.
+	 */
+	void trace(std::vector<ReaderRegion> *trace)
+	{
+		m_trace = trace;
+	}
+
+	/*!
+	 * How many report frames of every type were parsed so far.
+	 *
+	 * Invalid and unknown report frames are counted as well.
+	 *
+	 * @return The counts, indexed by the type of the report frame.
+	 */
+	[[nodiscard]] const std::array<ReportCount, 256> &reports() const
+	{
+		return m_reports;
+	}
+
+	/*!
+	 * In which ways the data that was parsed so far deviated from the protocol.
+	 *
+	 * @return The results of all checks.
+	 */
+	[[nodiscard]] const Conformance &conformance() const
+	{
+		return m_conformance;
+	}
+
+	/*!
+	 * Skips reports that can't be parsed and continues with the next one.
+	 *
+	 * If disabled, an invalid report aborts parsing the buffer that contains it.
+	 * The @ref on_invalid callback will be invoked for every skipped report.
+	 *
+	 * @param[in] enabled Whether invalid reports are skipped.
+	 */
+	void resync(const bool enabled)
+	{
+		m_resync = enabled;
+	}
+
+	/*!
+	 * Forgets the last seen frame counter.
+	 *
+	 * The next frame will not be checked for dropped buffers.
+	 */
+	void reset()
+	{
+		m_counter = std::nullopt;
+		m_timestamp = std::nullopt;
+	}
+
+	/*!
+	 * Calculates how many frames are missing between two consecutive frame counters.
+	 *
+	 * The counter is a free running 32 bit value, so the comparison has to handle wraparound.
+	 * If the current counter is equal to or behind the last one (e.g. because the device was
+	 * reset), no frames are considered missing.
+	 *
+	 * @param[in] last The counter of the previous frame.
+	 * @param[in] current The counter of the current frame.
+	 * @return The number of frames that were skipped.
+	 */
+	[[nodiscard]] static u32 sequence_gap(const u32 last, const u32 current)
+	{
+		// Unsigned subtraction is well defined and wraps around.
+		const u32 distance = current - last;
+
+		// A distance of more than half the counter range means the counter went backwards.
+		if (distance == 0 || distance > std::numeric_limits<u32>::max() / 2)
+			return 0;
+
+		return distance - 1;
+	}
+
 private:
+	using Check = Conformance::Check;
+
 	void parse_with_header(const gsl::span<u8> data, const usize header)
 	{
 		Reader reader(data);
+		reader.trace(m_trace);
 		reader.skip(header);
 
 		this->parse_hid_frame(reader);
@@ -90,15 +237,16 @@ private:
 	 * For more information, see @ref protocol::hid::Frame
 	 *
 	 * @param[in] reader The chunk of data allocated to the HID frame.
+	 * @param[in] depth How many HID frames contain this one.
 	 */
-	void parse_hid_frame(Reader &reader)
+	void parse_hid_frame(Reader &reader, const usize depth = 0)
 	{
 		const auto frame = reader.read<protocol::hid::Frame>();
 		Reader sub = reader.sub(frame.size - sizeof(frame));
 
 		switch (frame.type) {
 		case protocol::hid::FrameType::Hid:
-			this->parse_hid_frames(sub);
+			this->parse_hid_frames(sub, depth + 1);
 			break;
 		case protocol::hid::FrameType::Heatmap:
 			this->parse_heatmap_frame(sub);
@@ -126,7 +274,9 @@ private:
 			this->parse_report_frames(sub);
 			break;
 		default:
-			// TODO: Add handler for unknown data and wire up debug tools
+			this->skip_unknown(samples::Unknown::Source::Hid,
+			                   static_cast<u16>(frame.type),
+			                   sub.size());
 			break;
 		}
 	}
@@ -135,11 +285,16 @@ private:
 	 * Parses a list of IPTS HID frames.
 	 *
 	 * @param[in] reader The chunk of data allocated to the HID frames.
+	 * @param[in] depth How many HID frames contain these ones.
 	 */
-	void parse_hid_frames(Reader &reader)
+	void parse_hid_frames(Reader &reader, const usize depth)
 	{
+		// Broken data must not nest frames until the stack runs out.
+		if (depth > MAX_DEPTH)
+			throw common::Error<Error::NestingTooDeep> {MAX_DEPTH};
+
 		while (reader.size() > 0)
-			this->parse_hid_frame(reader);
+			this->parse_hid_frame(reader, depth);
 	}
 
 	/*!
@@ -154,22 +309,144 @@ private:
 	{
 		const auto header = reader.read<protocol::legacy::Header>();
 
+		this->check_sequence(header.counter);
+
 		for (u32 i = 0; i < header.elements; i++) {
 			const auto group = reader.read<protocol::legacy::ReportGroup>();
 			Reader sub = reader.sub(group.size);
 
+			/*
+			 * The type of the group is not reliable. Devices like the Surface 3 send stylus
+			 * reports in touch groups. Report frames carry their own type, so both kinds
+			 * of groups are parsed the same way, and each report reaches its handler.
+			 */
 			switch (group.type) {
 			case protocol::legacy::GroupType::Stylus:
 			case protocol::legacy::GroupType::Touch:
 				this->parse_report_frames(sub);
 				break;
 			default:
-				// TODO: Add handler for unknown data and wire up debug tools
+				this->skip_unknown(samples::Unknown::Source::Legacy,
+				                   static_cast<u16>(group.type),
+				                   sub.size());
 				break;
 			}
 		}
 	}
 
+	/*!
+	 * Skips a frame of unknown type.
+	 *
+	 * The payload of the frame has already been split off by the caller, so it is enough
+	 * to not parse it. The @ref on_unknown callback will be invoked to report the frame.
+	 *
+	 * @param[in] source What kind of frame was skipped.
+	 * @param[in] type The type of the skipped frame.
+	 * @param[in] size The size of the payload of the skipped frame.
+	 */
+	void skip_unknown(const samples::Unknown::Source source,
+	                  const u16 type,
+	                  const usize size) const
+	{
+		if (!this->on_unknown)
+			return;
+
+		samples::Unknown unknown {};
+		unknown.source = source;
+		unknown.type = type;
+		unknown.size = size;
+
+		this->on_unknown(unknown);
+	}
+
+	/*!
+	 * Records a deviation from the protocol, if a check failed.
+	 *
+	 * @param[in] failed Whether the data failed the check.
+	 * @param[in] check The check.
+	 * @param[in] offset Where the data that was checked is, relative to its buffer.
+	 */
+	void check(const bool failed, const Check check, const usize offset)
+	{
+		if (!failed || !m_conformance.record(check, offset))
+			return;
+
+		if (this->on_nonconformant)
+			this->on_nonconformant(check);
+	}
+
+	/*!
+	 * Checks the parts of a stylus report that don't depend on the type of its samples.
+	 *
+	 * @param[in] reader The chunk of data allocated to the report, after the last sample.
+	 * @param[in] report The header of the stylus report.
+	 * @param[in] offset Where the report is, relative to its buffer.
+	 */
+	void check_report(const Reader &reader,
+	                  const protocol::stylus::Report &report,
+	                  const usize offset)
+	{
+		this->check(is_nonzero(report.reserved), Check::ReservedNonzero, offset);
+		this->check(reader.size() > 0, Check::TrailingData, offset);
+	}
+
+	/*!
+	 * Checks if the timestamp of a stylus sample is behind the last one.
+	 *
+	 * @param[in] timestamp The timestamp of the current sample.
+	 * @param[in] offset Where the report of the sample is, relative to its buffer.
+	 */
+	void check_timestamp(const u16 timestamp, const usize offset)
+	{
+		const std::optional<u16> last = m_timestamp;
+		m_timestamp = timestamp;
+
+		if (!last.has_value())
+			return;
+
+		// A distance of more than half the range means that the timestamp went backwards.
+		const auto distance = static_cast<u16>(timestamp - last.value());
+		const bool backwards = distance > std::numeric_limits<u16>::max() / 2;
+
+		this->check(backwards, Check::TimestampNotMonotonic, offset);
+	}
+
+	/*!
+	 * Whether a reserved field contains data.
+	 *
+	 * @param[in] data The reserved field.
+	 * @return true if any of its bytes is not zero.
+	 */
+	template <usize N>
+	[[nodiscard]] static bool is_nonzero(const std::array<u8, N> &data)
+	{
+		const auto nonzero = [](const u8 byte) { return byte != 0; };
+		return std::any_of(data.begin(), data.end(), nonzero);
+	}
+
+	/*!
+	 * Checks if any frames were skipped since the last one.
+	 *
+	 * When the daemon falls behind, the firmware overwrites buffers that have not been read
+	 * yet. These show up as gaps in the frame counter. If a gap is found, the @ref on_dropped
+	 * callback will be invoked with the number of lost frames.
+	 *
+	 * @param[in] counter The counter of the current frame.
+	 */
+	void check_sequence(const u32 counter)
+	{
+		const std::optional<u32> last = m_counter;
+		m_counter = counter;
+
+		if (!last.has_value())
+			return;
+
+		const u32 gap = sequence_gap(last.value(), counter);
+
+		if (gap > 0 && this->on_dropped)
+			this->on_dropped(gap);
+	}
+
 	/*!
 	 * Parses an IPTS metadata frame.
 	 *
@@ -212,7 +489,33 @@ private:
 		const auto frame = reader.read<protocol::report::Frame>();
 		Reader sub = reader.sub(frame.size);
 
-		switch (frame.type) {
+		ReportCount &count = m_reports.at(static_cast<u8>(frame.type));
+		count.count++;
+		count.bytes += frame.size;
+
+		if (!m_resync) {
+			this->parse_report(frame.type, sub);
+			return;
+		}
+
+		// The payload was already split off, so the next report can be parsed regardless.
+		try {
+			this->parse_report(frame.type, sub);
+		} catch (const std::exception &e) {
+			if (this->on_invalid)
+				this->on_invalid(static_cast<u16>(frame.type), e);
+		}
+	}
+
+	/*!
+	 * Parses the payload of an IPTS report frame.
+	 *
+	 * @param[in] type The type of the report frame.
+	 * @param[in] sub The chunk of data allocated to the payload of the report frame.
+	 */
+	void parse_report(const protocol::report::Type type, Reader &sub)
+	{
+		switch (type) {
 		case protocol::report::Type::StylusMPP_1_0:
 			this->parse_stylus_mpp_1_0(sub);
 			break;
@@ -234,8 +537,23 @@ private:
 		case protocol::report::Type::Button:
 			this->parse_button(sub);
 			break;
+		case protocol::report::Type::HeatmapTimestamp:
+		case protocol::report::Type::DftFrequencyNoise:
+		case protocol::report::Type::DftGeneral:
+		case protocol::report::Type::DftJnrOutput:
+		case protocol::report::Type::DftNoiseMetricsOutput:
+		case protocol::report::Type::DftDataSelection:
+		case protocol::report::Type::DftMagnitude:
+		case protocol::report::Type::DftMultipleRegion:
+		case protocol::report::Type::DftTouchedAntennas:
+		case protocol::report::Type::DftDetection:
+		case protocol::report::Type::DftLift:
+			// These types are known, but their contents are not used yet.
+			break;
 		default:
-			// TODO: Add handler for unknown data and wire up debug tools
+			this->skip_unknown(samples::Unknown::Source::Report,
+			                   static_cast<u16>(type),
+			                   sub.size());
 			break;
 		}
 	}
@@ -251,6 +569,31 @@ private:
 			this->parse_report_frame(reader);
 	}
 
+	/*!
+	 * Skips the samples of a stylus report that come before the one that is processed.
+	 *
+	 * The last sample that fits into the report is processed. If the report claims to contain
+	 * more than @ref protocol::stylus::MAX_SAMPLES samples, @ref on_truncated is invoked.
+	 *
+	 * @tparam T The type of the samples.
+	 * @param[in] reader The chunk of data allocated to the samples.
+	 * @param[in] report The header of the stylus report, with at least one sample.
+	 */
+	template <class T>
+	void skip_samples(Reader &reader, const protocol::stylus::Report &report) const
+	{
+		if (report.samples > protocol::stylus::MAX_SAMPLES && this->on_truncated)
+			this->on_truncated(report.samples);
+
+		const usize samples = std::min<usize>(report.samples, reader.size() / sizeof(T));
+
+		// If not even one sample fits, reading it fails.
+		if (samples == 0)
+			return;
+
+		reader.skip((samples - 1) * sizeof(T));
+	}
+
 	/*!
 	 * Parses an MPP (Microsoft Pen Protocol) 1.0 stylus report.
 	 *
@@ -262,19 +605,37 @@ private:
 	 *
 	 * @param[in] reader The chunk of data allocated to the report frame.
 	 */
-	void parse_stylus_mpp_1_0(Reader &reader) const
+	void parse_stylus_mpp_1_0(Reader &reader)
 	{
+		const usize offset = reader.offset();
 		const auto report = reader.read<protocol::stylus::Report>();
 
-		for (u8 i = 0; i < report.samples - 1; i++)
-			reader.skip(sizeof(protocol::stylus::SampleMPP_1_0));
+		this->check(report.samples == 0, Check::EmptyStylusReport, offset);
+
+		// Reports without samples contain no state that could be emitted.
+		if (report.samples == 0)
+			return;
+
+		this->skip_samples<protocol::stylus::SampleMPP_1_0>(reader, report);
 
 		const auto sample = reader.read<protocol::stylus::SampleMPP_1_0>();
 
+		const bool outside =
+			sample.x > protocol::stylus::MAX_X || sample.y > protocol::stylus::MAX_Y;
+		const bool reserved = is_nonzero(sample.reserved1) || is_nonzero(sample.reserved2);
+
+		this->check_report(reader, report, offset);
+		this->check(reserved, Check::ReservedNonzero, offset);
+		this->check(outside, Check::CoordinatesOutOfRange, offset);
+		this->check(sample.pressure > protocol::stylus::MAX_PRESSURE_MPP_1_0,
+		            Check::PressureOutOfRange,
+		            offset);
+
 		if (!this->on_stylus)
 			return;
 
 		samples::Stylus stylus {};
+		stylus.serial = report.serial;
 		stylus.proximity = sample.state.proximity;
 		stylus.button = sample.state.button;
 		stylus.rubber = sample.state.rubber;
@@ -289,6 +650,7 @@ private:
 		stylus.x /= protocol::stylus::MAX_X;
 		stylus.y /= protocol::stylus::MAX_Y;
 		stylus.pressure /= protocol::stylus::MAX_PRESSURE_MPP_1_0;
+		stylus.pressure_levels = protocol::stylus::MAX_PRESSURE_MPP_1_0;
 
 		stylus.altitude = 0;
 		stylus.azimuth = 0;
@@ -308,19 +670,39 @@ private:
 	 *
 	 * @param[in] reader The chunk of data allocated to the report frame.
 	 */
-	void parse_stylus_mpp_1_51(Reader &reader) const
+	void parse_stylus_mpp_1_51(Reader &reader)
 	{
+		const usize offset = reader.offset();
 		const auto report = reader.read<protocol::stylus::Report>();
 
-		for (u8 i = 0; i < report.samples - 1; i++)
-			reader.skip(sizeof(protocol::stylus::SampleMPP_1_51));
+		this->check(report.samples == 0, Check::EmptyStylusReport, offset);
+
+		// Reports without samples contain no state that could be emitted.
+		if (report.samples == 0)
+			return;
+
+		this->skip_samples<protocol::stylus::SampleMPP_1_51>(reader, report);
 
 		const auto sample = reader.read<protocol::stylus::SampleMPP_1_51>();
 
+		const bool outside =
+			sample.x > protocol::stylus::MAX_X || sample.y > protocol::stylus::MAX_Y;
+
+		this->check_report(reader, report, offset);
+		this->check(is_nonzero(sample.reserved), Check::ReservedNonzero, offset);
+		this->check(outside, Check::CoordinatesOutOfRange, offset);
+		this->check(sample.pressure > protocol::stylus::MAX_PRESSURE_MPP_1_51,
+		            Check::PressureOutOfRange,
+		            offset);
+		this->check(sample.altitude > 9000, Check::AltitudeOutOfRange, offset);
+		this->check(sample.azimuth >= 36000, Check::AzimuthOutOfRange, offset);
+		this->check_timestamp(sample.timestamp, offset);
+
 		if (!this->on_stylus)
 			return;
 
 		samples::Stylus stylus {};
+		stylus.serial = report.serial;
 		stylus.timestamp = sample.timestamp;
 
 		stylus.proximity = sample.state.proximity;
@@ -337,6 +719,7 @@ private:
 		stylus.x /= protocol::stylus::MAX_X;
 		stylus.y /= protocol::stylus::MAX_Y;
 		stylus.pressure /= protocol::stylus::MAX_PRESSURE_MPP_1_51;
+		stylus.pressure_levels = protocol::stylus::MAX_PRESSURE_MPP_1_51;
 
 		stylus.altitude = casts::to<f64>(sample.altitude);
 		stylus.azimuth = casts::to<f64>(sample.azimuth);
@@ -360,8 +743,15 @@ private:
 	 */
 	void parse_heatmap_dimensions(Reader &reader)
 	{
+		const usize offset = reader.offset();
 		m_dim = reader.read<protocol::heatmap::Dimensions>();
 
+		const bool x = m_dim.x_min != 0 || m_dim.x_max + 1 != m_dim.columns;
+		const bool y = m_dim.y_min != 0 || m_dim.y_max + 1 != m_dim.rows;
+
+		this->check(x || y, Check::DimensionsMismatch, offset);
+		this->check(m_dim.z_max == 0, Check::ZeroMaximum, offset);
+
 		// On newer devices, z_max may be 0, lets use a sane value instead.
 		if (m_dim.z_max == 0)
 			m_dim.z_max = 255;
@@ -376,16 +766,20 @@ private:
 	 *
 	 * @param[in] reader The chunk of data allocated to the report.
 	 */
-	void parse_heatmap_data(Reader &reader) const
+	void parse_heatmap_data(Reader &reader)
 	{
 		samples::Touch touch {};
 
+		const usize size = casts::to<usize>(m_dim.rows) * m_dim.columns;
+		this->check(reader.size() != size, Check::HeatmapSizeMismatch, reader.offset());
+
 		touch.rows = m_dim.rows;
 		touch.columns = m_dim.columns;
 		touch.min = m_dim.z_min;
 		touch.max = m_dim.z_max;
+		touch.timestamp = m_report_timestamp;
 
-		touch.heatmap = reader.subspan<u8>(casts::to<usize>(m_dim.rows) * m_dim.columns);
+		touch.heatmap = reader.subspan<u8>(size);
 
 		if (this->on_touch)
 			this->on_touch(touch);
@@ -399,11 +793,15 @@ private:
 	 *
 	 * @param[in] reader The chunk of data allocated to the frame.
 	 */
-	void parse_heatmap_frame(Reader &reader) const
+	void parse_heatmap_frame(Reader &reader)
 	{
+		const usize offset = reader.offset();
+
 		const auto header = reader.read<protocol::heatmap::Frame>();
 		Reader sub = reader.sub(header.size);
 
+		this->check(is_nonzero(header.reserved), Check::ReservedNonzero, offset);
+
 		this->parse_heatmap_data(sub);
 	}
 
@@ -19,6 +19,8 @@ constexpr u8 USAGE_TOUCHSCREEN = 0x04;
 constexpr u8 USAGE_TOUCHPAD = 0x05;
 
 constexpr u8 USAGE_SCAN_TIME = 0x56;
+constexpr u8 USAGE_SURFACE_SWITCH = 0x57;
+constexpr u8 USAGE_BUTTON_SWITCH = 0x58;
 constexpr u8 USAGE_GESTURE_DATA = 0x61;
 constexpr u8 USAGE_SET_MODE = 0xC8;
 constexpr u8 USAGE_METADATA = 0x63;
@@ -48,6 +50,21 @@ inline bool is_set_mode(const hid::Report &report)
 	       report.has_usage(USAGE_PAGE_VENDOR, USAGE_SET_MODE);
 }
 
+/*!
+ * Checks if a given report enables or disables the reporting of touch data.
+ *
+ * This is the selective reporting report from the Windows Precision Touchpad specification.
+ * Windows uses it to stop the device from scanning, e.g. when the touch input is turned off.
+ *
+ * @param[in] report The report to check.
+ * @return Whether the report matches the properties for a selective reporting report.
+ */
+inline bool is_selective_reporting(const hid::Report &report)
+{
+	return report.type == hid::Report::Type::Feature &&
+	       report.has_usage(USAGE_PAGE_DIGITIZER, USAGE_SURFACE_SWITCH);
+}
+
 /*!
  * Checks if a given report returns metadata for the device.
  *
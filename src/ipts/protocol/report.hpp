@@ -5,6 +5,8 @@
 
 #include <common/types.hpp>
 
+#include <string_view>
+
 namespace iptsd::ipts::protocol::report {
 
 enum class Type : u8 {
@@ -31,6 +33,56 @@ enum class Type : u8 {
 	Button = 0x90,
 };
 
+/*!
+ * A short name of a report type, e.g. for statistics.
+ *
+ * @param[in] type The type of the report frame.
+ * @return The name of the type, or "unknown" if the type is not known.
+ */
+inline std::string_view name(const Type type)
+{
+	switch (type) {
+	case Type::HeatmapTimestamp:
+		return "heatmap_timestamp";
+	case Type::HeatmapDimensions:
+		return "heatmap_dimensions";
+	case Type::HeatmapData:
+		return "heatmap_data";
+	case Type::StylusMPP_1_0:
+		return "stylus_mpp_1_0";
+	case Type::StylusMPP_1_51:
+		return "stylus_mpp_1_51";
+	case Type::DftFrequencyNoise:
+		return "dft_frequency_noise";
+	case Type::DftGeneral:
+		return "dft_general";
+	case Type::DftJnrOutput:
+		return "dft_jnr_output";
+	case Type::DftNoiseMetricsOutput:
+		return "dft_noise_metrics_output";
+	case Type::DftDataSelection:
+		return "dft_data_selection";
+	case Type::DftMagnitude:
+		return "dft_magnitude";
+	case Type::DftWindow:
+		return "dft_window";
+	case Type::DftMultipleRegion:
+		return "dft_multiple_region";
+	case Type::DftTouchedAntennas:
+		return "dft_touched_antennas";
+	case Type::DftMetadata:
+		return "dft_metadata";
+	case Type::DftDetection:
+		return "dft_detection";
+	case Type::DftLift:
+		return "dft_lift";
+	case Type::Button:
+		return "button";
+	default:
+		return "unknown";
+	}
+}
+
 /*!
  * Report frames contain very specific data, such as stylus coordinates or capacitive heatmaps.
  * Functionally they are equivalent to HID frames and legacy report groups, but in practice they
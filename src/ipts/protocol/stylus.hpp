@@ -15,6 +15,14 @@ constexpr u16 MAX_Y = 7200;
 constexpr u16 MAX_PRESSURE_MPP_1_0 = 1024;
 constexpr u16 MAX_PRESSURE_MPP_1_51 = 4096;
 
+/*!
+ * How many samples a stylus report is expected to contain at most.
+ *
+ * The firmware sends a handful of samples per report. Reports that claim more are reported,
+ * since their count is probably corrupted. Only the last sample is parsed either way.
+ */
+constexpr u8 MAX_SAMPLES = 32;
+
 /*!
  * A stylus report. This header is followed by one or more samples of the position and state
  * of the stylus. The sample type is determined by the type of the enclosing report frame.
@@ -23,6 +23,9 @@ struct Stylus {
 	//! The time at which this sample was generated.
 	u16 timestamp = 0;
 
+	//! Something like a serial number of the stylus. 0 if it is unknown.
+	u32 serial = 0;
+
 	//! The X / horizontal coordinate of the stylus tip.
 	//! Range: 0 to 1
 	f64 x = 0;
@@ -35,6 +38,9 @@ struct Stylus {
 	//! Range: 0 to 1
 	f64 pressure = 0;
 
+	//! How many levels of pressure the report can describe. 0 if it is not quantized.
+	u16 pressure_levels = 0;
+
 	//! The angle between the stylus tip and the display.
 	//! Unit: Radians
 	f64 altitude = 0;
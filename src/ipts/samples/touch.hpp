@@ -22,6 +22,9 @@ struct Touch {
 	//! The largest value that can occur in the heatmap.
 	u8 max = 0;
 
+	//! The timestamp of the HID report that contained the heatmap, 0 if it has none.
+	u16 timestamp = 0;
+
 	//! The capacitive heatmap, layed out in row-major mode.
 	gsl::span<u8> heatmap {};
 };
@@ -0,0 +1,34 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_IPTS_SAMPLES_UNKNOWN_HPP
+#define IPTSD_IPTS_SAMPLES_UNKNOWN_HPP
+
+#include <common/types.hpp>
+
+namespace iptsd::ipts::samples {
+
+struct Unknown {
+	enum class Source : u8 {
+		//! The data was found in a HID frame. See @ref protocol::hid::Frame
+		Hid,
+
+		//! The data was found in a legacy report group. See @ref protocol::legacy::ReportGroup
+		Legacy,
+
+		//! The data was found in a report frame. See @ref protocol::report::Frame
+		Report,
+	};
+
+	//! What kind of frame contained the data.
+	Source source = Source::Hid;
+
+	//! The type of the frame that the parser didn't recognize.
+	u16 type = 0;
+
+	//! The size of the skipped payload, in bytes.
+	usize size = 0;
+};
+
+} // namespace iptsd::ipts::samples
+
+#endif // IPTSD_IPTS_SAMPLES_UNKNOWN_HPP